@@ -2,18 +2,33 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 
+	"github.com/julianchen24/gitcherry/internal/clipboard"
 	"github.com/julianchen24/gitcherry/internal/config"
 	"github.com/julianchen24/gitcherry/internal/git"
 	"github.com/julianchen24/gitcherry/internal/logs"
@@ -24,24 +39,45 @@ import (
 	"github.com/julianchen24/gitcherry/internal/tui"
 )
 
-const dirtyWorktreeMessage = "Uncommitted changes detected. Please commit or stash before proceeding."
+const (
+	dirtyWorktreeMessage    = "Uncommitted changes detected. Please commit or stash before proceeding."
+	fallbackMessageTemplate = "[Transfer] {source} -> {target} {range}"
+	revertMessageTemplate   = "Revert {range} on {branch}"
+)
 
 var (
-	transferPlanFn             = transfer.Plan
-	transferDetectDuplicatesFn = transfer.DetectDuplicates
-	commitRangeFn              = collectCommitsForRange
+	transferRunFn              = transfer.Run
+	transferDetectDuplicatesFn = transfer.Options.DetectDuplicates
+	commitRangeFn              = transfer.RangeCommits
+	commitsBetweenFn           = git.CommitsBetweenOrder
 	editMessageFn              = editMessage
+	editEachMessageFn          = editEachMessage
 	revertPlanFn               = revert.Plan
 	restorePlanFn              = restore.Plan
-	logsWriteOperationFn       = logs.WriteOperation
+	logsRecordOperationFn      = logs.RecordOperation
 	logsPushUndoFn             = logs.PushUndo
 	logsUndoFn                 = logs.Undo
 	logsRedoFn                 = logs.Redo
+	logsRecoverFromReflogFn    = logs.RecoverFromReflog
+	logsOperationByIDFn        = logs.OperationByID
+	promptYesNoFn              = promptYesNo
+	stdinInteractiveFn         = func() bool { return isInteractive(os.Stdin) }
+	clipboardCopyFn            = clipboard.CopyToClipboard
+	lfsInstalledFn             = git.LFSInstalled
+	// repoLockTimeout is how long an applying command waits for another
+	// GitCherry run in the same repository to finish.
+	repoLockTimeout = 5 * time.Second
+	// messageFilterTimeout is how long the message_filter command may run
+	// before the operation gives up on it.
+	messageFilterTimeout = 10 * time.Second
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	rootCmd := newRootCommand()
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -50,16 +86,45 @@ func main() {
 func newRootCommand() *cobra.Command {
 	var (
 		flagRefresh     bool
+		flagUnshallow   bool
+		flagDeepen      int
 		flagApply       bool
 		flagNoPreview   bool
 		flagTUI         bool
 		flagOnDuplicate string
+		flagOutput      string
+		flagPreviewMax  int
+		flagLogFormat   string
+		flagForceClean  bool
+		flagRemote      string
+		flagConfirm     bool
+		flagTUITheme    string
+		flagMouse       bool
+		flagNoGPGSign   bool
+		flagYes         bool
+		flagLogLevel    string
+		flagStats       bool
+		flagPaginate    bool
+		flagNoPager     bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "gitcherry",
 		Short: "Interactive helper for cherry-picking Git commits.",
-		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			// Shell completion runs on every <Tab>, so it must not take the
+			// lock, fetch, or refuse to answer in a dirty worktree.
+			switch cmd.Name() {
+			case cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+				return nil
+			}
+
+			level, err := parseLogLevel(flagLogLevel)
+			if err != nil {
+				return err
+			}
+			slog.SetDefault(newLogger(cmd.ErrOrStderr(), level))
+
 			cfg, err := config.Load(".")
 			if err != nil {
 				return err
@@ -72,6 +137,43 @@ func newRootCommand() *cobra.Command {
 			if flagRefresh {
 				merged.AutoRefresh = true
 			}
+			if cmd.Root().PersistentFlags().Changed("remote") {
+				merged.RefreshRemote = flagRemote
+			}
+			merged.RefreshRemote = strings.TrimSpace(merged.RefreshRemote)
+			if cmd.Flags().Changed("preview-limit") {
+				merged.PreviewLimit = flagPreviewMax
+			}
+			if cmd.Flags().Changed("log-format") {
+				merged.CommitDisplayFormat = flagLogFormat
+			}
+			if merged.CommitDisplayFormat == "" {
+				merged.CommitDisplayFormat = git.DefaultDisplayFormat
+			}
+			if err := git.ValidateDisplayFormat(merged.CommitDisplayFormat); err != nil {
+				return fmt.Errorf("invalid commit display format: %w", err)
+			}
+			if cmd.Flags().Changed("mouse") {
+				merged.Mouse = flagMouse
+			}
+			if cmd.Flags().Changed("tui-theme") {
+				merged.TUITheme = flagTUITheme
+			}
+			if cmd.Flags().Changed("no-gpg-sign") {
+				merged.NoGPGSign = flagNoGPGSign
+			}
+			if err := tui.ValidateTheme(merged.TUITheme); err != nil {
+				return err
+			}
+			if err := merged.Validate(); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+			// Commands that never look at the repository work outside one.
+			inRepo := needsRepo(cmd)
+			if inRepo && strings.TrimSpace(merged.DefaultBranch) == "" {
+				// Detection is best effort; the guard is skipped without it.
+				merged.DefaultBranch, _ = git.DefaultBranch()
+			}
 
 			effectiveDuplicate := strings.TrimSpace(flagOnDuplicate)
 			effectiveDuplicate = strings.ToLower(effectiveDuplicate)
@@ -80,7 +182,8 @@ func newRootCommand() *cobra.Command {
 				if effectiveDuplicate == "" {
 					effectiveDuplicate = "ask"
 				}
-				if !cmd.Flags().Changed("on-duplicate") && !isInteractive(os.Stdin) {
+				// --yes answers the ask prompt instead of skipping.
+				if !cmd.Flags().Changed("on-duplicate") && !isInteractive(os.Stdin) && !flagYes {
 					effectiveDuplicate = "skip"
 				}
 			}
@@ -91,29 +194,127 @@ func newRootCommand() *cobra.Command {
 			}
 			merged.OnDuplicate = effectiveDuplicate
 
-			clean, err := git.IsClean()
-			if err != nil {
-				return err
+			output := strings.ToLower(strings.TrimSpace(flagOutput))
+			switch output {
+			case "text", "json":
+			default:
+				return fmt.Errorf("invalid value for --output: %s", flagOutput)
 			}
-			if !clean {
-				return fmt.Errorf(dirtyWorktreeMessage)
+			if flagApply && flagConfirm {
+				return errors.New("--apply and --dry-run-then-apply cannot be used together")
 			}
 
-			if flagRefresh {
-				if err := git.Fetch(true, true); err != nil {
+			// A trial apply commits too, even though it resets afterwards.
+			trial := cmd.Flags().Lookup("dry-run-apply")
+			applying := flagApply || flagConfirm || (trial != nil && trial.Changed)
+
+			inProgress := ""
+			if inRepo {
+				inProgress, err = git.InProgressOperation()
+				if err != nil {
+					return err
+				}
+			}
+			if inProgress != "" && flagForceClean {
+				if err := abortInProgress(inProgress); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: aborted the in-progress %s (--force-clean).\n", inProgress)
+				inProgress = ""
+			}
+			// Read-only commands work in the middle of a conflict, which is
+			// when inspecting the log is most useful.
+			readOnly := !mutatesRepo(cmd)
+			if inProgress != "" && !readOnly {
+				return fmt.Errorf("a %s is already in progress. Finish it with 'git %s --continue' or cancel it with 'git %s --abort' before running gitcherry", inProgress, inProgress, inProgress)
+			}
+
+			if !readOnly {
+				clean, err := git.IsClean()
+				if err != nil {
+					return err
+				}
+				if !clean {
+					return fmt.Errorf(dirtyWorktreeMessage)
+				}
+			}
+			if applying && !readOnly {
+				release, lockErr := logs.AcquireLock(repoLockTimeout)
+				if lockErr != nil {
+					return lockErr
+				}
+				defer func() {
+					if err != nil {
+						release()
+					}
+				}()
+				releaseAfterRun(cmd, release)
+			}
+
+			switch cmd.Name() {
+			case "transfer", "revert", "replay", "import":
+				if err := checkIdentity(cmd, applying); err != nil {
+					return err
+				}
+			}
+
+			pager := pagerAuto
+			switch {
+			case flagPaginate:
+				pager = pagerAlways
+			case flagNoPager:
+				pager = pagerNever
+			}
+
+			var stats *phaseStats
+			if flagStats {
+				stats = &phaseStats{}
+			}
+			if inRepo && merged.AutoRefresh {
+				done := stats.track("fetch")
+				err := refreshRemote(&git.Runner{}, merged.RefreshRemote)
+				done()
+				if err != nil {
+					return err
+				}
+			}
+			if flagDeepen < 0 {
+				return errors.New("--deepen must be a positive number of commits")
+			}
+			switch {
+			case !inRepo:
+			case flagUnshallow || flagDeepen > 0:
+				done := stats.track("fetch")
+				err := deepenHistory(cmd, &git.Runner{}, merged.RefreshRemote, flagDeepen)
+				done()
+				if err != nil {
 					return err
 				}
+			case readsRanges(cmd):
+				// Detection is advisory: a range past the shallow boundary
+				// still fails with git's own error.
+				if shallow, _ := git.IsShallow(&git.Runner{}); shallow {
+					fmt.Fprintln(cmd.ErrOrStderr(), "Warning: this is a shallow clone, so ranges reaching past its history cannot be found; pass --unshallow or --deepen N to fetch more.")
+				}
 			}
 
 			ctx := cmd.Context()
 			ctx = context.WithValue(ctx, ctxConfigKey{}, &merged)
 			ctx = context.WithValue(ctx, ctxApplyKey{}, flagApply)
+			ctx = context.WithValue(ctx, ctxDryRunThenApplyKey{}, flagConfirm)
 			ctx = context.WithValue(ctx, ctxRefreshKey{}, flagRefresh)
 			ctx = context.WithValue(ctx, ctxTUIKey{}, flagTUI)
 			ctx = context.WithValue(ctx, ctxDuplicateKey{}, effectiveDuplicate)
+			ctx = context.WithValue(ctx, ctxOutputKey{}, output)
+			ctx = context.WithValue(ctx, ctxYesKey{}, flagYes)
+			ctx = context.WithValue(ctx, ctxStatsKey{}, stats)
+			ctx = context.WithValue(ctx, ctxPagerKey{}, pager)
 			cmd.SetContext(ctx)
 			return nil
 		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			statsFromContext(cmd.Context()).print(cmd.ErrOrStderr())
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
 			defer stop()
@@ -123,15 +324,33 @@ func newRootCommand() *cobra.Command {
 				return fmt.Errorf("configuration not initialised")
 			}
 
-			audit := logs.NewAuditLog()
+			audit, err := logs.NewPersistentAuditLog(logs.SessionAuditPath())
+			if err != nil {
+				return fmt.Errorf("load session audit log: %w", err)
+			}
 			audit.Record(logs.Entry{Summary: "session started"})
 
 			gitRunner := &git.Runner{}
 			app := tui.NewApp(gitRunner, cfg, audit)
+			// The TUI asks before every transfer, which is the confirmation
+			// --dry-run-then-apply stands for.
+			app.SetApply(isApply(ctx) || isDryRunThenApply(ctx))
+			app.SetTransferGuards(tui.TransferGuards{
+				DefaultBranch: func() string { return defaultBranch(gitRunner, cfg) },
+				CheckIdentity: func() error {
+					message, err := identityProblem(gitRunner)
+					if err != nil || message == "" {
+						return err
+					}
+					return errors.New(message)
+				},
+				FilterMessage: func(message string) (string, error) { return filterMessage(cfg, message) },
+			})
 			runner := ops.NewRunner(app, cfg, audit)
+			runner.SetOutput(cmd.OutOrStdout())
 
-			if !isApply(ctx) {
-				printDryRunNotice("session")
+			if !isApply(ctx) && !isDryRunThenApply(ctx) {
+				runner.Printf("[dry-run] session; use --apply to execute\n")
 			}
 
 			return runner.Run(ctx)
@@ -140,15 +359,45 @@ func newRootCommand() *cobra.Command {
 
 	cmd.PersistentFlags().BoolVar(&flagRefresh, "refresh", false, "Fetch latest remote refs before operations")
 	cmd.PersistentFlags().BoolVar(&flagApply, "apply", false, "Execute operations instead of dry-run")
+	cmd.PersistentFlags().BoolVar(&flagConfirm, "dry-run-then-apply", false, "Print the plan and ask before executing it")
 	cmd.PersistentFlags().BoolVar(&flagNoPreview, "no-preview", false, "Disable preview before applying changes")
 	cmd.PersistentFlags().BoolVar(&flagTUI, "tui", false, "Launch the interactive TUI")
 	cmd.PersistentFlags().StringVar(&flagOnDuplicate, "on-duplicate", "", "Duplicate handling strategy: ask|skip|apply")
+	cmd.PersistentFlags().StringVar(&flagOutput, "output", "text", "Output format for reports: text|json")
+	cmd.PersistentFlags().IntVar(&flagPreviewMax, "preview-limit", 0, "Maximum number of commits shown in previews (0 for no limit)")
+	cmd.PersistentFlags().StringVar(&flagLogFormat, "log-format", "", "git --pretty format for commit subjects in lists and previews")
+	cmd.PersistentFlags().StringVar(&flagRemote, "remote", "", "Remote to fetch when refreshing or deepening (defaults to refreshRemote, then all remotes)")
+	cmd.PersistentFlags().BoolVar(&flagUnshallow, "unshallow", false, "In a shallow clone, fetch the rest of the history from the remote before operating")
+	cmd.PersistentFlags().IntVar(&flagDeepen, "deepen", 0, "In a shallow clone, fetch this many more commits of history from the remote before operating")
+	cmd.MarkFlagsMutuallyExclusive("unshallow", "deepen")
+	cmd.PersistentFlags().StringVar(&flagTUITheme, "tui-theme", "", "TUI colour theme: default|mono|high-contrast (defaults to tuiTheme)")
+	cmd.PersistentFlags().BoolVar(&flagMouse, "mouse", false, "Enable mouse clicks and wheel scrolling in the TUI (defaults to mouse in config)")
+	cmd.PersistentFlags().BoolVar(&flagNoGPGSign, "no-gpg-sign", false, "Do not sign new commits even when commit.gpgsign is set (defaults to noGpgSign)")
+	cmd.PersistentFlags().BoolVar(&flagForceClean, "force-clean", false, "Abort a stuck cherry-pick or revert before starting")
+	cmd.PersistentFlags().BoolVarP(&flagPaginate, "paginate", "p", false, "Pipe list, preview, and show output through $GIT_PAGER, $PAGER, or less when stdout is a terminal")
+	cmd.PersistentFlags().BoolVar(&flagNoPager, "no-pager", false, "Never pipe output through a pager")
+	cmd.MarkFlagsMutuallyExclusive("paginate", "no-pager")
+	cmd.PersistentFlags().BoolVar(&flagStats, "stats", false, "Print the wall time of each phase (fetch, commit enumeration, duplicate scan, apply) to stderr")
+	cmd.PersistentFlags().StringVar(&flagLogLevel, "log-level", "warn", "Diagnostics written to stderr: debug|info|warn|error")
+	cmd.PersistentFlags().BoolVarP(&flagYes, "yes", "y", false, "Answer yes to every confirmation prompt (duplicates, --dry-run-then-apply)")
+	cmd.PersistentFlags().BoolVar(&flagYes, "assume-yes", false, "Alias for --yes")
+	_ = cmd.PersistentFlags().MarkHidden("assume-yes")
 
 	cmd.AddCommand(newTransferCmd())
+	cmd.AddCommand(newPreviewCmd())
+	cmd.AddCommand(newFormatMessageCmd())
 	cmd.AddCommand(newRevertCmd())
+	cmd.AddCommand(newImportCmd())
 	cmd.AddCommand(newRestoreCmd())
 	cmd.AddCommand(newUndoCmd())
 	cmd.AddCommand(newRedoCmd())
+	cmd.AddCommand(newRefLogCmd())
+	cmd.AddCommand(newShowCmd())
+	cmd.AddCommand(newReplayCmd())
+	cmd.AddCommand(newListCmd())
+	cmd.AddCommand(newListBranchesCmd())
+	cmd.AddCommand(newInitCmd())
+	cmd.AddCommand(newConfigCmd())
 
 	cmd.SetContext(context.Background())
 	cmd.SilenceUsage = true
@@ -158,9 +407,14 @@ func newRootCommand() *cobra.Command {
 
 type ctxConfigKey struct{}
 type ctxApplyKey struct{}
+type ctxDryRunThenApplyKey struct{}
 type ctxRefreshKey struct{}
 type ctxTUIKey struct{}
 type ctxDuplicateKey struct{}
+type ctxOutputKey struct{}
+type ctxYesKey struct{}
+type ctxStatsKey struct{}
+type ctxPagerKey struct{}
 
 func configFromContext(ctx context.Context) *config.Config {
 	if ctx == nil {
@@ -174,26 +428,102 @@ func configFromContext(ctx context.Context) *config.Config {
 
 func newTransferCmd() *cobra.Command {
 	var (
-		flagFrom    string
-		flagTo      string
-		flagRange   string
-		flagMessage string
-		flagEdit    bool
-		flagAuto    bool
+		flagFrom     string
+		flagTo       []string
+		flagTargets  []string
+		flagKeepGo   bool
+		flagRange    string
+		flagRangeIn  string
+		flagMessage  string
+		flagEdit     bool
+		flagAuto     bool
+		flagKeepMsg  bool
+		flagPreserve bool
+		flagSquash   bool
+		flagNoFF     bool
+		flagSummary  bool
+		flagKeepTS   bool
+		flagSparse   bool
+		flagTagAfter string
+		flagTagMsg   string
+		flagEach     bool
+		flagEditEach bool
+		flagKeepDate bool
+		flagSuffix   string
+		flagStrategy string
+		flagHeadChk  bool
+		flagOrder    string
+		flagTrial    bool
+		flagEmpty    bool
+		flagIdle     bool
+		flagQuiet    bool
+		flagExport   string
+		flagPatches  string
+		flagSubmods  bool
+		flagLFSPull  bool
+		flagTrailers []string
+		flagForce    bool
+		flagNewHead  bool
+		flagMarkers  bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "transfer",
 		Short: "Transfer commits between branches",
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			ctx := cmd.Context()
 			cfg := configFromContext(ctx)
 			if cfg == nil {
 				return errors.New("configuration not available")
 			}
 
-			if flagFrom == "" || flagTo == "" || flagRange == "" {
-				return errors.New("--from, --to, and --range are required")
+			if flagRangeIn != "" {
+				if flagRange, err = readRangeFile(flagRangeIn); err != nil {
+					return err
+				}
+			}
+			targets := transferTargets(flagTo, flagTargets)
+			if flagPatches != "" {
+				if flagFrom != "" || flagRange != "" || len(targets) != 1 {
+					return errors.New("--apply-patches takes exactly one --to target and no --from or --range")
+				}
+				return applyPatchDir(cmd, commitRunner(cmd, cfg), flagPatches, targets[0])
+			}
+			if flagExport != "" {
+				if flagFrom == "" || flagRange == "" || len(targets) > 0 {
+					return errors.New("--export-patches takes --from and --range (or --range-file) and no --to target")
+				}
+				return exportPatches(cmd, flagRange, flagExport)
+			}
+			if flagFrom == "" || len(targets) == 0 || flagRange == "" {
+				return errors.New("--from, --to (or --targets), and --range (or --range-file) are required")
+			}
+			// Everything else goes to stderr so stdout holds only the hashes.
+			stdout := cmd.OutOrStdout()
+			if flagNewHead {
+				if !isApply(ctx) && !isDryRunThenApply(ctx) {
+					return errors.New("--print-new-head needs --apply or --dry-run-then-apply")
+				}
+				cmd.SetOut(cmd.ErrOrStderr())
+				defer cmd.SetOut(stdout)
+			}
+			// With --output json stdout holds only the JSON, so progress
+			// and result text moves to stderr as well.
+			jsonOut := stdout
+			switch {
+			case flagNewHead:
+				jsonOut = cmd.ErrOrStderr()
+			case outputFormat(ctx) == "json":
+				cmd.SetOut(cmd.ErrOrStderr())
+				defer cmd.SetOut(stdout)
+			}
+			switch flagStrategy {
+			case transfer.StrategyPatchID, transfer.StrategyHeuristic:
+			default:
+				return fmt.Errorf("invalid --duplicate-strategy %q (expected %s or %s)", flagStrategy, transfer.StrategyPatchID, transfer.StrategyHeuristic)
+			}
+			if _, err := git.CommitOrderArgs(flagOrder); err != nil {
+				return err
 			}
 
 			startHash, endHash, err := parseRangeSpec(flagRange, false)
@@ -207,311 +537,2355 @@ func newTransferCmd() *cobra.Command {
 			if flagEdit && flagAuto {
 				return errors.New("--edit and --auto-message cannot be used together")
 			}
+			if flagPreserve && (flagMessage != "" || flagEdit || flagAuto) {
+				return errors.New("--preserve keeps the original commit messages and cannot be combined with message flags")
+			}
+			if flagNoFF && (flagSquash || flagPreserve) {
+				return errors.New("--no-ff cannot be combined with --squash or --preserve")
+			}
+			if flagKeepTS && flagPreserve {
+				return errors.New("--keep-timestamps cannot be combined with --preserve")
+			}
+			if flagEach && (flagMessage != "" || flagEdit || flagAuto) {
+				return errors.New("--commit-each keeps the original commit messages and cannot be combined with message flags")
+			}
+			if flagEach && (flagSquash || flagPreserve || flagNoFF || flagKeepTS) {
+				return errors.New("--commit-each cannot be combined with --squash, --preserve, --no-ff, or --keep-timestamps")
+			}
+			if flagSuffix != "" && !flagEach {
+				return errors.New("--commit-each-message-suffix requires --commit-each")
+			}
+			if flagEditEach && !flagPreserve {
+				return errors.New("--edit-each requires --preserve")
+			}
+			if len(flagTrailers) > 0 && (flagPreserve || flagEach) {
+				return errors.New("--trailer only applies to the squash and --no-ff commit messages")
+			}
+			if err := validateTrailers(flagTrailers); err != nil {
+				return err
+			}
+			perCommit := flagPreserve || flagEach
+			// The config default only applies to per-commit modes; squash
+			// transfers have --keep-timestamps instead.
+			keepDates := cfg.PreserveDates
+			if cmd.Flags().Changed("preserve-dates") {
+				if flagKeepDate && !perCommit {
+					return errors.New("--preserve-dates requires --preserve or --commit-each")
+				}
+				keepDates = flagKeepDate
+			}
+			if flagTagMsg != "" && flagTagAfter == "" {
+				return errors.New("--tag-message requires --tag-after")
+			}
+			if flagTagAfter != "" && len(targets) > 1 {
+				return errors.New("--tag-after cannot be used with more than one target")
+			}
+			if flagKeepGo && len(targets) < 2 {
+				return errors.New("--keep-going requires more than one target")
+			}
+			if flagQuiet && !flagIdle {
+				return errors.New("--quiet requires --quiet-if-empty")
+			}
+			if flagTrial && (isApply(ctx) || isDryRunThenApply(ctx)) {
+				return errors.New("--dry-run-apply resets the target afterwards and cannot be combined with --apply or --dry-run-then-apply")
+			}
 
-			runner := &git.Runner{}
-			commits, err := commitRangeFn(runner, startHash, endHash)
+			runner := commitRunner(cmd, cfg)
+			heads := git.NewRefResolver(runner)
+			if flagTagAfter != "" {
+				if err := git.ValidateTagName(runner, flagTagAfter); err != nil {
+					return err
+				}
+			}
+			stats := statsFromContext(ctx)
+			done := stats.track("enumerate")
+			commits, err := commitRangeFn(runner, startHash, endHash, flagOrder)
+			done()
 			if err != nil {
 				return err
 			}
+			// Cherry-picking a gitlink moves the pointer but not the
+			// submodule's checkout. The check is advisory, so a range git
+			// cannot walk here is left to fail in the steps that need it.
+			submodules, _ := runner.ChangedSubmodules(fmt.Sprintf("%s^..%s", startHash, endHash))
+			if len(submodules) > 0 && !flagSubmods {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the range changes submodule(s) %s; run 'git submodule update --init' after applying, or pass --update-submodules.\n", strings.Join(submodules, ", "))
+			}
+			lfsPull := checkLFS(cmd, runner, startHash, endHash, flagLFSPull)
 
 			mode := duplicateMode(ctx)
 			if mode == "" {
 				mode = "ask"
 			}
-			if len(commits) > 0 {
-				dups, err := transferDetectDuplicatesFn(runner, flagTo, commits)
-				if err != nil {
-					return err
+			applyDups, confirmDups, err := duplicatePolicy(cmd, mode)
+			if err != nil {
+				return err
+			}
+			// nothingToDo reports a target that already has the whole range,
+			// either as its commits or (contained false) as their changes.
+			// With --quiet-if-empty it is the only line printed, and --quiet
+			// drops it too.
+			nothingToDo := func(to string, contained bool) error {
+				switch {
+				case flagQuiet:
+				case contained:
+					fmt.Fprintf(cmd.OutOrStdout(), "Nothing to transfer: %s already contains %s.\n", to, shortHash(endHash))
+				default:
+					fmt.Fprintf(cmd.OutOrStdout(), "Nothing to transfer: %s already has the changes in %s..%s.\n", to, shortHash(startHash), shortHash(endHash))
+				}
+				return nil
+			}
+			targetStats := stats.perTarget()
+			// transferTo plans, confirms, applies, and logs the range for one
+			// target; each target gets its own operation, undo entry, and
+			// phase timings.
+			transferTo := func(to string) (err error) {
+				commits := commits
+				stats := targetStats()
+				if !flagEmpty && rangeApplied(runner, commits, endHash, to) {
+					return nothingToDo(to, true)
 				}
-				if len(dups) > 0 {
-					proceed, err := handleDuplicateChoice(cmd, mode, dups)
+				opts := transfer.Options{
+					From:                 flagFrom,
+					To:                   to,
+					StartHash:            startHash,
+					EndHash:              endHash,
+					Order:                flagOrder,
+					Mode:                 transferMode(flagPreserve, flagEach, flagNoFF),
+					Suffix:               flagSuffix,
+					Reword:               flagEditEach,
+					KeepDates:            keepDates,
+					Strategy:             flagStrategy,
+					ApplyDuplicates:      applyDups,
+					ConfirmDuplicates:    confirmDups,
+					AllowConflictMarkers: flagMarkers,
+				}
+				var skipped []git.Commit
+				if len(commits) > 0 {
+					done := stats.track("duplicates")
+					dups, err := transferDetectDuplicatesFn(opts, ctx, runner, commits, cmd.ErrOrStderr())
+					done()
 					if err != nil {
 						return err
 					}
-					if !proceed {
-						fmt.Fprintln(cmd.OutOrStdout(), "Skipping transfer due to duplicate patches.")
+					if flagIdle && len(dups) == len(commits) {
+						return nothingToDo(to, false)
+					}
+					if commits, skipped, err = opts.SkipDuplicates(commits, dups, cmd.OutOrStdout()); err != nil {
+						return err
+					}
+					if len(commits) == 0 {
 						return nil
 					}
 				}
-			}
+				switch {
+				case opts.Reword:
+					if commits, err = editEachCommit(runner, commits); err != nil {
+						return err
+					}
+				case opts.Mode == transfer.ModeCommitEach && opts.Suffix != "":
+					if commits, err = transfer.LoadMessages(runner, commits); err != nil {
+						return err
+					}
+				case !perCommit:
+					explicit := flagMessage
+					if flagKeepMsg {
+						if explicit, err = sourceMessage(cmd, runner, commits); err != nil {
+							return err
+						}
+					}
+					rangeSpec := fmt.Sprintf("%s..%s", startHash, endHash)
+					opts.Message, err = resolveTransferMessage(cmd, cfg, explicit, flagEdit, flagAuto, flagFrom, to, rangeSpec)
+					if err != nil {
+						return err
+					}
+					if opts.Message, err = filterMessage(cfg, opts.Message); err != nil {
+						return err
+					}
+					if opts.Message, err = appendTrailers(opts.Message, flagTrailers); err != nil {
+						return err
+					}
+				}
+				opts.Commits = commits
+				commands := opts.Plan(commits)
+
+				// Refreshing makes the remote-tracking refs trustworthy, so the
+				// check is on by default then. It runs before the prompt so
+				// nobody approves a plan that is then refused.
+				checkHead := cfg.AutoRefresh
+				if cmd.Flags().Changed("target-head-check") {
+					checkHead = flagHeadChk
+				}
+				if checkHead && (flagTrial || isApply(ctx) || isDryRunThenApply(ctx)) {
+					if err := checkTargetHead(runner, to); err != nil {
+						return err
+					}
+				}
 
-			rangeSpec := fmt.Sprintf("%s..%s", startHash, endHash)
-			message, err := resolveTransferMessage(cmd, cfg, flagMessage, flagEdit, flagAuto, flagFrom, flagTo, rangeSpec)
-			if err != nil {
-				return err
-			}
+				tag := transferTag{name: flagTagAfter, message: flagTagMsg}
+				if flagTrial {
+					printPlan(cmd, commands)
+				} else {
+					apply, err := confirmApply(cmd, tag.plan(commands, to))
+					if err != nil || !apply {
+						return err
+					}
+				}
+				if !flagTrial {
+					if apply, err := confirmDefaultBranch(cmd, runner, cfg, to, flagForce); err != nil || !apply {
+						return err
+					}
+				}
 
-			commands := transferPlanFn(flagFrom, flagTo, startHash, endHash, message)
-			if !isApply(ctx) {
-				printPlan(cmd, commands)
-				return nil
-			}
+				if flagSparse {
+					var added []string
+					added, err = expandSparse(runner, startHash, endHash)
+					if err != nil {
+						return err
+					}
+					// Leave the cone expanded after a failure so conflicts can be resolved.
+					defer func() {
+						if err == nil {
+							err = git.SparseRemove(runner, added)
+						}
+					}()
+				}
 
-			beforeHead, err := currentHead(runner, flagTo)
-			if err != nil {
-				return err
-			}
+				applyRunner := runner
+				if flagKeepTS {
+					date, err := committerDate(runner, endHash)
+					if err != nil {
+						return err
+					}
+					keep := runner.WithExtraEnv("GIT_COMMITTER_DATE=" + date)
+					applyRunner = &keep
+				}
+				opts.Apply = true
 
-			if err := runCommands(cmd, runner, commands); err != nil {
-				return err
-			}
+				if flagTrial {
+					done := stats.track("apply")
+					err := trialApply(cmd, runner, heads, to, func() error {
+						_, err := transferRunFn(ctx, applyRunner, opts, nil)
+						return err
+					})
+					done()
+					if flagNoFF {
+						_, _, _ = runner.Run("branch", "-D", transfer.TempBranchName(to))
+					}
+					if flagIdle && errors.Is(err, transfer.ErrNothingToCommit) {
+						return nothingToDo(to, false)
+					}
+					return err
+				}
 
-			afterHead, err := currentHead(runner, flagTo)
-			if err != nil {
-				return err
+				done := stats.track("apply")
+				result, err := transferRunFn(ctx, applyRunner, opts, nil)
+				done()
+				heads.Forget(to)
+				switch {
+				case flagIdle && errors.Is(err, transfer.ErrNothingToCommit):
+					return nothingToDo(to, false)
+				case err != nil && (ctx.Err() != nil || !perCommit):
+					// Interrupted transfers were rolled back, and a squash
+					// that failed made no commits to summarize.
+					return err
+				}
+
+				if flagSummary {
+					progress := transfer.Progress{Total: len(commits), Applied: result.Applied, Skipped: result.Skipped}
+					if len(result.Conflicted) > 0 {
+						progress.Failed = result.Conflicted[0]
+					}
+					if err := printSummary(cmd, jsonOut, transfer.NewSummary(to, progress, skipped, result.NewCommits)); err != nil {
+						return err
+					}
+				}
+				if err != nil {
+					return err
+				}
+
+				tags, err := tag.create(runner, to)
+				if err != nil {
+					return err
+				}
+				// Keep the tag only if the transfer is also recorded.
+				defer func() {
+					if err != nil {
+						tag.remove(runner)
+					}
+				}()
+
+				op := result.Operation(opts)
+				op.Commands = tag.plan(commands, to)
+				op.Tags = tags
+				op.Timings = stats.millis()
+				if _, err := logsRecordOperationFn(op); err != nil {
+					return err
+				}
+				if err := logsPushUndoFn(result.UndoEntry()); err != nil {
+					return err
+				}
+
+				switch {
+				case outputFormat(ctx) != "text":
+				case perCommit:
+					fmt.Fprintf(cmd.OutOrStdout(), "Transfer applied successfully (%d of %d commits preserved).\n", len(result.Applied), len(commits))
+				default:
+					fmt.Fprintln(cmd.OutOrStdout(), "Transfer applied successfully.")
+				}
+
+				// The transfer is applied and logged by now, so failing to
+				// fill in the working tree only warns.
+				if flagSubmods && len(submodules) > 0 {
+					if err := git.UpdateSubmodules(runner, submodules); err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the transfer was applied, but updating submodules failed: %v; run 'git submodule update --init' to retry.\n", err)
+					}
+				}
+				if lfsPull {
+					if err := git.LFSPull(runner); err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the transfer was applied, but git lfs pull failed: %v; run 'git lfs pull' to retry.\n", err)
+					}
+				}
+				return nil
 			}
 
-			op := logs.Operation{
-				Source:    flagFrom,
-				Target:    flagTo,
-				StartHash: startHash,
-				EndHash:   endHash,
-				Message:   message,
-				Commands:  commands,
+			if flagNewHead {
+				apply := transferTo
+				transferTo = func(to string) error {
+					if err := apply(to); err != nil {
+						return err
+					}
+					heads.Forget(to)
+					head, err := heads.Resolve(to)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintln(stdout, head)
+					return nil
+				}
 			}
-			if err := logsWriteOperationFn(op); err != nil {
-				return err
+
+			if len(targets) == 1 {
+				return transferTo(targets[0])
 			}
 
-			undo := logs.UndoEntry{
-				Source:     flagTo,
-				Target:     flagTo,
-				BeforeHead: beforeHead,
-				AfterHead:  afterHead,
+			results := make([]targetResult, len(targets))
+			for i, to := range targets {
+				results[i].target = to
 			}
-			if err := logsPushUndoFn(undo); err != nil {
-				return err
+			var failed int
+			for i, to := range targets {
+				fmt.Fprintf(cmd.OutOrStdout(), "==> %s\n", to)
+				results[i].attempted = true
+				// With --keep-going, a target that stops mid-transfer is put
+				// back so the next one starts from a clean repository.
+				var original, beforeHead string
+				if flagKeepGo {
+					original, _ = git.CurrentBranch()
+					beforeHead, _ = heads.Resolve(to)
+				}
+				if err := transferTo(to); err != nil {
+					results[i].err = err
+					failed++
+					fmt.Fprintf(cmd.ErrOrStderr(), "Transfer to %s failed: %v\n", to, err)
+					if !flagKeepGo {
+						break
+					}
+					if beforeHead == "" || original == "" {
+						continue
+					}
+					heads.Forget(to)
+					restored, err := restoreFailedTarget(runner, to, beforeHead, original)
+					if err != nil {
+						printTargetResults(cmd.OutOrStdout(), results)
+						return fmt.Errorf("could not restore %s after its transfer failed, so the remaining targets were not attempted: %v; run 'git checkout %s && git reset --hard %s' to restore it", to, err, to, beforeHead)
+					}
+					if restored {
+						fmt.Fprintf(cmd.ErrOrStderr(), "Restored %s to %s.\n", to, shortHash(beforeHead))
+					}
+				}
+			}
+			printTargetResults(cmd.OutOrStdout(), results)
+			if failed > 0 {
+				return fmt.Errorf("transfer failed for %d of %d targets", failed, len(targets))
 			}
-
-			fmt.Fprintln(cmd.OutOrStdout(), "Transfer applied successfully.")
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&flagFrom, "from", "", "Source branch")
-	cmd.Flags().StringVar(&flagTo, "to", "", "Target branch")
+	cmd.Flags().StringArrayVar(&flagTo, "to", nil, "Target branch; repeat to transfer to several targets in turn")
+	cmd.Flags().StringSliceVar(&flagTargets, "targets", nil, "Comma-separated target branches, transferred to in order")
+	cmd.Flags().BoolVar(&flagKeepGo, "keep-going", false, "With several targets, roll back a target that fails and continue with the next instead of stopping")
 	cmd.Flags().StringVar(&flagRange, "range", "", "Commit range (a..b)")
+	cmd.Flags().StringVar(&flagStrategy, "duplicate-strategy", transfer.StrategyPatchID, "How to spot commits already on the target: patch-id|heuristic (same subject and files)")
+	cmd.Flags().StringVar(&flagOrder, "commit-order", git.OrderDefault, "Order to apply the range in: default|topo|date|author-date (git rev-list ordering)")
+	cmd.Flags().BoolVar(&flagHeadChk, "target-head-check", false, "Refuse to apply when the target is behind its upstream (default on with --refresh)")
+	cmd.Flags().StringVar(&flagRangeIn, "range-file", "", "Read the commit range from a file containing a single a..b line")
 	cmd.Flags().StringVar(&flagMessage, "message", "", "Commit message to use")
 	cmd.Flags().BoolVar(&flagEdit, "edit", false, "Edit commit message before applying")
 	cmd.Flags().BoolVar(&flagAuto, "auto-message", false, "Generate commit message from template")
+	cmd.Flags().BoolVar(&flagKeepMsg, "keep-message", false, "Reuse the source commit's message when the range is a single commit")
+	cmd.Flags().BoolVar(&flagPreserve, "preserve", false, "Cherry-pick each commit individually instead of squashing")
+	cmd.Flags().BoolVar(&flagSquash, "squash", false, "Squash the range into a single commit (default)")
+	cmd.Flags().BoolVar(&flagNoFF, "no-ff", false, "Wrap the cherry-picked range in a merge commit on the target")
+	cmd.Flags().BoolVar(&flagSummary, "summary", false, "Print a summary of applied, skipped, and conflicted commits")
+	cmd.Flags().BoolVar(&flagSparse, "auto-sparse", false, "Temporarily add the range's directories to a sparse checkout while applying")
+	cmd.Flags().BoolVar(&flagKeepTS, "keep-timestamps", false, "Use the committer date of the range's last commit for the new commits")
+	cmd.Flags().BoolVar(&flagEach, "commit-each", false, "Create one target commit per source commit, keeping the original messages")
+	cmd.Flags().BoolVar(&flagKeepDate, "preserve-dates", false, "With --preserve or --commit-each, give each new commit its source commit's committer date (default from preserveDates config)")
+	cmd.Flags().BoolVar(&flagEditEach, "edit-each", false, "With --preserve, edit each commit's message in your editor before anything is applied")
+	cmd.Flags().StringVar(&flagSuffix, "commit-each-message-suffix", "", "Append this to each --commit-each subject; {source} and {hash} are replaced")
+	cmd.Flags().StringVar(&flagTagAfter, "tag-after", "", "Tag the target branch HEAD after a successful transfer")
+	cmd.Flags().StringVar(&flagTagMsg, "tag-message", "", "Create an annotated --tag-after tag with this message")
+	cmd.Flags().BoolVar(&flagSubmods, "update-submodules", false, "Run git submodule update --init for submodules the range changes once it is applied")
+	cmd.Flags().StringArrayVar(&flagTrailers, "trailer", nil, "Append a 'Key: value' git trailer to the commit message; repeatable")
+	cmd.Flags().BoolVar(&flagForce, "force", false, "Apply to the default branch without asking for confirmation")
+	cmd.Flags().BoolVar(&flagLFSPull, "lfs-pull", false, "Run git lfs pull once the transfer is applied when LFS objects for the range are missing locally")
+	cmd.Flags().StringVar(&flagExport, "export-patches", "", "Write the range as git format-patch files to this directory instead of transferring it")
+	cmd.Flags().StringVar(&flagPatches, "apply-patches", "", "Apply the .patch files in this directory to the --to branch with git am")
+	cmd.Flags().BoolVar(&flagNewHead, "print-new-head", false, "Print only each target's resulting head hash to stdout, for scripts; other output goes to stderr")
+	cmd.Flags().BoolVar(&flagMarkers, "allow-conflict-markers", false, "Commit a squash or no-ff transfer even when staged files still contain conflict markers")
+	cmd.Flags().BoolVar(&flagEmpty, "allow-empty-range", false, "Transfer even when the target already contains every commit in the range")
+	cmd.Flags().BoolVar(&flagIdle, "quiet-if-empty", false, "Exit 0 with a single line when the target already has the range, its patches, or its changes, so re-runs are idempotent")
+	cmd.Flags().BoolVar(&flagQuiet, "quiet", false, "With --quiet-if-empty, print nothing when there is nothing to transfer")
+	cmd.Flags().BoolVar(&flagTrial, "dry-run-apply", false, "Apply the transfer for real, report the result, then reset the target to its original head (nothing is logged)")
 	cmd.MarkFlagsMutuallyExclusive("message", "edit")
 	cmd.MarkFlagsMutuallyExclusive("message", "auto-message")
 	cmd.MarkFlagsMutuallyExclusive("edit", "auto-message")
-	_ = cmd.MarkFlagRequired("from")
-	_ = cmd.MarkFlagRequired("to")
-	_ = cmd.MarkFlagRequired("range")
+	cmd.MarkFlagsMutuallyExclusive("print-new-head", "dry-run-apply")
+	cmd.MarkFlagsMutuallyExclusive("print-new-head", "export-patches")
+	cmd.MarkFlagsMutuallyExclusive("print-new-head", "apply-patches")
+	cmd.MarkFlagsMutuallyExclusive("keep-message", "message")
+	cmd.MarkFlagsMutuallyExclusive("keep-message", "edit")
+	cmd.MarkFlagsMutuallyExclusive("keep-message", "auto-message")
+	cmd.MarkFlagsMutuallyExclusive("keep-message", "preserve")
+	cmd.MarkFlagsMutuallyExclusive("keep-message", "commit-each")
+	cmd.MarkFlagsMutuallyExclusive("preserve", "message")
+	cmd.MarkFlagsMutuallyExclusive("preserve", "edit")
+	cmd.MarkFlagsMutuallyExclusive("preserve", "auto-message")
+	cmd.MarkFlagsMutuallyExclusive("squash", "preserve", "no-ff", "commit-each")
+	cmd.MarkFlagsMutuallyExclusive("keep-timestamps", "preserve")
+	cmd.MarkFlagsMutuallyExclusive("range", "range-file")
+	cmd.MarkFlagsMutuallyExclusive("dry-run-apply", "tag-after")
+	cmd.MarkFlagsMutuallyExclusive("export-patches", "apply-patches")
+	cmd.MarkFlagsMutuallyExclusive("quiet-if-empty", "allow-empty-range")
+	// --from, --to, and --range are checked in RunE, since the patch
+	// modes each need only some of them.
+	_ = cmd.RegisterFlagCompletionFunc("range", completeRange)
 	cmd.SilenceUsage = true
 	return cmd
 }
 
-func newRevertCmd() *cobra.Command {
-	var (
-		flagOn      string
-		flagRange   string
-		flagMessage string
-	)
-
-	cmd := &cobra.Command{
-		Use:   "revert",
-		Short: "Revert commits on a branch",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx := cmd.Context()
-			if flagOn == "" {
-				return errors.New("--on is required")
-			}
-			if flagRange == "" {
-				return errors.New("--range is required")
-			}
+// rangeCompletionLimit caps how many of the source branch's commits are
+// offered when completing --range.
+const rangeCompletionLimit = 30
+
+// completeRange completes --range with the newest commits on the --from
+// branch, described by their subjects. After "a.." it completes the end of
+// the range.
+func completeRange(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	from, _ := cmd.Flags().GetString("from")
+	if from == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	commits, err := git.RecentCommits(&git.Runner{}, from, rangeCompletionLimit)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
 
-			startHash, endHash, err := parseRangeSpec(flagRange, true)
-			if err != nil {
-				return err
-			}
+	prefix, partial := "", toComplete
+	if start, end, ok := strings.Cut(toComplete, ".."); ok {
+		prefix, partial = start+"..", end
+	}
+	var completions []string
+	for _, commit := range commits {
+		// Full hashes stay unambiguous however large the repository grows.
+		if !strings.HasPrefix(commit.Hash, partial) {
+			continue
+		}
+		completions = append(completions, prefix+commit.Hash+"\t"+commit.Message)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
 
-			message := flagMessage
-			if message == "" {
-				message = fmt.Sprintf("Revert %s on %s", flagRange, flagOn)
-			}
+// checkLFS warns when the range touches LFS-tracked files that would land on
+// the target as bare pointers: git-lfs is not installed, or their objects are
+// not in the local store. It reports whether git lfs pull should run after
+// the transfer, which only happens with pull set. Like the submodule check it
+// is advisory, so git errors are ignored.
+func checkLFS(cmd *cobra.Command, runner *git.Runner, startHash, endHash string, pull bool) bool {
+	paths, _ := git.LFSPaths(runner, fmt.Sprintf("%s^..%s", startHash, endHash))
+	if len(paths) == 0 {
+		return false
+	}
+	if !lfsInstalledFn(runner) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the range changes LFS-tracked file(s) %s but git-lfs is not installed; the target will only get their pointer files.\n", strings.Join(paths, ", "))
+		return false
+	}
+	missing, _ := git.MissingLFSObjects(runner, endHash, paths)
+	if len(missing) == 0 {
+		return false
+	}
+	if !pull {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: LFS objects for %s are not present locally; run 'git lfs pull' after applying, or pass --lfs-pull.\n", strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
+
+// exportPatches writes rangeSpec (start..end, start included) to dir as
+// git format-patch files, for carrying to a machine that cannot fetch.
+func exportPatches(cmd *cobra.Command, rangeSpec, dir string) error {
+	startHash, endHash, err := parseRangeSpec(rangeSpec, false)
+	if err != nil {
+		return err
+	}
+	formatRange := fmt.Sprintf("%s^..%s", startHash, endHash)
+	apply, err := confirmApply(cmd, []string{fmt.Sprintf("git format-patch -o %s %s", dir, formatRange)})
+	if err != nil || !apply {
+		return err
+	}
+
+	patches, err := git.FormatPatch(formatRange, dir)
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Exported %d patch(es) to %s:\n", len(patches), dir)
+	for _, patch := range patches {
+		fmt.Fprintf(out, "  %s\n", patch)
+	}
+	return nil
+}
+
+// applyPatchDir applies the patches exported by exportPatches to target.
+func applyPatchDir(cmd *cobra.Command, runner *git.Runner, dir, target string) error {
+	patches, err := git.PatchFiles(dir)
+	if err != nil {
+		return err
+	}
+	return applyPatches(cmd, runner, dir, patches, target, git.AmOptions{ThreeWay: true})
+}
+
+// applyPatches applies patches to target with git am, logging the result
+// like a transfer from source so it can be undone. A patch that does not
+// apply leaves git am stopped for the conflict to be resolved.
+func applyPatches(cmd *cobra.Command, runner *git.Runner, source string, patches []string, target string, opts git.AmOptions) error {
+	commands := []string{
+		fmt.Sprintf("git checkout %s", target),
+		"git am " + strings.Join(git.AmArgs(patches, opts), " "),
+	}
+	apply, err := confirmApply(cmd, commands)
+	if err != nil || !apply {
+		return err
+	}
+
+	if err := git.CheckoutBranch(runner, target, false); err != nil {
+		return fmt.Errorf("git checkout %s failed: %w", target, err)
+	}
+	heads := git.NewRefResolver(runner)
+	beforeHead, err := heads.Resolve(target)
+	if err != nil {
+		return err
+	}
+	// git am resolves relative paths against the repository, not the caller.
+	absolute := make([]string, 0, len(patches))
+	for _, patch := range patches {
+		path, err := filepath.Abs(patch)
+		if err != nil {
+			return err
+		}
+		absolute = append(absolute, path)
+	}
+	if err := git.ApplyPatches(runner, absolute, opts); err != nil {
+		return err
+	}
+
+	heads.Forget(target)
+	afterHead, err := heads.Resolve(target)
+	if err != nil {
+		return err
+	}
+	newCommits, err := runner.NewCommits(beforeHead, afterHead, target)
+	if err != nil {
+		return err
+	}
+	op := logs.Operation{
+		Kind:       logs.KindImport,
+		Source:     source,
+		Target:     target,
+		Commands:   commands,
+		NewCommits: newCommits,
+		BeforeHead: beforeHead,
+	}
+	if _, err := logsRecordOperationFn(op); err != nil {
+		return err
+	}
+	undo := logs.UndoEntry{
+		Source:     target,
+		Target:     target,
+		BeforeHead: beforeHead,
+		AfterHead:  afterHead,
+		NewCommits: newCommits,
+	}
+	if err := logsPushUndoFn(undo); err != nil {
+		return err
+	}
+
+	if outputFormat(cmd.Context()) == "text" {
+		fmt.Fprintf(cmd.OutOrStdout(), "Applied %d patch(es) to %s.\n", len(newCommits), target)
+	}
+	return nil
+}
+
+// transferMode maps the transfer command's mode flags to a transfer.Mode.
+func transferMode(preserve, each, noFF bool) transfer.Mode {
+	switch {
+	case preserve:
+		return transfer.ModePreserve
+	case each:
+		return transfer.ModeCommitEach
+	case noFF:
+		return transfer.ModeNoFF
+	default:
+		return transfer.ModeSquash
+	}
+}
+
+// transferTargets merges the --to and --targets values in order, dropping
+// blanks and repeats.
+func transferTargets(to, targets []string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, target := range append(append([]string{}, to...), targets...) {
+		target = strings.TrimSpace(target)
+		if target == "" || seen[target] {
+			continue
+		}
+		seen[target] = true
+		merged = append(merged, target)
+	}
+	return merged
+}
+
+// targetResult records how a multi-target transfer went for one target.
+type targetResult struct {
+	target    string
+	attempted bool
+	err       error
+}
+
+func printTargetResults(out io.Writer, results []targetResult) {
+	fmt.Fprintln(out, "Summary:")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case !result.attempted:
+			status = "not attempted"
+		case result.err != nil:
+			// The full error was already reported; keep the table to one line.
+			status = "failed: " + strings.SplitN(result.err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", result.target, status)
+	}
+	tw.Flush()
+}
+
+// checkIdentity stops a command that is about to commit when git has no user
+// identity, since the commit step would otherwise fail part-way through. Dry
+// runs only warn.
+func checkIdentity(cmd *cobra.Command, applying bool) error {
+	message, err := identityProblem(&git.Runner{})
+	if err != nil || message == "" {
+		return err
+	}
+	if !applying {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", message)
+		return nil
+	}
+	return errors.New(message)
+}
+
+// identityProblem describes what is missing from git's user identity and how
+// to set it, or returns "" when the identity is complete.
+func identityProblem(runner *git.Runner) (string, error) {
+	missing, err := git.MissingIdentity(runner)
+	if err != nil || len(missing) == 0 {
+		return "", err
+	}
+
+	examples := map[string]string{"user.name": "Your Name", "user.email": "you@example.com"}
+	hints := make([]string, 0, len(missing))
+	for _, key := range missing {
+		hints = append(hints, fmt.Sprintf("git config --global %s %q", key, examples[key]))
+	}
+	return fmt.Sprintf("git user identity is not configured (%s unset); set it with: %s", strings.Join(missing, " and "), strings.Join(hints, "; ")), nil
+}
+
+// commitRunner returns the runner used by commands that create commits. With
+// cfg.NoGPGSign it overrides commit.gpgsign for every git call; otherwise it
+// warns when the repository signs commits without being asked to.
+func commitRunner(cmd *cobra.Command, cfg *config.Config) *git.Runner {
+	runner := &git.Runner{}
+	if cfg != nil && cfg.NoGPGSign {
+		unsigned := runner.WithConfig(git.NoGPGSignConfig)
+		return &unsigned
+	}
+	if signing, err := git.SigningEnabled(runner); err == nil && signing {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Warning: commit.gpgsign is set, so new commits will be signed; pass --no-gpg-sign if no signing key is available.")
+	}
+	return runner
+}
+
+// sourceMessage returns the full message of the only commit in commits for
+// --keep-message. A longer range has no single message to keep, so it warns
+// and returns "" to fall back to the message template.
+func sourceMessage(cmd *cobra.Command, runner *git.Runner, commits []git.Commit) (string, error) {
+	if len(commits) != 1 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: --keep-message needs a single-commit range, but this one has %d commits; using the message template instead.\n", len(commits))
+		return "", nil
+	}
+	loaded, err := transfer.LoadMessages(runner, commits)
+	if err != nil {
+		return "", err
+	}
+	return loaded[0].Message, nil
+}
+
+// mutatesRepo reports whether cmd changes branches when it applies, and so
+// must hold the repository lock while it runs.
+func mutatesRepo(cmd *cobra.Command) bool {
+	if cmd == cmd.Root() {
+		return true // the TUI transfers with --apply
+	}
+	switch cmd.Name() {
+	case "transfer", "revert", "replay", "import", "restore":
+		return true
+	}
+	return false
+}
+
+// needsRepo reports whether cmd reads the repository. Rendering a
+// format-message template is pure string work unless it comes from the
+// config, so the root checks must not stop it outside a repository.
+func needsRepo(cmd *cobra.Command) bool {
+	if cmd.Name() == "format-message" {
+		fromConfig, _ := cmd.Flags().GetBool("from-config")
+		return fromConfig
+	}
+	return true
+}
+
+// readsRanges reports whether cmd walks commit ranges, which a shallow clone
+// may not have all of.
+func readsRanges(cmd *cobra.Command) bool {
+	switch cmd.Name() {
+	case "transfer", "revert", "replay", "preview":
+		return true
+	}
+	return false
+}
+
+// releaseAfterRun calls release once cmd's RunE returns, whether it succeeds,
+// fails, or is interrupted. PersistentPostRun is skipped after an error, so
+// the lock cannot be released there.
+func releaseAfterRun(cmd *cobra.Command, release func()) {
+	run := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		defer release()
+		return run(cmd, args)
+	}
+}
+
+// rangeApplied reports whether there is nothing left to transfer to target:
+// the range has no commits, or target already contains its last one and so
+// every commit before it. A range git cannot check is not treated as applied.
+func rangeApplied(runner *git.Runner, commits []git.Commit, endHash, target string) bool {
+	if len(commits) == 0 {
+		return true
+	}
+	contained, err := git.IsAncestor(runner, endHash, target)
+	return err == nil && contained
+}
+
+// trialApply runs apply against target for real, reports how many commits it
+// produced, and then puts target (and the checked-out branch) back the way
+// they were. It records no operation and no undo entry.
+func trialApply(cmd *cobra.Command, runner *git.Runner, heads *git.RefResolver, target string, apply func() error) error {
+	original, err := git.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	beforeHead, err := heads.Resolve(target)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Warning: --dry-run-apply commits to %s and then resets it to %s; do not use this repository until it finishes.\n", target, shortHash(beforeHead))
+
+	applyErr := apply()
+	heads.Forget(target)
+	var newCommits []string
+	if applyErr == nil {
+		afterHead, err := heads.Resolve(target)
+		if err == nil {
+			newCommits, err = runner.NewCommits(beforeHead, afterHead, target)
+		}
+		applyErr = err
+	}
+
+	if err := resetTrial(runner, target, beforeHead, original); err != nil {
+		return fmt.Errorf("trial apply could not restore %s: %v; run 'git checkout %s && git reset --hard %s' to restore it", target, err, target, beforeHead)
+	}
+	if applyErr != nil {
+		return fmt.Errorf("trial apply onto %s failed (%s was reset to %s): %w", target, target, shortHash(beforeHead), applyErr)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Trial apply succeeded: would have produced %d commit(s) on %s, no conflicts. %s was reset to %s.\n", len(newCommits), target, target, shortHash(beforeHead))
+	return nil
+}
+
+// resetTrial abandons whatever a trial apply left behind, moves target back
+// to beforeHead, and checks out original again.
+func resetTrial(runner *git.Runner, target, beforeHead, original string) error {
+	if err := git.RestoreBranch(runner, target, beforeHead); err != nil {
+		return err
+	}
+	if original == target || original == "HEAD" {
+		return nil
+	}
+	return git.CheckoutBranch(runner, original, false)
+}
+
+// restoreFailedTarget puts the repository back the way a failed transfer to
+// target found it: any stopped cherry-pick or merge is aborted, target is
+// reset to beforeHead, and original is checked out again. A transfer that
+// failed before changing anything is left alone, and the result reports
+// whether there was anything to restore.
+func restoreFailedTarget(runner *git.Runner, target, beforeHead, original string) (bool, error) {
+	operation, err := runner.InProgressOperation()
+	if err != nil {
+		return false, err
+	}
+	current, err := git.CurrentBranch()
+	if err != nil {
+		return false, err
+	}
+	clean, err := git.IsClean()
+	if err != nil {
+		return false, err
+	}
+	if operation == "" && current == original && clean {
+		return false, nil
+	}
+	if err := resetTrial(runner, target, beforeHead, original); err != nil {
+		return true, err
+	}
+	// A --no-ff transfer stops on its scratch branch.
+	_, _, _ = runner.Run("branch", "-D", transfer.TempBranchName(target))
+	return true, nil
+}
+
+// checkTargetHead refuses to transfer onto target when it is behind its
+// upstream, since pushing the result would not fast-forward. Branches without
+// an upstream pass.
+func checkTargetHead(runner *git.Runner, target string) error {
+	upstream, err := git.Upstream(runner, target)
+	if err != nil || upstream == "" {
+		return err
+	}
+	_, behind, err := git.AheadBehind(runner, target, upstream)
+	if err != nil {
+		return err
+	}
+	if behind > 0 {
+		return fmt.Errorf("target %s is %d commit(s) behind %s; fast-forward it (for example 'git pull --ff-only') and rerun with --refresh, or pass --target-head-check=false", target, behind, upstream)
+	}
+	return nil
+}
+
+// printSummary prints summary as text to cmd's output, or as JSON to jsonOut
+// with --output json.
+func printSummary(cmd *cobra.Command, jsonOut io.Writer, summary transfer.Summary) error {
+	if outputFormat(cmd.Context()) == "json" {
+		return printJSON(jsonOut, summary)
+	}
+	out := cmd.OutOrStdout()
+
+	fmt.Fprintf(out, "Summary: %d applied, %d skipped, %d conflicted\n", len(summary.Applied), len(summary.Skipped), len(summary.Conflicted))
+	for _, hash := range summary.Conflicted {
+		fmt.Fprintf(out, "  conflicted: %s\n", shortHash(hash))
+	}
+	if len(summary.NewCommits) > 0 {
+		fmt.Fprintf(out, "New commits on %s:\n", summary.Target)
+		for _, hash := range summary.NewCommits {
+			fmt.Fprintf(out, "  %s\n", hash)
+		}
+	}
+	return nil
+}
+
+func printJSON(out io.Writer, value any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func commitHashes(commits []git.Commit) []string {
+	hashes := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		hashes = append(hashes, commit.Hash)
+	}
+	return hashes
+}
+
+func newPreviewCmd() *cobra.Command {
+	var (
+		flagFrom      string
+		flagTo        string
+		flagRange     string
+		flagPorcelain bool
+		flagCopy      bool
+		flagOrder     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "preview",
+		Short: "Show the commits and message a transfer would use",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configFromContext(cmd.Context())
+			if cfg == nil {
+				return errors.New("configuration not available")
+			}
+			if flagFrom == "" || flagTo == "" {
+				return errors.New("--from and --to are required")
+			}
+			if flagPorcelain && outputFormat(cmd.Context()) == "json" {
+				return errors.New("--porcelain cannot be combined with --output json")
+			}
+			if _, err := git.CommitOrderArgs(flagOrder); err != nil {
+				return err
+			}
+
+			base, head := flagTo, flagFrom
+			if flagRange != "" {
+				startHash, endHash, err := parseRangeSpec(flagRange, true)
+				if err != nil {
+					return err
+				}
+				base, head = startHash+"^", endHash
+			}
+
+			format := cfg.CommitDisplayFormat
+			if flagPorcelain {
+				// Porcelain output always carries the plain subject.
+				format = git.DefaultDisplayFormat
+			}
+			commits, err := commitsBetweenFn(base, head, format, flagOrder)
+			if err != nil {
+				return err
+			}
+
+			if !flagPorcelain {
+				defer startPager(cmd)()
+			}
+			out := cmd.OutOrStdout()
+			if flagPorcelain {
+				for _, commit := range commits {
+					writePorcelain(out, commit.Hash, commit.Author, commit.Message)
+				}
+				return nil
+			}
+			fmt.Fprintf(out, "Target: %s\n", flagTo)
+			if len(commits) == 0 {
+				fmt.Fprintf(out, "No commits to transfer from %s to %s\n", flagFrom, flagTo)
+				return nil
+			}
+			printCommitPreview(cmd, commits, cfg.PreviewLimit)
+
+			rangeSpec := fmt.Sprintf("%s..%s", commits[0].Hash, commits[len(commits)-1].Hash)
+			message := renderTemplate(cfg.MessageTemplate, flagFrom, flagTo, rangeSpec)
+			fmt.Fprintln(out, "Message:")
+			fmt.Fprintln(out, message)
+			if flagCopy {
+				// A missing clipboard tool should not fail an otherwise useful preview.
+				if err := clipboardCopyFn(message); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Warning: could not copy message: %v\n", err)
+				} else {
+					fmt.Fprintln(cmd.ErrOrStderr(), "Message copied to clipboard.")
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&flagFrom, "from", "", "Source branch")
+	cmd.Flags().StringVar(&flagTo, "to", "", "Target branch")
+	cmd.Flags().StringVar(&flagRange, "range", "", "Commit range (a..b or a) to preview instead of the full branch delta")
+	cmd.Flags().BoolVar(&flagPorcelain, "porcelain", false, "Print every commit as HASH<TAB>AUTHOR<TAB>SUBJECT")
+	cmd.Flags().BoolVar(&flagCopy, "copy", false, "Copy the rendered message to the system clipboard")
+	cmd.Flags().StringVar(&flagOrder, "commit-order", git.OrderDefault, "Order to list commits in: default|topo|date|author-date (git rev-list ordering)")
+	cmd.MarkFlagsMutuallyExclusive("porcelain", "copy")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.RegisterFlagCompletionFunc("range", completeRange)
+	_ = cmd.MarkFlagRequired("to")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func newListCmd() *cobra.Command {
+	var flagPorcelain bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List logged operations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			asJSON := outputFormat(cmd.Context()) == "json"
+			if flagPorcelain && asJSON {
+				return errors.New("--porcelain cannot be combined with --output json")
+			}
+
+			ops, err := logs.ListOperations(logs.OperationsDir())
+			if err != nil {
+				return err
+			}
+
+			if !flagPorcelain {
+				defer startPager(cmd)()
+			}
+			out := cmd.OutOrStdout()
+			switch {
+			case asJSON:
+				return printJSON(out, ops)
+			case flagPorcelain:
+				for _, op := range ops {
+					writePorcelain(out, op.Timestamp.UTC().Format(time.RFC3339), op.Source, op.Target, op.StartHash+".."+op.EndHash)
+				}
+			case len(ops) == 0:
+				fmt.Fprintln(out, "No operations logged.")
+			default:
+				for _, op := range ops {
+					fmt.Fprintf(out, "%s  %s -> %s  %s..%s\n", op.ID, op.Source, op.Target, shortHash(op.StartHash), shortHash(op.EndHash))
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&flagPorcelain, "porcelain", false, "Print each operation as TIMESTAMP<TAB>SOURCE<TAB>TARGET<TAB>RANGE")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func newListBranchesCmd() *cobra.Command {
+	var (
+		flagSort     string
+		flagFilter   string
+		flagMerged   string
+		flagNoMerged string
+		flagRemote   bool
+		flagLocal    bool
+		flagAll      bool
+		flagJSON     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list-branches",
+		Short: "List branches with their latest commit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := path.Match(flagFilter, ""); err != nil {
+				return fmt.Errorf("invalid --filter pattern %q: %w", flagFilter, err)
+			}
+			switch flagSort {
+			case "name", "newest-commit", "author-date":
+			default:
+				return fmt.Errorf("invalid --sort %q (expected name, newest-commit or author-date)", flagSort)
+			}
+
+			branches, err := listBranches(&git.Runner{}, branchFilter{
+				local:    !flagRemote,
+				remote:   flagRemote || flagAll,
+				pattern:  flagFilter,
+				merged:   flagMerged,
+				noMerged: flagNoMerged,
+			})
+			if err != nil {
+				return err
+			}
+			sortBranches(branches, flagSort)
+
+			out := cmd.OutOrStdout()
+			if flagJSON || outputFormat(cmd.Context()) == "json" {
+				return printJSON(out, branches)
+			}
+			defer startPager(cmd)()
+			out = cmd.OutOrStdout()
+			if len(branches) == 0 {
+				fmt.Fprintln(out, "No branches found.")
+				return nil
+			}
+			table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "NAME\tLAST COMMIT\tAUTHOR\tSUBJECT")
+			for _, branch := range branches {
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", branch.Name, branch.CommitDate.Format("2006-01-02"), branch.Author, branch.Subject)
+			}
+			return table.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&flagSort, "sort", "name", "Sort order: name|newest-commit|author-date")
+	cmd.Flags().StringVar(&flagFilter, "filter", "", "Only list branches whose name matches this glob")
+	cmd.Flags().StringVar(&flagMerged, "merged", "", "Only list branches merged into the given commit (default HEAD)")
+	cmd.Flags().StringVar(&flagNoMerged, "no-merged", "", "Only list branches not merged into the given commit (default HEAD)")
+	cmd.Flags().Lookup("merged").NoOptDefVal = "HEAD"
+	cmd.Flags().Lookup("no-merged").NoOptDefVal = "HEAD"
+	cmd.Flags().BoolVar(&flagRemote, "remote", false, "List remote-tracking branches only")
+	cmd.Flags().BoolVar(&flagLocal, "local", false, "List local branches only (default)")
+	cmd.Flags().BoolVar(&flagAll, "all", false, "List local and remote-tracking branches")
+	cmd.Flags().BoolVar(&flagJSON, "json", false, "Print branches as JSON (same as --output json)")
+	cmd.MarkFlagsMutuallyExclusive("merged", "no-merged")
+	cmd.MarkFlagsMutuallyExclusive("local", "remote", "all")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+// branchFilter selects which branches list-branches prints.
+type branchFilter struct {
+	local    bool
+	remote   bool
+	pattern  string
+	merged   string
+	noMerged string
+}
+
+func listBranches(runner *git.Runner, filter branchFilter) ([]git.BranchInfo, error) {
+	var local, remote []string
+	if filter.local {
+		names, err := git.ListBranches()
+		if err != nil {
+			return nil, err
+		}
+		local = names
+	}
+	if filter.remote {
+		names, err := git.RemoteBranches(runner)
+		if err != nil {
+			return nil, err
+		}
+		remote = names
+	}
+
+	var allowed map[string]bool
+	if ref, merged := filter.merged, true; ref != "" || filter.noMerged != "" {
+		if ref == "" {
+			ref, merged = filter.noMerged, false
+		}
+		names, err := git.MergedBranches(runner, ref, merged)
+		if err != nil {
+			return nil, err
+		}
+		allowed = make(map[string]bool, len(names))
+		for _, name := range names {
+			allowed[name] = true
+		}
+	}
+
+	keep := func(names []string) map[string]bool {
+		kept := make(map[string]bool, len(names))
+		for _, name := range names {
+			if allowed != nil && !allowed[name] {
+				continue
+			}
+			if filter.pattern != "" {
+				if ok, _ := path.Match(filter.pattern, name); !ok {
+					continue
+				}
+			}
+			kept[name] = true
+		}
+		return kept
+	}
+	keepLocal, keepRemote := keep(local), keep(remote)
+
+	details, err := git.Branches(runner, filter.remote)
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]git.BranchInfo, 0, len(keepLocal)+len(keepRemote))
+	for _, branch := range details {
+		if branch.Remote && keepRemote[branch.Name] || !branch.Remote && keepLocal[branch.Name] {
+			branches = append(branches, branch)
+		}
+	}
+	return branches, nil
+}
+
+// sortBranches orders branches by name, or newest first by commit or author
+// date, breaking ties by name.
+func sortBranches(branches []git.BranchInfo, order string) {
+	slices.SortStableFunc(branches, func(a, b git.BranchInfo) int {
+		switch order {
+		case "newest-commit":
+			if c := b.CommitDate.Compare(a.CommitDate); c != 0 {
+				return c
+			}
+		case "author-date":
+			if c := b.AuthorDate.Compare(a.AuthorDate); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// writePorcelain prints fields as one tab-separated line. Tabs and line
+// breaks inside a field become spaces so the field count never changes.
+func writePorcelain(out io.Writer, fields ...string) {
+	clean := make([]string, len(fields))
+	for i, field := range fields {
+		clean[i] = strings.Map(func(r rune) rune {
+			switch r {
+			case '\t', '\n', '\r':
+				return ' '
+			}
+			return r
+		}, field)
+	}
+	fmt.Fprintln(out, strings.Join(clean, "\t"))
+}
+
+func printCommitPreview(cmd *cobra.Command, commits []git.Commit, limit int) {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Commits (%d):\n", len(commits))
+	for i, commit := range commits {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(out, "  …and %d more\n", len(commits)-i)
+			return
+		}
+		fmt.Fprintf(out, "  %s  %s  %s\n", shortHash(commit.Hash), commit.Author, commit.Message)
+	}
+}
+
+func newFormatMessageCmd() *cobra.Command {
+	var (
+		flagTemplate   string
+		flagSource     string
+		flagTarget     string
+		flagRange      string
+		flagFromConfig bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "format-message",
+		Short: "Render a message template with sample values",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			template := flagTemplate
+			if flagFromConfig {
+				cfg := configFromContext(cmd.Context())
+				if cfg == nil {
+					return errors.New("configuration not available")
+				}
+				template = cfg.MessageTemplate
+				if template == "" {
+					template = fallbackMessageTemplate
+				}
+			}
+			if template == "" {
+				return errors.New("--template or --from-config is required")
+			}
+
+			message := renderTemplate(template, flagSource, flagTarget, flagRange)
+			defer startPager(cmd)()
+			_, err := fmt.Fprintln(cmd.OutOrStdout(), message)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&flagTemplate, "template", "", "Template to render")
+	cmd.Flags().StringVar(&flagSource, "source", "", "Value for {source}")
+	cmd.Flags().StringVar(&flagTarget, "target", "", "Value for {target}")
+	cmd.Flags().StringVar(&flagRange, "range", "", "Value for {range}")
+	cmd.Flags().BoolVar(&flagFromConfig, "from-config", false, "Render the template from the loaded configuration")
+	cmd.MarkFlagsMutuallyExclusive("template", "from-config")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+// Paging modes chosen by --paginate and --no-pager.
+const (
+	pagerAuto   = "auto"
+	pagerAlways = "always"
+	pagerNever  = "never"
+)
+
+// pagerTerminalFn reports whether w is a terminal worth paging and how many
+// rows it has.
+var pagerTerminalFn = func(w io.Writer) (int, bool) {
+	if w != os.Stdout || !isInteractive(os.Stdout) {
+		return 0, false
+	}
+	_, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height <= 0 {
+		height = 24
+	}
+	return height, true
+}
+
+// pagerCommand returns the pager to use, like git: $GIT_PAGER, then $PAGER,
+// then less. An empty value or "cat" turns paging off.
+func pagerCommand() []string {
+	pager := "less"
+	for _, name := range []string{"GIT_PAGER", "PAGER"} {
+		if value, ok := os.LookupEnv(name); ok {
+			pager = value
+			break
+		}
+	}
+	parts := strings.Fields(pager)
+	if len(parts) == 0 || parts[0] == "cat" {
+		return nil
+	}
+	return parts
+}
+
+// pagerWriter holds output back until it is taller than the terminal, then
+// starts the pager, feeds it what it held, and streams the rest to it.
+// Shorter output is written through on Close.
+type pagerWriter struct {
+	out     io.Writer
+	command []string
+	height  int
+	held    bytes.Buffer
+	pager   *exec.Cmd
+	stdin   io.WriteCloser
+	failed  bool
+}
+
+func (p *pagerWriter) Write(b []byte) (int, error) {
+	if p.stdin != nil {
+		return p.stdin.Write(b)
+	}
+	p.held.Write(b)
+	if !p.failed && bytes.Count(p.held.Bytes(), []byte("\n")) >= p.height {
+		p.start()
+	}
+	return len(b), nil
+}
+
+// start launches the pager; if that fails, output is written through.
+func (p *pagerWriter) start() {
+	pager := exec.Command(p.command[0], p.command[1:]...)
+	pager.Stdout = p.out
+	pager.Stderr = os.Stderr
+	pager.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		// git's defaults: quit if one screen, keep colours, no init sequence.
+		pager.Env = append(pager.Env, "LESS=FRX")
+	}
+	stdin, err := pager.StdinPipe()
+	if err == nil {
+		err = pager.Start()
+	}
+	if err != nil {
+		p.failed = true
+		return
+	}
+	p.pager, p.stdin = pager, stdin
+	_, _ = p.stdin.Write(p.held.Bytes())
+	p.held.Reset()
+}
+
+// Close writes held output or waits for the user to leave the pager.
+func (p *pagerWriter) Close() error {
+	if p.stdin == nil {
+		_, err := p.out.Write(p.held.Bytes())
+		return err
+	}
+	p.stdin.Close()
+	return p.pager.Wait()
+}
+
+// startPager sends the rest of cmd's text output through a pager when it goes
+// to a terminal, unless --no-pager or --output json says otherwise. Without
+// --paginate the pager only starts once the output outgrows the screen. Call
+// the returned func when the command has written everything.
+func startPager(cmd *cobra.Command) func() {
+	ctx := cmd.Context()
+	mode := pagerMode(ctx)
+	if mode == pagerNever || outputFormat(ctx) == "json" {
+		return func() {}
+	}
+	out := cmd.OutOrStdout()
+	height, ok := pagerTerminalFn(out)
+	command := pagerCommand()
+	if !ok || command == nil {
+		return func() {}
+	}
+	if mode == pagerAlways {
+		height = 0
+	}
+	writer := &pagerWriter{out: out, command: command, height: height}
+	cmd.SetOut(writer)
+	return func() {
+		cmd.SetOut(out)
+		// Quitting the pager early is not an error worth reporting.
+		_ = writer.Close()
+	}
+}
+
+func newRevertCmd() *cobra.Command {
+	var (
+		flagOn      string
+		flagRange   string
+		flagRangeIn string
+		flagMessage string
+		flagEdit    bool
+		flagAuto    bool
+		flagTrailer []string
+		flagForce   bool
+		flagMarkers bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "revert",
+		Short: "Revert commits on a branch",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if flagOn == "" {
+				return errors.New("--on is required")
+			}
+			if flagRangeIn != "" {
+				spec, err := readRangeFile(flagRangeIn)
+				if err != nil {
+					return err
+				}
+				flagRange = spec
+			}
+			if flagRange == "" {
+				return errors.New("--range or --range-file is required")
+			}
+			if err := validateTrailers(flagTrailer); err != nil {
+				return err
+			}
+
+			startHash, endHash, err := parseRangeSpec(flagRange, true)
+			if err != nil {
+				return err
+			}
+
+			template := revertMessageTemplate
+			if cfg := configFromContext(ctx); cfg != nil && cfg.RevertMessageTemplate != "" {
+				template = cfg.RevertMessageTemplate
+			}
+			var initial string
+			if flagMessage == "" {
+				initial, err = renderRevertMessage(template, flagOn, flagRange, startHash, endHash)
+				if err != nil {
+					return err
+				}
+			}
+			message, err := resolveMessage(flagMessage, initial, flagEdit && !flagAuto)
+			if err != nil {
+				return err
+			}
+			if message, err = filterMessage(configFromContext(ctx), message); err != nil {
+				return err
+			}
+			if message, err = appendTrailers(message, flagTrailer); err != nil {
+				return err
+			}
 
 			commands := revertPlanFn(flagOn, flagOn, startHash, endHash, message)
-			if !isApply(ctx) {
-				printPlan(cmd, commands)
+			if apply, err := confirmApply(cmd, commands); err != nil || !apply {
+				return err
+			}
+
+			runner := commitRunner(cmd, configFromContext(ctx))
+			if apply, err := confirmDefaultBranch(cmd, runner, configFromContext(ctx), flagOn, flagForce); err != nil || !apply {
+				return err
+			}
+			heads := git.NewRefResolver(runner)
+			beforeHead, err := heads.Resolve(flagOn)
+			if err != nil {
+				return err
+			}
+
+			stats := statsFromContext(ctx)
+			done := stats.track("apply")
+			opts := revert.Options{
+				Source:               flagOn,
+				Target:               flagOn,
+				StartHash:            startHash,
+				EndHash:              endHash,
+				Message:              message,
+				AllowConflictMarkers: flagMarkers,
+				Apply:                true,
+			}
+			result, err := revert.Run(ctx, runner, opts, nil)
+			done()
+			if err != nil {
+				return rollbackOnInterrupt(ctx, runner, flagOn, beforeHead, err)
+			}
+
+			op := result.Operation(opts)
+			op.Commands = commands
+			op.Timings = stats.millis()
+			if result.OperationID, err = logsRecordOperationFn(op); err != nil {
+				return err
+			}
+			if err := logsPushUndoFn(result.UndoEntry()); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Revert applied successfully (operation %s).\n", result.OperationID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&flagOn, "on", "", "Branch to revert on")
+	cmd.Flags().StringVar(&flagRange, "range", "", "Commit or range to revert (a or a..b)")
+	cmd.Flags().StringVar(&flagRangeIn, "range-file", "", "Read the commit or range from a file containing a single a or a..b line")
+	cmd.Flags().StringVar(&flagMessage, "message", "", "Commit message for the revert")
+	cmd.Flags().BoolVar(&flagEdit, "edit", false, "Edit the revert message before applying")
+	cmd.Flags().BoolVar(&flagAuto, "auto-message", false, "Use the default revert message without prompting (revert_message_template)")
+	cmd.Flags().StringArrayVar(&flagTrailer, "trailer", nil, "Append a 'Key: value' git trailer to the commit message; repeatable")
+	cmd.Flags().BoolVar(&flagForce, "force", false, "Revert on the default branch without asking for confirmation")
+	cmd.Flags().BoolVar(&flagMarkers, "allow-conflict-markers", false, "Commit the revert even when staged files still contain conflict markers")
+	_ = cmd.MarkFlagRequired("on")
+	cmd.MarkFlagsMutuallyExclusive("message", "edit")
+	cmd.MarkFlagsMutuallyExclusive("message", "auto-message")
+	cmd.MarkFlagsMutuallyExclusive("edit", "auto-message")
+	cmd.MarkFlagsMutuallyExclusive("range", "range-file")
+	cmd.MarkFlagsOneRequired("range", "range-file")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func newImportCmd() *cobra.Command {
+	var (
+		flagOn       string
+		flagMbox     []string
+		flagSignoff  bool
+		flagThreeWay bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Apply patches from mbox or .patch files onto a branch",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flagOn == "" || len(flagMbox) == 0 {
+				return errors.New("--on and --mbox are required")
+			}
+			for _, path := range flagMbox {
+				if _, err := os.Stat(path); err != nil {
+					return fmt.Errorf("cannot read %s: %w", path, err)
+				}
+			}
+			opts := git.AmOptions{ThreeWay: flagThreeWay, Signoff: flagSignoff}
+			runner := commitRunner(cmd, configFromContext(cmd.Context()))
+			return applyPatches(cmd, runner, strings.Join(flagMbox, ","), flagMbox, flagOn, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&flagOn, "on", "", "Branch to apply the patches on")
+	cmd.Flags().StringArrayVar(&flagMbox, "mbox", nil, "mbox or .patch file to apply; repeat to apply several in order")
+	cmd.Flags().BoolVar(&flagSignoff, "signoff", false, "Add a Signed-off-by trailer to each imported commit")
+	cmd.Flags().BoolVarP(&flagThreeWay, "3way", "3", false, "Fall back to a three-way merge when a patch does not apply cleanly")
+	_ = cmd.MarkFlagRequired("on")
+	_ = cmd.MarkFlagRequired("mbox")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func newRestoreCmd() *cobra.Command {
+	var (
+		flagCommit string
+		flagBranch string
+		flagFromOp string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Create a branch at a previous commit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flagBranch == "" {
+				return errors.New("--branch-name is required")
+			}
+			if err := git.ValidateBranchName(flagBranch); err != nil {
+				return fmt.Errorf("invalid --branch-name %q: %w", flagBranch, err)
+			}
+			if (flagCommit == "") == (flagFromOp == "") {
+				return errors.New("exactly one of --at or --from-operation is required")
+			}
+			if flagFromOp != "" {
+				commit, err := operationBeforeHead(&git.Runner{}, flagFromOp)
+				if err != nil {
+					return err
+				}
+				flagCommit = commit
+			}
+
+			commands := restorePlanFn(flagBranch, flagCommit)
+			if apply, err := confirmApply(cmd, commands); err != nil || !apply {
+				return err
+			}
+
+			opts := restore.Options{Branch: flagBranch, Commit: flagCommit, Audit: logs.NewAuditLog(), Apply: true}
+			result, err := restore.Run(cmd.Context(), &git.Runner{}, opts, nil)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Restore completed successfully (operation %s).\n", result.OperationID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&flagCommit, "at", "", "Commit to restore")
+	cmd.Flags().StringVar(&flagFromOp, "from-operation", "", "Restore the target's head from before a logged operation (an ID, or \"latest\")")
+	cmd.Flags().StringVar(&flagBranch, "branch-name", "", "Branch name to create")
+	cmd.MarkFlagsMutuallyExclusive("at", "from-operation")
+	cmd.MarkFlagsOneRequired("at", "from-operation")
+	_ = cmd.MarkFlagRequired("branch-name")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+// resolveOperationID maps "latest" to the ID of the most recently logged
+// operation in dir and returns any other ID unchanged.
+func resolveOperationID(dir, id string) (string, error) {
+	if id != "latest" {
+		return id, nil
+	}
+	ops, err := logs.ListOperations(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(ops) == 0 {
+		return "", errors.New("no operations have been logged")
+	}
+	return ops[len(ops)-1].ID, nil
+}
+
+// operationBeforeHead returns the head the target branch had before the logged
+// operation id ran. "latest" selects the most recent operation. Entries
+// written before the head was recorded fall back to the parent of the first
+// new commit or of the merge commit.
+func operationBeforeHead(runner *git.Runner, id string) (string, error) {
+	dir := logs.OperationsDir()
+	id, err := resolveOperationID(dir, id)
+	if err != nil {
+		return "", err
+	}
+
+	op, err := logsOperationByIDFn(dir, id)
+	if err != nil {
+		return "", err
+	}
+	if op.BeforeHead != "" {
+		return op.BeforeHead, nil
+	}
+
+	var child string
+	switch {
+	case op.MergeCommit != "":
+		child = op.MergeCommit
+	case len(op.NewCommits) > 0:
+		child = op.NewCommits[0]
+	default:
+		return "", fmt.Errorf("operation %s does not record the head it started from", id)
+	}
+	parent, stderr, err := runner.Run("rev-parse", "--verify", child+"^1")
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse %s^1 failed: %v (%s)", child, err, strings.TrimSpace(stderr))
+	}
+	return strings.TrimSpace(parent), nil
+}
+
+func newUndoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Undo the last GitCherry operation",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entry, ok, err := logsUndoFn()
+			if err != nil {
+				if recovered := printReflogFallback(cmd); recovered {
+					return nil
+				}
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(cmd.OutOrStdout(), "No undo information available.")
+				printReflogFallback(cmd)
+				return nil
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Undo entry: branch=%s before=%s after=%s\n", entry.Source, entry.BeforeHead, entry.AfterHead)
+			fmt.Fprintln(cmd.OutOrStdout(), "Please manually reset your repository as needed (e.g., git reset --hard).")
+			return nil
+		},
+	}
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func newRedoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "redo",
+		Short: "Redo the last undone GitCherry operation",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entry, ok, err := logsRedoFn()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(cmd.OutOrStdout(), "No redo information available.")
+				return nil
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Redo entry: branch=%s before=%s after=%s\n", entry.Source, entry.BeforeHead, entry.AfterHead)
+			fmt.Fprintln(cmd.OutOrStdout(), "Please manually adjust your repository as needed (e.g., git reset --hard).")
+			return nil
+		},
+	}
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func newRefLogCmd() *cobra.Command {
+	var (
+		flagBranch string
+		flagLimit  int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reflog",
+		Short: "Show recent reflog entries for a branch",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			branch := strings.TrimSpace(flagBranch)
+			if branch == "" {
+				current, err := git.CurrentBranch()
+				if err != nil {
+					return err
+				}
+				branch = current
+			}
+
+			entries, err := git.RefLog(&git.Runner{}, branch, flagLimit)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if len(entries) == 0 {
+				fmt.Fprintf(out, "No reflog entries for %s.\n", branch)
 				return nil
 			}
+			for _, entry := range entries {
+				fmt.Fprintf(out, "%s %s %s %s\n", shortHash(entry.Hash), entry.Selector, entry.Date.Format("2006-01-02 15:04:05"), entry.Description)
+			}
+			return nil
+		},
+	}
 
-			runner := &git.Runner{}
-			beforeHead, err := currentHead(runner, flagOn)
-			if err != nil {
-				return err
-			}
+	cmd.Flags().StringVar(&flagBranch, "branch", "", "Branch to inspect (defaults to the current branch)")
+	cmd.Flags().IntVar(&flagLimit, "limit", 20, "Maximum number of entries to show")
+	cmd.SilenceUsage = true
+	return cmd
+}
 
-			if err := revert.Execute(ctx, runner, flagOn, startHash, endHash, message); err != nil {
-				return err
+func newShowCmd() *cobra.Command {
+	var flagDiff bool
+
+	cmd := &cobra.Command{
+		Use:   "show <operation-id>",
+		Short: "Show the details of a logged operation",
+		Long:  "Show the details of an operation stored in .gitcherry/logs. The ID is the log file name, for example 20240102T030405Z.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flagDiff && outputFormat(cmd.Context()) == "json" {
+				return errors.New("--diff cannot be combined with --output json")
 			}
 
-			afterHead, err := currentHead(runner, flagOn)
+			op, err := logsOperationByIDFn(logs.OperationsDir(), args[0])
 			if err != nil {
 				return err
 			}
 
-			op := logs.Operation{
-				Source:    flagOn,
-				Target:    flagOn,
-				StartHash: startHash,
-				EndHash:   endHash,
-				Message:   message,
-				Commands:  commands,
-			}
-			if err := logsWriteOperationFn(op); err != nil {
-				return err
+			defer startPager(cmd)()
+			out := cmd.OutOrStdout()
+			if outputFormat(cmd.Context()) == "json" {
+				if err := printJSON(out, op); err != nil {
+					return err
+				}
+			} else {
+				printOperation(cmd, strings.TrimSuffix(args[0], ".json"), op)
 			}
 
-			undo := logs.UndoEntry{
-				Source:     flagOn,
-				Target:     flagOn,
-				BeforeHead: beforeHead,
-				AfterHead:  afterHead,
+			if !flagDiff {
+				return nil
 			}
-			if err := logsPushUndoFn(undo); err != nil {
+			diff, err := operationDiff(&git.Runner{}, op)
+			if err != nil {
 				return err
 			}
-
-			fmt.Fprintln(cmd.OutOrStdout(), "Revert applied successfully.")
-			return nil
+			fmt.Fprintln(out)
+			_, err = fmt.Fprint(out, diff)
+			return err
 		},
 	}
 
-	cmd.Flags().StringVar(&flagOn, "on", "", "Branch to revert on")
-	cmd.Flags().StringVar(&flagRange, "range", "", "Commit or range to revert (a or a..b)")
-	cmd.Flags().StringVar(&flagMessage, "message", "", "Commit message for the revert")
-	_ = cmd.MarkFlagRequired("on")
-	_ = cmd.MarkFlagRequired("range")
+	cmd.Flags().BoolVar(&flagDiff, "diff", false, "Show the diff of the transferred commits")
 	cmd.SilenceUsage = true
 	return cmd
 }
 
-func newRestoreCmd() *cobra.Command {
+func newReplayCmd() *cobra.Command {
 	var (
-		flagCommit string
-		flagBranch string
+		flagOperation string
+		flagTo        string
+		flagMessage   string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "restore",
-		Short: "Create a branch at a previous commit",
+		Use:   "replay",
+		Short: "Re-apply a logged operation's commit range onto another branch",
+		Long:  "Re-run the transfer recorded by a logged operation onto a new target. The range, source branch, and mode (squash, --preserve, --commit-each with its suffix, or --no-ff) come from the log; the squash message is reused unless --message is given. Reverts, imports, restores, and operations logged without a kind are refused. Pass latest to replay the most recent operation.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if flagCommit == "" || flagBranch == "" {
-				return errors.New("--at and --branch-name are required")
+			dir := logs.OperationsDir()
+			id, err := resolveOperationID(dir, flagOperation)
+			if err != nil {
+				return err
+			}
+			op, err := logsOperationByIDFn(dir, id)
+			if err != nil {
+				return err
+			}
+			switch op.Kind {
+			case logs.KindTransfer:
+			case "":
+				return fmt.Errorf("operation %s does not record what kind of operation it was; only transfers logged by this version can be replayed", id)
+			default:
+				return fmt.Errorf("operation %s was logged by %s; only transfers can be replayed", id, op.Kind)
+			}
+			if op.StartHash == "" || op.EndHash == "" {
+				return fmt.Errorf("operation %s does not record a commit range", id)
 			}
 
-			commands := restorePlanFn(flagBranch, flagCommit)
-			if !isApply(cmd.Context()) {
-				printPlan(cmd, commands)
-				return nil
+			runner := &git.Runner{}
+			for _, hash := range []string{op.StartHash, op.EndHash} {
+				exists, err := git.CommitExists(runner, hash)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return fmt.Errorf("operation %s references commit %s, which no longer exists", id, shortHash(hash))
+				}
 			}
 
-			audit := logs.NewAuditLog()
-			if err := restore.Execute(cmd.Context(), &git.Runner{}, flagBranch, flagCommit, audit); err != nil {
+			transferArgs := []string{"--from", op.Source, "--to", flagTo, "--range", op.StartHash + ".." + op.EndHash}
+			message := op.Message
+			if cmd.Flags().Changed("message") {
+				message = flagMessage
+			}
+			switch transfer.Mode(op.Mode) {
+			case transfer.ModeSquash:
+				transferArgs = append(transferArgs, "--message", message)
+			case transfer.ModeNoFF:
+				transferArgs = append(transferArgs, "--no-ff", "--message", message)
+			case transfer.ModePreserve:
+				transferArgs = append(transferArgs, "--preserve")
+			case transfer.ModeCommitEach:
+				transferArgs = append(transferArgs, "--commit-each")
+				if op.Suffix != "" {
+					transferArgs = append(transferArgs, "--commit-each-message-suffix", op.Suffix)
+				}
+			default:
+				return fmt.Errorf("operation %s has unknown transfer mode %q", id, op.Mode)
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "Replaying operation %s (%s..%s from %s) onto %s\n", id, shortHash(op.StartHash), shortHash(op.EndHash), op.Source, flagTo)
+			transferCmd := newTransferCmd()
+			transferCmd.SetContext(cmd.Context())
+			transferCmd.SetIn(cmd.InOrStdin())
+			transferCmd.SetOut(cmd.OutOrStdout())
+			transferCmd.SetErr(cmd.ErrOrStderr())
+			if err := transferCmd.ParseFlags(transferArgs); err != nil {
 				return err
 			}
+			return transferCmd.RunE(transferCmd, nil)
+		},
+	}
+
+	cmd.Flags().StringVar(&flagOperation, "operation", "", "ID of the logged operation to replay, or latest")
+	cmd.Flags().StringVar(&flagTo, "to", "", "Branch to replay the operation onto")
+	cmd.Flags().StringVar(&flagMessage, "message", "", "Commit message to use instead of the logged one (squash and --no-ff only)")
+	_ = cmd.MarkFlagRequired("operation")
+	_ = cmd.MarkFlagRequired("to")
+	cmd.SilenceUsage = true
+	return cmd
+}
+
+func printOperation(cmd *cobra.Command, id string, op logs.Operation) {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "%-12s %s\n", "ID:", id)
+	fmt.Fprintf(out, "%-12s %s\n", "Timestamp:", op.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(out, "%-12s %s\n", "Status:", op.Status)
+	fmt.Fprintf(out, "%-12s %s\n", "Source:", op.Source)
+	fmt.Fprintf(out, "%-12s %s\n", "Target:", op.Target)
+	fmt.Fprintf(out, "%-12s %s..%s\n", "Range:", op.StartHash, op.EndHash)
+	fmt.Fprintf(out, "%-12s %s\n", "Message:", op.Message)
+	if op.MergeCommit != "" {
+		fmt.Fprintf(out, "%-12s %s\n", "Merge:", op.MergeCommit)
+	}
+	if len(op.Tags) > 0 {
+		fmt.Fprintf(out, "%-12s %s\n", "Tags:", strings.Join(op.Tags, ", "))
+	}
+	if len(op.NewCommits) > 0 {
+		fmt.Fprintln(out, "New commits:")
+		for _, hash := range op.NewCommits {
+			fmt.Fprintf(out, "  %s\n", hash)
+		}
+	}
+	fmt.Fprintln(out, "Commands:")
+	for _, command := range op.Commands {
+		fmt.Fprintf(out, "  %s\n", command)
+	}
+}
+
+// operationDiff renders the changes an operation introduced. Operations that
+// recorded their new commits show those; older entries fall back to the
+// source range.
+func operationDiff(runner *git.Runner, op logs.Operation) (string, error) {
+	args := []string{"show", "--stat", "--patch"}
+	if len(op.NewCommits) > 0 {
+		args = append(args, op.NewCommits...)
+	} else {
+		if op.StartHash == "" || op.EndHash == "" {
+			return "", errors.New("operation has no commits to diff")
+		}
+		args = []string{"diff", "--stat", "--patch", op.StartHash + "^", op.EndHash}
+	}
+	limited := runner.WithMaxOutputBytes(git.DefaultMaxOutputBytes)
+	stdout, stderr, err := limited.Run(append([]string{"-c", "core.quotePath=false"}, args...)...)
+	if errors.Is(err, git.ErrOutputTruncated) {
+		return stdout + fmt.Sprintf("\n[diff truncated after %d bytes]\n", limited.MaxOutputBytes), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v (%s)", args[0], err, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+func newInitCmd() *cobra.Command {
+	var (
+		flagGlobal bool
+		flagReinit bool
+	)
 
-			fmt.Fprintln(cmd.OutOrStdout(), "Restore completed successfully.")
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create the .gitcherry directory and a default config file",
+		// init prepares the repository, so it skips the root checks for a
+		// loadable config and a clean worktree.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			return nil
 		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flagGlobal {
+				return initGlobalConfig(cmd, flagReinit)
+			}
+			return initRepository(cmd, &git.Runner{}, flagReinit)
+		},
 	}
 
-	cmd.Flags().StringVar(&flagCommit, "at", "", "Commit to restore")
-	cmd.Flags().StringVar(&flagBranch, "branch-name", "", "Branch name to create")
-	_ = cmd.MarkFlagRequired("at")
-	_ = cmd.MarkFlagRequired("branch-name")
+	cmd.Flags().BoolVar(&flagGlobal, "global", false, "Initialise the user config file instead of the repository")
+	cmd.Flags().BoolVar(&flagReinit, "reinit", false, "Run even if GitCherry is already initialised")
 	cmd.SilenceUsage = true
 	return cmd
 }
 
-func newUndoCmd() *cobra.Command {
+func newConfigCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "undo",
-		Short: "Undo the last GitCherry operation",
+		Use:   "config",
+		Short: "Inspect GitCherry's configuration",
+		// Reading the config needs neither a clean worktree nor the lock.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	}
+
+	dump := &cobra.Command{
+		Use:   "dump",
+		Short: "Print the effective config as YAML, ready to save as .gitcherry.yml",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			entry, ok, err := logsUndoFn()
+			cfg, err := config.Load(".")
 			if err != nil {
 				return err
 			}
-			if !ok {
-				fmt.Fprintln(cmd.OutOrStdout(), "No undo information available.")
-				return nil
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+			data, err := yaml.Marshal(cfg)
+			if err != nil {
+				return err
 			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	dump.SilenceUsage = true
+	cmd.AddCommand(dump)
+	return cmd
+}
+
+func initRepository(cmd *cobra.Command, runner *git.Runner, reinit bool) error {
+	stdout, stderr, err := runner.Run("rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("git rev-parse --show-toplevel failed: %v (%s)", err, strings.TrimSpace(stderr))
+	}
+	root := strings.TrimSpace(stdout)
+
+	stateDir := filepath.Join(root, ".gitcherry")
+	if _, err := os.Stat(stateDir); err == nil && !reinit {
+		return fmt.Errorf("%s already exists; use --reinit to initialise again", stateDir)
+	}
+	if err := os.MkdirAll(filepath.Join(stateDir, "logs"), 0o755); err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	configPath := config.RepoConfigPath(root)
+	wroteConfig, err := writeFileIfMissing(configPath, config.DefaultFileContents())
+	if err != nil {
+		return err
+	}
+	if wroteConfig {
+		fmt.Fprintf(out, "Wrote default config to %s\n", configPath)
+	} else {
+		fmt.Fprintf(out, "Kept existing config at %s\n", configPath)
+	}
+
+	ignorePath := filepath.Join(root, ".gitignore")
+	if _, err := os.Stat(ignorePath); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		stdout, stderr, err := runner.Run("rev-parse", "--git-path", "info/exclude")
+		if err != nil {
+			return fmt.Errorf("git rev-parse --git-path failed: %v (%s)", err, strings.TrimSpace(stderr))
+		}
+		ignorePath = strings.TrimSpace(stdout)
+		if !filepath.IsAbs(ignorePath) {
+			ignorePath = filepath.Join(root, ignorePath)
+		}
+	}
+	added, err := appendIgnoreEntry(ignorePath, ".gitcherry/")
+	if err != nil {
+		return err
+	}
+	if added {
+		fmt.Fprintf(out, "Ignored .gitcherry/ in %s\n", ignorePath)
+	}
+
+	fmt.Fprintf(out, "Initialised %s.\n", stateDir)
+	fmt.Fprintln(out, "Edit .gitcherry.yml to adjust defaults, then commit it (and .gitignore, if changed) so the worktree is clean before running gitcherry.")
+	return nil
+}
+
+func initGlobalConfig(cmd *cobra.Command, reinit bool) error {
+	path, err := config.HomeConfigPath()
+	if err != nil {
+		return fmt.Errorf("locate user config directory: %w", err)
+	}
+	if _, err := os.Stat(path); err == nil && !reinit {
+		return fmt.Errorf("%s already exists; use --reinit to initialise again", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	wrote, err := writeFileIfMissing(path, config.DefaultFileContents())
+	if err != nil {
+		return err
+	}
+	if wrote {
+		fmt.Fprintf(out, "Wrote default config to %s\n", path)
+	} else {
+		fmt.Fprintf(out, "Kept existing config at %s\n", path)
+	}
+	fmt.Fprintln(out, "Settings here apply to every repository without its own .gitcherry.yml.")
+	return nil
+}
+
+func writeFileIfMissing(path, contents string) (bool, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := file.WriteString(contents); err != nil {
+		file.Close()
+		return false, err
+	}
+	return true, file.Close()
+}
+
+// appendIgnoreEntry adds entry to the ignore file at path unless a line
+// already matches it. It returns whether the file changed.
+func appendIgnoreEntry(path, entry string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == entry || line == strings.TrimSuffix(entry, "/") || line == "/"+entry {
+			return false, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, err
+	}
+	prefix := ""
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		prefix = "\n"
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return false, err
+	}
+	if _, err := file.WriteString(prefix + entry + "\n"); err != nil {
+		file.Close()
+		return false, err
+	}
+	return true, file.Close()
+}
+
+// printReflogFallback reports the latest reflog movement of the current branch
+// when the undo stack cannot help. It returns whether anything was printed.
+func printReflogFallback(cmd *cobra.Command) bool {
+	branch, err := git.CurrentBranch()
+	if err != nil {
+		return false
+	}
+	entries, err := logsRecoverFromReflogFn(&git.Runner{}, branch)
+	if err != nil || len(entries) == 0 {
+		return false
+	}
+
+	latest := entries[len(entries)-1]
+	if latest.BeforeHead == "" {
+		return false
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Reflog fallback: branch=%s before=%s after=%s\n", latest.Source, latest.BeforeHead, latest.AfterHead)
+	fmt.Fprintln(cmd.OutOrStdout(), "Please manually reset your repository as needed (e.g., git reset --hard).")
+	return true
+}
+
+func isApply(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if apply, ok := ctx.Value(ctxApplyKey{}).(bool); ok {
+		return apply
+	}
+	return false
+}
+
+func isDryRunThenApply(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if confirm, ok := ctx.Value(ctxDryRunThenApplyKey{}).(bool); ok {
+		return confirm
+	}
+	return false
+}
+
+func isRefresh(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if refresh, ok := ctx.Value(ctxRefreshKey{}).(bool); ok {
+		return refresh
+	}
+	return false
+}
+
+func isTUI(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if tui, ok := ctx.Value(ctxTUIKey{}).(bool); ok {
+		return tui
+	}
+	return false
+}
+
+func duplicateMode(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if mode, ok := ctx.Value(ctxDuplicateKey{}).(string); ok {
+		return mode
+	}
+	return ""
+}
+
+// phaseStats records the wall time of each phase of an operation for --stats.
+// A nil *phaseStats records nothing, so callers need not check the flag.
+type phaseStats struct {
+	phases []string
+	took   map[string]time.Duration
+	parent *phaseStats
+}
+
+// perTarget snapshots the phases timed so far, which every target of a
+// transfer shares, and returns a func that starts one target's stats from
+// them. Phases timed for a target also add up in s, so --stats still reports
+// the totals.
+func (s *phaseStats) perTarget() func() *phaseStats {
+	if s == nil {
+		return func() *phaseStats { return nil }
+	}
+	phases, took := slices.Clone(s.phases), maps.Clone(s.took)
+	return func() *phaseStats {
+		return &phaseStats{phases: slices.Clone(phases), took: maps.Clone(took), parent: s}
+	}
+}
+
+// track starts timing phase and returns the func that stops it. Time spent in
+// the same phase more than once, as with several targets, adds up.
+func (s *phaseStats) track(phase string) func() {
+	if s == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		s.add(phase, time.Since(start))
+	}
+}
+
+func (s *phaseStats) add(phase string, took time.Duration) {
+	if s.took == nil {
+		s.took = make(map[string]time.Duration)
+	}
+	if _, ok := s.took[phase]; !ok {
+		s.phases = append(s.phases, phase)
+	}
+	s.took[phase] += took
+	if s.parent != nil {
+		s.parent.add(phase, took)
+	}
+}
+
+// millis returns the recorded phases in milliseconds for logs.Operation.
+func (s *phaseStats) millis() map[string]int64 {
+	if s == nil || len(s.took) == 0 {
+		return nil
+	}
+	out := make(map[string]int64, len(s.took))
+	for phase, took := range s.took {
+		out[phase] = took.Milliseconds()
+	}
+	return out
+}
+
+func (s *phaseStats) print(w io.Writer) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintln(w, "Stats:")
+	for _, phase := range s.phases {
+		fmt.Fprintf(w, "  %-12s %s\n", phase, s.took[phase].Round(time.Millisecond))
+	}
+}
+
+func statsFromContext(ctx context.Context) *phaseStats {
+	if ctx == nil {
+		return nil
+	}
+	stats, _ := ctx.Value(ctxStatsKey{}).(*phaseStats)
+	return stats
+}
 
-			fmt.Fprintf(cmd.OutOrStdout(), "Undo entry: branch=%s before=%s after=%s\n", entry.Source, entry.BeforeHead, entry.AfterHead)
-			fmt.Fprintln(cmd.OutOrStdout(), "Please manually reset your repository as needed (e.g., git reset --hard).")
-			return nil
-		},
+// parseLogLevel maps a --log-level value to its slog level.
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
 	}
-	cmd.SilenceUsage = true
-	return cmd
+	return 0, fmt.Errorf("invalid value for --log-level: %s (expected debug, info, warn, or error)", name)
 }
 
-func newRedoCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "redo",
-		Short: "Redo the last undone GitCherry operation",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			entry, ok, err := logsRedoFn()
-			if err != nil {
-				return err
-			}
-			if !ok {
-				fmt.Fprintln(cmd.OutOrStdout(), "No redo information available.")
-				return nil
+// newLogger writes diagnostics at level and above to w, which is stderr in
+// normal use so stdout stays clean for --output json.
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
 			}
-
-			fmt.Fprintf(cmd.OutOrStdout(), "Redo entry: branch=%s before=%s after=%s\n", entry.Source, entry.BeforeHead, entry.AfterHead)
-			fmt.Fprintln(cmd.OutOrStdout(), "Please manually adjust your repository as needed (e.g., git reset --hard).")
-			return nil
+			return attr
 		},
-	}
-	cmd.SilenceUsage = true
-	return cmd
+	}))
 }
 
-func isApply(ctx context.Context) bool {
+func pagerMode(ctx context.Context) string {
 	if ctx == nil {
-		return false
+		return pagerAuto
 	}
-	if apply, ok := ctx.Value(ctxApplyKey{}).(bool); ok {
-		return apply
+	if mode, ok := ctx.Value(ctxPagerKey{}).(string); ok && mode != "" {
+		return mode
 	}
-	return false
+	return pagerAuto
 }
 
-func isRefresh(ctx context.Context) bool {
+func isAssumeYes(ctx context.Context) bool {
 	if ctx == nil {
 		return false
 	}
-	if refresh, ok := ctx.Value(ctxRefreshKey{}).(bool); ok {
-		return refresh
+	if yes, ok := ctx.Value(ctxYesKey{}).(bool); ok {
+		return yes
 	}
 	return false
 }
 
-func isTUI(ctx context.Context) bool {
-	if ctx == nil {
-		return false
-	}
-	if tui, ok := ctx.Value(ctxTUIKey{}).(bool); ok {
-		return tui
+// assumeYes reports a prompt that --yes answered, on stderr and in the session
+// audit log, so a bypassed confirmation is never silent.
+func assumeYes(cmd *cobra.Command, action string) {
+	fmt.Fprintf(cmd.ErrOrStderr(), "Assuming yes (--yes): %s\n", action)
+	audit, err := logs.NewPersistentAuditLog(logs.SessionAuditPath())
+	if err != nil {
+		return
 	}
-	return false
+	audit.Record(logs.Entry{
+		Summary:  "assumed yes: " + action,
+		Metadata: map[string]string{"command": cmd.Name()},
+	})
 }
 
-func duplicateMode(ctx context.Context) string {
+func outputFormat(ctx context.Context) string {
 	if ctx == nil {
-		return ""
+		return "text"
 	}
-	if mode, ok := ctx.Value(ctxDuplicateKey{}).(string); ok {
-		return mode
+	if format, ok := ctx.Value(ctxOutputKey{}).(string); ok && format != "" {
+		return format
 	}
-	return ""
+	return "text"
 }
 
 func isInteractive(file *os.File) bool {
@@ -521,10 +2895,6 @@ func isInteractive(file *os.File) bool {
 	return term.IsTerminal(int(file.Fd()))
 }
 
-func printDryRunNotice(subject string) {
-	fmt.Printf("[dry-run] %s; use --apply to execute\n", subject)
-}
-
 func printPlan(cmd *cobra.Command, commands []string) {
 	out := cmd.OutOrStdout()
 	if len(commands) == 0 {
@@ -537,72 +2907,350 @@ func printPlan(cmd *cobra.Command, commands []string) {
 	}
 }
 
+// confirmApply reports whether the planned commands should be executed. Without
+// --apply the plan is printed instead; with --dry-run-then-apply the user is
+// then asked to approve it, unless --yes approves it or stdin cannot be
+// prompted.
+func confirmApply(cmd *cobra.Command, commands []string) (bool, error) {
+	ctx := cmd.Context()
+	if isApply(ctx) {
+		return true, nil
+	}
+	printPlan(cmd, commands)
+	if !isDryRunThenApply(ctx) || len(commands) == 0 {
+		return false, nil
+	}
+	if isAssumeYes(ctx) {
+		assumeYes(cmd, fmt.Sprintf("applying %d planned commands", len(commands)))
+		return true, nil
+	}
+	if !stdinInteractiveFn() {
+		return false, nil
+	}
+	ok, err := promptYesNoFn("Apply these changes? [y/N]: ")
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		fmt.Fprintln(cmd.OutOrStdout(), "No changes applied.")
+	}
+	return ok, nil
+}
+
+// confirmDefaultBranch reports whether to go ahead with changing target. When
+// target is cfg.DefaultBranch, or the branch git.DefaultBranch detects if
+// that is unset, the user must confirm unless --force or --yes answers for
+// them; without a terminal to ask on, it is an error.
+func confirmDefaultBranch(cmd *cobra.Command, runner *git.Runner, cfg *config.Config, target string, force bool) (bool, error) {
+	branch := defaultBranch(runner, cfg)
+	if branch == "" || target != branch {
+		return true, nil
+	}
+
+	switch {
+	case force:
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: changing the default branch %s (--force).\n", target)
+		return true, nil
+	case isAssumeYes(cmd.Context()):
+		assumeYes(cmd, "changing the default branch "+target)
+		return true, nil
+	case !stdinInteractiveFn():
+		return false, fmt.Errorf("%s is the default branch; confirm interactively or pass --force to change it", target)
+	}
+	ok, err := promptYesNoFn(fmt.Sprintf("%s is the default branch. Change it anyway? [y/N]: ", target))
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		fmt.Fprintln(cmd.OutOrStdout(), "No changes applied.")
+	}
+	return ok, nil
+}
+
+// defaultBranch returns cfg.DefaultBranch, or the branch git.DefaultBranch
+// detects if that is unset. Detection is best effort; "" means the default
+// branch is unknown and not guarded.
+func defaultBranch(runner *git.Runner, cfg *config.Config) string {
+	if cfg != nil {
+		if branch := strings.TrimSpace(cfg.DefaultBranch); branch != "" {
+			return branch
+		}
+	}
+	branch, _ := runner.DefaultBranch()
+	return branch
+}
+
+// trailerPattern matches a "Key: value" git trailer. Keys follow git's token
+// rules: letters, digits, and hyphens, starting with a letter or digit.
+var trailerPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*: \S.*$`)
+
+// validateTrailers rejects --trailer values that are not "Key: value".
+func validateTrailers(trailers []string) error {
+	for _, trailer := range trailers {
+		if strings.ContainsAny(trailer, "\r\n") || !trailerPattern.MatchString(trailer) {
+			return fmt.Errorf("invalid --trailer %q (expected 'Key: value', for example 'Backport-of: abc123')", trailer)
+		}
+	}
+	return nil
+}
+
+// appendTrailers adds trailers to message with git interpret-trailers, which
+// joins an existing trailer block when the message already ends with one.
+func appendTrailers(message string, trailers []string) (string, error) {
+	if len(trailers) == 0 {
+		return message, nil
+	}
+	return git.InterpretTrailers(message, trailers)
+}
+
 func resolveTransferMessage(cmd *cobra.Command, cfg *config.Config, explicit string, edit bool, auto bool, from, to, rangeSpec string) (string, error) {
+	template := cfg.MessageTemplate
+	if template == "" {
+		template = fallbackMessageTemplate
+	}
+	return resolveMessage(explicit, renderTemplate(template, from, to, rangeSpec), edit && !auto)
+}
+
+// resolveMessage returns explicit when it is set, and otherwise the rendered
+// template, opened in the editor first when edit is set.
+func resolveMessage(explicit, rendered string, edit bool) (string, error) {
 	if explicit != "" {
 		return explicit, nil
 	}
-
-	initial := cfg.MessageTemplate
-	if initial == "" {
-		initial = "[Transfer] {source} -> {target} {range}"
+	if edit {
+		return editMessageFn(rendered)
 	}
-	message := renderTemplate(initial, from, to, rangeSpec)
+	return rendered, nil
+}
 
-	if auto {
+// filterMessage pipes message through cfg's message_filter command, when one
+// is set, and returns what the command prints. Like an editor command it is
+// split into arguments and run without a shell. A filter that fails, times
+// out, or prints nothing stops the operation before anything is committed.
+func filterMessage(cfg *config.Config, message string) (string, error) {
+	if cfg == nil || strings.TrimSpace(cfg.MessageFilter) == "" {
 		return message, nil
 	}
-	if edit {
-		return editMessageFn(message)
+	args, err := splitEditorCommand(cfg.MessageFilter)
+	if err != nil {
+		return "", fmt.Errorf("invalid message_filter %q: %w", cfg.MessageFilter, err)
 	}
-	return message, nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageFilterTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	filter := exec.CommandContext(ctx, args[0], args[1:]...)
+	filter.Stdin = strings.NewReader(message + "\n")
+	filter.Stdout = &stdout
+	filter.Stderr = &stderr
+	if err := filter.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("message_filter %q did not finish within %s", cfg.MessageFilter, messageFilterTimeout)
+		}
+		return "", fmt.Errorf("message_filter %q failed: %v (%s)", cfg.MessageFilter, err, strings.TrimSpace(stderr.String()))
+	}
+	filtered := strings.TrimSpace(stdout.String())
+	if filtered == "" {
+		return "", fmt.Errorf("message_filter %q printed an empty message", cfg.MessageFilter)
+	}
+	return filtered, nil
+}
+
+// renderRevertMessage fills in a revert_message_template. {commits} lists the
+// short hashes of the reverted commits, so git is only asked for them when the
+// template uses it.
+func renderRevertMessage(template, branch, rangeSpec, startHash, endHash string) (string, error) {
+	pairs := []string{"{range}", rangeSpec, "{branch}", branch}
+	if strings.Contains(template, "{commits}") {
+		commits, err := git.CommitsBetween(startHash+"^", endHash)
+		if err != nil {
+			return "", fmt.Errorf("list commits for {commits}: %w", err)
+		}
+		hashes := make([]string, 0, len(commits))
+		for _, commit := range commits {
+			hashes = append(hashes, shortHash(commit.Hash))
+		}
+		pairs = append(pairs, "{commits}", strings.Join(hashes, ", "))
+	}
+	return renderPlaceholders(template, pairs...), nil
 }
 
 func renderTemplate(template, source, target, rangeSpec string) string {
-	replacements := map[string]string{
-		"{source}": source,
-		"{target}": target,
-		"{range}":  rangeSpec,
+	return renderPlaceholders(template, "{source}", source, "{target}", target, "{range}", rangeSpec)
+}
+
+// renderPlaceholders replaces the {name} placeholders in template, given as
+// placeholder and value pairs, in a single pass, so a value that contains a
+// placeholder is left as it is.
+func renderPlaceholders(template string, pairs ...string) string {
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+func editMessage(initial string) (string, error) {
+	message, _, err := runEditor(initial)
+	return message, err
+}
+
+// errEditNotSaved reports that --edit-each's editor exited without saving.
+var errEditNotSaved = errors.New("editor closed without saving")
+
+// editEachMessage is editMessage for --edit-each, which treats an editor that
+// exits without saving, or an emptied message, as a request to abort.
+func editEachMessage(initial string) (string, error) {
+	message, saved, err := runEditor(initial)
+	if err != nil {
+		return "", err
 	}
-	result := template
-	for key, value := range replacements {
-		result = strings.ReplaceAll(result, key, value)
+	if !saved || message == "" {
+		return "", errEditNotSaved
 	}
-	return result
+	return message, nil
 }
 
-func editMessage(initial string) (string, error) {
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editor = "vi"
+// editEachCommit loads the full message of every commit and lets the user edit
+// each one in turn, before any of them is applied.
+func editEachCommit(runner *git.Runner, commits []git.Commit) ([]git.Commit, error) {
+	commits, err := transfer.LoadMessages(runner, commits)
+	if err != nil {
+		return nil, err
+	}
+	for i, commit := range commits {
+		message, err := editEachMessageFn(strings.TrimSpace(commit.Message))
+		if err != nil {
+			return nil, fmt.Errorf("editing the message for %s: %w; transfer aborted before applying any commits", shortHash(commit.Hash), err)
+		}
+		commits[i].Message = message
+	}
+	return commits, nil
+}
+
+// resolveEditor picks the editor the way git does: $GIT_EDITOR, core.editor,
+// $VISUAL, $EDITOR, then the platform default.
+func resolveEditor() string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor, ok, err := git.ConfigGet("core.editor"); err == nil && ok && strings.TrimSpace(editor) != "" {
+		return editor
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	return defaultEditor(runtime.GOOS)
+}
+
+// defaultEditor is the editor used when none is configured: notepad on
+// Windows, which has no vi, and vi elsewhere.
+func defaultEditor(goos string) string {
+	if goos == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+// splitEditorCommand splits an editor setting such as "code --wait" into the
+// program and its arguments. Unlike a POSIX shell it keeps backslashes, so
+// Windows paths work: single quotes are literal, and inside double quotes
+// only \" and \\ are escapes.
+func splitEditorCommand(command string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+	)
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+				continue
+			}
+			if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				r = runes[i]
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+			continue
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+			continue
+		}
+		current.WriteRune(r)
+		inArg = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
 	}
+	return args, nil
+}
+
+// runEditor opens the resolved editor on a file holding initial and returns
+// the trimmed result and whether the editor wrote the file.
+func runEditor(initial string) (string, bool, error) {
+	editor := resolveEditor()
 
 	file, err := os.CreateTemp("", "gitcherry-msg-*.txt")
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	defer os.Remove(file.Name())
 
 	if _, err := file.WriteString(initial + "\n"); err != nil {
 		file.Close()
-		return "", err
+		return "", false, err
 	}
 	if err := file.Close(); err != nil {
-		return "", err
+		return "", false, err
+	}
+	// Backdate the file so a save is visible even on coarse-grained clocks.
+	written := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(file.Name(), written, written); err != nil {
+		return "", false, err
 	}
 
-	cmd := exec.Command(editor, file.Name())
+	// Editors are often configured with arguments, such as "code --wait".
+	args, err := splitEditorCommand(editor)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid editor command %q: %w", editor, err)
+	}
+	if len(args) == 0 {
+		args = []string{defaultEditor(runtime.GOOS)}
+	}
+	cmd := exec.Command(args[0], append(args[1:], file.Name())...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		return "", err
+		return "", false, err
 	}
 
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		return "", false, err
+	}
 	data, err := os.ReadFile(file.Name())
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
-	return strings.TrimSpace(string(data)), nil
+	return strings.TrimSpace(string(data)), !info.ModTime().Equal(written), nil
 }
 
 func parseRangeSpec(spec string, allowSingle bool) (string, string, error) {
@@ -626,130 +3274,204 @@ func parseRangeSpec(spec string, allowSingle bool) (string, string, error) {
 	return spec, spec, nil
 }
 
-func runCommands(cmd *cobra.Command, runner *git.Runner, commands []string) error {
-	for _, command := range commands {
-		args, err := splitCommand(command)
-		if err != nil {
-			return err
-		}
-		if len(args) == 0 {
-			continue
-		}
-		if args[0] != "git" {
-			return fmt.Errorf("unsupported command: %s", command)
-		}
-
-		stdout, stderr, err := runner.Run(args[1:]...)
-		if err != nil {
-			return fmt.Errorf("%s failed: %v (%s)", command, err, strings.TrimSpace(stderr))
-		}
-		if out := strings.TrimSpace(stdout); out != "" {
-			fmt.Fprintln(cmd.OutOrStdout(), out)
-		}
+// readRangeFile returns the range spec stored in path, which must hold a
+// single non-empty line such as a..b.
+func readRangeFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read range file: %w", err)
 	}
-	return nil
+	spec := strings.TrimSpace(string(data))
+	if spec == "" {
+		return "", fmt.Errorf("range file %s is empty", path)
+	}
+	if strings.ContainsAny(spec, "\r\n") {
+		return "", fmt.Errorf("range file %s must contain a single line", path)
+	}
+	return spec, nil
 }
 
-func splitCommand(command string) ([]string, error) {
-	var (
-		args     []string
-		current  strings.Builder
-		inQuotes bool
-	)
+// transferTag is the optional tag created on the target once a transfer has
+// been applied.
+type transferTag struct {
+	name    string
+	message string
+}
 
-	runes := []rune(command)
-	for i := 0; i < len(runes); i++ {
-		r := runes[i]
-		switch r {
-		case '"':
-			inQuotes = !inQuotes
-		case ' ':
-			if !inQuotes {
-				if current.Len() > 0 {
-					args = append(args, current.String())
-					current.Reset()
-				}
-				continue
-			}
-			current.WriteRune(r)
-		case '\\':
-			if inQuotes && i+1 < len(runes) {
-				i++
-				current.WriteRune(runes[i])
-			} else {
-				current.WriteRune(r)
-			}
-		default:
-			current.WriteRune(r)
-		}
+// plan appends the tag command to the transfer commands for display and logging.
+func (t transferTag) plan(commands []string, target string) []string {
+	if t.name == "" {
+		return commands
 	}
+	return append(slices.Clone(commands), transfer.PlanTag(target, t.name, t.message))
+}
 
-	if inQuotes {
-		return nil, errors.New("unterminated quote in command")
+func (t transferTag) create(runner *git.Runner, target string) ([]string, error) {
+	if t.name == "" {
+		return nil, nil
 	}
-	if current.Len() > 0 {
-		args = append(args, current.String())
+	if err := git.CreateAnnotatedTag(runner, t.name, target, t.message); err != nil {
+		return nil, fmt.Errorf("transfer applied but tagging %s failed: %w", target, err)
 	}
-	return args, nil
+	return []string{t.name}, nil
 }
 
-func collectCommitsForRange(runner *git.Runner, start, end string) ([]git.Commit, error) {
-	if runner == nil {
-		runner = &git.Runner{}
+func (t transferTag) remove(runner *git.Runner) {
+	if t.name != "" {
+		_ = git.DeleteTag(runner, t.name)
 	}
+}
 
-	specs := []string{fmt.Sprintf("%s^..%s", start, end), fmt.Sprintf("%s..%s", start, end)}
-	var hashes []string
-	for idx, spec := range specs {
-		out, stderr, err := runner.Run("rev-list", "--reverse", spec)
-		if err != nil {
-			if idx == len(specs)-1 {
-				return nil, fmt.Errorf("git rev-list %s failed: %v (%s)", spec, err, strings.TrimSpace(stderr))
-			}
-			continue
-		}
+// expandSparse adds the directories touched by start^..end to the sparse
+// checkout cone and returns those that were not already included.
+func expandSparse(runner *git.Runner, start, end string) ([]string, error) {
+	current, err := git.SparseList(runner)
+	if err != nil {
+		return nil, fmt.Errorf("--auto-sparse: %w", err)
+	}
+
+	files, err := runner.RunNUL("-c", "core.quotePath=false", "diff", "-z", "--name-only", start+"^", end)
+	if err != nil {
+		return nil, fmt.Errorf("git diff --name-only failed: %w", err)
+	}
 
-		lines := strings.Fields(strings.TrimSpace(out))
-		if len(lines) == 0 && idx == 0 {
+	var added []string
+	for _, file := range files {
+		dir := path.Dir(file)
+		if dir == "." || slices.Contains(added, dir) || inSparseCone(current, dir) {
 			continue
 		}
-		hashes = lines
-		if idx == 1 && (len(hashes) == 0 || hashes[0] != start) {
-			hashes = append([]string{start}, hashes...)
-		}
-		break
+		added = append(added, dir)
+	}
+
+	if err := git.SparseAdd(runner, added); err != nil {
+		return nil, err
 	}
+	return added, nil
+}
 
-	if len(hashes) == 0 {
-		hashes = []string{start}
-		if start != end {
-			hashes = append(hashes, end)
+func inSparseCone(cone []string, dir string) bool {
+	for _, included := range cone {
+		if dir == included || strings.HasPrefix(dir, included+"/") {
+			return true
 		}
 	}
+	return false
+}
 
-	commits := make([]git.Commit, 0, len(hashes))
-	for _, hash := range hashes {
-		commits = append(commits, git.Commit{Hash: hash})
+// refreshRemote fetches remote (or every remote when empty), pruning deleted
+// refs and including tags. A named remote must exist.
+func refreshRemote(runner *git.Runner, remote string) error {
+	if err := checkRemote(runner, remote); err != nil {
+		return err
 	}
-	return commits, nil
+	return git.FetchWithOptions(runner, git.FetchOptions{Remote: remote, Prune: true, Tags: true})
+}
+
+// checkRemote returns an error when remote is named but not configured.
+func checkRemote(runner *git.Runner, remote string) error {
+	if remote == "" {
+		return nil
+	}
+	remotes, err := git.ListRemotes(runner)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(remotes, remote) {
+		return fmt.Errorf("remote %q does not exist (configured remotes: %s)", remote, strings.Join(remotes, ", "))
+	}
+	return nil
+}
+
+// deepenHistory fetches more history into a shallow clone from remote (git's
+// default remote when empty): all of it, or depth more commits when depth is
+// positive. It reports how many commits arrived and whether the clone is
+// still shallow. A complete repository is left alone.
+func deepenHistory(cmd *cobra.Command, runner *git.Runner, remote string, depth int) error {
+	shallow, err := git.IsShallow(runner)
+	if err != nil || !shallow {
+		return err
+	}
+	if err := checkRemote(runner, remote); err != nil {
+		return err
+	}
+	before, err := git.CommitCount(runner)
+	if err != nil {
+		return err
+	}
+	if err := git.FetchWithOptions(runner, git.FetchOptions{Remote: remote, Deepen: depth, Unshallow: depth == 0}); err != nil {
+		return err
+	}
+	after, err := git.CommitCount(runner)
+	if err != nil {
+		return err
+	}
+	if shallow, err = git.IsShallow(runner); err != nil {
+		return err
+	}
+
+	source, state := remote, "the clone now has its full history"
+	if source == "" {
+		source = "the default remote"
+	}
+	if shallow {
+		state = "it is still shallow"
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Fetched %d more commit(s) from %s; %s.\n", after-before, source, state)
+	return nil
+}
+
+// abortInProgress cancels a stopped cherry-pick or revert so a new operation
+// can start. Other operations are left for the user to resolve because
+// aborting them could discard work GitCherry knows nothing about.
+func abortInProgress(operation string) error {
+	switch operation {
+	case "cherry-pick":
+		return git.AbortCherryPick()
+	case "revert":
+		return git.AbortRevert()
+	default:
+		return fmt.Errorf("--force-clean only aborts cherry-picks and reverts; finish or abort the %s with 'git %s --continue' or 'git %s --abort'", operation, operation, operation)
+	}
+}
+
+// rollbackOnInterrupt restores target to beforeHead when applyErr was caused
+// by the context being cancelled (for example by SIGINT) part-way through an
+// apply. Any other error is returned unchanged.
+func rollbackOnInterrupt(ctx context.Context, runner *git.Runner, target, beforeHead string, applyErr error) error {
+	if ctx.Err() == nil {
+		return applyErr
+	}
+	if err := git.RestoreBranch(runner, target, beforeHead); err != nil {
+		return fmt.Errorf("interrupted; rollback failed: %w", err)
+	}
+	return fmt.Errorf("interrupted: rolled back %s to %s", target, shortHash(beforeHead))
 }
 
-func handleDuplicateChoice(cmd *cobra.Command, mode string, duplicates []git.Commit) (bool, error) {
+// duplicatePolicy maps an --on-duplicate mode to transfer.Options'
+// ApplyDuplicates and ConfirmDuplicates. "ask" prompts, or assumes yes with
+// --yes, and skips the duplicates when it cannot prompt.
+func duplicatePolicy(cmd *cobra.Command, mode string) (bool, func([]git.Commit) (bool, error), error) {
 	switch mode {
 	case "skip":
-		fmt.Fprintf(cmd.OutOrStdout(), "Detected %d duplicate patches; skipping.\n", len(duplicates))
-		return false, nil
+		return false, nil, nil
 	case "apply":
-		return true, nil
+		return true, nil, nil
 	case "ask":
-		if !isInteractive(os.Stdin) {
-			fmt.Fprintln(cmd.OutOrStdout(), "Detected duplicate patches but cannot prompt; skipping.")
-			return false, nil
-		}
-		example := shortHash(duplicates[0].Hash)
-		return promptYesNo(fmt.Sprintf("Detected %d duplicate patches already on target (e.g., %s). Apply anyway? [y/N]: ", len(duplicates), example))
+		return false, func(duplicates []git.Commit) (bool, error) {
+			if isAssumeYes(cmd.Context()) {
+				assumeYes(cmd, fmt.Sprintf("applying %d duplicate patches already on target (e.g., %s)", len(duplicates), shortHash(duplicates[0].Hash)))
+				return true, nil
+			}
+			if !isInteractive(os.Stdin) {
+				fmt.Fprintln(cmd.OutOrStdout(), "Detected duplicate patches but cannot prompt; skipping.")
+				return false, nil
+			}
+			example := shortHash(duplicates[0].Hash)
+			return promptYesNoFn(fmt.Sprintf("Detected %d duplicate patches already on target (e.g., %s). Apply anyway? [y/N]: ", len(duplicates), example))
+		}, nil
 	default:
-		return false, fmt.Errorf("unknown duplicate mode: %s", mode)
+		return false, nil, fmt.Errorf("unknown duplicate mode: %s", mode)
 	}
 }
 
@@ -775,10 +3497,10 @@ func shortHash(hash string) string {
 	return hash[:6]
 }
 
-func currentHead(runner *git.Runner, ref string) (string, error) {
-	stdout, stderr, err := runner.Run("rev-parse", ref)
+func committerDate(runner *git.Runner, ref string) (string, error) {
+	date, err := git.CommitDate(runner, ref)
 	if err != nil {
-		return "", fmt.Errorf("git rev-parse %s failed: %v (%s)", ref, err, strings.TrimSpace(stderr))
+		return "", err
 	}
-	return strings.TrimSpace(stdout), nil
+	return date.Format(time.RFC3339), nil
 }
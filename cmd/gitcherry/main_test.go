@@ -3,12 +3,29 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 
+	"github.com/julianchen24/gitcherry/internal/clipboard"
 	"github.com/julianchen24/gitcherry/internal/config"
 	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/internal/logs"
+	"github.com/julianchen24/gitcherry/internal/ops/transfer"
 	"github.com/julianchen24/gitcherry/tests/repohelper"
 )
 
@@ -45,6 +62,39 @@ func TestRootCommandUnknownFlag(t *testing.T) {
 	require.Contains(t, err.Error(), "unknown flag")
 }
 
+func TestLogLevelDebugShowsDiagnostics(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	original := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(original) })
+
+	run := func(args ...string) (string, string) {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		root.SetArgs(args)
+		var stdout, stderr bytes.Buffer
+		root.SetOut(&stdout)
+		root.SetErr(&stderr)
+		require.NoError(t, root.Execute())
+		return stdout.String(), stderr.String()
+	}
+
+	stdout, stderr := run("--log-level", "debug", "list", "--output", "json")
+	require.Contains(t, stderr, "level=DEBUG msg=\"loaded config\" source=defaults")
+	require.Contains(t, stderr, "level=DEBUG msg=\"running git\"")
+	require.NotContains(t, stdout, "level=DEBUG")
+
+	_, stderr = run("list")
+	require.NotContains(t, stderr, "level=DEBUG")
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"--log-level", "verbose", "list"})
+	root.SetOut(io.Discard)
+	root.SetErr(io.Discard)
+	require.ErrorContains(t, root.Execute(), "invalid value for --log-level: verbose")
+}
+
 func TestRootCommandFailsWhenDirty(t *testing.T) {
 	repo := repohelper.Init(t)
 	repohelper.Chdir(t, repo.Path)
@@ -64,27 +114,82 @@ func TestRootCommandFailsWhenDirty(t *testing.T) {
 	require.EqualError(t, err, dirtyWorktreeMessage)
 }
 
+func TestRootCommandFailsWhenCherryPickInProgress(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	repo.MustRun(t, "checkout", "-b", "release")
+	repo.CommitFile(t, "conflict.txt", "release\n", "release change")
+	repo.MustRun(t, "checkout", "main")
+	conflicting := repo.CommitFile(t, "conflict.txt", "main\n", "main change")
+	repo.MustRun(t, "checkout", "release")
+	_, _, err := repo.Run("cherry-pick", conflicting)
+	require.Error(t, err)
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"revert", "--on", "release", "--range", conflicting})
+
+	var buf bytes.Buffer
+	root.SetOut(&buf)
+	root.SetErr(&buf)
+
+	err = root.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a cherry-pick is already in progress")
+	require.Contains(t, err.Error(), "git cherry-pick --abort")
+
+	// Commands that only read still run, conflict and all.
+	root = newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"reflog", "--limit", "1"})
+	root.SetOut(&buf)
+	root.SetErr(&buf)
+	require.NoError(t, root.Execute())
+}
+
+func TestRootCommandForceCleanAbortsCherryPick(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "checkout", "-b", "release")
+	release := repo.CommitFile(t, "conflict.txt", "release\n", "release change")
+	repo.MustRun(t, "checkout", "main")
+	conflicting := repo.CommitFile(t, "conflict.txt", "main\n", "main change")
+	repo.MustRun(t, "checkout", "release")
+	_, _, err := repo.Run("cherry-pick", conflicting)
+	require.Error(t, err)
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"--force-clean", "reflog", "--limit", "1"})
+
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+
+	require.NoError(t, root.Execute())
+	require.Contains(t, stderr.String(), "Warning: aborted the in-progress cherry-pick")
+
+	operation, err := (&git.Runner{Dir: repo.Path}).InProgressOperation()
+	require.NoError(t, err)
+	require.Empty(t, operation)
+	require.Equal(t, release, strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+}
+
 func TestTransferDryRunUsesPlan(t *testing.T) {
-	origPlan := transferPlanFn
-	defer func() { transferPlanFn = origPlan }()
 	origRange := commitRangeFn
 	defer func() { commitRangeFn = origRange }()
 	origDup := transferDetectDuplicatesFn
 	defer func() { transferDetectDuplicatesFn = origDup }()
 
-	var captured struct {
-		from, to, start, end, message string
-	}
-	transferPlanFn = func(from, to, start, end, message string) []string {
-		captured = struct {
-			from, to, start, end, message string
-		}{from, to, start, end, message}
-		return []string{"git checkout " + to}
-	}
-	commitRangeFn = func(*git.Runner, string, string) ([]git.Commit, error) {
+	commitRangeFn = func(*git.Runner, string, string, string) ([]git.Commit, error) {
 		return []git.Commit{{Hash: "a"}, {Hash: "b"}}, nil
 	}
-	transferDetectDuplicatesFn = func(*git.Runner, string, []git.Commit) ([]git.Commit, error) {
+	transferDetectDuplicatesFn = func(transfer.Options, context.Context, *git.Runner, []git.Commit, io.Writer) ([]git.Commit, error) {
 		return nil, nil
 	}
 
@@ -106,31 +211,20 @@ func TestTransferDryRunUsesPlan(t *testing.T) {
 	require.NoError(t, cmd.Flags().Set("message", "custom"))
 
 	require.NoError(t, cmd.Execute())
-	require.Equal(t, "main", captured.from)
-	require.Equal(t, "feature", captured.to)
-	require.Equal(t, "a", captured.start)
-	require.Equal(t, "b", captured.end)
-	require.Equal(t, "custom", captured.message)
 	require.Contains(t, buf.String(), "Planned commands")
+	require.Contains(t, buf.String(), strings.Join(transfer.Plan("main", "feature", "a", "b", "custom"), "\n  "))
 }
 
 func TestTransferSkipsWhenDuplicatesAndModeSkip(t *testing.T) {
-	origPlan := transferPlanFn
-	defer func() { transferPlanFn = origPlan }()
 	origRange := commitRangeFn
 	defer func() { commitRangeFn = origRange }()
 	origDup := transferDetectDuplicatesFn
 	defer func() { transferDetectDuplicatesFn = origDup }()
 
-	called := false
-	transferPlanFn = func(from, to, start, end, message string) []string {
-		called = true
-		return nil
-	}
-	commitRangeFn = func(*git.Runner, string, string) ([]git.Commit, error) {
+	commitRangeFn = func(*git.Runner, string, string, string) ([]git.Commit, error) {
 		return []git.Commit{{Hash: "a"}}, nil
 	}
-	transferDetectDuplicatesFn = func(*git.Runner, string, []git.Commit) ([]git.Commit, error) {
+	transferDetectDuplicatesFn = func(transfer.Options, context.Context, *git.Runner, []git.Commit, io.Writer) ([]git.Commit, error) {
 		return []git.Commit{{Hash: "dup"}}, nil
 	}
 
@@ -151,10 +245,123 @@ func TestTransferSkipsWhenDuplicatesAndModeSkip(t *testing.T) {
 	require.NoError(t, cmd.Flags().Set("range", "a..a"))
 
 	require.NoError(t, cmd.Execute())
-	require.False(t, called)
+	require.NotContains(t, buf.String(), "Planned commands")
 	require.Contains(t, buf.String(), "Skipping transfer")
 }
 
+func TestTransferReadsRangeFile(t *testing.T) {
+	origRange := commitRangeFn
+	defer func() { commitRangeFn = origRange }()
+	origDup := transferDetectDuplicatesFn
+	defer func() { transferDetectDuplicatesFn = origDup }()
+
+	commitRangeFn = func(*git.Runner, string, string, string) ([]git.Commit, error) {
+		return []git.Commit{{Hash: "abc"}, {Hash: "def"}}, nil
+	}
+	transferDetectDuplicatesFn = func(transfer.Options, context.Context, *git.Runner, []git.Commit, io.Writer) ([]git.Commit, error) {
+		return nil, nil
+	}
+
+	rangeFile := filepath.Join(t.TempDir(), "range")
+	require.NoError(t, os.WriteFile(rangeFile, []byte("  abc..def\n"), 0o600))
+
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	ctx := context.WithValue(context.Background(), ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+	cmd.SetArgs([]string{"--from", "main", "--to", "feature", "--message", "m", "--range-file", rangeFile})
+
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), "git cherry-pick --no-commit abc^..def")
+
+	cmd = newTransferCmd()
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(ctx)
+	cmd.SetArgs([]string{"--from", "main", "--to", "feature", "--range", "a..b", "--range-file", rangeFile})
+	require.ErrorContains(t, cmd.Execute(), "none of the others can be")
+}
+
+func TestTransferPassesDuplicateStrategy(t *testing.T) {
+	origRange := commitRangeFn
+	defer func() { commitRangeFn = origRange }()
+	origDup := transferDetectDuplicatesFn
+	defer func() { transferDetectDuplicatesFn = origDup }()
+
+	commitRangeFn = func(*git.Runner, string, string, string) ([]git.Commit, error) {
+		return []git.Commit{{Hash: "a"}, {Hash: "b"}}, nil
+	}
+	var strategies []string
+	transferDetectDuplicatesFn = func(opts transfer.Options, _ context.Context, _ *git.Runner, commits []git.Commit, _ io.Writer) ([]git.Commit, error) {
+		strategies = append(strategies, opts.Strategy)
+		return commits[:1], nil
+	}
+
+	run := func(args ...string) (string, error) {
+		cmd := newTransferCmd()
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetErr(out)
+		ctx := context.WithValue(context.Background(), ctxConfigKey{}, config.Default())
+		ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+		cmd.SetContext(ctx)
+		cmd.SetArgs(append([]string{"--from", "main", "--to", "feature", "--range", "a..b", "--message", "m"}, args...))
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	out, err := run()
+	require.NoError(t, err)
+	require.Contains(t, out, "Detected 1 duplicate patches; skipping.")
+	require.Contains(t, out, "Skipping transfer due to duplicate patches.")
+
+	_, err = run("--duplicate-strategy", "heuristic")
+	require.NoError(t, err)
+	require.Equal(t, []string{transfer.StrategyPatchID, transfer.StrategyHeuristic}, strategies)
+
+	_, err = run("--duplicate-strategy", "fuzzy")
+	require.ErrorContains(t, err, `invalid --duplicate-strategy "fuzzy"`)
+}
+
+func TestTransferPreserveDryRunPlansEachCommit(t *testing.T) {
+	origRange := commitRangeFn
+	defer func() { commitRangeFn = origRange }()
+	origDup := transferDetectDuplicatesFn
+	defer func() { transferDetectDuplicatesFn = origDup }()
+
+	commitRangeFn = func(*git.Runner, string, string, string) ([]git.Commit, error) {
+		return []git.Commit{{Hash: "a"}, {Hash: "b"}}, nil
+	}
+	transferDetectDuplicatesFn = func(transfer.Options, context.Context, *git.Runner, []git.Commit, io.Writer) ([]git.Commit, error) {
+		return nil, nil
+	}
+
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, false)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "main"))
+	require.NoError(t, cmd.Flags().Set("to", "feature"))
+	require.NoError(t, cmd.Flags().Set("range", "a..b"))
+	require.NoError(t, cmd.Flags().Set("preserve", "true"))
+
+	require.NoError(t, cmd.Execute())
+	out := buf.String()
+	require.Contains(t, out, "git cherry-pick a\n")
+	require.Contains(t, out, "git cherry-pick b\n")
+	require.NotContains(t, out, "--no-commit")
+}
+
 func TestRevertDryRunUsesPlan(t *testing.T) {
 	origPlan := revertPlanFn
 	defer func() { revertPlanFn = origPlan }()
@@ -186,6 +393,152 @@ func TestRevertDryRunUsesPlan(t *testing.T) {
 	require.Contains(t, buf.String(), "Planned commands")
 }
 
+func TestRevertUsesConfiguredMessageTemplate(t *testing.T) {
+	repo := repohelper.Init(t)
+	first := repo.CommitFile(t, "a.txt", "a\n", "add a")
+	second := repo.CommitFile(t, "b.txt", "b\n", "add b")
+	repohelper.Chdir(t, repo.Path)
+
+	origPlan := revertPlanFn
+	defer func() { revertPlanFn = origPlan }()
+	var message string
+	revertPlanFn = func(source, target, start, end, m string) []string {
+		message = m
+		return []string{"git revert --no-commit"}
+	}
+
+	cfg := config.Default()
+	cfg.RevertMessageTemplate = "Back out {commits} ({range}) on {branch}"
+	cmd := newRevertCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, cfg))
+	rangeSpec := first + ".." + second
+	cmd.SetArgs([]string{"--on", "main", "--range", rangeSpec})
+	require.NoError(t, cmd.Execute())
+
+	require.Equal(t, fmt.Sprintf("Back out %s, %s (%s) on main", shortHash(first), shortHash(second), rangeSpec), message)
+}
+
+func TestRenderPlaceholdersLeavesPlaceholdersInValues(t *testing.T) {
+	for range 20 {
+		require.Equal(t, "Backport {target} fix from main to release", renderTemplate("Backport {range} from {source} to {target}", "main", "release", "{target} fix"))
+	}
+	message, err := renderRevertMessage("Revert {range} on {branch}", "main", "{branch}..b", "a", "b")
+	require.NoError(t, err)
+	require.Equal(t, "Revert {branch}..b on main", message)
+}
+
+func TestRevertEditOpensEditor(t *testing.T) {
+	origPlan := revertPlanFn
+	origEdit := editMessageFn
+	defer func() {
+		revertPlanFn = origPlan
+		editMessageFn = origEdit
+	}()
+
+	var message string
+	revertPlanFn = func(source, target, start, end, m string) []string {
+		message = m
+		return []string{"git revert --no-commit"}
+	}
+	var initial string
+	editMessageFn = func(s string) (string, error) {
+		initial = s
+		return "Revert flaky retry change", nil
+	}
+
+	cmd := newRevertCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"--on", "main", "--range", "a..b", "--edit"})
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "Revert a..b on main", initial)
+	require.Equal(t, "Revert flaky retry change", message)
+}
+
+func TestRevertAutoMessageSkipsEditor(t *testing.T) {
+	origPlan := revertPlanFn
+	origEdit := editMessageFn
+	defer func() {
+		revertPlanFn = origPlan
+		editMessageFn = origEdit
+	}()
+
+	var message string
+	revertPlanFn = func(source, target, start, end, m string) []string {
+		message = m
+		return []string{"git revert --no-commit"}
+	}
+	editMessageFn = func(string) (string, error) {
+		t.Fatal("editor should not open with --auto-message")
+		return "", nil
+	}
+
+	cmd := newRevertCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"--on", "release", "--range", "abc123", "--auto-message"})
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "Revert abc123 on release", message)
+
+	cmd = newRevertCmd()
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"--on", "main", "--range", "a..b", "--edit", "--auto-message"})
+	require.Error(t, cmd.Execute())
+}
+
+func TestRevertReadsRangeFile(t *testing.T) {
+	origPlan := revertPlanFn
+	defer func() { revertPlanFn = origPlan }()
+
+	var start, end, message string
+	revertPlanFn = func(source, target, s, e, m string) []string {
+		start, end, message = s, e, m
+		return []string{"git revert --no-commit"}
+	}
+
+	dir := t.TempDir()
+	single := filepath.Join(dir, "single")
+	require.NoError(t, os.WriteFile(single, []byte("abc123\n"), 0o600))
+
+	cmd := newRevertCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"--on", "main", "--range-file", single})
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "abc123", start)
+	require.Equal(t, "abc123", end)
+	require.Equal(t, "Revert abc123 on main", message)
+
+	for name, content := range map[string]string{"empty": " \n", "multi": "a..b\nc..d\n"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		cmd := newRevertCmd()
+		cmd.SetOut(buf)
+		cmd.SetErr(buf)
+		cmd.SetContext(context.Background())
+		cmd.SetArgs([]string{"--on", "main", "--range-file", path})
+		require.Error(t, cmd.Execute(), name)
+	}
+
+	cmd = newRevertCmd()
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"--on", "main", "--range-file", filepath.Join(dir, "missing")})
+	require.ErrorContains(t, cmd.Execute(), "read range file")
+}
+
 func TestRestoreDryRunUsesPlan(t *testing.T) {
 	origPlan := restorePlanFn
 	defer func() { restorePlanFn = origPlan }()
@@ -212,3 +565,2395 @@ func TestRestoreDryRunUsesPlan(t *testing.T) {
 	require.Equal(t, "abc", captured.commit)
 	require.Contains(t, buf.String(), "Planned commands")
 }
+
+func TestRestoreFromOperationUsesBeforeHead(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(repo.Path)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+
+	transferCmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+first, "--message", "Squashed")
+	require.NoError(t, transferCmd.Execute())
+	require.NotEqual(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, before, ops[0].BeforeHead)
+
+	for _, id := range []string{"latest", ops[0].ID} {
+		branch := "before-" + strings.ReplaceAll(id, "T", "-")
+		cmd := newRestoreCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetContext(context.WithValue(context.Background(), ctxApplyKey{}, true))
+		cmd.SetArgs([]string{"--from-operation", id, "--branch-name", branch})
+		require.NoError(t, cmd.Execute())
+		require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", branch)))
+	}
+
+	cmd := newRestoreCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"--from-operation", "latest", "--at", before, "--branch-name", "x"})
+	require.Error(t, cmd.Execute())
+}
+
+func TestRefLogCommandListsEntries(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	hash := repo.CommitFile(t, "a.txt", "a\n", "add a")
+
+	cmd := newRefLogCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	require.NoError(t, cmd.Flags().Set("branch", "main"))
+	require.NoError(t, cmd.Flags().Set("limit", "1"))
+
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), shortHash(hash))
+	require.Contains(t, buf.String(), "commit: add a")
+	require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+}
+
+func TestUndoFallsBackToReflogWhenUndoStateCorrupt(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(repo.Path)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	after := repo.CommitFile(t, "a.txt", "a\n", "add a")
+	require.NoError(t, repo.WriteFile(".gitcherry/undo.json", "{not json"))
+
+	cmd := newUndoCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), "Reflog fallback: branch=main before="+before+" after="+after)
+}
+
+func TestTransferSummaryReportsMixedPreserveTransfer(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "checkout", "-b", "release")
+	repo.CommitFile(t, "fix.txt", "fix\n", "hotfix")
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	dup := repo.CommitFile(t, "fix.txt", "fix\n", "hotfix again")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+
+	cmd := newTransferCmd()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	ctx = context.WithValue(ctx, ctxOutputKey{}, "json")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("range", dup+".."+last))
+	require.NoError(t, cmd.Flags().Set("preserve", "true"))
+	require.NoError(t, cmd.Flags().Set("summary", "true"))
+
+	require.NoError(t, cmd.Execute())
+
+	// stdout is nothing but the JSON document; the progress text is on stderr.
+	var summary transfer.Summary
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &summary))
+	require.Contains(t, stderr.String(), "Detected 1 duplicate patches; skipping.")
+	require.Equal(t, "release", summary.Target)
+	require.Equal(t, []string{first, last}, summary.Applied)
+	require.Equal(t, []string{dup}, summary.Skipped)
+	require.Empty(t, summary.Conflicted)
+	require.Len(t, summary.NewCommits, 2)
+
+	newCommits := strings.Fields(repo.MustRun(t, "rev-list", "--reverse", "main..release"))
+	require.Equal(t, newCommits[1:], summary.NewCommits)
+
+	undo, ok, err := logs.Undo()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, summary.NewCommits, undo.NewCommits)
+}
+
+func TestTransferNoFFCreatesMergeCommit(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logDir := t.TempDir()
+	logs.SetBasePath(logDir)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("range", first+".."+last))
+	require.NoError(t, cmd.Flags().Set("message", "Merge feature into release"))
+	require.NoError(t, cmd.Flags().Set("no-ff", "true"))
+
+	require.NoError(t, cmd.Execute())
+
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+	parents := strings.Fields(repo.MustRun(t, "log", "-1", "--pretty=%P", "release"))
+	require.Len(t, parents, 2)
+	require.Equal(t, "Merge feature into release", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--pretty=%s", "release")))
+	require.Equal(t, "feature a\nfeature b", strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--pretty=%s", "release^1..release^2")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "branch", "--list", transfer.TempBranchName("release"))))
+
+	entries, err := os.ReadDir(filepath.Join(logDir, ".gitcherry", "logs"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	data, err := os.ReadFile(filepath.Join(logDir, ".gitcherry", "logs", entries[0].Name()))
+	require.NoError(t, err)
+	var op logs.Operation
+	require.NoError(t, json.Unmarshal(data, &op))
+	require.Equal(t, head, op.MergeCommit)
+}
+
+func TestTransferNoFFRejectsSquash(t *testing.T) {
+	cmd := newTransferCmd()
+	cmd.SilenceErrors = true
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, config.Default()))
+	cmd.SetArgs([]string{"--from", "main", "--to", "release", "--range", "a..b", "--no-ff", "--squash"})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no-ff")
+}
+
+func TestPreviewCopyCopiesRenderedMessage(t *testing.T) {
+	origBetween := commitsBetweenFn
+	defer func() { commitsBetweenFn = origBetween }()
+	origCopy := clipboardCopyFn
+	defer func() { clipboardCopyFn = origCopy }()
+
+	commitsBetweenFn = func(string, string, string, string) ([]git.Commit, error) {
+		return []git.Commit{{Hash: "aaaaaaa1", Message: "one"}, {Hash: "bbbbbbb2", Message: "two"}}, nil
+	}
+	var copied string
+	clipboardCopyFn = func(text string) error {
+		copied = text
+		return nil
+	}
+
+	cfg := config.Default()
+	cfg.MessageTemplate = "{source}->{target} {range}"
+	run := func() (string, string) {
+		cmd := newPreviewCmd()
+		out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetErr(errOut)
+		cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, cfg))
+		cmd.SetArgs([]string{"--from", "feature", "--to", "release", "--copy"})
+		require.NoError(t, cmd.Execute())
+		return out.String(), errOut.String()
+	}
+
+	out, errOut := run()
+	require.Equal(t, "feature->release aaaaaaa1..bbbbbbb2", copied)
+	require.Contains(t, out, copied)
+	require.Equal(t, "Message copied to clipboard.\n", errOut)
+
+	clipboardCopyFn = func(string) error { return clipboard.ErrUnavailable }
+	_, errOut = run()
+	require.Contains(t, errOut, "Warning: could not copy message: no clipboard tool found")
+}
+
+func TestPreviewTruncatesToPreviewLimit(t *testing.T) {
+	origBetween := commitsBetweenFn
+	defer func() { commitsBetweenFn = origBetween }()
+
+	var base, head string
+	commitsBetweenFn = func(b, h, format, order string) ([]git.Commit, error) {
+		base, head = b, h
+		return []git.Commit{
+			{Hash: "aaaaaaa1", Author: "Alice", Message: "one"},
+			{Hash: "bbbbbbb2", Author: "Bob", Message: "two"},
+			{Hash: "ccccccc3", Author: "Carol", Message: "three"},
+			{Hash: "ddddddd4", Author: "Dan", Message: "four"},
+			{Hash: "eeeeeee5", Author: "Eve", Message: "five"},
+		}, nil
+	}
+
+	cfg := config.Default()
+	cfg.PreviewLimit = 2
+	cfg.MessageTemplate = "{source}->{target} {range}"
+
+	cmd := newPreviewCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, cfg))
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Execute())
+
+	out := buf.String()
+	require.Equal(t, "release", base)
+	require.Equal(t, "feature", head)
+	require.Contains(t, out, "Commits (5):")
+	require.Contains(t, out, "aaaaaa  Alice  one")
+	require.Contains(t, out, "bbbbbb  Bob  two")
+	require.NotContains(t, out, "three")
+	require.Contains(t, out, "  …and 3 more\n")
+	require.Contains(t, out, "feature->release aaaaaaa1..eeeeeee5")
+}
+
+func TestFormatMessageRendersAllPlaceholders(t *testing.T) {
+	cmd := newFormatMessageCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, config.Default()))
+	cmd.SetArgs([]string{
+		"--template", "{source}|{target}|{range}|{source}",
+		"--source", "main",
+		"--target", "release",
+		"--range", "a1..b2",
+	})
+
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "main|release|a1..b2|main\n", buf.String())
+}
+
+func TestFormatMessageFromConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.MessageTemplate = "Backport {range} from {source} to {target}"
+
+	cmd := newFormatMessageCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, cfg))
+	cmd.SetArgs([]string{"--from-config", "--source", "dev", "--target", "prod", "--range", "x..y"})
+
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "Backport x..y from dev to prod\n", buf.String())
+}
+
+func TestFormatMessageRequiresTemplate(t *testing.T) {
+	cmd := newFormatMessageCmd()
+	cmd.SilenceErrors = true
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, config.Default()))
+	cmd.SetArgs([]string{"--source", "main"})
+
+	require.Error(t, cmd.Execute())
+}
+
+func TestFormatMessageTemplateWorksOutsideRepository(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, ".config"))
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	repohelper.Chdir(t, dir)
+
+	run := func(args ...string) (string, error) {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		root.SetArgs(args)
+		var stdout bytes.Buffer
+		root.SetOut(&stdout)
+		root.SetErr(io.Discard)
+		err := root.Execute()
+		return stdout.String(), err
+	}
+
+	out, err := run("format-message", "--template", "{source}->{target}", "--source", "dev", "--target", "prod")
+	require.NoError(t, err)
+	require.Equal(t, "dev->prod\n", out)
+
+	// The configured template still goes through the repository checks.
+	_, err = run("format-message", "--from-config")
+	require.Error(t, err)
+}
+
+func TestTransferInterruptRollsBackTarget(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "checkout", "-b", "release")
+	repo.CommitFile(t, "conflict.txt", "release\n", "release change")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "conflict.txt", "feature\n", "feature conflict")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	origRun := transferRunFn
+	defer func() { transferRunFn = origRun }()
+	transferRunFn = func(ctx context.Context, runner *git.Runner, opts transfer.Options, out io.Writer) (transfer.Result, error) {
+		// Interrupt before the first pick.
+		cancel()
+		return transfer.Run(ctx, runner, opts, out)
+	}
+
+	cmd := newTransferCmd()
+	cmd.SilenceErrors = true
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "apply")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("range", first+".."+last))
+	require.NoError(t, cmd.Flags().Set("preserve", "true"))
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "interrupted: rolled back release")
+
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+	require.Equal(t, "release", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+
+	_, ok, err := logs.Undo()
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestTransferKeepTimestampsUsesRangeCommitterDate(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	require.NoError(t, repo.WriteFile("b.txt", "b\n"))
+	repo.MustRun(t, "add", "b.txt")
+	dated := (&git.Runner{Dir: repo.Path}).WithExtraEnv("GIT_COMMITTER_DATE=2001-02-03T04:05:06+00:00")
+	_, stderr, err := dated.Run("commit", "-m", "feature b")
+	require.NoError(t, err, stderr)
+	last := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("range", first+".."+last))
+	require.NoError(t, cmd.Flags().Set("message", "Squashed feature"))
+	require.NoError(t, cmd.Flags().Set("keep-timestamps", "true"))
+
+	require.NoError(t, cmd.Execute())
+
+	require.Equal(t, "2001-02-03T04:05:06+00:00", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%cI", "release")))
+}
+
+func newApplyTransferCmd(t *testing.T, args ...string) (*cobra.Command, *bytes.Buffer) {
+	t.Helper()
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+	cmd.SetArgs(args)
+	return cmd, buf
+}
+
+func TestTransferRefusesTargetBehindUpstream(t *testing.T) {
+	repo, upstream := repohelper.InitWithRemote(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	upstream.CommitFile(t, "remote.txt", "r\n", "remote work")
+	repo.MustRun(t, "fetch", "origin")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "main"))
+	rangeSpec := first + ".." + last
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", rangeSpec, "--message", "m", "--target-head-check")
+	err := cmd.Execute()
+	require.ErrorContains(t, err, "target main is 1 commit(s) behind origin/main")
+	require.ErrorContains(t, err, "--refresh")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+
+	// Auto refresh turns the check on without the flag.
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", rangeSpec, "--message", "m")
+	cfg := config.Default()
+	cfg.AutoRefresh = true
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxConfigKey{}, cfg))
+	require.ErrorContains(t, cmd.Execute(), "behind origin/main")
+
+	// The check comes before --dry-run-then-apply asks for approval.
+	origPrompt, origInteractive := promptYesNoFn, stdinInteractiveFn
+	t.Cleanup(func() { promptYesNoFn, stdinInteractiveFn = origPrompt, origInteractive })
+	promptYesNoFn = func(prompt string) (bool, error) {
+		t.Fatalf("unexpected prompt %q", prompt)
+		return false, nil
+	}
+	stdinInteractiveFn = func() bool { return true }
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", rangeSpec, "--message", "m", "--target-head-check")
+	ctx := context.WithValue(cmd.Context(), ctxApplyKey{}, false)
+	cmd.SetContext(context.WithValue(ctx, ctxDryRunThenApplyKey{}, true))
+	require.ErrorContains(t, cmd.Execute(), "behind origin/main")
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", rangeSpec, "--message", "m", "--target-head-check=false", "--force")
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxConfigKey{}, cfg))
+	require.NoError(t, cmd.Execute())
+	require.NotEqual(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+}
+
+func TestTransferOntoDefaultBranchAsksFirst(t *testing.T) {
+	repo, _ := repohelper.InitWithRemote(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "main"))
+	args := []string{"--from", "feature", "--to", "main", "--range", first + ".." + last, "--message", "Squashed feature"}
+
+	origPrompt, origInteractive := promptYesNoFn, stdinInteractiveFn
+	t.Cleanup(func() { promptYesNoFn, stdinInteractiveFn = origPrompt, origInteractive })
+	var prompts []string
+	approve := false
+	promptYesNoFn = func(prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return approve, nil
+	}
+
+	stdinInteractiveFn = func() bool { return false }
+	cmd, _ := newApplyTransferCmd(t, args...)
+	require.ErrorContains(t, cmd.Execute(), "main is the default branch; confirm interactively or pass --force")
+	require.Empty(t, prompts)
+
+	stdinInteractiveFn = func() bool { return true }
+	cmd, buf := newApplyTransferCmd(t, args...)
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, []string{"main is the default branch. Change it anyway? [y/N]: "}, prompts)
+	require.Contains(t, buf.String(), "No changes applied.")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+
+	approve = true
+	cmd, _ = newApplyTransferCmd(t, args...)
+	require.NoError(t, cmd.Execute())
+	require.Len(t, prompts, 2)
+	require.Equal(t, "Squashed feature", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "main")))
+
+	// The defaultBranch config setting wins over detection.
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--message", "m")
+	repo.MustRun(t, "branch", "release", before)
+	cfg := config.Default()
+	cfg.DefaultBranch = "release"
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxConfigKey{}, cfg))
+	approve = false
+	require.NoError(t, cmd.Execute())
+	require.Len(t, prompts, 3)
+	require.Equal(t, "release is the default branch. Change it anyway? [y/N]: ", prompts[2])
+}
+
+func TestRootCommandDetectsDefaultBranch(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+	head := repo.CommitFile(t, "a.txt", "a\n", "change")
+
+	origInteractive := stdinInteractiveFn
+	t.Cleanup(func() { stdinInteractiveFn = origInteractive })
+	stdinInteractiveFn = func() bool { return false }
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"--apply", "revert", "--on", "main", "--range", head})
+	root.SetOut(io.Discard)
+	root.SetErr(io.Discard)
+	require.ErrorContains(t, root.Execute(), "main is the default branch")
+	require.Equal(t, head, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+}
+
+func TestTransferTagAfterTagsNewCommit(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	var logged logs.Operation
+	origRecord := logsRecordOperationFn
+	defer func() { logsRecordOperationFn = origRecord }()
+	logsRecordOperationFn = func(op logs.Operation) (string, error) {
+		logged = op
+		return "1", nil
+	}
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last,
+		"--message", "Squashed feature", "--tag-after", "v1.0.0", "--tag-message", "Release 1.0.0")
+	require.NoError(t, cmd.Execute())
+
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+	require.Equal(t, head, strings.TrimSpace(repo.MustRun(t, "rev-parse", "v1.0.0^{commit}")))
+	require.Equal(t, "tag", strings.TrimSpace(repo.MustRun(t, "cat-file", "-t", "v1.0.0")))
+	require.Equal(t, "Release 1.0.0", strings.TrimSpace(repo.MustRun(t, "tag", "-l", "--format=%(contents)", "v1.0.0")))
+	require.Equal(t, []string{"v1.0.0"}, logged.Tags)
+	require.Equal(t, `git tag -a -m "Release 1.0.0" v1.0.0 release`, logged.Commands[len(logged.Commands)-1])
+}
+
+func TestTransferTagAfterRemovesTagWhenLoggingFails(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+
+	origRecord := logsRecordOperationFn
+	defer func() { logsRecordOperationFn = origRecord }()
+	logsRecordOperationFn = func(logs.Operation) (string, error) { return "", errors.New("disk full") }
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+first,
+		"--preserve", "--tag-after", "v1.0.1")
+	require.ErrorContains(t, cmd.Execute(), "disk full")
+
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "tag", "-l", "v1.0.1")))
+	require.Equal(t, "feature a", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release")))
+}
+
+func TestTransferTagAfterRejectsExistingTag(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "tag", "v1.0.0")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+first,
+		"--message", "Squashed", "--tag-after", "v1.0.0")
+	require.ErrorContains(t, cmd.Execute(), "tag v1.0.0 already exists")
+	require.Equal(t, "initial", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release")))
+}
+
+func TestTransferCommitEachCreatesCommitPerSource(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	dryRun := newTransferCmd()
+	buf := &bytes.Buffer{}
+	dryRun.SetOut(buf)
+	ctx := context.WithValue(context.Background(), ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	dryRun.SetContext(ctx)
+	dryRun.SetArgs([]string{"--from", "feature", "--to", "release", "--range", first + ".." + last,
+		"--commit-each", "--commit-each-message-suffix", "(cherry-picked from {source})"})
+	require.NoError(t, dryRun.Execute())
+	require.Contains(t, buf.String(), "git cherry-pick "+first+"\n")
+	require.Contains(t, buf.String(), `git commit --amend -m "feature b (cherry-picked from feature)"`)
+
+	cmd, out := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last,
+		"--commit-each", "--commit-each-message-suffix", "(cherry-picked from {source})")
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), "2 of 2 commits preserved")
+
+	subjects := strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--format=%s", "main..release"))
+	require.Equal(t, "feature a (cherry-picked from feature)\nfeature b (cherry-picked from feature)", subjects)
+
+	bad, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last,
+		"--commit-each-message-suffix", "x")
+	require.ErrorContains(t, bad.Execute(), "requires --commit-each")
+}
+
+func runDryRunThenApplyTransfer(t *testing.T, approve bool) (string, string) {
+	t.Helper()
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	origPrompt, origInteractive := promptYesNoFn, stdinInteractiveFn
+	t.Cleanup(func() { promptYesNoFn, stdinInteractiveFn = origPrompt, origInteractive })
+	var prompts []string
+	promptYesNoFn = func(prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return approve, nil
+	}
+	stdinInteractiveFn = func() bool { return true }
+
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxDryRunThenApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("range", first+".."+last))
+	require.NoError(t, cmd.Flags().Set("message", "Squashed feature"))
+
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, []string{"Apply these changes? [y/N]: "}, prompts)
+	require.Contains(t, buf.String(), "Planned commands:")
+	return buf.String(), strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release"))
+}
+
+func TestTransferDryRunThenApplyExecutesWhenApproved(t *testing.T) {
+	out, subject := runDryRunThenApplyTransfer(t, true)
+	require.Equal(t, "Squashed feature", subject)
+	require.Contains(t, out, "Transfer applied successfully.")
+}
+
+func TestTransferDryRunThenApplyStopsWhenRejected(t *testing.T) {
+	out, subject := runDryRunThenApplyTransfer(t, false)
+	require.Equal(t, "initial", subject)
+	require.Contains(t, out, "No changes applied.")
+	require.NotContains(t, out, "Transfer applied successfully.")
+}
+
+func TestDryRunThenApplyConfirmsRevertAndRestore(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	base := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	commit := repo.CommitFile(t, "a.txt", "a\n", "add a")
+
+	origPrompt, origInteractive := promptYesNoFn, stdinInteractiveFn
+	t.Cleanup(func() { promptYesNoFn, stdinInteractiveFn = origPrompt, origInteractive })
+	answer := false
+	var prompts []string
+	promptYesNoFn = func(prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return answer, nil
+	}
+	stdinInteractiveFn = func() bool { return true }
+
+	run := func(args ...string) string {
+		root := newRootCommand()
+		root.SetArgs(append(args, "--dry-run-then-apply"))
+		buf := &bytes.Buffer{}
+		root.SetOut(buf)
+		root.SetErr(buf)
+		require.NoError(t, root.Execute())
+		return buf.String()
+	}
+
+	out := run("revert", "--on", "main", "--range", commit, "--message", "Back out a", "--force")
+	require.Contains(t, out, "Planned commands:")
+	require.Contains(t, out, "No changes applied.")
+	require.Equal(t, commit, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+
+	answer = true
+	out = run("revert", "--on", "main", "--range", commit, "--message", "Back out a", "--force")
+	match := regexp.MustCompile(`Revert applied successfully \(operation (\S+)\)\.`).FindStringSubmatch(out)
+	require.NotNil(t, match, out)
+	op, err := logs.OperationByID(logs.OperationsDir(), match[1])
+	require.NoError(t, err)
+	require.Equal(t, logs.KindRevert, op.Kind)
+	require.Equal(t, "Back out a", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "main")))
+
+	out = run("restore", "--at", base, "--branch-name", "rescued")
+	require.Contains(t, out, "Restore completed successfully")
+	require.Equal(t, base, strings.TrimSpace(repo.MustRun(t, "rev-parse", "rescued")))
+	require.Len(t, prompts, 3)
+}
+
+func TestAssumeYesSkipsPrompts(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "-b", "release", "main")
+	repo.MustRun(t, "cherry-pick", first)
+	repo.MustRun(t, "checkout", "main")
+
+	origPrompt, origInteractive := promptYesNoFn, stdinInteractiveFn
+	t.Cleanup(func() { promptYesNoFn, stdinInteractiveFn = origPrompt, origInteractive })
+	promptYesNoFn = func(prompt string) (bool, error) {
+		t.Fatalf("unexpected prompt %q", prompt)
+		return false, nil
+	}
+	stdinInteractiveFn = func() bool { return false }
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"-y", "--dry-run-then-apply", "transfer", "--from", "feature", "--to", "release", "--range", first + ".." + last, "--message", "Squashed feature"})
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+
+	require.NoError(t, root.Execute())
+	require.Contains(t, stdout.String(), "Planned commands:")
+	require.Contains(t, stderr.String(), "Assuming yes (--yes): applying 1 duplicate patches already on target")
+	require.Contains(t, stderr.String(), "Assuming yes (--yes): applying 3 planned commands")
+	require.Equal(t, "Squashed feature", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release")))
+
+	audit, err := os.ReadFile(logs.SessionAuditPath())
+	require.NoError(t, err)
+	require.Contains(t, string(audit), "assumed yes: applying 1 duplicate patches")
+	require.Contains(t, string(audit), "assumed yes: applying 3 planned commands")
+}
+
+func TestAssumeYesKeepsExplicitSkip(t *testing.T) {
+	cmd := &cobra.Command{}
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxYesKey{}, true))
+
+	apply, confirm, err := duplicatePolicy(cmd, "skip")
+	require.NoError(t, err)
+	require.False(t, apply)
+	require.Nil(t, confirm)
+	kept, skipped, err := transfer.Options{Mode: transfer.ModePreserve, ApplyDuplicates: apply, ConfirmDuplicates: confirm}.SkipDuplicates([]git.Commit{{Hash: "abcdef123"}, {Hash: "fedcba321"}}, []git.Commit{{Hash: "abcdef123"}}, buf)
+	require.NoError(t, err)
+	require.Equal(t, []git.Commit{{Hash: "fedcba321"}}, kept)
+	require.Equal(t, []git.Commit{{Hash: "abcdef123"}}, skipped)
+	require.NotContains(t, buf.String(), "Assuming yes")
+}
+
+func TestStatsPrintsEachPhase(t *testing.T) {
+	repo, _ := repohelper.InitWithRemote(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"--stats", "--refresh", "--apply", "transfer", "--from", "feature", "--to", "release", "--range", first + ".." + last, "--message", "Squashed feature"})
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+	require.NoError(t, root.Execute())
+
+	require.Contains(t, stderr.String(), "Stats:\n")
+	for _, phase := range []string{"fetch", "enumerate", "duplicates", "apply"} {
+		require.Regexp(t, `(?m)^  `+phase+` +\S+$`, stderr.String())
+	}
+	require.NotContains(t, stdout.String(), "Stats:")
+
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.ElementsMatch(t, []string{"fetch", "enumerate", "duplicates", "apply"}, slices.Collect(maps.Keys(ops[0].Timings)))
+}
+
+func TestPhaseStatsStartOverForEachTarget(t *testing.T) {
+	stats := &phaseStats{}
+	stats.add("fetch", 5*time.Millisecond)
+	forTarget := stats.perTarget()
+	first := forTarget()
+	first.add("apply", 10*time.Millisecond)
+	second := forTarget()
+	second.add("apply", 20*time.Millisecond)
+
+	require.Equal(t, map[string]int64{"fetch": 5, "apply": 10}, first.millis())
+	require.Equal(t, map[string]int64{"fetch": 5, "apply": 20}, second.millis())
+	require.Equal(t, map[string]int64{"fetch": 5, "apply": 30}, stats.millis())
+	require.Equal(t, []string{"fetch", "apply"}, stats.phases)
+	require.Nil(t, (*phaseStats)(nil).perTarget()())
+}
+
+func TestStatsOffByDefault(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"list"})
+	var stderr bytes.Buffer
+	root.SetOut(io.Discard)
+	root.SetErr(&stderr)
+	require.NoError(t, root.Execute())
+	require.NotContains(t, stderr.String(), "Stats:")
+}
+
+func TestPagerPipesLongOutput(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	repo.MustRun(t, "checkout", "-b", "feature")
+	for i := range 5 {
+		repo.CommitFile(t, fmt.Sprintf("f%d.txt", i), "x\n", fmt.Sprintf("change %d", i))
+	}
+
+	original := pagerTerminalFn
+	t.Cleanup(func() { pagerTerminalFn = original })
+	pagerTerminalFn = func(io.Writer) (int, bool) { return 4, true }
+	// The shim marks every line it pages.
+	t.Setenv("GIT_PAGER", "sed s/^/paged:/")
+
+	run := func(args ...string) string {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		root.SetArgs(args)
+		var stdout bytes.Buffer
+		root.SetOut(&stdout)
+		root.SetErr(io.Discard)
+		require.NoError(t, root.Execute())
+		return stdout.String()
+	}
+
+	out := run("preview", "--from", "feature", "--to", "main")
+	require.Contains(t, out, "paged:Target: main\n")
+	require.Contains(t, out, "paged:Message:\n")
+	require.Contains(t, run("--paginate", "format-message", "--template", "short"), "paged:short\n")
+
+	require.Equal(t, "short\n", run("format-message", "--template", "short"))
+	require.NotContains(t, run("--no-pager", "preview", "--from", "feature", "--to", "main"), "paged:")
+	require.NotContains(t, run("--output", "json", "list"), "paged:")
+
+	t.Setenv("GIT_PAGER", "cat")
+	require.NotContains(t, run("--paginate", "format-message", "--template", "short"), "paged:")
+}
+
+func TestShowPrintsOperationFromFixture(t *testing.T) {
+	origByID := logsOperationByIDFn
+	defer func() { logsOperationByIDFn = origByID }()
+	logsOperationByIDFn = func(_ string, id string) (logs.Operation, error) {
+		return logs.OperationByID(filepath.Join("..", "..", "tests", "fixtures", "operations"), id)
+	}
+
+	cmd := newShowCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"20240102T030405Z"})
+
+	require.NoError(t, cmd.Execute())
+
+	out := buf.String()
+	require.Contains(t, out, "ID:          20240102T030405Z")
+	require.Contains(t, out, "Timestamp:   2024-01-02T03:04:05Z")
+	require.Contains(t, out, "Status:      applied")
+	require.Contains(t, out, "Range:       1111111111111111111111111111111111111111..2222222222222222222222222222222222222222")
+	require.Contains(t, out, "New commits:\n  3333333333333333333333333333333333333333\n")
+	require.Contains(t, out, "Commands:\n  git checkout release\n")
+}
+
+func TestShowPrintsJSON(t *testing.T) {
+	origByID := logsOperationByIDFn
+	defer func() { logsOperationByIDFn = origByID }()
+	logsOperationByIDFn = func(_ string, id string) (logs.Operation, error) {
+		return logs.OperationByID(filepath.Join("..", "..", "tests", "fixtures", "operations"), id)
+	}
+
+	cmd := newShowCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxOutputKey{}, "json"))
+	cmd.SetArgs([]string{"20240102T030405Z_1"})
+
+	require.NoError(t, cmd.Execute())
+
+	var op logs.Operation
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &op))
+	require.Equal(t, "Revert 4444444 on main", op.Message)
+	require.Equal(t, logs.StatusApplied, op.Status)
+}
+
+func TestShowDiffUsesNewCommits(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(repo.Path)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	hash := repo.CommitFile(t, "feature.txt", "transferred line\n", "feature")
+	stamp := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, logs.WriteOperation(logs.Operation{
+		Source:     "feature",
+		Target:     "main",
+		StartHash:  hash,
+		EndHash:    hash,
+		NewCommits: []string{hash},
+		Timestamp:  stamp,
+	}))
+
+	cmd := newShowCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	cmd.SetArgs([]string{"20240304T050607Z", "--diff"})
+
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), "Source:      feature")
+	require.Contains(t, buf.String(), "+transferred line")
+}
+
+func TestRootCommandRefreshesConfiguredRemote(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GITCHERRY_AUTO_REFRESH", "true")
+	t.Setenv("GITCHERRY_REFRESH_REMOTE", "upstream")
+
+	local, origin := repohelper.InitWithRemote(t)
+	upstream := repohelper.Init(t)
+	upstreamHead := upstream.CommitFile(t, "up.txt", "up\n", "upstream change")
+	local.MustRun(t, "remote", "add", "upstream", "file://"+filepath.ToSlash(upstream.Path))
+	originBefore := strings.TrimSpace(local.MustRun(t, "rev-parse", "refs/remotes/origin/main"))
+	origin.CommitFile(t, "origin.txt", "origin\n", "origin change")
+	repohelper.Chdir(t, local.Path)
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"reflog", "--limit", "1"})
+	var buf bytes.Buffer
+	root.SetOut(&buf)
+	root.SetErr(&buf)
+	require.NoError(t, root.Execute())
+
+	require.Equal(t, upstreamHead, strings.TrimSpace(local.MustRun(t, "rev-parse", "refs/remotes/upstream/main")))
+	require.Equal(t, originBefore, strings.TrimSpace(local.MustRun(t, "rev-parse", "refs/remotes/origin/main")))
+
+	root = newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"--remote", "missing", "reflog"})
+	root.SetOut(&buf)
+	root.SetErr(&buf)
+	err := root.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `remote "missing" does not exist`)
+}
+
+func TestUnshallowFetchesHistoryATransferNeeds(t *testing.T) {
+	upstream := repohelper.Init(t)
+	upstream.MustRun(t, "branch", "release")
+	upstream.MustRun(t, "checkout", "-b", "feature")
+	first := upstream.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := upstream.CommitFile(t, "b.txt", "b\n", "feature b")
+	upstream.MustRun(t, "checkout", "main")
+
+	repo := repohelper.Clone(t, upstream, "--depth=1", "--no-single-branch")
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+	repo.MustRun(t, "branch", "release", "origin/release")
+	args := []string{"--apply", "transfer", "--from", "origin/feature", "--to", "release", "--range", first + ".." + last, "--message", "Backport"}
+
+	run := func(args ...string) (string, error) {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		root.SetArgs(args)
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&out)
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	// feature a is past the shallow boundary, so the range cannot be read.
+	out, err := run(args...)
+	require.Error(t, err)
+	require.Contains(t, out, "Warning: this is a shallow clone")
+
+	out, err = run(append([]string{"--unshallow"}, args...)...)
+	require.NoError(t, err, out)
+	require.Contains(t, out, "Fetched 1 more commit(s) from the default remote; the clone now has its full history.")
+	require.Equal(t, "false", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--is-shallow-repository")))
+	require.Equal(t, "Backport", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release")))
+}
+
+func TestTransferAutoSparseExpandsAndRestoresCone(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.CommitFile(t, "a/keep.txt", "keep\n", "add a")
+	repo.CommitFile(t, "b/base.txt", "base\n", "add b")
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "b/new.txt", "new\n", "feature b")
+	last := repo.CommitFile(t, "a/more.txt", "more\n", "feature a")
+	repo.MustRun(t, "sparse-checkout", "set", "--cone", "a")
+
+	cmd := newTransferCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxConfigKey{}, config.Default())
+	ctx = context.WithValue(ctx, ctxApplyKey{}, true)
+	ctx = context.WithValue(ctx, ctxDuplicateKey{}, "skip")
+	cmd.SetContext(ctx)
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("range", first+".."+last))
+	require.NoError(t, cmd.Flags().Set("message", "Sparse transfer"))
+	require.NoError(t, cmd.Flags().Set("auto-sparse", "true"))
+
+	require.NoError(t, cmd.Execute())
+
+	require.Equal(t, "new", strings.TrimSpace(repo.MustRun(t, "show", "release:b/new.txt")))
+	require.Equal(t, "a", strings.TrimSpace(repo.MustRun(t, "sparse-checkout", "list")))
+	require.NoFileExists(t, filepath.Join(repo.Path, "b", "new.txt"))
+}
+
+func TestInitCreatesStateDirectoryAndConfig(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	run := func(args ...string) (string, error) {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		root.SetArgs(args)
+		var buf bytes.Buffer
+		root.SetOut(&buf)
+		root.SetErr(&buf)
+		err := root.Execute()
+		return buf.String(), err
+	}
+
+	out, err := run("init")
+	require.NoError(t, err)
+	require.Contains(t, out, "Wrote default config")
+
+	require.DirExists(t, filepath.Join(repo.Path, ".gitcherry", "logs"))
+	data, err := os.ReadFile(filepath.Join(repo.Path, ".gitcherry.yml"))
+	require.NoError(t, err)
+	require.Equal(t, config.DefaultFileContents(), string(data))
+	exclude, err := os.ReadFile(filepath.Join(repo.Path, ".git", "info", "exclude"))
+	require.NoError(t, err)
+	require.Contains(t, string(exclude), "\n.gitcherry/\n")
+	require.NoFileExists(t, filepath.Join(repo.Path, ".gitignore"))
+	require.Equal(t, "?? .gitcherry.yml", strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+
+	_, err = run("init")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "use --reinit")
+
+	out, err = run("init", "--reinit")
+	require.NoError(t, err)
+	require.Contains(t, out, "Kept existing config")
+	exclude, err = os.ReadFile(filepath.Join(repo.Path, ".git", "info", "exclude"))
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(exclude), ".gitcherry/"))
+}
+
+func TestInitPrefersGitignore(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.CommitFile(t, ".gitignore", "*.log", "ignore logs")
+	repohelper.Chdir(t, repo.Path)
+
+	root := newRootCommand()
+	root.SetArgs([]string{"init"})
+	root.SetOut(&bytes.Buffer{})
+	require.NoError(t, root.Execute())
+
+	data, err := os.ReadFile(filepath.Join(repo.Path, ".gitignore"))
+	require.NoError(t, err)
+	require.Equal(t, "*.log\n.gitcherry/\n", string(data))
+}
+
+func TestInitGlobalWritesUserConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	path, err := config.HomeConfigPath()
+	require.NoError(t, err)
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"init", "--global"})
+	root.SetOut(&bytes.Buffer{})
+	require.NoError(t, root.Execute())
+	require.FileExists(t, path)
+
+	root = newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"init", "--global"})
+	root.SetOut(&bytes.Buffer{})
+	require.Error(t, root.Execute())
+}
+
+func TestConfigDumpReloadsToTheSameConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	// Dumping only reads the config, so a dirty worktree is fine.
+	require.NoError(t, repo.WriteFile("scratch.txt", "wip\n"))
+
+	envs := map[string]string{
+		"GITCHERRY_ON_DUPLICATE":            "apply",
+		"GITCHERRY_PREVIEW_LIMIT":           "0",
+		"GITCHERRY_MESSAGE_TEMPLATE":        "Backport {range} to {target}",
+		"GITCHERRY_REVERT_MESSAGE_TEMPLATE": "Back out {commits}",
+		"GITCHERRY_TUI_THEME":               "high-contrast",
+		"GITCHERRY_NO_GPG_SIGN":             "true",
+	}
+	for key, value := range envs {
+		t.Setenv(key, value)
+	}
+	want, err := config.Load(".")
+	require.NoError(t, err)
+
+	root := newRootCommand()
+	root.SilenceErrors = true
+	root.SetArgs([]string{"config", "dump"})
+	var out bytes.Buffer
+	root.SetOut(&out)
+	require.NoError(t, root.Execute())
+	require.Contains(t, out.String(), "on_duplicate: apply\n")
+
+	for key := range envs {
+		t.Setenv(key, "")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(config.RepoConfigPath(dir), out.Bytes(), 0o644))
+	got, err := config.Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.NotEqual(t, config.Default(), got)
+}
+
+func TestPreviewPorcelainPrintsTabSeparatedCommits(t *testing.T) {
+	origBetween := commitsBetweenFn
+	defer func() { commitsBetweenFn = origBetween }()
+
+	var usedFormat string
+	commitsBetweenFn = func(b, h, format, order string) ([]git.Commit, error) {
+		usedFormat = format
+		return []git.Commit{
+			{Hash: "aaaaaaa1", Author: "Alice Smith", Message: "one"},
+			{Hash: "bbbbbbb2", Author: "Bob", Message: "tab\tin subject"},
+			{Hash: "ccccccc3", Author: "Carol", Message: "three"},
+		}, nil
+	}
+
+	cfg := config.Default()
+	cfg.PreviewLimit = 1
+	cfg.CommitDisplayFormat = "%s (%an)"
+
+	cmd := newPreviewCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, cfg))
+
+	require.NoError(t, cmd.Flags().Set("from", "feature"))
+	require.NoError(t, cmd.Flags().Set("to", "release"))
+	require.NoError(t, cmd.Flags().Set("porcelain", "true"))
+	require.NoError(t, cmd.Execute())
+
+	require.Equal(t, git.DefaultDisplayFormat, usedFormat)
+	require.Equal(t, "aaaaaaa1\tAlice Smith\tone\nbbbbbbb2\tBob\ttab in subject\nccccccc3\tCarol\tthree\n", buf.String())
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		require.Len(t, strings.Split(line, "\t"), 3)
+	}
+}
+
+func TestListPorcelainPrintsOperations(t *testing.T) {
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	stamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, logs.WriteOperation(logs.Operation{Source: "feature", Target: "release", StartHash: "abc", EndHash: "def", Timestamp: stamp}))
+	require.NoError(t, logs.WriteOperation(logs.Operation{Source: "main", Target: "main", StartHash: "123", EndHash: "456", Timestamp: stamp.Add(time.Hour)}))
+
+	cmd := newListCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetContext(context.Background())
+	require.NoError(t, cmd.Flags().Set("porcelain", "true"))
+	require.NoError(t, cmd.Execute())
+
+	require.Equal(t, "2024-01-02T03:04:05Z\tfeature\trelease\tabc..def\n2024-01-02T04:04:05Z\tmain\tmain\t123..456\n", buf.String())
+
+	cmd = newListCmd()
+	buf.Reset()
+	cmd.SetOut(buf)
+	cmd.SetContext(context.Background())
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "20240102T030405Z  feature -> release  abc..def\n20240102T040405Z  main -> main  123..456\n", buf.String())
+}
+
+func TestListBranchesFilters(t *testing.T) {
+	repo, _ := repohelper.InitWithRemote(t)
+	repohelper.Chdir(t, repo.Path)
+	repo.MustRun(t, "branch", "fix-merged")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	repo.CommitFile(t, "feature.txt", "f\n", "feature work")
+	repo.MustRun(t, "checkout", "main")
+
+	cases := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "local by default", args: nil, want: []string{"feature", "fix-merged", "main"}},
+		{name: "local explicit", args: []string{"--local"}, want: []string{"feature", "fix-merged", "main"}},
+		{name: "remote only", args: []string{"--remote"}, want: []string{"origin/main"}},
+		{name: "all", args: []string{"--all"}, want: []string{"feature", "fix-merged", "main", "origin/main"}},
+		{name: "glob filter", args: []string{"--filter", "f*"}, want: []string{"feature", "fix-merged"}},
+		{name: "merged into HEAD", args: []string{"--merged"}, want: []string{"fix-merged", "main"}},
+		{name: "no-merged", args: []string{"--no-merged=main"}, want: []string{"feature"}},
+		{name: "all merged with filter", args: []string{"--all", "--merged", "--filter", "origin/*"}, want: []string{"origin/main"}},
+		{name: "newest commit first", args: []string{"--sort", "newest-commit", "--filter", "f*"}, want: []string{"feature", "fix-merged"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := newListBranchesCmd()
+			buf := &bytes.Buffer{}
+			cmd.SetOut(buf)
+			cmd.SetErr(buf)
+			cmd.SetContext(context.Background())
+			cmd.SetArgs(append([]string{"--json"}, tc.args...))
+			require.NoError(t, cmd.Execute())
+
+			var branches []git.BranchInfo
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &branches))
+			names := make([]string, 0, len(branches))
+			for _, branch := range branches {
+				names = append(names, branch.Name)
+			}
+			require.Equal(t, tc.want, names)
+		})
+	}
+}
+
+func TestListBranchesTableAndValidation(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	cmd := newListBranchesCmd()
+	buf := &bytes.Buffer{}
+	cmd.SetOut(buf)
+	cmd.SetContext(context.Background())
+	require.NoError(t, cmd.Execute())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, []string{"NAME", "LAST", "COMMIT", "AUTHOR", "SUBJECT"}, strings.Fields(lines[0]))
+	require.True(t, strings.HasPrefix(lines[1], "main  "))
+	require.True(t, strings.HasSuffix(lines[1], "initial"))
+
+	for _, args := range [][]string{{"--sort", "size"}, {"--filter", "["}, {"--local", "--all"}, {"--merged", "--no-merged"}} {
+		cmd := newListBranchesCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetContext(context.Background())
+		cmd.SetArgs(args)
+		require.Error(t, cmd.Execute(), args)
+	}
+}
+
+func TestReplayAppliesLoggedOperationToAnotherTarget(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logDir := t.TempDir()
+	logs.SetBasePath(logDir)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release/1.4")
+	repo.MustRun(t, "branch", "release/1.5")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release/1.4", "--range", first+".."+last, "--message", "Backport feature")
+	require.NoError(t, cmd.Execute())
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+
+	replay := newReplayCmd()
+	out := &bytes.Buffer{}
+	replay.SetOut(out)
+	replay.SetErr(out)
+	replay.SetContext(cmd.Context())
+	replay.SetArgs([]string{"--operation", ops[0].ID, "--to", "release/1.5"})
+	require.NoError(t, replay.Execute())
+	require.Contains(t, out.String(), "Replaying operation "+ops[0].ID)
+	require.Contains(t, out.String(), "Transfer applied successfully.")
+
+	require.Equal(t, "Backport feature", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release/1.5")))
+	require.Equal(t, "a\n", repo.MustRun(t, "show", "release/1.5:a.txt"))
+	require.Equal(t, "b\n", repo.MustRun(t, "show", "release/1.5:b.txt"))
+
+	ops, err = logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	require.Equal(t, "release/1.5", ops[1].Target)
+	require.Equal(t, first, ops[1].StartHash)
+	require.Equal(t, last, ops[1].EndHash)
+}
+
+func TestReplayRejectsMissingRangeCommits(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	missing := strings.Repeat("1", 40)
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	require.NoError(t, logs.WriteOperation(logs.Operation{Kind: logs.KindTransfer, Mode: "squash", Source: "feature", Target: "main", StartHash: head, EndHash: missing, Message: "m"}))
+
+	replay := newReplayCmd()
+	replay.SetOut(&bytes.Buffer{})
+	replay.SetErr(&bytes.Buffer{})
+	replay.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, config.Default()))
+	replay.SetArgs([]string{"--operation", "latest", "--to", "main"})
+	require.ErrorContains(t, replay.Execute(), "references commit "+shortHash(missing)+", which no longer exists")
+}
+
+func TestReplayRefusesOperationsThatAreNotTransfers(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	for _, tc := range []struct {
+		kind string
+		want string
+	}{
+		{kind: logs.KindRevert, want: "was logged by revert; only transfers can be replayed"},
+		{kind: logs.KindImport, want: "was logged by import; only transfers can be replayed"},
+		{kind: "", want: "does not record what kind of operation it was"},
+	} {
+		require.NoError(t, logs.WriteOperation(logs.Operation{Kind: tc.kind, Source: "main", Target: "main", StartHash: head, EndHash: head, Message: "m"}))
+
+		replay := newReplayCmd()
+		replay.SetOut(&bytes.Buffer{})
+		replay.SetErr(&bytes.Buffer{})
+		replay.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, config.Default()))
+		replay.SetArgs([]string{"--operation", "latest", "--to", "main"})
+		require.ErrorContains(t, replay.Execute(), tc.want)
+	}
+}
+
+func TestReplayKeepsCommitEachSuffix(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release/1.4")
+	repo.MustRun(t, "branch", "release/1.5")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release/1.4", "--range", first+".."+last, "--commit-each", "--commit-each-message-suffix", "[backport]")
+	require.NoError(t, cmd.Execute())
+
+	replay := newReplayCmd()
+	replay.SetOut(&bytes.Buffer{})
+	replay.SetErr(&bytes.Buffer{})
+	replay.SetContext(cmd.Context())
+	replay.SetArgs([]string{"--operation", "latest", "--to", "release/1.5"})
+	require.NoError(t, replay.Execute())
+
+	require.Equal(t, "feature b [backport]\nfeature a [backport]\n", repo.MustRun(t, "log", "-2", "--format=%s", "release/1.5"))
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	require.Equal(t, logs.KindTransfer, ops[1].Kind)
+	require.Equal(t, "commit-each", ops[1].Mode)
+	require.Equal(t, "[backport]", ops[1].Suffix)
+}
+
+func TestTransferToMultipleTargets(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release/1.3")
+	repo.MustRun(t, "branch", "release/1.4")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release/1.3"))
+
+	cmd, buf := newApplyTransferCmd(t, "--from", "feature", "--to", "release/1.3", "--targets", "release/1.4,release/1.3", "--range", first+".."+last, "--message", "Backport fix")
+	require.NoError(t, cmd.Execute())
+
+	for _, target := range []string{"release/1.3", "release/1.4"} {
+		head := strings.TrimSpace(repo.MustRun(t, "rev-parse", target))
+		require.NotEqual(t, before, head, target)
+		require.Equal(t, "Backport fix", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", target)))
+		require.Equal(t, "b\n", repo.MustRun(t, "show", target+":b.txt"))
+	}
+	require.Contains(t, buf.String(), "==> release/1.3")
+	require.Regexp(t, `Summary:\n  release/1\.3\s+ok\n  release/1\.4\s+ok\n`, buf.String())
+
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	require.Equal(t, "release/1.3", ops[0].Target)
+	require.Equal(t, "release/1.4", ops[1].Target)
+
+	cmd, buf = newApplyTransferCmd(t, "--from", "feature", "--targets", "missing,release/1.4", "--range", first+".."+last, "--message", "Again")
+	require.ErrorContains(t, cmd.Execute(), "transfer failed for 1 of 2 targets")
+	require.Regexp(t, `missing\s+failed: .*\n  release/1\.4\s+not attempted`, buf.String())
+
+	repo.MustRun(t, "checkout", "feature")
+	fix := repo.CommitFile(t, "d.txt", "d\n", "feature d")
+	repo.MustRun(t, "checkout", "main")
+	cmd, buf = newApplyTransferCmd(t, "--from", "feature", "--targets", "missing,release/1.4", "--range", fix+".."+fix, "--message", "Keep going", "--keep-going")
+	require.ErrorContains(t, cmd.Execute(), "transfer failed for 1 of 2 targets")
+	require.Regexp(t, `release/1\.4\s+ok`, buf.String())
+	require.Equal(t, "Keep going", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release/1.4")))
+}
+
+func TestTransferKeepGoingRestoresTargetAfterConflict(t *testing.T) {
+	for name, mode := range map[string][]string{
+		"squash":   {"--message", "Backport fix"},
+		"preserve": {"--preserve"},
+		"no-ff":    {"--no-ff", "--message", "Backport fix"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo := repohelper.Init(t)
+			repohelper.Chdir(t, repo.Path)
+			logs.SetBasePath(t.TempDir())
+			t.Cleanup(func() { logs.SetBasePath("") })
+
+			repo.CommitFile(t, "a.txt", "base\n", "base")
+			repo.MustRun(t, "branch", "release/1.4")
+			repo.MustRun(t, "checkout", "-b", "release/1.3")
+			repo.CommitFile(t, "a.txt", "release\n", "release change")
+			repo.MustRun(t, "checkout", "main")
+			repo.MustRun(t, "checkout", "-b", "feature")
+			fix := repo.CommitFile(t, "a.txt", "fix\n", "feature fix")
+			repo.MustRun(t, "checkout", "main")
+			before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release/1.3"))
+
+			args := append([]string{"--from", "feature", "--targets", "release/1.3,release/1.4", "--range", fix + ".." + fix, "--keep-going"}, mode...)
+			cmd, buf := newApplyTransferCmd(t, args...)
+			require.ErrorContains(t, cmd.Execute(), "transfer failed for 1 of 2 targets")
+			require.Regexp(t, `release/1\.3\s+failed: `, buf.String())
+			require.Regexp(t, `release/1\.4\s+ok`, buf.String())
+			require.Contains(t, buf.String(), "Restored release/1.3 to "+shortHash(before))
+
+			require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release/1.3")))
+			require.Equal(t, "fix\n", repo.MustRun(t, "show", "release/1.4:a.txt"))
+			require.Equal(t, "release/1.4", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+			require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+			operation, err := (&git.Runner{}).InProgressOperation()
+			require.NoError(t, err)
+			require.Empty(t, operation)
+		})
+	}
+}
+func TestTransferKeepMessageReusesSingleCommitMessage(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	require.NoError(t, repo.WriteFile("a.txt", "a\n"))
+	repo.MustRun(t, "add", "a.txt")
+	repo.MustRun(t, "commit", "-m", "Fix timezone offset", "-m", "Offsets east of UTC were applied twice.")
+	fix := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", fix+".."+fix, "--keep-message")
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "Fix timezone offset\n\nOffsets east of UTC were applied twice.", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%B", "release")))
+}
+
+func TestTransferKeepMessageFallsBackForLongerRanges(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, buf := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--keep-message")
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), "Warning: --keep-message needs a single-commit range, but this one has 2 commits")
+	want := renderTemplate(config.Default().MessageTemplate, "feature", "release", first+".."+last)
+	require.Equal(t, want, strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%B", "release")))
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--keep-message", "--message", "m")
+	require.Error(t, cmd.Execute())
+}
+
+func TestTransferPrintNewHeadPrintsOnlyTheHash(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "branch", "hotfix")
+	repo.MustRun(t, "branch", "stable")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, stderr := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--message", "Backport", "--print-new-head")
+	stdout := &bytes.Buffer{}
+	cmd.SetOut(stdout)
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, repo.MustRun(t, "rev-parse", "release"), stdout.String())
+	require.Contains(t, stderr.String(), "Transfer applied successfully.")
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--targets", "hotfix,stable", "--range", first+".."+last, "--message", "Backport", "--print-new-head")
+	stdout.Reset()
+	cmd.SetOut(stdout)
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, repo.MustRun(t, "rev-parse", "hotfix")+repo.MustRun(t, "rev-parse", "stable"), stdout.String())
+
+	cmd = newTransferCmd()
+	cmd.SetOut(stdout)
+	cmd.SetErr(stdout)
+	cmd.SetContext(context.WithValue(context.Background(), ctxConfigKey{}, config.Default()))
+	cmd.SetArgs([]string{"--from", "feature", "--to", "release", "--range", first + ".." + last, "--print-new-head"})
+	require.ErrorContains(t, cmd.Execute(), "--print-new-head needs --apply")
+}
+
+func TestTransferStopsWhenTargetAlreadyHasRange(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "merge", "--no-ff", "-m", "merge feature", "feature")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "main"))
+
+	cmd, buf := newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", first+".."+last, "--message", "Again", "--force")
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), "Nothing to transfer: main already contains "+shortHash(last))
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Empty(t, ops)
+
+	cmd, buf = newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", first+".."+last, "--message", "Again", "--force", "--allow-empty-range")
+	require.NoError(t, cmd.Execute())
+	require.NotContains(t, buf.String(), "Nothing to transfer")
+	require.Contains(t, buf.String(), "Skipping transfer due to duplicate patches.")
+}
+
+func TestTransferPipesMessageThroughFilter(t *testing.T) {
+	for _, tool := range []string{"tr", "false", "sleep"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available: %v", tool, err)
+		}
+	}
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	transfer := func(filter string, extra ...string) error {
+		cfg := config.Default()
+		cfg.MessageFilter = filter
+		cmd, _ := newApplyTransferCmd(t, append([]string{"--from", "feature", "--to", "release", "--range", first + ".." + last, "--message", "backport feature"}, extra...)...)
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxConfigKey{}, cfg))
+		return cmd.Execute()
+	}
+
+	require.ErrorContains(t, transfer("false"), `message_filter "false" failed`)
+	origTimeout := messageFilterTimeout
+	messageFilterTimeout = 50 * time.Millisecond
+	err := transfer("sleep 5")
+	messageFilterTimeout = origTimeout
+	require.ErrorContains(t, err, `message_filter "sleep 5" did not finish within 50ms`)
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")), "a failed filter commits nothing")
+
+	// Trailers are added after the filter, so they keep their spelling.
+	require.NoError(t, transfer("tr a-z A-Z", "--trailer", "Backport-of: feature"))
+	require.Equal(t, "BACKPORT FEATURE\n\nBackport-of: feature", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%B", "release")))
+}
+
+func TestTransferQuietIfEmptySucceedsWhenRerun(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	args := []string{"--from", "feature", "--to", "release", "--range", first + ".." + last, "--message", "Backport"}
+
+	cmd, _ := newApplyTransferCmd(t, args...)
+	require.NoError(t, cmd.Execute())
+	applied := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+
+	// The squash commit matches neither source commit's patch, so only the
+	// empty commit gives the re-run away.
+	cmd, _ = newApplyTransferCmd(t, args...)
+	require.ErrorIs(t, cmd.Execute(), transfer.ErrNothingToCommit)
+
+	cmd, buf := newApplyTransferCmd(t, append(args, "--quiet-if-empty")...)
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, buf.String(), "Nothing to transfer: release already has the changes in "+shortHash(first)+".."+shortHash(last)+".")
+	require.Equal(t, applied, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+
+	cmd, buf = newApplyTransferCmd(t, append(args, "--quiet-if-empty", "--quiet")...)
+	require.NoError(t, cmd.Execute())
+	require.NotContains(t, buf.String(), "Nothing to transfer")
+	require.Equal(t, applied, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+	ops, err = logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+
+	cmd, _ = newApplyTransferCmd(t, append(args[:len(args)-2], "--quiet")...)
+	require.ErrorContains(t, cmd.Execute(), "--quiet requires --quiet-if-empty")
+}
+
+func TestCollectCommitsForRangeHonoursCommitOrder(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	commitAt := func(name, date string) string {
+		t.Setenv("GIT_AUTHOR_DATE", date)
+		t.Setenv("GIT_COMMITTER_DATE", date)
+		return repo.CommitFile(t, name, name+"\n", name)
+	}
+	repo.MustRun(t, "checkout", "-b", "feature")
+	a1 := commitAt("a1", "2024-01-01T00:00:01+00:00")
+	repo.MustRun(t, "checkout", "-b", "side", a1)
+	b1 := commitAt("b1", "2024-01-01T00:00:02+00:00")
+	repo.MustRun(t, "checkout", "feature")
+	a2 := commitAt("a2", "2024-01-01T00:00:03+00:00")
+	repo.MustRun(t, "checkout", "side")
+	b2 := commitAt("b2", "2024-01-01T00:00:04+00:00")
+	repo.MustRun(t, "checkout", "feature")
+	repo.MustRun(t, "merge", "--no-ff", "-m", "merge side", "side")
+	merge := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	hashes := func(order string) []string {
+		commits, err := transfer.RangeCommits(&git.Runner{}, a1, merge, order)
+		require.NoError(t, err)
+		return commitHashes(commits)
+	}
+	require.Equal(t, []string{a1, b1, a2, b2, merge}, hashes(git.OrderDefault))
+	topo := hashes(git.OrderTopo)
+	require.Equal(t, a1, topo[0])
+	require.Contains(t, [][]string{{a1, a2, b1, b2, merge}, {a1, b1, b2, a2, merge}}, topo)
+
+	// Squash and no-ff pick the listed commits one by one to keep the order.
+	plan := func(args ...string) string {
+		root := newRootCommand()
+		out := &bytes.Buffer{}
+		root.SetOut(out)
+		root.SetErr(out)
+		root.SetArgs(append([]string{"--on-duplicate", "apply", "transfer", "--from", "feature", "--to", "main", "--range", a1 + ".." + merge, "--message", "m"}, args...))
+		require.NoError(t, root.Execute())
+		return out.String()
+	}
+	require.Contains(t, plan(), "git cherry-pick --no-commit "+a1+"^.."+merge)
+	require.Contains(t, plan("--commit-order", "topo"), "git cherry-pick --no-commit "+strings.Join(topo, " "))
+	require.Contains(t, plan("--commit-order", "topo", "--no-ff"), "git cherry-pick "+strings.Join(topo, " "))
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "main", "--range", a1+".."+merge, "--message", "m", "--commit-order", "newest")
+	require.ErrorContains(t, cmd.Execute(), `invalid commit order "newest"`)
+}
+
+func TestTransferRefusesToCommitConflictMarkers(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	bad := repo.CommitFile(t, "merge.txt", "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> other\n", "half-resolved merge")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", bad+".."+bad, "--message", "m")
+	require.ErrorContains(t, cmd.Execute(), "refusing to commit: conflict markers remain in merge.txt")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+
+	repo.MustRun(t, "reset", "--hard")
+	repo.MustRun(t, "checkout", "main")
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", bad+".."+bad, "--message", "fixture", "--allow-conflict-markers")
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "fixture", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release")))
+}
+
+func TestTransferEditEachRewordsEveryCommit(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	editor := filepath.Join(t.TempDir(), "editor.sh")
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\nmsg=$(cat \"$1\")\nprintf '[backport] %s\\n' \"$msg\" > \"$1\"\n"), 0o755))
+	t.Setenv("GIT_EDITOR", editor)
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--preserve", "--edit-each")
+	require.NoError(t, cmd.Execute())
+	subjects := strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--format=%s", "main..release"))
+	require.Equal(t, "[backport] feature a\n[backport] feature b", subjects)
+
+	// An editor that exits without writing aborts before anything is applied.
+	repo.MustRun(t, "branch", "release-2", "main")
+	t.Setenv("GIT_EDITOR", "true")
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release-2", "--range", first+".."+last, "--preserve", "--edit-each")
+	err := cmd.Execute()
+	require.ErrorContains(t, err, "editor closed without saving")
+	require.ErrorContains(t, err, "transfer aborted before applying any commits")
+	require.Equal(t, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")), strings.TrimSpace(repo.MustRun(t, "rev-parse", "release-2")))
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release-2", "--range", first+".."+last, "--edit-each")
+	require.ErrorContains(t, cmd.Execute(), "--edit-each requires --preserve")
+}
+
+func TestTransferPreserveDatesKeepsCommitterDates(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "branch", "release-2")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	t.Setenv("GIT_COMMITTER_DATE", "2020-05-06T07:08:09+00:00")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	t.Setenv("GIT_COMMITTER_DATE", "2021-01-02T03:04:05+00:00")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	require.NoError(t, os.Unsetenv("GIT_COMMITTER_DATE"))
+	repo.MustRun(t, "checkout", "main")
+
+	committerDates := func(rangeSpec string) []time.Time {
+		var dates []time.Time
+		for _, line := range strings.Fields(repo.MustRun(t, "log", "--reverse", "--format=%cI", rangeSpec)) {
+			date, err := time.Parse(time.RFC3339, line)
+			require.NoError(t, err)
+			dates = append(dates, date)
+		}
+		return dates
+	}
+	want := committerDates("main..feature")
+
+	cmd, _ := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--preserve", "--preserve-dates")
+	require.NoError(t, cmd.Execute())
+	got := committerDates("main..release")
+	require.Len(t, got, 2)
+	for i := range want {
+		require.WithinDuration(t, want[i], got[i], time.Second)
+	}
+
+	// The config default also covers the amend made by --commit-each.
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release-2", "--range", first+".."+last, "--commit-each", "--commit-each-message-suffix", "(picked)")
+	cfg := config.Default()
+	cfg.PreserveDates = true
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxConfigKey{}, cfg))
+	require.NoError(t, cmd.Execute())
+	got = committerDates("main..release-2")
+	require.Len(t, got, 2)
+	for i := range want {
+		require.WithinDuration(t, want[i], got[i], time.Second)
+	}
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--message", "m", "--preserve-dates")
+	require.ErrorContains(t, cmd.Execute(), "--preserve-dates requires --preserve or --commit-each")
+}
+
+func TestNoGPGSignOverridesRepoSigning(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+	// Signing is on but cannot succeed, as in CI without a key.
+	repo.MustRun(t, "config", "commit.gpgsign", "true")
+	repo.MustRun(t, "config", "gpg.program", "false")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	cmd, buf := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+first, "--message", "m")
+	require.Error(t, cmd.Execute())
+	require.Contains(t, buf.String(), "Warning: commit.gpgsign is set")
+	repo.MustRun(t, "reset", "--hard")
+	repo.MustRun(t, "checkout", "main")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+
+	// Settings the user passes through GIT_CONFIG_COUNT must survive.
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "user.name")
+	t.Setenv("GIT_CONFIG_VALUE_0", "Env User")
+	root := newRootCommand()
+	out := &bytes.Buffer{}
+	root.SetOut(out)
+	root.SetErr(out)
+	root.SetArgs([]string{"--apply", "--on-duplicate", "skip", "--no-gpg-sign", "transfer", "--from", "feature", "--to", "release", "--range", first + ".." + first, "--message", "unsigned"})
+	require.NoError(t, root.Execute())
+	require.NotContains(t, out.String(), "Warning: commit.gpgsign")
+	require.Equal(t, "unsigned", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "release")))
+	require.Equal(t, "N", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%G?", "release")))
+	require.Equal(t, "Env User", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%cn", "release")))
+	require.Equal(t, "true", strings.TrimSpace(repo.MustRun(t, "config", "commit.gpgsign")))
+}
+
+func TestResolveEditorFollowsGitPrecedence(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	t.Setenv("GIT_EDITOR", "")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	require.Equal(t, "vi", resolveEditor())
+	t.Setenv("EDITOR", "nano")
+	require.Equal(t, "nano", resolveEditor())
+	t.Setenv("VISUAL", "emacs")
+	require.Equal(t, "emacs", resolveEditor())
+	repo.MustRun(t, "config", "core.editor", "code --wait")
+	require.Equal(t, "code --wait", resolveEditor())
+	t.Setenv("GIT_EDITOR", "ed")
+	require.Equal(t, "ed", resolveEditor())
+}
+
+func TestDefaultEditorPerPlatform(t *testing.T) {
+	require.Equal(t, "notepad", defaultEditor("windows"))
+	require.Equal(t, "vi", defaultEditor("linux"))
+	require.Equal(t, "vi", defaultEditor("darwin"))
+}
+
+func TestSplitEditorCommand(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		want    []string
+	}{
+		{`code --wait`, []string{"code", "--wait"}},
+		{`  vim   -c "set tw=72"  `, []string{"vim", "-c", "set tw=72"}},
+		{`"C:\Program Files\Notepad++\notepad++.exe" -multiInst -nosession`, []string{`C:\Program Files\Notepad++\notepad++.exe`, "-multiInst", "-nosession"}},
+		{`C:\Windows\notepad.exe`, []string{`C:\Windows\notepad.exe`}},
+		{`'/Applications/Sublime Text.app/Contents/SharedSupport/bin/subl' -w`, []string{"/Applications/Sublime Text.app/Contents/SharedSupport/bin/subl", "-w"}},
+		{`emacs --eval "(setq msg \"a\\b\")"`, []string{"emacs", "--eval", `(setq msg "a\b")`}},
+		{`nano ""`, []string{"nano", ""}},
+		{``, nil},
+	} {
+		got, err := splitEditorCommand(tc.command)
+		require.NoError(t, err, tc.command)
+		require.Equal(t, tc.want, got, tc.command)
+	}
+
+	_, err := splitEditorCommand(`"C:\Program Files\editor.exe`)
+	require.EqualError(t, err, "unterminated \" quote")
+	_, err = splitEditorCommand(`subl 'unclosed`)
+	require.EqualError(t, err, "unterminated ' quote")
+}
+
+func TestTransferChecksGitIdentity(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+
+	// A fresh CI container: no identity in any config file or the environment.
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL", "EMAIL"} {
+		t.Setenv(name, "")
+		require.NoError(t, os.Unsetenv(name))
+	}
+	repo.MustRun(t, "config", "--unset", "user.name")
+	repo.MustRun(t, "config", "--unset", "user.email")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	run := func(args ...string) (string, error) {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		out := &bytes.Buffer{}
+		root.SetOut(out)
+		root.SetErr(out)
+		root.SetArgs(append(args, "transfer", "--from", "feature", "--to", "release", "--range", first+".."+first, "--message", "m"))
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	out, err := run("--on-duplicate", "skip")
+	require.NoError(t, err)
+	require.Contains(t, out, "Warning: git user identity is not configured (user.name and user.email unset)")
+
+	_, err = run("--apply", "--on-duplicate", "skip")
+	require.ErrorContains(t, err, `git config --global user.email "you@example.com"`)
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+
+	t.Setenv("GIT_AUTHOR_NAME", "CI")
+	t.Setenv("GIT_COMMITTER_NAME", "CI")
+	t.Setenv("EMAIL", "ci@example.com")
+	out, err = run("--apply", "--on-duplicate", "skip")
+	require.NoError(t, err)
+	require.NotContains(t, out, "identity")
+	require.Equal(t, "CI <ci@example.com>", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%an <%ae>", "release")))
+}
+
+func TestApplyFailsFastWhileAnotherRunHoldsTheLock(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+	origTimeout := repoLockTimeout
+	repoLockTimeout = 0
+	t.Cleanup(func() { repoLockTimeout = origTimeout })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	run := func(args ...string) error {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&bytes.Buffer{})
+		root.SetArgs(append([]string{"--on-duplicate", "skip", "transfer", "--from", "feature", "--to", "release", "--range", first + ".." + first, "--message", "m"}, args...))
+		return root.Execute()
+	}
+
+	// The first run is still applying.
+	release, err := logs.AcquireLock(0)
+	require.NoError(t, err)
+
+	err = run("--apply")
+	require.ErrorIs(t, err, logs.ErrLocked)
+	require.ErrorContains(t, err, "another GitCherry operation is in progress")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+	require.NoError(t, run(), "dry runs do not take the lock")
+
+	release()
+	require.ErrorContains(t, run("--apply", "--commit-order", "newest"), "invalid commit order")
+	require.NoError(t, run("--apply"), "the failed run released the lock")
+	require.NotEqual(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+}
+
+func TestTransferDryRunApplyResetsTarget(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	root := newRootCommand()
+	out := &bytes.Buffer{}
+	root.SetOut(out)
+	root.SetErr(out)
+	root.SetArgs([]string{"--on-duplicate", "skip", "transfer", "--from", "feature", "--to", "release", "--range", first + ".." + last, "--preserve", "--dry-run-apply"})
+	require.NoError(t, root.Execute())
+
+	require.Contains(t, out.String(), "Warning: --dry-run-apply commits to release")
+	require.Contains(t, out.String(), "would have produced 2 commit(s) on release, no conflicts")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+	require.Equal(t, "main", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Empty(t, ops)
+	_, ok, err := logs.Undo()
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestCompleteRangeListsSourceCommits(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd := newTransferCmd()
+	require.NoError(t, cmd.ParseFlags([]string{"--from", "feature"}))
+	completions, directive := completeRange(cmd, nil, "")
+	require.Equal(t, cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveNoSpace, directive)
+	require.GreaterOrEqual(t, len(completions), 2)
+	require.Equal(t, last+"\tfeature b", completions[0])
+	require.Equal(t, first+"\tfeature a", completions[1])
+
+	completions, _ = completeRange(cmd, nil, first+".."+last[:10])
+	require.Equal(t, []string{first + ".." + last + "\tfeature b"}, completions)
+
+	// Without --from there is nothing to offer.
+	completions, directive = completeRange(newTransferCmd(), nil, "")
+	require.Empty(t, completions)
+	require.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+}
+
+func TestCompletionSkipsRootChecks(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	repo.MustRun(t, "checkout", "-b", "feature")
+	last := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+	require.NoError(t, repo.WriteFile("dirty.txt", "x\n"))
+
+	root := newRootCommand()
+	root.SetArgs([]string{cobra.ShellCompRequestCmd, "transfer", "--refresh", "--from", "feature", "--range", ""})
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+
+	require.NoError(t, root.Execute())
+	require.Contains(t, stdout.String(), last+"\tfeature a")
+	require.NotContains(t, stderr.String(), "Error")
+}
+
+func TestTransferExportsAndAppliesPatches(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	dir := t.TempDir()
+
+	cmd, out := newApplyTransferCmd(t, "--from", "feature", "--range", first+".."+last, "--export-patches", dir)
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), "Exported 2 patch(es) to "+dir)
+	patches, err := filepath.Glob(filepath.Join(dir, "*.patch"))
+	require.NoError(t, err)
+	require.Len(t, patches, 2)
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", first+".."+last, "--export-patches", dir)
+	require.ErrorContains(t, cmd.Execute(), "no --to target")
+
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+	cmd, out = newApplyTransferCmd(t, "--to", "release", "--apply-patches", dir)
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), "Applied 2 patch(es) to release.")
+	require.Equal(t, "feature a\nfeature b", strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--format=%s", "main..release")))
+
+	entry, ok, err := logs.Undo()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, before, entry.BeforeHead)
+}
+
+func TestImportAppliesMbox(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	last := repo.CommitFile(t, "b.txt", "b\n", "feature b")
+	repo.MustRun(t, "checkout", "main")
+	mbox := filepath.Join(t.TempDir(), "series.mbox")
+	require.NoError(t, os.WriteFile(mbox, []byte(repo.MustRun(t, "format-patch", "--stdout", first+"^.."+last)), 0o644))
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "release"))
+
+	run := func(args ...string) (string, error) {
+		root := newRootCommand()
+		root.SilenceErrors = true
+		out := &bytes.Buffer{}
+		root.SetOut(out)
+		root.SetErr(out)
+		root.SetArgs(args)
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	out, err := run("import", "--on", "release", "--mbox", mbox, "--signoff", "-3")
+	require.NoError(t, err)
+	require.Contains(t, out, "git am --3way --signoff "+mbox)
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "release")))
+
+	out, err = run("--apply", "import", "--on", "release", "--mbox", mbox, "--signoff", "-3")
+	require.NoError(t, err)
+	require.Contains(t, out, "Applied 2 patch(es) to release.")
+	require.Equal(t, "feature a\nfeature b", strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--format=%s", "main..release")))
+	require.Contains(t, repo.MustRun(t, "log", "-1", "--format=%b", "release"), "Signed-off-by: ")
+
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, mbox, ops[0].Source)
+	require.Len(t, ops[0].NewCommits, 2)
+	entry, ok, err := logs.Undo()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, before, entry.BeforeHead)
+
+	// Applying the same series again conflicts and leaves git am stopped.
+	_, err = run("--apply", "import", "--on", "release", "--mbox", mbox)
+	require.ErrorContains(t, err, "git am --continue")
+	repo.MustRun(t, "am", "--abort")
+}
+
+func TestTransferWarnsAboutSubmoduleChanges(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	base := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	plain := repo.CommitFile(t, "plain.txt", "plain\n", "plain change")
+	repo.MustRun(t, "update-index", "--add", "--cacheinfo", "160000,"+base+",vendor/lib")
+	repo.MustRun(t, "commit", "-m", "bump vendor/lib")
+	bump := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, out := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", plain+".."+bump, "--preserve")
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxApplyKey{}, false))
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), "Warning: the range changes submodule(s) vendor/lib; run 'git submodule update --init' after applying, or pass --update-submodules.")
+
+	cmd, out = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", plain+".."+plain, "--preserve")
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxApplyKey{}, false))
+	require.NoError(t, cmd.Execute())
+	require.NotContains(t, out.String(), "submodule")
+
+	// vendor/lib has no .gitmodules entry, so the update fails after the
+	// transfer is recorded; that is a warning, not a failed transfer.
+	cmd, out = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", plain+".."+bump, "--preserve", "--update-submodules")
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), "Warning: the transfer was applied, but updating submodules failed")
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+}
+
+func TestTransferWarnsAboutLFSFiles(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.CommitFile(t, ".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n", "track bin files with lfs")
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:" + strings.Repeat("cd", 32) + "\nsize 5\n"
+	model := repo.CommitFile(t, "model.bin", pointer, "add model")
+	repo.MustRun(t, "checkout", "main")
+
+	installed := false
+	orig := lfsInstalledFn
+	t.Cleanup(func() { lfsInstalledFn = orig })
+	lfsInstalledFn = func(*git.Runner) bool { return installed }
+
+	dryRun := func() string {
+		cmd, out := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", model+".."+model, "--preserve")
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxApplyKey{}, false))
+		require.NoError(t, cmd.Execute())
+		return out.String()
+	}
+
+	require.Contains(t, dryRun(), "Warning: the range changes LFS-tracked file(s) model.bin but git-lfs is not installed")
+
+	installed = true
+	require.Contains(t, dryRun(), "Warning: LFS objects for model.bin are not present locally; run 'git lfs pull' after applying, or pass --lfs-pull.")
+
+	// There is no remote to pull the objects from, so the pull fails after
+	// the transfer is recorded; that is a warning, not a failed transfer.
+	cmd, out := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", model+".."+model, "--preserve", "--lfs-pull")
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), "Warning: the transfer was applied, but git lfs pull failed")
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+}
+
+func TestAppendTrailers(t *testing.T) {
+	trailers := []string{"Backport-of: abc123", "Reviewed-by: Ana <ana@example.com>"}
+	for _, tc := range []struct{ message, want string }{
+		{"Fix crash\n", "Fix crash\n\nBackport-of: abc123\nReviewed-by: Ana <ana@example.com>"},
+		{"Fix crash\n\nBody text.", "Fix crash\n\nBody text.\n\nBackport-of: abc123\nReviewed-by: Ana <ana@example.com>"},
+		{"Fix crash\n\nSigned-off-by: Bo <bo@example.com>\n", "Fix crash\n\nSigned-off-by: Bo <bo@example.com>\nBackport-of: abc123\nReviewed-by: Ana <ana@example.com>"},
+	} {
+		got, err := appendTrailers(tc.message, trailers)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, got)
+	}
+
+	got, err := appendTrailers("Fix crash", nil)
+	require.NoError(t, err)
+	require.Equal(t, "Fix crash", got)
+}
+
+func TestTrailerFlag(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "release")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	commit := repo.CommitFile(t, "a.txt", "a\n", "feature a")
+	repo.MustRun(t, "checkout", "main")
+
+	cmd, out := newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", commit+".."+commit, "--message", "Backport fix",
+		"--trailer", "Backport-of: "+commit, "--trailer", "Reviewed-by: Ana <ana@example.com>")
+	cmd.SetContext(context.WithValue(cmd.Context(), ctxApplyKey{}, false))
+	require.NoError(t, cmd.Execute())
+	require.Contains(t, out.String(), fmt.Sprintf(`git commit -m "Backport fix\n\nBackport-of: %s\nReviewed-by: Ana <ana@example.com>"`, commit))
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", commit+".."+commit, "--message", "m", "--trailer", "Backport-of: "+commit)
+	require.NoError(t, cmd.Execute())
+	trailers := repo.MustRun(t, "log", "-1", "--format=%(trailers:key=Backport-of,valueonly)", "release")
+	require.Equal(t, commit, strings.TrimSpace(trailers))
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", commit+".."+commit, "--message", "m", "--trailer", "Backport of abc")
+	require.ErrorContains(t, cmd.Execute(), `invalid --trailer "Backport of abc" (expected 'Key: value'`)
+
+	cmd, _ = newApplyTransferCmd(t, "--from", "feature", "--to", "release", "--range", commit+".."+commit, "--preserve", "--trailer", "Backport-of: abc")
+	require.ErrorContains(t, cmd.Execute(), "--trailer only applies to the squash and --no-ff commit messages")
+}
@@ -0,0 +1,58 @@
+package clipboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrUnavailable is returned when no supported clipboard tool is installed.
+var ErrUnavailable = errors.New("no clipboard tool found (install pbcopy, wl-copy, xclip, or clip.exe)")
+
+var (
+	lookPath  = exec.LookPath
+	commandFn = exec.Command
+)
+
+// tool is a clipboard command that reads the text to copy from stdin.
+type tool struct {
+	name string
+	args []string
+}
+
+// tools returns the clipboard commands to try, most specific first. wl-copy is
+// only tried under Wayland so X sessions that also ship it fall through to
+// xclip.
+func tools() []tool {
+	candidates := []tool{{name: "pbcopy"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, tool{name: "wl-copy"})
+	}
+	return append(candidates,
+		tool{name: "xclip", args: []string{"-selection", "clipboard"}},
+		tool{name: "clip.exe"},
+	)
+}
+
+// CopyToClipboard writes text to the system clipboard. It returns
+// ErrUnavailable when none of the supported tools is on the PATH.
+func CopyToClipboard(text string) error {
+	for _, candidate := range tools() {
+		path, err := lookPath(candidate.name)
+		if err != nil {
+			continue
+		}
+		cmd := commandFn(path, candidate.args...)
+		cmd.Stdin = strings.NewReader(text)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v (%s)", candidate.name, err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	return ErrUnavailable
+}
@@ -0,0 +1,77 @@
+package clipboard
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubTools(t *testing.T, available map[string]bool, command func(name string, args ...string) *exec.Cmd) {
+	t.Helper()
+	origLook, origCommand := lookPath, commandFn
+	t.Cleanup(func() { lookPath, commandFn = origLook, origCommand })
+
+	lookPath = func(name string) (string, error) {
+		if available[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", exec.ErrNotFound
+	}
+	commandFn = command
+}
+
+func TestCopyToClipboardPipesTextToFirstAvailableTool(t *testing.T) {
+	t.Setenv("WAYLAND_DISPLAY", "")
+	out := filepath.Join(t.TempDir(), "clipboard")
+
+	var gotName string
+	var gotArgs []string
+	stubTools(t, map[string]bool{"xclip": true, "clip.exe": true}, func(name string, args ...string) *exec.Cmd {
+		gotName, gotArgs = name, args
+		return exec.Command("sh", "-c", `cat > "$0"`, out)
+	})
+
+	require.NoError(t, CopyToClipboard("[Transfer] message\n"))
+	require.Equal(t, "/usr/bin/xclip", gotName)
+	require.Equal(t, []string{"-selection", "clipboard"}, gotArgs)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "[Transfer] message\n", string(data))
+}
+
+func TestCopyToClipboardPrefersWlCopyUnderWayland(t *testing.T) {
+	t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+
+	var gotName string
+	stubTools(t, map[string]bool{"wl-copy": true, "xclip": true}, func(name string, args ...string) *exec.Cmd {
+		gotName = name
+		return exec.Command("true")
+	})
+
+	require.NoError(t, CopyToClipboard("text"))
+	require.Equal(t, "/usr/bin/wl-copy", gotName)
+}
+
+func TestCopyToClipboardReportsMissingTool(t *testing.T) {
+	stubTools(t, nil, func(string, ...string) *exec.Cmd {
+		t.Fatal("no command should run")
+		return nil
+	})
+
+	require.True(t, errors.Is(CopyToClipboard("text"), ErrUnavailable))
+}
+
+func TestCopyToClipboardReportsToolFailure(t *testing.T) {
+	stubTools(t, map[string]bool{"pbcopy": true}, func(string, ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "echo denied >&2; exit 1")
+	})
+
+	err := CopyToClipboard("text")
+	require.ErrorContains(t, err, "pbcopy failed")
+	require.ErrorContains(t, err, "denied")
+}
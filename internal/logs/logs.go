@@ -7,33 +7,104 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/julianchen24/gitcherry/internal/git"
 )
 
 // Entry captures a single auditable action that GitCherry performed.
 type Entry struct {
-	Summary  string
-	Metadata map[string]string
+	Summary  string            `json:"summary"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // AuditLog stores chronological actions and supports undo/redo navigation.
+// When Path is set, the file at that path holds the log as JSON lines so the
+// history survives across sessions, and recording an entry after an undo
+// drops the undone entries from the file as well.
 type AuditLog struct {
+	Path string
+
 	mu       sync.Mutex
 	entries  []Entry
 	position int
+	// synced counts the leading entries that are known to be in the file.
+	synced int
+	err    error
 }
 
+// auditLockTimeout bounds how long Record waits for another process that is
+// writing the same audit file.
+const auditLockTimeout = 2 * time.Second
+
 // NewAuditLog returns an empty in-memory audit log.
 func NewAuditLog() *AuditLog {
 	return &AuditLog{}
 }
 
-// Record appends a new entry and truncates any redo history.
+// NewPersistentAuditLog returns an audit log backed by the JSON lines file at
+// path, loading any entries already stored there.
+func NewPersistentAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := readAuditFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{Path: path, entries: entries, position: len(entries), synced: len(entries)}, nil
+}
+
+func readAuditFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []Entry
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SessionAuditPath returns the default location of the persistent session audit log.
+func SessionAuditPath() string {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	return filepath.Join(basePath, ".gitcherry", "session-audit.jsonl")
+}
+
+// Record appends a new entry and truncates any redo history. Persisting the
+// entry is best effort; the first write failure is reported by Err.
 func (a *AuditLog) Record(entry Entry) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if a.Path != "" {
+		err := a.persistLocked(entry)
+		if err == nil {
+			return
+		}
+		if a.err == nil {
+			a.err = err
+		}
+	}
+
 	if a.position < len(a.entries) {
 		a.entries = append([]Entry{}, a.entries[:a.position]...)
 	}
@@ -41,6 +112,91 @@ func (a *AuditLog) Record(entry Entry) {
 	a.position = len(a.entries)
 }
 
+// persistLocked writes entry to the audit file. It re-reads the file under a
+// lock first, so entries other processes recorded since this log last wrote
+// are kept and follow the ones this log has not undone.
+func (a *AuditLog) persistLocked(entry Entry) error {
+	release, err := acquireFileLock(a.Path+".lock", auditLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	onDisk, err := readAuditFile(a.Path)
+	if err != nil {
+		return err
+	}
+	var others []Entry
+	if a.synced < len(onDisk) {
+		others = onDisk[a.synced:]
+	}
+
+	kept := append(append([]Entry{}, a.entries[:min(a.position, a.synced)]...), others...)
+	kept = append(kept, entry)
+	if a.position < a.synced || len(onDisk) < a.synced {
+		err = writeAuditFile(a.Path, kept)
+	} else {
+		err = appendJSONLine(a.Path, entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.entries = kept
+	a.position = len(kept)
+	a.synced = len(kept)
+	return nil
+}
+
+// writeAuditFile replaces the audit file with entries, renaming a complete
+// temporary file into place so readers never see half of it.
+func writeAuditFile(path string, entries []Entry) error {
+	var sb strings.Builder
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Entries returns a copy of the recorded entries.
+func (a *AuditLog) Entries() []Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Entry{}, a.entries...)
+}
+
+// Err returns the first error encountered while persisting entries.
+func (a *AuditLog) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func appendJSONLine(path string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 // Undo steps back in the history and returns the entry if available.
 func (a *AuditLog) Undo() (Entry, bool) {
 	a.mu.Lock()
@@ -66,23 +222,50 @@ func (a *AuditLog) Redo() (Entry, bool) {
 	return entry, true
 }
 
-// Operation describes a transfer that GitCherry performed.
+// Operation describes a change that GitCherry performed.
 type Operation struct {
-	Source    string    `json:"source"`
-	Target    string    `json:"target"`
-	StartHash string    `json:"start_hash"`
-	EndHash   string    `json:"end_hash"`
-	Message   string    `json:"message"`
-	Commands  []string  `json:"commands"`
-	Timestamp time.Time `json:"timestamp"`
+	// Kind says which command produced the operation. Operations logged
+	// before it was recorded have none.
+	Kind string `json:"kind,omitempty"`
+	// Mode is the transfer mode of a KindTransfer operation.
+	Mode string `json:"mode,omitempty"`
+	// Suffix is the --commit-each-message-suffix of a commit-each transfer.
+	Suffix      string    `json:"suffix,omitempty"`
+	Source      string    `json:"source"`
+	Target      string    `json:"target"`
+	StartHash   string    `json:"start_hash"`
+	EndHash     string    `json:"end_hash"`
+	Message     string    `json:"message"`
+	Commands    []string  `json:"commands"`
+	NewCommits  []string  `json:"new_commits,omitempty"`
+	MergeCommit string    `json:"merge_commit,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	BeforeHead  string    `json:"before_head,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	// Timings holds the wall time of each phase in milliseconds, recorded
+	// when the operation ran with --stats.
+	Timings map[string]int64 `json:"timings_ms,omitempty"`
 }
 
+// StatusApplied marks an operation whose commands all completed.
+const StatusApplied = "applied"
+
+// Operation kinds.
+const (
+	KindTransfer = "transfer"
+	KindRevert   = "revert"
+	KindImport   = "import"
+	KindRestore  = "restore"
+)
+
 // UndoEntry captures metadata required to restore repository state.
 type UndoEntry struct {
 	Source     string    `json:"source"`
 	Target     string    `json:"target"`
 	BeforeHead string    `json:"before_head"`
 	AfterHead  string    `json:"after_head"`
+	NewCommits []string  `json:"new_commits,omitempty"`
 	Timestamp  time.Time `json:"timestamp"`
 }
 
@@ -104,16 +287,26 @@ func SetBasePath(path string) {
 
 // WriteOperation persists the provided operation to the on-disk log.
 func WriteOperation(op Operation) error {
+	_, err := RecordOperation(op)
+	return err
+}
+
+// RecordOperation is like WriteOperation but also returns the ID the
+// operation was stored under, as accepted by OperationByID.
+func RecordOperation(op Operation) (string, error) {
 	storageMu.Lock()
 	defer storageMu.Unlock()
 
 	if op.Timestamp.IsZero() {
 		op.Timestamp = time.Now().UTC()
 	}
+	if op.Status == "" {
+		op.Status = StatusApplied
+	}
 
-	dir := filepath.Join(basePath, ".gitcherry", "logs")
+	dir := operationsDir()
 	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return err
+		return "", err
 	}
 
 	baseName := op.Timestamp.Format("20060102T150405Z0700")
@@ -130,9 +323,12 @@ func WriteOperation(op Operation) error {
 
 	data, err := json.MarshalIndent(op, "", "  ")
 	if err != nil {
-		return err
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
 	}
-	return os.WriteFile(path, data, 0o600)
+	return strings.TrimSuffix(filepath.Base(path), ".json"), nil
 }
 
 // PushUndo appends a new undo entry to the persistent stack.
@@ -204,6 +400,104 @@ func Redo() (UndoEntry, bool, error) {
 	return entry, true, nil
 }
 
+// MaxRecentPairs caps how many branch pairs RecordRecentPair keeps.
+const MaxRecentPairs = 5
+
+// RecentPair is a source/target branch pair chosen in a previous session.
+type RecentPair struct {
+	Source string    `json:"source"`
+	Target string    `json:"target"`
+	UsedAt time.Time `json:"used_at"`
+}
+
+// RecordRecentPair moves source and target to the front of the persisted
+// recent pairs, dropping any earlier copy and the oldest beyond MaxRecentPairs.
+func RecordRecentPair(source, target string) error {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+
+	pairs, err := loadRecentPairsLocked()
+	if err != nil {
+		return err
+	}
+
+	updated := []RecentPair{{Source: source, Target: target, UsedAt: time.Now().UTC()}}
+	for _, pair := range pairs {
+		if pair.Source == source && pair.Target == target {
+			continue
+		}
+		updated = append(updated, pair)
+	}
+	if len(updated) > MaxRecentPairs {
+		updated = updated[:MaxRecentPairs]
+	}
+
+	dir := filepath.Join(basePath, ".gitcherry")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recentPairsPath(), data, 0o600)
+}
+
+// LoadRecentPairs returns the persisted recent pairs, most recent first.
+func LoadRecentPairs() ([]RecentPair, error) {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	return loadRecentPairsLocked()
+}
+
+func loadRecentPairsLocked() ([]RecentPair, error) {
+	data, err := os.ReadFile(recentPairsPath())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var pairs []RecentPair
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return nil, err
+	}
+	if len(pairs) > MaxRecentPairs {
+		pairs = pairs[:MaxRecentPairs]
+	}
+	return pairs, nil
+}
+
+// reflogRecoveryLimit bounds how far back RecoverFromReflog looks.
+const reflogRecoveryLimit = 50
+
+// RecoverFromReflog reconstructs undo entries for branch from its reflog,
+// oldest first. It is a fallback for when undo.json is missing or unreadable.
+func RecoverFromReflog(runner *git.Runner, branch string) ([]UndoEntry, error) {
+	reflog, err := git.RefLog(runner, branch, reflogRecoveryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]UndoEntry, 0, len(reflog))
+	for i := len(reflog) - 1; i >= 0; i-- {
+		entry := UndoEntry{
+			Source:    branch,
+			Target:    branch,
+			AfterHead: reflog[i].Hash,
+			Timestamp: reflog[i].Date.UTC(),
+		}
+		if i+1 < len(reflog) {
+			entry.BeforeHead = reflog[i+1].Hash
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 type undoState struct {
 	History  []UndoEntry `json:"history"`
 	Position int         `json:"position"`
@@ -243,6 +537,140 @@ func saveUndoStateLocked(state undoState) error {
 	return os.WriteFile(undoStatePath(), data, 0o600)
 }
 
+// OperationsDir returns the directory WriteOperation stores operations in.
+func OperationsDir() string {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	return operationsDir()
+}
+
+// OperationByID loads the operation stored in dir under id, which is the file
+// name WriteOperation chose for it, with or without the .json extension.
+func OperationByID(dir, id string) (Operation, error) {
+	id = strings.TrimSuffix(strings.TrimSpace(id), ".json")
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return Operation{}, fmt.Errorf("invalid operation id %q", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Operation{}, fmt.Errorf("operation %s not found: %w", id, err)
+		}
+		return Operation{}, err
+	}
+
+	var op Operation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return Operation{}, fmt.Errorf("operation %s is corrupt: %w", id, err)
+	}
+	// Operations are only logged once applied, including those written
+	// before the status field existed.
+	if op.Status == "" {
+		op.Status = StatusApplied
+	}
+	return op, nil
+}
+
+// StoredOperation is an operation together with the ID it is stored under.
+type StoredOperation struct {
+	ID string `json:"id"`
+	Operation
+}
+
+// ListOperations returns every operation stored in dir, oldest first.
+func ListOperations(dir string) ([]StoredOperation, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(ids)
+
+	ops := make([]StoredOperation, 0, len(ids))
+	for _, id := range ids {
+		op, err := OperationByID(dir, id)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, StoredOperation{ID: id, Operation: op})
+	}
+	return ops, nil
+}
+
+// ErrLocked is returned by AcquireLock when another GitCherry process holds
+// the repository lock.
+var ErrLocked = errors.New("another GitCherry operation is in progress")
+
+// lockRetryInterval is how often AcquireLock checks a held lock again.
+const lockRetryInterval = 50 * time.Millisecond
+
+// AcquireLock takes the repository-wide lock that keeps two GitCherry runs
+// from changing the repository at once, waiting up to timeout for a holder
+// to finish. The lock lives in the common git directory of the repository
+// containing the working directory, so every worktree shares it and it never
+// shows up as an untracked file. release removes the lock again and is safe
+// to call more than once. A process that dies while holding the lock leaves
+// the file behind; the error names it so it can be deleted.
+func AcquireLock(timeout time.Duration) (release func(), err error) {
+	path, err := lockPath()
+	if err != nil {
+		return nil, err
+	}
+	return acquireFileLock(path, timeout)
+}
+
+// acquireFileLock creates the lock file at path, waiting up to timeout for
+// whoever holds it to remove it.
+func acquireFileLock(path string, timeout time.Duration) (release func(), err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			fmt.Fprintf(file, "pid %d since %s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+			file.Close()
+			var once sync.Once
+			return func() { once.Do(func() { _ = os.Remove(path) }) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			holder, _ := os.ReadFile(path)
+			return nil, fmt.Errorf("%w (%s); wait for it to finish, or delete %s if no GitCherry process is running",
+				ErrLocked, strings.TrimSpace(string(holder)), path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func lockPath() (string, error) {
+	common, err := git.CommonDir(&git.Runner{})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(common, "gitcherry.lock"), nil
+}
+
+func operationsDir() string {
+	return filepath.Join(basePath, ".gitcherry", "logs")
+}
+
 func undoStatePath() string {
 	return filepath.Join(basePath, ".gitcherry", "undo.json")
 }
+
+func recentPairsPath() string {
+	return filepath.Join(basePath, ".gitcherry", "recent.json")
+}
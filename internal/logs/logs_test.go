@@ -2,12 +2,17 @@ package logs
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/tests/repohelper"
 )
 
 func TestWriteOperationPersistsJSON(t *testing.T) {
@@ -82,3 +87,211 @@ func TestUndoRedoLifecycle(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, ok)
 }
+
+func TestRecoverFromReflog(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	initial := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	entries, err := RecoverFromReflog(&git.Runner{Dir: repo.Path}, "main")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	require.Equal(t, "", entries[0].BeforeHead)
+	require.Equal(t, initial, entries[0].AfterHead)
+	require.Equal(t, initial, entries[1].BeforeHead)
+	require.Equal(t, first, entries[1].AfterHead)
+	require.Equal(t, first, entries[2].BeforeHead)
+	require.Equal(t, second, entries[2].AfterHead)
+	require.Equal(t, "main", entries[2].Source)
+	require.NotZero(t, entries[2].Timestamp)
+}
+
+func TestPersistentAuditLogSurvivesReload(t *testing.T) {
+	dir := t.TempDir()
+	SetBasePath(dir)
+	t.Cleanup(func() { SetBasePath("") })
+
+	path := SessionAuditPath()
+	require.Equal(t, filepath.Join(dir, ".gitcherry", "session-audit.jsonl"), path)
+
+	first, err := NewPersistentAuditLog(path)
+	require.NoError(t, err)
+	require.Empty(t, first.Entries())
+	first.Record(Entry{Summary: "session started"})
+	first.Record(Entry{Summary: "restore", Metadata: map[string]string{"branch": "hotfix"}})
+	require.NoError(t, first.Err())
+
+	reloaded, err := NewPersistentAuditLog(path)
+	require.NoError(t, err)
+	require.Equal(t, first.Entries(), reloaded.Entries())
+
+	undone, ok := reloaded.Undo()
+	require.True(t, ok)
+	require.Equal(t, "restore", undone.Summary)
+
+	// The undone entry stays gone after a reload.
+	reloaded.Record(Entry{Summary: "session started"})
+	again, err := NewPersistentAuditLog(path)
+	require.NoError(t, err)
+	require.Equal(t, reloaded.Entries(), again.Entries())
+	require.Len(t, again.Entries(), 2)
+}
+
+func TestPersistentAuditLogKeepsEntriesFromOtherSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	first, err := NewPersistentAuditLog(path)
+	require.NoError(t, err)
+	first.Record(Entry{Summary: "first one"})
+	first.Record(Entry{Summary: "first two"})
+
+	second, err := NewPersistentAuditLog(path)
+	require.NoError(t, err)
+	second.Record(Entry{Summary: "second one"})
+
+	// first undoes its last entry and records another; the entry the second
+	// session wrote in the meantime survives.
+	_, ok := first.Undo()
+	require.True(t, ok)
+	first.Record(Entry{Summary: "first three"})
+	require.NoError(t, first.Err())
+
+	summaries := func(entries []Entry) []string {
+		var out []string
+		for _, entry := range entries {
+			out = append(out, entry.Summary)
+		}
+		return out
+	}
+	want := []string{"first one", "second one", "first three"}
+	require.Equal(t, want, summaries(first.Entries()))
+
+	reloaded, err := NewPersistentAuditLog(path)
+	require.NoError(t, err)
+	require.Equal(t, want, summaries(reloaded.Entries()))
+}
+
+func TestPersistentAuditLogRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"summary\":\"ok\"}\nnot-json\n"), 0o600))
+
+	_, err := NewPersistentAuditLog(path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "audit.jsonl:2")
+}
+
+func TestOperationByIDLoadsFixture(t *testing.T) {
+	dir := filepath.Join("..", "..", "tests", "fixtures", "operations")
+
+	op, err := OperationByID(dir, "20240102T030405Z")
+	require.NoError(t, err)
+	require.Equal(t, "feature", op.Source)
+	require.Equal(t, "release", op.Target)
+	require.Len(t, op.Commands, 3)
+	require.Equal(t, []string{"3333333333333333333333333333333333333333"}, op.NewCommits)
+	require.Equal(t, StatusApplied, op.Status, "legacy entries default to applied")
+	require.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), op.Timestamp)
+
+	second, err := OperationByID(dir, "20240102T030405Z_1.json")
+	require.NoError(t, err)
+	require.Equal(t, "Revert 4444444 on main", second.Message)
+}
+
+func TestOperationByIDRejectsUnknownAndInvalidIDs(t *testing.T) {
+	dir := filepath.Join("..", "..", "tests", "fixtures", "operations")
+
+	_, err := OperationByID(dir, "20000101T000000Z")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	for _, id := range []string{"", "../undo", ".hidden"} {
+		_, err := OperationByID(dir, id)
+		require.Error(t, err, id)
+		require.Contains(t, err.Error(), "invalid operation id")
+	}
+}
+
+func TestWriteOperationRecordsStatusAndID(t *testing.T) {
+	dir := t.TempDir()
+	SetBasePath(dir)
+	t.Cleanup(func() { SetBasePath("") })
+
+	stamp := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	require.NoError(t, WriteOperation(Operation{Source: "a", Target: "b", Timestamp: stamp}))
+
+	op, err := OperationByID(OperationsDir(), "20240506T070809Z")
+	require.NoError(t, err)
+	require.Equal(t, StatusApplied, op.Status)
+}
+
+func TestListOperationsOrdersByID(t *testing.T) {
+	ops, err := ListOperations(filepath.Join("..", "..", "tests", "fixtures", "operations"))
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	require.Equal(t, "20240102T030405Z", ops[0].ID)
+	require.Equal(t, "feature", ops[0].Source)
+	require.Equal(t, "20240102T030405Z_1", ops[1].ID)
+
+	ops, err = ListOperations(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	require.Empty(t, ops)
+}
+
+func TestRecordRecentPairDeduplicatesAndCaps(t *testing.T) {
+	dir := t.TempDir()
+	SetBasePath(dir)
+	t.Cleanup(func() { SetBasePath("") })
+
+	pairs, err := LoadRecentPairs()
+	require.NoError(t, err)
+	require.Empty(t, pairs)
+
+	for i := 0; i < MaxRecentPairs+2; i++ {
+		require.NoError(t, RecordRecentPair(fmt.Sprintf("feature-%d", i), "main"))
+	}
+	require.NoError(t, RecordRecentPair("feature-3", "main"))
+
+	pairs, err = LoadRecentPairs()
+	require.NoError(t, err)
+	require.Len(t, pairs, MaxRecentPairs)
+
+	var sources []string
+	for _, pair := range pairs {
+		require.Equal(t, "main", pair.Target)
+		require.False(t, pair.UsedAt.IsZero())
+		sources = append(sources, pair.Source)
+	}
+	require.Equal(t, []string{"feature-3", "feature-6", "feature-5", "feature-4", "feature-2"}, sources)
+
+	_, err = os.Stat(filepath.Join(dir, ".gitcherry", "recent.json"))
+	require.NoError(t, err)
+}
+
+func TestAcquireLockWaitsForHolder(t *testing.T) {
+	repo := repohelper.Init(t)
+	repohelper.Chdir(t, repo.Path)
+	SetBasePath(t.TempDir())
+	t.Cleanup(func() { SetBasePath("") })
+
+	release, err := AcquireLock(0)
+	require.NoError(t, err)
+
+	_, err = AcquireLock(0)
+	require.ErrorIs(t, err, ErrLocked)
+	require.ErrorContains(t, err, fmt.Sprintf("pid %d", os.Getpid()))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}()
+	second, err := AcquireLock(5 * time.Second)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(repo.Path, ".git", "gitcherry.lock"))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")), "the lock is not an untracked file")
+	second()
+	second()
+	_, err = os.Stat(filepath.Join(repo.Path, ".git", "gitcherry.lock"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
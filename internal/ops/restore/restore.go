@@ -3,6 +3,8 @@ package restore
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/julianchen24/gitcherry/internal/git"
@@ -14,19 +16,75 @@ func Plan(branchName, commitHash string) []string {
 	return []string{fmt.Sprintf("git branch %s %s", branchName, commitHash)}
 }
 
+// Options describes a restore for Run.
+type Options struct {
+	Branch string
+	Commit string
+	// Audit, when set, receives an entry for the restore.
+	Audit *logs.AuditLog
+	// Apply creates the branch; otherwise Run only plans it.
+	Apply bool
+}
+
+// Result reports what Run planned and, when applied, what it created.
+type Result struct {
+	Commands []string
+	// Branch is the branch created, empty until the restore is applied.
+	Branch      string
+	NewHead     string
+	OperationID string
+}
+
 // Execute runs the restore operation and records the bookkeeping artifacts.
 func Execute(ctx context.Context, runner *git.Runner, branchName, commitHash string, audit *logs.AuditLog) error {
+	_, err := Run(ctx, runner, Options{Branch: branchName, Commit: commitHash, Audit: audit, Apply: true}, nil)
+	return err
+}
+
+// Run plans the restore described by opts and, when opts.Apply is set,
+// creates the branch and records it in the operation log and undo stack.
+// Messages for the user go to out; a nil out discards them.
+func Run(ctx context.Context, runner *git.Runner, opts Options, out io.Writer) (Result, error) {
 	_ = ctx
 	if runner == nil {
 		runner = &git.Runner{}
 	}
+	if out == nil {
+		out = io.Discard
+	}
+	branchName, commitHash := opts.Branch, opts.Commit
+	result := Result{Commands: Plan(branchName, commitHash)}
+	if err := git.ValidateBranchName(branchName); err != nil {
+		return result, fmt.Errorf("invalid branch name %q: %w", branchName, err)
+	}
+
+	exists, err := runner.BranchExists(branchName)
+	if err != nil {
+		return result, err
+	}
+	if exists {
+		return result, fmt.Errorf("branch %s already exists", branchName)
+	}
+	if !opts.Apply {
+		fmt.Fprintln(out, "Planned commands:")
+		for _, command := range result.Commands {
+			fmt.Fprintf(out, "  %s\n", command)
+		}
+		return result, nil
+	}
 
 	if _, stderr, err := runner.Run("branch", branchName, commitHash); err != nil {
-		return fmt.Errorf("git branch %s %s failed: %v (%s)", branchName, commitHash, err, stderr)
+		return result, fmt.Errorf("git branch %s %s failed: %v (%s)", branchName, commitHash, err, stderr)
+	}
+	result.Branch = branchName
+	stdout, stderr, err := runner.Run("rev-parse", branchName)
+	if err != nil {
+		return result, fmt.Errorf("git rev-parse %s failed: %v (%s)", branchName, err, strings.TrimSpace(stderr))
 	}
+	result.NewHead = strings.TrimSpace(stdout)
 
-	if audit != nil {
-		audit.Record(logs.Entry{
+	if opts.Audit != nil {
+		opts.Audit.Record(logs.Entry{
 			Summary: fmt.Sprintf("restore branch %s", branchName),
 			Metadata: map[string]string{
 				"branch": branchName,
@@ -35,18 +93,18 @@ func Execute(ctx context.Context, runner *git.Runner, branchName, commitHash str
 		})
 	}
 
-	plan := Plan(branchName, commitHash)
 	op := logs.Operation{
+		Kind:      logs.KindRestore,
 		Source:    branchName,
 		Target:    branchName,
 		StartHash: commitHash,
 		EndHash:   commitHash,
 		Message:   fmt.Sprintf("Restore branch %s at %s", branchName, commitHash),
-		Commands:  plan,
+		Commands:  result.Commands,
 		Timestamp: time.Now().UTC(),
 	}
-	if err := logs.WriteOperation(op); err != nil {
-		return err
+	if result.OperationID, err = logs.RecordOperation(op); err != nil {
+		return result, err
 	}
 
 	undo := logs.UndoEntry{
@@ -55,8 +113,9 @@ func Execute(ctx context.Context, runner *git.Runner, branchName, commitHash str
 		Timestamp: time.Now().UTC(),
 	}
 	if err := logs.PushUndo(undo); err != nil {
-		return err
+		return result, err
 	}
 
-	return nil
+	fmt.Fprintf(out, "Created branch %s at %s.\n", branchName, result.NewHead)
+	return result, nil
 }
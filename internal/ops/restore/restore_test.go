@@ -47,3 +47,45 @@ func TestExecuteCreatesBranchAndLogs(t *testing.T) {
 	require.True(t, ok)
 	require.Equal(t, branchName, undoEntry.Source)
 }
+
+func TestExecuteRejectsExistingBranch(t *testing.T) {
+	repo := repohelper.Init(t)
+	logs.SetBasePath(repo.Path)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	repo.MustRun(t, "branch", "backup")
+	commit := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	err := Execute(context.Background(), &git.Runner{Dir: repo.Path}, "backup", commit, nil)
+	require.EqualError(t, err, "branch backup already exists")
+
+	_, statErr := os.Stat(filepath.Join(repo.Path, ".gitcherry", "logs"))
+	require.True(t, os.IsNotExist(statErr))
+}
+
+func TestRunReturnsResult(t *testing.T) {
+	repo := repohelper.Init(t)
+	logs.SetBasePath(repo.Path)
+	t.Cleanup(func() { logs.SetBasePath("") })
+
+	commit := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	runner := &git.Runner{Dir: repo.Path}
+
+	result, err := Run(context.Background(), runner, Options{Branch: "backup", Commit: commit}, nil)
+	require.NoError(t, err)
+	require.Equal(t, Plan("backup", commit), result.Commands)
+	require.Empty(t, result.Branch)
+	require.Empty(t, result.OperationID)
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "branch", "--list", "backup")))
+
+	result, err = Run(context.Background(), runner, Options{Branch: "backup", Commit: commit, Apply: true}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "backup", result.Branch)
+	require.Equal(t, commit, result.NewHead)
+	require.NotEmpty(t, result.OperationID)
+
+	op, err := logs.OperationByID(logs.OperationsDir(), result.OperationID)
+	require.NoError(t, err)
+	require.Equal(t, "backup", op.Target)
+	require.Equal(t, commit, op.StartHash)
+}
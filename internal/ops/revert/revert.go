@@ -3,9 +3,14 @@ package revert
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 )
 
-import "github.com/julianchen24/gitcherry/internal/git"
+import (
+	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/internal/logs"
+)
 
 // Plan returns the shell commands required to revert a range of commits.
 func Plan(source, target, startHash, endHash, message string) []string {
@@ -17,25 +22,128 @@ func Plan(source, target, startHash, endHash, message string) []string {
 	}
 }
 
+// Options describes a revert for Run.
+type Options struct {
+	Source    string
+	Target    string
+	StartHash string
+	EndHash   string
+	Message   string
+	// AllowConflictMarkers commits the revert even when staged files still
+	// contain conflict markers.
+	AllowConflictMarkers bool
+	// Apply performs the planned commands; otherwise Run only plans them.
+	Apply bool
+}
+
+// Result reports what Run planned and, when applied, the commit it made.
+type Result struct {
+	Target     string
+	Commands   []string
+	BeforeHead string
+	NewHead    string
+	// OperationID is the ID the revert was logged under. Run does not log,
+	// so the caller that records Operation sets it.
+	OperationID string
+}
+
+// Operation returns the log entry for r, the result of applying opts.
+func (r Result) Operation(opts Options) logs.Operation {
+	return logs.Operation{
+		Kind:       logs.KindRevert,
+		Source:     opts.Source,
+		Target:     opts.Target,
+		StartHash:  opts.StartHash,
+		EndHash:    opts.EndHash,
+		Message:    opts.Message,
+		Commands:   r.Commands,
+		BeforeHead: r.BeforeHead,
+	}
+}
+
+// UndoEntry returns the undo entry that moves the target of r back.
+func (r Result) UndoEntry() logs.UndoEntry {
+	return logs.UndoEntry{
+		Source:     r.Target,
+		Target:     r.Target,
+		BeforeHead: r.BeforeHead,
+		AfterHead:  r.NewHead,
+	}
+}
+
 // Execute performs the revert using the provided git runner.
 func Execute(ctx context.Context, runner *git.Runner, target, startHash, endHash, message string) error {
+	_, err := Run(ctx, runner, Options{Target: target, StartHash: startHash, EndHash: endHash, Message: message, Apply: true}, nil)
+	return err
+}
+
+// Run plans the revert described by opts and performs it when opts.Apply is
+// set, reverting the range in a single commit on the target. Messages for the
+// user go to out; a nil out discards them. Run does not log the operation or
+// record an undo entry.
+func Run(ctx context.Context, runner *git.Runner, opts Options, out io.Writer) (Result, error) {
 	if runner == nil {
 		runner = &git.Runner{}
 	}
+	if out == nil {
+		out = io.Discard
+	}
+	result := Result{
+		Target:   opts.Target,
+		Commands: Plan(opts.Source, opts.Target, opts.StartHash, opts.EndHash, opts.Message),
+	}
+	if !opts.Apply {
+		fmt.Fprintln(out, "Planned commands:")
+		for _, command := range result.Commands {
+			fmt.Fprintf(out, "  %s\n", command)
+		}
+		return result, nil
+	}
 
-	if _, stderr, err := runner.Run("checkout", target); err != nil {
-		return fmt.Errorf("git checkout %s failed: %v (%s)", target, err, stderr)
+	if err := git.CheckoutBranch(runner, opts.Target, false); err != nil {
+		return result, fmt.Errorf("git checkout %s failed: %w", opts.Target, err)
 	}
+	head, err := revParse(runner, "HEAD")
+	if err != nil {
+		return result, err
+	}
+	result.BeforeHead = head
 
-	rangeSpec := fmt.Sprintf("%s^..%s", startHash, endHash)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	rangeSpec := fmt.Sprintf("%s^..%s", opts.StartHash, opts.EndHash)
 	if _, stderr, err := runner.Run("revert", "--no-commit", rangeSpec); err != nil {
-		return fmt.Errorf("git revert --no-commit %s failed: %v (%s). Resolve conflicts, then run 'git revert --continue' or 'git revert --abort'",
+		return result, fmt.Errorf("git revert --no-commit %s failed: %v (%s). Resolve conflicts, then run 'git revert --continue' or 'git revert --abort'",
 			rangeSpec, err, stderr)
 	}
 
-	if _, stderr, err := runner.Run("commit", "-m", message); err != nil {
-		return fmt.Errorf("git commit failed: %v (%s)", err, stderr)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	if !opts.AllowConflictMarkers {
+		if err := git.CheckStagedConflictMarkers(runner); err != nil {
+			return result, err
+		}
+	}
+
+	if _, stderr, err := runner.Run("commit", "-m", opts.Message); err != nil {
+		return result, fmt.Errorf("git commit failed: %v (%s)", err, stderr)
 	}
 
-	return nil
+	if result.NewHead, err = revParse(runner, "HEAD"); err != nil {
+		return result, err
+	}
+	fmt.Fprintf(out, "Reverted %s on %s as %s.\n", rangeSpec, opts.Target, result.NewHead)
+	return result, nil
+}
+
+func revParse(runner *git.Runner, ref string) (string, error) {
+	stdout, stderr, err := runner.Run("rev-parse", ref)
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse %s failed: %v (%s)", ref, err, strings.TrimSpace(stderr))
+	}
+	return strings.TrimSpace(stdout), nil
 }
@@ -1,6 +1,7 @@
 package revert
 
 import (
+	"bytes"
 	"context"
 	"strings"
 	"testing"
@@ -8,6 +9,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/internal/logs"
 	"github.com/julianchen24/gitcherry/tests/repohelper"
 )
 
@@ -43,3 +45,55 @@ func TestExecuteRevert(t *testing.T) {
 	status := strings.TrimSpace(repo.MustRun(t, "status", "--porcelain"))
 	require.Empty(t, status)
 }
+
+func TestRunReturnsResult(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "feature")
+	commit := repo.CommitFile(t, "file1.txt", "first\n", "first commit")
+
+	runner := &git.Runner{Dir: repo.Path}
+	opts := Options{Source: "main", Target: "feature", StartHash: commit, EndHash: commit, Message: "Revert first"}
+	out := &bytes.Buffer{}
+	result, err := Run(context.Background(), runner, opts, out)
+	require.NoError(t, err)
+	require.Equal(t, Plan("main", "feature", commit, commit, "Revert first"), result.Commands)
+	require.Empty(t, result.NewHead)
+	require.Contains(t, out.String(), "Planned commands:")
+	require.Equal(t, commit, strings.TrimSpace(repo.MustRun(t, "rev-parse", "feature")))
+
+	opts.Apply = true
+	result, err = Run(context.Background(), runner, opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, commit, result.BeforeHead)
+	require.Equal(t, strings.TrimSpace(repo.MustRun(t, "rev-parse", "feature")), result.NewHead)
+	require.NotEqual(t, commit, result.NewHead)
+	require.Empty(t, result.OperationID)
+
+	op := result.Operation(opts)
+	require.Equal(t, logs.KindRevert, op.Kind)
+	require.Equal(t, result.BeforeHead, op.BeforeHead)
+	require.Equal(t, "Revert first", op.Message)
+	undo := result.UndoEntry()
+	require.Equal(t, "feature", undo.Target)
+	require.Equal(t, result.NewHead, undo.AfterHead)
+}
+
+func TestRunAllowConflictMarkers(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "feature")
+	repo.CommitFile(t, "merge.txt", "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> other\n", "fixture")
+	fix := repo.CommitFile(t, "merge.txt", "resolved\n", "resolve fixture")
+
+	runner := &git.Runner{Dir: repo.Path}
+	opts := Options{Source: "feature", Target: "feature", StartHash: fix, EndHash: fix, Message: "Revert fix", Apply: true}
+	_, err := Run(context.Background(), runner, opts, nil)
+	require.ErrorContains(t, err, "conflict markers remain in merge.txt")
+	repo.MustRun(t, "reset", "--hard")
+	require.Equal(t, fix, strings.TrimSpace(repo.MustRun(t, "rev-parse", "feature")))
+
+	opts.AllowConflictMarkers = true
+	result, err := Run(context.Background(), runner, opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, fix, result.BeforeHead)
+	require.Equal(t, "Revert fix", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "feature")))
+}
@@ -2,39 +2,85 @@ package ops
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
 
 	"github.com/julianchen24/gitcherry/internal/config"
 	"github.com/julianchen24/gitcherry/internal/logs"
-	"github.com/julianchen24/gitcherry/internal/tui"
 )
 
+// UIRunner is the user interface driven by a Runner. *tui.App implements it;
+// keeping ops free of a tui import lets the TUI depend on ops later.
+type UIRunner interface {
+	Run(ctx context.Context) error
+	Stop()
+}
+
+// NullUI is a UIRunner for headless use. Run returns immediately.
+type NullUI struct{}
+
+// Run implements UIRunner.
+func (NullUI) Run(context.Context) error { return nil }
+
+// Stop implements UIRunner.
+func (NullUI) Stop() {}
+
 // Runner wires together the configuration, audit trail, and user interface.
+// User-facing messages go to its output writer, os.Stdout unless SetOutput
+// says otherwise.
 type Runner struct {
-	app   *tui.App
+	app   UIRunner
 	cfg   *config.Config
 	audit *logs.AuditLog
+	out   io.Writer
 }
 
-// NewRunner constructs a Runner using the provided collaborators.
-func NewRunner(app *tui.App, cfg *config.Config, audit *logs.AuditLog) *Runner {
+// NewRunner constructs a Runner using the provided collaborators. A nil app
+// runs headless with NullUI.
+func NewRunner(app UIRunner, cfg *config.Config, audit *logs.AuditLog) *Runner {
 	if cfg == nil {
 		cfg = config.Default()
 	}
+	if app == nil {
+		app = NullUI{}
+	}
 	return &Runner{
 		app:   app,
 		cfg:   cfg,
 		audit: audit,
+		out:   os.Stdout,
+	}
+}
+
+// SetOutput sends the runner's messages to w, so programs embedding
+// GitCherry can capture them. A nil w discards them.
+func (r *Runner) SetOutput(w io.Writer) {
+	if w == nil {
+		w = io.Discard
 	}
+	r.out = w
 }
 
-// Run boots the terminal UI. Additional orchestration will be added later.
+// Output returns the writer the runner's messages go to.
+func (r *Runner) Output() io.Writer {
+	return r.out
+}
+
+// Printf writes a user-facing message to the runner's output.
+func (r *Runner) Printf(format string, args ...any) {
+	fmt.Fprintf(r.out, format, args...)
+}
+
+// Run boots the user interface. Additional orchestration will be added later.
 func (r *Runner) Run(ctx context.Context) error {
 	if r.audit != nil {
 		r.audit.Record(logs.Entry{Summary: "runner started"})
 	}
-
-	if r.app == nil {
-		return nil
-	}
 	return r.app.Run(ctx)
 }
+
+// Stop asks the user interface to exit.
+func (r *Runner) Stop() {
+	r.app.Stop()
+}
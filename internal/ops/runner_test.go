@@ -0,0 +1,71 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/julianchen24/gitcherry/internal/logs"
+)
+
+type fakeUI struct {
+	runs    int
+	stopped bool
+}
+
+func (f *fakeUI) Run(context.Context) error {
+	f.runs++
+	return nil
+}
+
+func (f *fakeUI) Stop() { f.stopped = true }
+
+func TestRunnerDelegatesToUI(t *testing.T) {
+	ui := &fakeUI{}
+	audit := logs.NewAuditLog()
+	runner := NewRunner(ui, nil, audit)
+
+	require.NoError(t, runner.Run(context.Background()))
+	require.Equal(t, 1, ui.runs)
+	require.Equal(t, "runner started", audit.Entries()[0].Summary)
+
+	runner.Stop()
+	require.True(t, ui.stopped)
+}
+
+func TestRunnerDefaultsToNullUI(t *testing.T) {
+	runner := NewRunner(nil, nil, nil)
+	require.NoError(t, runner.Run(context.Background()))
+	runner.Stop()
+}
+
+func TestRunnerWritesToConfiguredOutput(t *testing.T) {
+	runner := NewRunner(nil, nil, nil)
+	buf := &bytes.Buffer{}
+	runner.SetOutput(buf)
+
+	runner.Printf("[dry-run] %s; use --apply to execute\n", "session")
+	require.NoError(t, runner.Run(context.Background()))
+	require.Equal(t, "[dry-run] session; use --apply to execute\n", buf.String())
+	require.Same(t, buf, runner.Output())
+
+	runner.SetOutput(nil)
+	runner.Printf("dropped\n")
+	require.Equal(t, "[dry-run] session; use --apply to execute\n", buf.String())
+}
+
+func TestOpsDoesNotImportTUI(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+	out, err := exec.Command(goBin, "list", "-deps", "./...").CombinedOutput()
+	require.NoError(t, err, string(out))
+	for _, pkg := range strings.Fields(string(out)) {
+		require.NotEqual(t, "github.com/julianchen24/gitcherry/internal/tui", pkg)
+	}
+}
@@ -1,14 +1,63 @@
 package transfer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/julianchen24/gitcherry/internal/git"
 )
 
-// DetectDuplicates returns commits whose patch-ids already exist on the target branch.
-func DetectDuplicates(runner *git.Runner, target string, commits []git.Commit) ([]git.Commit, error) {
+// Duplicate detection strategies accepted by --duplicate-strategy.
+const (
+	StrategyPatchID   = "patch-id"
+	StrategyHeuristic = "heuristic"
+)
+
+// HeuristicTargetDepth is how many of the target's most recent commits the
+// heuristic strategy compares against.
+const HeuristicTargetDepth = 1000
+
+// Duplicate pairs a commit from the transfer range with the commit on the
+// target branch that already carries the same patch.
+type Duplicate struct {
+	Commit     git.Commit
+	TargetHash string
+}
+
+// DetectDuplicates returns commits whose patch-ids already exist on the target
+// branch. Cancelling ctx stops the scan and returns ctx's error.
+func DetectDuplicates(ctx context.Context, runner *git.Runner, target string, commits []git.Commit) ([]git.Commit, error) {
+	matches, err := FindDuplicates(ctx, runner, target, commits)
+	if err != nil {
+		return nil, err
+	}
+	duplicates := make([]git.Commit, 0, len(matches))
+	for _, match := range matches {
+		duplicates = append(duplicates, match.Commit)
+	}
+	return duplicates, nil
+}
+
+// FindDuplicates is like DetectDuplicates but also reports which target
+// commit each duplicate matches. Patch-ids are computed with GOMAXPROCS
+// concurrent git processes.
+func FindDuplicates(ctx context.Context, runner *git.Runner, target string, commits []git.Commit) ([]Duplicate, error) {
+	return FindDuplicatesWithWorkers(ctx, runner, target, commits, 0)
+}
+
+// FindDuplicatesWithWorkers is like FindDuplicates but computes patch-ids with
+// at most workers concurrent git processes. A non-positive workers uses
+// GOMAXPROCS. The result does not depend on the number of workers.
+//
+// Only the target's commits since it diverged from the range are compared by
+// patch-id; commits of the range the target already contains match
+// themselves.
+func FindDuplicatesWithWorkers(ctx context.Context, runner *git.Runner, target string, commits []git.Commit, workers int) ([]Duplicate, error) {
 	if len(commits) == 0 {
 		return nil, nil
 	}
@@ -16,38 +65,221 @@ func DetectDuplicates(runner *git.Runner, target string, commits []git.Commit) (
 		runner = &git.Runner{}
 	}
 
-	out, stderr, err := runner.Run("log", "--pretty=%H", target)
+	rangeHashes := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		rangeHashes = append(rangeHashes, commit.Hash)
+	}
+	targetHashes, err := runner.RunLines(append([]string{"rev-list", target, "--not"}, rangeHashes...)...)
+	if err != nil {
+		return nil, err
+	}
+	outside, err := runner.RunLines(append(append([]string{"rev-list"}, rangeHashes...), "--not", target)...)
+	if err != nil {
+		return nil, err
+	}
+	missing := make(map[string]bool, len(outside))
+	for _, hash := range outside {
+		missing[hash] = true
+	}
+
+	var picked []git.Commit
+	contained := make(map[string]bool)
+	hashes := append([]string{}, targetHashes...)
+	for _, commit := range commits {
+		if !missing[commit.Hash] {
+			contained[commit.Hash] = true
+			continue
+		}
+		picked = append(picked, commit)
+		hashes = append(hashes, commit.Hash)
+	}
+	pids, err := PatchIDs(ctx, runner, hashes, workers)
 	if err != nil {
-		return nil, commandError(err, stderr)
+		return nil, err
 	}
 
-	targetHashes := strings.Fields(strings.TrimSpace(out))
-	patches := make(map[string]struct{}, len(targetHashes))
-	for _, hash := range targetHashes {
-		pid, err := runner.PatchID(hash)
-		if err != nil || pid == "" {
+	// Walk the target newest first so each patch maps to its latest commit.
+	patches := make(map[string]string, len(targetHashes))
+	for i, hash := range targetHashes {
+		pid := pids[i]
+		if pid == "" {
+			continue
+		}
+		if _, seen := patches[pid]; !seen {
+			patches[pid] = hash
+		}
+	}
+
+	pickedPIDs := make(map[string]string, len(picked))
+	for i, commit := range picked {
+		pickedPIDs[commit.Hash] = pids[len(targetHashes)+i]
+	}
+	duplicates := make([]Duplicate, 0)
+	for _, commit := range commits {
+		if contained[commit.Hash] {
+			duplicates = append(duplicates, Duplicate{Commit: commit, TargetHash: commit.Hash})
 			continue
 		}
-		patches[pid] = struct{}{}
+		pid := pickedPIDs[commit.Hash]
+		if pid == "" {
+			continue
+		}
+		if match, ok := patches[pid]; ok {
+			duplicates = append(duplicates, Duplicate{Commit: commit, TargetHash: match})
+		}
+	}
+	return duplicates, nil
+}
+
+// PatchIDs returns the patch-id of each hash, in the same order, using at most
+// workers concurrent git processes (GOMAXPROCS when workers is not positive).
+// Commits without a diff, such as merges, map to "". Any other failure is
+// returned rather than leaving a hole that would hide a duplicate.
+// Cancelling ctx kills the running git processes and returns ctx's error. If
+// git patch-id itself fails the error wraps git.ErrPatchIDUnavailable.
+func PatchIDs(ctx context.Context, runner *git.Runner, hashes []string, workers int) ([]string, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(hashes))
+
+	pids := make([]string, len(hashes))
+	errs := make([]error, len(hashes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Each worker writes only its own index, so no locking is needed.
+				pids[i], errs[i] = runner.PatchIDContext(ctx, hashes[i])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range hashes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("duplicate scan cancelled: %w", err)
+	}
+	for _, err := range errs {
+		if errors.Is(err, git.ErrPatchIDUnavailable) {
+			return nil, err
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("computing the patch-id of %s: %w", shortHash(hashes[i]), err)
+		}
+	}
+	return pids, nil
+}
+
+// DetectDuplicatesHeuristic is like DetectDuplicates but treats a commit as a
+// duplicate when one of the target's HeuristicTargetDepth most recent commits
+// has the same subject and changes the same files. It needs two git processes
+// instead of one per commit and catches commits whose content changed while
+// being rebased, but it also flags unrelated commits that happen to share a
+// subject and file list.
+func DetectDuplicatesHeuristic(ctx context.Context, runner *git.Runner, target string, commits []git.Commit) ([]git.Commit, error) {
+	matches, err := FindDuplicatesHeuristic(ctx, runner, target, commits)
+	if err != nil {
+		return nil, err
+	}
+	duplicates := make([]git.Commit, 0, len(matches))
+	for _, match := range matches {
+		duplicates = append(duplicates, match.Commit)
+	}
+	return duplicates, nil
+}
+
+// FindDuplicatesHeuristic is like DetectDuplicatesHeuristic but also reports
+// which target commit each duplicate matches.
+func FindDuplicatesHeuristic(ctx context.Context, runner *git.Runner, target string, commits []git.Commit) ([]Duplicate, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	if runner == nil {
+		runner = &git.Runner{}
+	}
+
+	targetKeys, err := subjectAndFiles(ctx, runner, "log", fmt.Sprintf("--max-count=%d", HeuristicTargetDepth), target)
+	if err != nil {
+		return nil, err
+	}
+	patches := make(map[string]string, len(targetKeys))
+	for _, entry := range targetKeys {
+		if _, seen := patches[entry.key]; !seen {
+			patches[entry.key] = entry.hash
+		}
 	}
 
-	duplicates := make([]git.Commit, 0)
+	args := []string{"show"}
 	for _, commit := range commits {
-		pid, err := runner.PatchID(commit.Hash)
-		if err != nil || pid == "" {
+		args = append(args, commit.Hash)
+	}
+	sourceKeys, err := subjectAndFiles(ctx, runner, args...)
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]string, len(sourceKeys))
+	for _, entry := range sourceKeys {
+		byHash[entry.hash] = entry.key
+	}
+
+	duplicates := make([]Duplicate, 0)
+	for _, commit := range commits {
+		key, ok := byHash[commit.Hash]
+		if !ok {
 			continue
 		}
-		if _, ok := patches[pid]; ok {
-			duplicates = append(duplicates, commit)
+		if match, ok := patches[key]; ok {
+			duplicates = append(duplicates, Duplicate{Commit: commit, TargetHash: match})
 		}
 	}
 	return duplicates, nil
 }
 
-func commandError(err error, stderr string) error {
-	stderr = strings.TrimSpace(stderr)
-	if stderr == "" {
-		return err
+type commitKey struct {
+	hash string
+	key  string
+}
+
+// subjectAndFiles runs a git log or show command and keys each commit by its
+// subject and the sorted list of files it changes. Commits that change no
+// files, such as merges, are left out.
+func subjectAndFiles(ctx context.Context, runner *git.Runner, args ...string) ([]commitKey, error) {
+	args = append([]string{"-c", "core.quotePath=false"}, append(args, "-z", "--format=%x1e%H%x00%s", "--name-only", "--")...)
+	stdout, stderr, err := runner.RunContext(ctx, args...)
+	if err != nil {
+		return nil, git.CommandError(err, stderr)
+	}
+
+	var keys []commitKey
+	for _, record := range strings.Split(stdout, "\x1e") {
+		// <hash> NUL <subject> NUL, then the NUL-terminated paths, which
+		// may contain newlines.
+		hash, rest, ok := strings.Cut(record, "\x00")
+		if !ok {
+			continue
+		}
+		subject, body, _ := strings.Cut(rest, "\x00")
+		files := git.SplitNUL(strings.TrimPrefix(body, "\n"))
+		if len(files) == 0 {
+			continue
+		}
+		slices.Sort(files)
+		keys = append(keys, commitKey{hash: hash, key: subject + "\x00" + strings.Join(files, "\n")})
 	}
-	return fmt.Errorf("%w: %s", err, stderr)
+	return keys, nil
 }
@@ -1,8 +1,13 @@
 package transfer
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -25,7 +30,7 @@ func TestDetectDuplicatesFindsMatchingPatch(t *testing.T) {
 	dupHash := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
 	commits := []git.Commit{{Hash: dupHash}}
 
-	duplicates, err := DetectDuplicates(&git.Runner{Dir: repo.Path}, "target", commits)
+	duplicates, err := DetectDuplicates(context.Background(), &git.Runner{Dir: repo.Path}, "target", commits)
 	require.NoError(t, err)
 	require.Len(t, duplicates, 1)
 	require.Equal(t, dupHash, duplicates[0].Hash)
@@ -43,7 +48,257 @@ func TestDetectDuplicatesIgnoresUniquePatch(t *testing.T) {
 	dupHash := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
 	commits := []git.Commit{{Hash: dupHash}}
 
-	duplicates, err := DetectDuplicates(&git.Runner{Dir: repo.Path}, "target", commits)
+	duplicates, err := DetectDuplicates(context.Background(), &git.Runner{Dir: repo.Path}, "target", commits)
 	require.NoError(t, err)
 	require.Len(t, duplicates, 0)
 }
+
+func TestFindDuplicatesReportsTargetMatch(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	targetHash := repo.CommitFile(t, "file.txt", "line1\n", "target commit")
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	repo.MustRun(t, "cherry-pick", "target")
+	dupHash := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	unique := repo.CommitFile(t, "other.txt", "other\n", "unique")
+
+	matches, err := FindDuplicates(context.Background(), &git.Runner{Dir: repo.Path}, "target", []git.Commit{{Hash: dupHash}, {Hash: unique}})
+	require.NoError(t, err)
+	require.Equal(t, []Duplicate{{Commit: git.Commit{Hash: dupHash}, TargetHash: targetHash}}, matches)
+}
+
+// buildDuplicateRepo creates a target branch with n commits and a source branch
+// whose every third commit repeats a target patch.
+func buildDuplicateRepo(tb testing.TB, n int) (*repohelper.Repo, []git.Commit) {
+	tb.Helper()
+	repo := repohelper.Init(tb)
+
+	repo.MustRun(tb, "checkout", "-b", "target")
+	for i := 0; i < n; i++ {
+		repo.CommitFile(tb, fmt.Sprintf("t%03d.txt", i), fmt.Sprintf("%d\n", i), fmt.Sprintf("target %d", i))
+	}
+
+	repo.MustRun(tb, "checkout", "main")
+	repo.MustRun(tb, "checkout", "-b", "source")
+	commits := make([]git.Commit, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("s%03d.txt", i)
+		if i%3 == 0 {
+			name = fmt.Sprintf("t%03d.txt", i)
+		}
+		hash := repo.CommitFile(tb, name, fmt.Sprintf("%d\n", i), fmt.Sprintf("source %d", i))
+		commits = append(commits, git.Commit{Hash: hash})
+	}
+	return repo, commits
+}
+
+func TestFindDuplicatesParallelMatchesSerial(t *testing.T) {
+	repo, commits := buildDuplicateRepo(t, 24)
+	runner := &git.Runner{Dir: repo.Path}
+
+	serial, err := FindDuplicatesWithWorkers(context.Background(), runner, "target", commits, 1)
+	require.NoError(t, err)
+	require.Len(t, serial, 8)
+
+	for _, workers := range []int{0, 4, 64} {
+		parallel, err := FindDuplicatesWithWorkers(context.Background(), runner, "target", commits, workers)
+		require.NoError(t, err)
+		require.Equal(t, serial, parallel, "workers=%d", workers)
+	}
+}
+
+func TestFindDuplicatesStopsWhenCancelled(t *testing.T) {
+	repo, commits := buildDuplicateRepo(t, 3)
+	runner := &git.Runner{Dir: repo.Path}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := FindDuplicates(ctx, runner, "target", commits)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Scan a long history and cancel part-way through.
+	hashes := make([]string, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		hashes = append(hashes, commits[i%len(commits)].Hash)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = PatchIDs(ctx, runner, hashes, 2)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func BenchmarkFindDuplicates(b *testing.B) {
+	repo, commits := buildDuplicateRepo(b, 200)
+	runner := &git.Runner{Dir: repo.Path}
+
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{{"serial", 1}, {"parallel", 0}} {
+		b.Run(bench.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := FindDuplicatesWithWorkers(context.Background(), runner, "target", commits, bench.workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFindDuplicatesReportsUnavailablePatchID(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "file.txt", "line1\n", "target commit")
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	hash := repo.CommitFile(t, "other.txt", "x\n", "source commit")
+
+	runner := &git.Runner{Dir: repo.Path, ExtraEnv: []string{
+		"GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=patchid.stable", "GIT_CONFIG_VALUE_0=maybe",
+	}}
+	_, err := FindDuplicates(context.Background(), runner, "target", []git.Commit{{Hash: hash}})
+	require.ErrorIs(t, err, git.ErrPatchIDUnavailable)
+}
+
+func TestPatchIDsReportsFailuresAndSkipsMerges(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "side")
+	side := repo.CommitFile(t, "side.txt", "side\n", "side commit")
+	repo.MustRun(t, "checkout", "main")
+	repo.CommitFile(t, "main.txt", "main\n", "main commit")
+	repo.MustRun(t, "merge", "--no-ff", "-m", "merge side", "side")
+	merge := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	runner := &git.Runner{Dir: repo.Path}
+
+	pids, err := PatchIDs(context.Background(), runner, []string{side, merge}, 2)
+	require.NoError(t, err)
+	require.Len(t, pids[0], 40)
+	require.Empty(t, pids[1], "a merge has no patch-id")
+
+	// A commit that cannot be read fails the scan instead of being treated
+	// as having no duplicate.
+	missing := strings.Repeat("0", 40)
+	_, err = PatchIDs(context.Background(), runner, []string{side, missing}, 2)
+	require.ErrorContains(t, err, "computing the patch-id of 0000000")
+}
+
+func TestFindDuplicatesHeuristicMatchesSubjectAndFiles(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	require.NoError(t, repo.WriteFile("a.txt", "target a\n"))
+	require.NoError(t, repo.WriteFile("b c.txt", "target b\n"))
+	repo.MustRun(t, "add", ".")
+	repo.MustRun(t, "commit", "-m", "shared change")
+	targetHash := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	repo.CommitFile(t, "a.txt", "later\n", "same files, other subject")
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	// Same subject and files but different content: patch-ids differ, the
+	// heuristic still matches.
+	require.NoError(t, repo.WriteFile("b c.txt", "source b\n"))
+	require.NoError(t, repo.WriteFile("a.txt", "source a\n"))
+	repo.MustRun(t, "add", ".")
+	repo.MustRun(t, "commit", "-m", "shared change")
+	match := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+	differentFiles := repo.CommitFile(t, "c.txt", "c\n", "shared change")
+	differentSubject := repo.CommitFile(t, "a.txt", "again\n", "unrelated")
+
+	runner := &git.Runner{Dir: repo.Path}
+	commits := []git.Commit{{Hash: match}, {Hash: differentFiles}, {Hash: differentSubject}}
+	duplicates, err := FindDuplicatesHeuristic(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Equal(t, []Duplicate{{Commit: git.Commit{Hash: match}, TargetHash: targetHash}}, duplicates)
+
+	exact, err := FindDuplicates(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Empty(t, exact)
+
+	hashes, err := DetectDuplicatesHeuristic(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Equal(t, []git.Commit{{Hash: match}}, hashes)
+}
+
+func TestDetectDuplicatesHeuristicCatchesRebasedCommit(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.CommitFile(t, "config.txt", "timeout=10\n", "add config")
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "config.txt", "timeout=10\nretries=3\n", "add retries setting")
+
+	// The source carries the same change, but it was adjusted while resolving
+	// a rebase conflict, so its patch-id no longer matches.
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	rebased := repo.CommitFile(t, "config.txt", "timeout=10\nretries=5\n", "add retries setting")
+
+	runner := &git.Runner{Dir: repo.Path}
+	commits := []git.Commit{{Hash: rebased}}
+
+	exact, err := DetectDuplicates(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Empty(t, exact)
+
+	approximate, err := DetectDuplicatesHeuristic(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Equal(t, commits, approximate)
+}
+
+func TestDetectDuplicatesHeuristicFalsePositive(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "README.md", "Install with make.\n", "update docs")
+
+	// An unrelated change with the same generic subject and file is flagged
+	// even though it carries different content.
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	unrelated := repo.CommitFile(t, "README.md", "Licensed under MIT.\n", "update docs")
+
+	runner := &git.Runner{Dir: repo.Path}
+	commits := []git.Commit{{Hash: unrelated}}
+
+	exact, err := DetectDuplicates(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Empty(t, exact)
+
+	approximate, err := DetectDuplicatesHeuristic(context.Background(), runner, "target", commits)
+	require.NoError(t, err)
+	require.Equal(t, commits, approximate)
+}
+
+func TestFindDuplicatesOnlyScansTargetSinceTheRange(t *testing.T) {
+	repo := repohelper.Init(t)
+	// Shared history whose diff cannot be shown, as with a partial clone:
+	// scanning it would fail the whole duplicate check.
+	repo.CommitFile(t, "old.txt", "unreadable\n", "old shared commit")
+	blob := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD:old.txt"))
+	repo.MustRun(t, "rm", "-q", "old.txt")
+	repo.MustRun(t, "commit", "-q", "-m", "remove old.txt")
+	require.NoError(t, os.Remove(filepath.Join(repo.Path, ".git", "objects", blob[:2], blob[2:])))
+
+	repo.MustRun(t, "checkout", "-b", "source")
+	contained := repo.CommitFile(t, "a.txt", "a\n", "already merged")
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.MustRun(t, "checkout", "source")
+	picked := repo.CommitFile(t, "b.txt", "b\n", "cherry-picked")
+	fresh := repo.CommitFile(t, "c.txt", "c\n", "new")
+	repo.MustRun(t, "checkout", "target")
+	repo.MustRun(t, "cherry-pick", picked)
+	targetPick := strings.TrimSpace(repo.MustRun(t, "rev-parse", "HEAD"))
+
+	commits := []git.Commit{{Hash: contained}, {Hash: picked}, {Hash: fresh}}
+	duplicates, err := FindDuplicates(context.Background(), &git.Runner{Dir: repo.Path}, "target", commits)
+	require.NoError(t, err)
+	require.Equal(t, []Duplicate{
+		{Commit: git.Commit{Hash: contained}, TargetHash: contained},
+		{Commit: git.Commit{Hash: picked}, TargetHash: targetPick},
+	}, duplicates)
+}
@@ -1,14 +1,103 @@
 package transfer
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // Plan describes the shell commands required to move commits from the source
 // branch onto the target branch.
 func Plan(source, target, startHash, endHash, message string) []string {
-	rangeSpec := fmt.Sprintf("%s^..%s", startHash, endHash)
-	return []string{
-		fmt.Sprintf("git checkout %s", target),
-		fmt.Sprintf("git cherry-pick --no-commit %s", rangeSpec),
-		fmt.Sprintf("git commit -m %q", message),
+	return renderSteps(squashSteps(target, rangePicks(startHash, endHash), message))
+}
+
+// PlanNoFF describes the commands required to cherry-pick the range onto a
+// temporary branch and merge it into the target with an explicit merge commit.
+func PlanNoFF(source, target, startHash, endHash, message string) []string {
+	return renderSteps(noFFSteps(target, rangePicks(startHash, endHash), message))
+}
+
+// rangePicks returns the git cherry-pick arguments that pick the range in
+// git's default order.
+func rangePicks(startHash, endHash string) []string {
+	return []string{fmt.Sprintf("%s^..%s", startHash, endHash)}
+}
+
+// step is one git command of a squash or no-ff transfer. A step with a
+// branch set is a checkout, which Run performs through git.CheckoutBranch
+// rather than by running args.
+type step struct {
+	args   []string
+	branch string
+	create bool
+}
+
+func checkoutStep(branch string, create bool) step {
+	args := []string{"checkout", branch}
+	if create {
+		args = []string{"checkout", "-b", branch}
 	}
+	return step{args: args, branch: branch, create: create}
+}
+
+func gitStep(args ...string) step {
+	return step{args: args}
+}
+
+// squashSteps and noFFSteps hold the steps of the squash and no-ff
+// transfers, which cherry-pick picks. Plan and PlanNoFF render them and Run
+// executes them, so a dry run shows exactly what applying runs.
+func squashSteps(target string, picks []string, message string) []step {
+	return []step{
+		checkoutStep(target, false),
+		gitStep(append([]string{"cherry-pick", "--no-commit"}, picks...)...),
+		gitStep("commit", "-m", message),
+	}
+}
+
+func noFFSteps(target string, picks []string, message string) []step {
+	temp := TempBranchName(target)
+	return []step{
+		checkoutStep(target, false),
+		checkoutStep(temp, true),
+		gitStep(append([]string{"cherry-pick"}, picks...)...),
+		checkoutStep(target, false),
+		gitStep("merge", "--no-ff", temp, "-m", message),
+		gitStep("branch", "-d", temp),
+	}
+}
+
+// renderSteps formats steps as git command lines, quoting messages.
+func renderSteps(steps []step) []string {
+	commands := make([]string, 0, len(steps))
+	for _, s := range steps {
+		commands = append(commands, renderStep(s.args))
+	}
+	return commands
+}
+
+func renderStep(args []string) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+		if i > 0 && args[i-1] == "-m" {
+			parts[i] = strconv.Quote(arg)
+		}
+	}
+	return "git " + strings.Join(parts, " ")
+}
+
+// PlanTag describes the command that tags the target branch once a transfer
+// has been applied. A non-empty message creates an annotated tag.
+func PlanTag(target, name, message string) string {
+	if message == "" {
+		return fmt.Sprintf("git tag %s %s", name, target)
+	}
+	return fmt.Sprintf("git tag -a -m %q %s %s", message, name, target)
+}
+
+// TempBranchName returns the scratch branch used while preparing a transfer onto target.
+func TempBranchName(target string) string {
+	return fmt.Sprintf("gitcherry-transfer/%s", target)
 }
@@ -18,3 +18,43 @@ func TestPlan(t *testing.T) {
 		}
 	}
 }
+
+func TestPlanNoFF(t *testing.T) {
+	commands := PlanNoFF("main", "release", "abc123", "def456", "Merge hotfix")
+	expected := []string{
+		"git checkout release",
+		"git checkout -b gitcherry-transfer/release",
+		"git cherry-pick abc123^..def456",
+		"git checkout release",
+		"git merge --no-ff gitcherry-transfer/release -m \"Merge hotfix\"",
+		"git branch -d gitcherry-transfer/release",
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("expected %d commands, got %d", len(expected), len(commands))
+	}
+	for i, cmd := range expected {
+		if commands[i] != cmd {
+			t.Fatalf("command %d mismatch: expected %q got %q", i, cmd, commands[i])
+		}
+	}
+}
+
+func TestNoFFStepsCheckOutThroughBranchHelper(t *testing.T) {
+	steps := noFFSteps("release", rangePicks("abc123", "def456"), "Merge hotfix")
+	checkouts := map[int]step{0: {branch: "release"}, 1: {branch: "gitcherry-transfer/release", create: true}, 3: {branch: "release"}}
+	for i, s := range steps {
+		want, ok := checkouts[i]
+		if s.branch != want.branch || s.create != want.create {
+			t.Fatalf("step %d: expected checkout %v of %q, got %q (create %v)", i, ok, want.branch, s.branch, s.create)
+		}
+	}
+}
+
+func TestPlanTag(t *testing.T) {
+	if got := PlanTag("release", "v1.2.0", ""); got != "git tag v1.2.0 release" {
+		t.Fatalf("unexpected lightweight tag command: %q", got)
+	}
+	if got := PlanTag("release", "v1.2.0", "Release 1.2.0"); got != "git tag -a -m \"Release 1.2.0\" v1.2.0 release" {
+		t.Fatalf("unexpected annotated tag command: %q", got)
+	}
+}
@@ -0,0 +1,189 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/julianchen24/gitcherry/internal/git"
+)
+
+// Progress records how far a preserve-mode transfer advanced.
+type Progress struct {
+	Total   int
+	Applied []string
+	Skipped []string
+	Failed  string
+}
+
+// Remaining returns the number of commits that were neither applied nor skipped.
+func (p Progress) Remaining() int {
+	return p.Total - len(p.Applied) - len(p.Skipped)
+}
+
+// PlanPreserve describes the commands required to cherry-pick each commit
+// individually onto the target branch, keeping the original commits intact.
+func PlanPreserve(target string, commits []git.Commit) []string {
+	commands := make([]string, 0, len(commits)+1)
+	commands = append(commands, fmt.Sprintf("git checkout %s", target))
+	for _, commit := range commits {
+		commands = append(commands, fmt.Sprintf("git cherry-pick %s", commit.Hash))
+	}
+	return commands
+}
+
+// PlanCommitEach is like PlanPreserve but, when suffix is set, amends each
+// picked commit so its subject ends with the rendered suffix. Commit messages
+// must be loaded into Commit.Message for the planned amend commands.
+func PlanCommitEach(source, target string, commits []git.Commit, suffix string) []string {
+	if suffix == "" {
+		return PlanPreserve(target, commits)
+	}
+	commands := make([]string, 0, 2*len(commits)+1)
+	commands = append(commands, fmt.Sprintf("git checkout %s", target))
+	for _, commit := range commits {
+		message := AppendSubjectSuffix(commit.Message, RenderSuffix(suffix, source, commit.Hash))
+		commands = append(commands,
+			fmt.Sprintf("git cherry-pick %s", commit.Hash),
+			fmt.Sprintf("git commit --amend -m %q", message),
+		)
+	}
+	return commands
+}
+
+// PlanReword is like PlanPreserve but amends each picked commit to use the
+// message stored in its Commit.Message.
+func PlanReword(target string, commits []git.Commit) []string {
+	commands := make([]string, 0, 2*len(commits)+1)
+	commands = append(commands, fmt.Sprintf("git checkout %s", target))
+	for _, commit := range commits {
+		commands = append(commands,
+			fmt.Sprintf("git cherry-pick %s", commit.Hash),
+			fmt.Sprintf("git commit --amend -m %q", commit.Message),
+		)
+	}
+	return commands
+}
+
+// RenderSuffix expands the {source} and {hash} placeholders in a
+// --commit-each-message-suffix value.
+func RenderSuffix(suffix, source, hash string) string {
+	return strings.NewReplacer("{source}", source, "{hash}", hash).Replace(suffix)
+}
+
+// AppendSubjectSuffix adds suffix to the end of the first line of message,
+// separated by a space, leaving the body untouched.
+func AppendSubjectSuffix(message, suffix string) string {
+	message = strings.TrimRight(message, "\n")
+	if suffix == "" {
+		return message
+	}
+	subject, body, hasBody := strings.Cut(message, "\n")
+	subject = strings.TrimSpace(subject + " " + suffix)
+	if !hasBody {
+		return subject
+	}
+	return subject + "\n" + body
+}
+
+// ExecutePreserve cherry-picks the commits onto target one at a time, in the
+// order given, and stops at the first commit that fails to apply. Commits that
+// become empty on the target are skipped rather than treated as failures.
+// Cancelling ctx stops the loop before the next commit is picked. With
+// keepDates each new commit also takes the committer date of the commit it was
+// picked from; the author and author date are always kept by cherry-pick.
+func ExecutePreserve(ctx context.Context, runner *git.Runner, target string, commits []git.Commit, keepDates bool) (Progress, error) {
+	return executeEach(ctx, runner, target, commits, keepDates, nil)
+}
+
+// ExecuteCommitEach is like ExecutePreserve but amends each applied commit so
+// its subject ends with the rendered suffix. An empty suffix keeps the
+// original messages.
+func ExecuteCommitEach(ctx context.Context, runner *git.Runner, source, target string, commits []git.Commit, suffix string, keepDates bool) (Progress, error) {
+	if suffix == "" {
+		return ExecutePreserve(ctx, runner, target, commits, keepDates)
+	}
+	return executeEach(ctx, runner, target, commits, keepDates, func(runner *git.Runner, commit git.Commit) error {
+		subject, body, err := runner.CommitMessage("HEAD")
+		if err != nil {
+			return fmt.Errorf("reading the message of HEAD: %w", err)
+		}
+		message := AppendSubjectSuffix(joinMessage(subject, body), RenderSuffix(suffix, source, commit.Hash))
+		if _, stderr, err := runner.Run("commit", "--amend", "-m", message); err != nil {
+			return fmt.Errorf("git commit --amend failed: %v (%s)", err, strings.TrimSpace(stderr))
+		}
+		return nil
+	})
+}
+
+// ExecuteReword is like ExecutePreserve but amends each applied commit to use
+// the message stored in its Commit.Message.
+func ExecuteReword(ctx context.Context, runner *git.Runner, target string, commits []git.Commit, keepDates bool) (Progress, error) {
+	return executeEach(ctx, runner, target, commits, keepDates, func(runner *git.Runner, commit git.Commit) error {
+		if _, stderr, err := runner.Run("commit", "--amend", "-m", commit.Message); err != nil {
+			return fmt.Errorf("git commit --amend failed: %v (%s)", err, strings.TrimSpace(stderr))
+		}
+		return nil
+	})
+}
+
+// executeEach runs the preserve loop, calling afterPick (when set) once each
+// commit has been picked successfully. With keepDates the pick and afterPick
+// run with GIT_COMMITTER_DATE set to the picked commit's committer date.
+func executeEach(ctx context.Context, runner *git.Runner, target string, commits []git.Commit, keepDates bool, afterPick func(*git.Runner, git.Commit) error) (Progress, error) {
+	if runner == nil {
+		runner = &git.Runner{}
+	}
+
+	progress := Progress{Total: len(commits)}
+	if err := git.CheckoutBranch(runner, target, false); err != nil {
+		return progress, fmt.Errorf("git checkout %s failed: %w", target, err)
+	}
+
+	for _, commit := range commits {
+		if err := ctx.Err(); err != nil {
+			return progress, fmt.Errorf("transfer interrupted after processing %d of %d commits: %w", progress.Total-progress.Remaining(), progress.Total, err)
+		}
+		pickRunner := runner
+		if keepDates {
+			date, err := git.CommitDate(runner, commit.Hash)
+			if err != nil {
+				return progress, err
+			}
+			dated := runner.WithExtraEnv("GIT_COMMITTER_DATE=" + date.Format(time.RFC3339))
+			pickRunner = &dated
+		}
+		if _, stderr, err := pickRunner.Run("cherry-pick", commit.Hash); err != nil {
+			if emptyPick(runner) {
+				if _, skipErr, err := runner.Run("cherry-pick", "--skip"); err != nil {
+					return progress, fmt.Errorf("git cherry-pick --skip failed: %v (%s)", err, strings.TrimSpace(skipErr))
+				}
+				progress.Skipped = append(progress.Skipped, commit.Hash)
+				continue
+			}
+			progress.Failed = commit.Hash
+			return progress, fmt.Errorf("git cherry-pick %s failed after processing %d of %d commits: %v (%s). Resolve conflicts, then run 'git cherry-pick --continue' or 'git cherry-pick --abort'",
+				commit.Hash, progress.Total-progress.Remaining(), progress.Total, err, strings.TrimSpace(stderr))
+		}
+		if afterPick != nil {
+			if err := afterPick(pickRunner, commit); err != nil {
+				progress.Failed = commit.Hash
+				return progress, err
+			}
+		}
+		progress.Applied = append(progress.Applied, commit.Hash)
+	}
+
+	return progress, nil
+}
+
+// emptyPick reports whether a stopped cherry-pick has nothing left to commit,
+// meaning the change already exists on the target.
+func emptyPick(runner *git.Runner) bool {
+	if _, _, err := runner.Run("diff", "--cached", "--quiet"); err != nil {
+		return false
+	}
+	unmerged, _, err := runner.Run("ls-files", "--unmerged")
+	return err == nil && strings.TrimSpace(unmerged) == ""
+}
@@ -0,0 +1,158 @@
+package transfer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/tests/repohelper"
+)
+
+func TestPlanPreserve(t *testing.T) {
+	commands := PlanPreserve("release", []git.Commit{{Hash: "abc"}, {Hash: "def"}})
+	require.Equal(t, []string{
+		"git checkout release",
+		"git cherry-pick abc",
+		"git cherry-pick def",
+	}, commands)
+}
+
+func TestPlanCommitEach(t *testing.T) {
+	commits := []git.Commit{{Hash: "abc", Message: "first\n\nbody"}, {Hash: "def", Message: "second"}}
+	require.Equal(t, PlanPreserve("release", commits), PlanCommitEach("main", "release", commits, ""))
+	require.Equal(t, []string{
+		"git checkout release",
+		"git cherry-pick abc",
+		`git commit --amend -m "first (from main@abc)\n\nbody"`,
+		"git cherry-pick def",
+		`git commit --amend -m "second (from main@def)"`,
+	}, PlanCommitEach("main", "release", commits, "(from {source}@{hash})"))
+}
+
+func TestPlanReword(t *testing.T) {
+	commits := []git.Commit{{Hash: "abc", Message: "first, edited"}, {Hash: "def", Message: "second"}}
+	require.Equal(t, []string{
+		"git checkout release",
+		"git cherry-pick abc",
+		`git commit --amend -m "first, edited"`,
+		"git cherry-pick def",
+		`git commit --amend -m "second"`,
+	}, PlanReword("release", commits))
+}
+
+func TestAppendSubjectSuffix(t *testing.T) {
+	require.Equal(t, "fix (picked)", AppendSubjectSuffix("fix\n", "(picked)"))
+	require.Equal(t, "fix (picked)\n\nbody\nmore", AppendSubjectSuffix("fix\n\nbody\nmore\n", "(picked)"))
+	require.Equal(t, "fix", AppendSubjectSuffix("fix", ""))
+}
+
+func TestExecutePreserveAppliesInOrder(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	runner := &git.Runner{Dir: repo.Path}
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "target"))
+	commits := []git.Commit{{Hash: first}, {Hash: second}}
+	progress, err := ExecutePreserve(context.Background(), runner, "target", commits, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, progress.Total)
+	require.Equal(t, []string{first, second}, progress.Applied)
+	require.Empty(t, progress.Failed)
+	require.Zero(t, progress.Remaining())
+
+	subjects := strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--pretty=%s", "main..target"))
+	require.Equal(t, "first\nsecond", subjects)
+
+	newCommits, err := runner.NewCommits(before, "", "target")
+	require.NoError(t, err)
+	require.Equal(t, strings.Fields(repo.MustRun(t, "rev-list", "--reverse", "main..target")), newCommits)
+	require.Len(t, newCommits, 2)
+}
+
+func TestExecutePreserveStopsAtConflict(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "conflict.txt", "target\n", "target change")
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "conflict.txt", "source\n", "conflicting")
+	third := repo.CommitFile(t, "c.txt", "c\n", "third")
+
+	commits := []git.Commit{{Hash: first}, {Hash: second}, {Hash: third}}
+	progress, err := ExecutePreserve(context.Background(), &git.Runner{Dir: repo.Path}, "target", commits, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "after processing 1 of 3 commits")
+	require.Equal(t, 3, progress.Total)
+	require.Equal(t, []string{first}, progress.Applied)
+	require.Equal(t, second, progress.Failed)
+	require.Equal(t, 2, progress.Remaining())
+
+	repo.MustRun(t, "cherry-pick", "--abort")
+	subjects := strings.TrimSpace(repo.MustRun(t, "log", "-2", "--pretty=%s", "target"))
+	require.Equal(t, "first\ntarget change", subjects)
+}
+
+func TestExecutePreserveSkipsEmptyCommits(t *testing.T) {
+	repo := repohelper.Init(t)
+
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "shared.txt", "same\n", "already on target")
+
+	repo.MustRun(t, "checkout", "main")
+	repo.MustRun(t, "checkout", "-b", "source")
+	duplicate := repo.CommitFile(t, "shared.txt", "same\n", "same change")
+	unique := repo.CommitFile(t, "unique.txt", "u\n", "unique")
+
+	commits := []git.Commit{{Hash: duplicate}, {Hash: unique}}
+	progress, err := ExecutePreserve(context.Background(), &git.Runner{Dir: repo.Path}, "target", commits, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{unique}, progress.Applied)
+	require.Equal(t, []string{duplicate}, progress.Skipped)
+	require.Zero(t, progress.Remaining())
+
+	status := strings.TrimSpace(repo.MustRun(t, "status", "--porcelain"))
+	require.Empty(t, status)
+}
+
+func TestExecutePreserveStopsWhenCancelled(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	progress, err := ExecutePreserve(ctx, &git.Runner{Dir: repo.Path}, "target", []git.Commit{{Hash: first}}, false)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, progress.Applied)
+	require.Equal(t, 1, progress.Remaining())
+}
+
+func TestExecuteCommitEachAppendsSuffix(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first\n\nwith body")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	commits := []git.Commit{{Hash: first}, {Hash: second}}
+	progress, err := ExecuteCommitEach(context.Background(), &git.Runner{Dir: repo.Path}, "source", "target", commits, "(cherry-picked from {source})", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{first, second}, progress.Applied)
+
+	subjects := strings.TrimSpace(repo.MustRun(t, "log", "--reverse", "--format=%s", "main..target"))
+	require.Equal(t, "first (cherry-picked from source)\nsecond (cherry-picked from source)", subjects)
+	require.Equal(t, "with body", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%b", "target~1")))
+}
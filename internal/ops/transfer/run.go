@@ -0,0 +1,487 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/internal/logs"
+)
+
+// Mode selects how Run moves the range onto the target.
+type Mode string
+
+// Transfer modes accepted in Options.Mode. The empty mode squashes.
+const (
+	ModeSquash     Mode = "squash"
+	ModePreserve   Mode = "preserve"
+	ModeCommitEach Mode = "commit-each"
+	ModeNoFF       Mode = "no-ff"
+)
+
+// Options describes a transfer for Run.
+type Options struct {
+	From      string
+	To        string
+	StartHash string
+	EndHash   string
+	// Order is a git.CommitOrderArgs order for listing the range.
+	Order string
+	Mode  Mode
+	// Message is the commit message for ModeSquash and ModeNoFF.
+	Message string
+	// Suffix is the ModeCommitEach subject suffix; see RenderSuffix.
+	Suffix string
+	// Reword makes ModePreserve amend each pick to its Commit.Message, so
+	// it needs Commits with their messages set.
+	Reword bool
+	// KeepDates gives per-commit modes their source committer dates.
+	KeepDates bool
+	// Strategy is StrategyPatchID (the default) or StrategyHeuristic.
+	Strategy string
+	// ApplyDuplicates transfers commits already on the target instead of
+	// skipping them.
+	ApplyDuplicates bool
+	// ConfirmDuplicates, when set and ApplyDuplicates is not, is asked
+	// whether to transfer the commits already on the target anyway.
+	ConfirmDuplicates func(dups []git.Commit) (bool, error)
+	// AllowConflictMarkers commits the squash or no-ff result even when
+	// staged files still contain conflict markers.
+	AllowConflictMarkers bool
+	// Commits, when set, is the range as the caller already listed and
+	// filtered it; Run then neither lists the range nor looks for
+	// duplicates. ModeCommitEach with a Suffix and Reword need each
+	// Commit.Message loaded.
+	Commits []git.Commit
+	// Apply performs the planned commands; otherwise Run only plans them.
+	Apply bool
+}
+
+// ErrNothingToCommit is returned when a squash transfer has nothing left to
+// commit because the target already has every change in the range.
+var ErrNothingToCommit = errors.New("nothing to commit: the target already has these changes")
+
+// applyFn performs the planned transfer; tests replace it to stop part-way.
+var applyFn = apply
+
+// detectPatchIDFn and detectHeuristicFn find duplicates with each strategy;
+// tests replace them.
+var (
+	detectPatchIDFn   = DetectDuplicates
+	detectHeuristicFn = DetectDuplicatesHeuristic
+)
+
+// Result reports what Run planned and, when applied, what it did.
+type Result struct {
+	Target     string
+	Commands   []string
+	Applied    []string
+	Skipped    []string
+	Conflicted []string
+	BeforeHead string
+	NewHead    string
+	NewCommits []string
+}
+
+// Operation returns the log entry for r, the result of applying opts.
+func (r Result) Operation(opts Options) logs.Operation {
+	mode := opts.Mode
+	if mode == "" {
+		mode = ModeSquash
+	}
+	op := logs.Operation{
+		Kind:       logs.KindTransfer,
+		Mode:       string(mode),
+		Suffix:     opts.Suffix,
+		Source:     opts.From,
+		Target:     opts.To,
+		StartHash:  opts.StartHash,
+		EndHash:    opts.EndHash,
+		Message:    opts.Message,
+		Commands:   r.Commands,
+		NewCommits: r.NewCommits,
+		BeforeHead: r.BeforeHead,
+	}
+	if mode == ModeNoFF {
+		op.MergeCommit = r.NewHead
+	}
+	return op
+}
+
+// UndoEntry returns the undo entry that moves the target of r back.
+func (r Result) UndoEntry() logs.UndoEntry {
+	return logs.UndoEntry{
+		Source:     r.Target,
+		Target:     r.Target,
+		BeforeHead: r.BeforeHead,
+		AfterHead:  r.NewHead,
+		NewCommits: r.NewCommits,
+	}
+}
+
+func (o Options) perCommit() bool {
+	return o.Mode == ModePreserve || o.Mode == ModeCommitEach
+}
+
+func (o Options) validate() error {
+	if o.From == "" || o.To == "" || o.StartHash == "" || o.EndHash == "" {
+		return errors.New("from, to, and a start and end hash are required")
+	}
+	switch o.Mode {
+	case "", ModeSquash, ModeNoFF:
+		if o.Message == "" {
+			return errors.New("a commit message is required to squash or merge the range")
+		}
+	case ModePreserve, ModeCommitEach:
+	default:
+		return fmt.Errorf("invalid transfer mode %q", o.Mode)
+	}
+	if o.Reword && o.Mode != ModePreserve {
+		return errors.New("rewording requires the preserve mode")
+	}
+	if o.Reword && o.Commits == nil {
+		return errors.New("rewording requires the commits and their new messages")
+	}
+	switch o.Strategy {
+	case "", StrategyPatchID, StrategyHeuristic:
+	default:
+		return fmt.Errorf("invalid duplicate strategy %q (expected %s or %s)", o.Strategy, StrategyPatchID, StrategyHeuristic)
+	}
+	_, err := git.CommitOrderArgs(o.Order)
+	return err
+}
+
+// Plan describes the commands that transfer commits under o. ModeCommitEach
+// with a suffix and Reword expect Commit.Message to hold each full message.
+func (o Options) Plan(commits []git.Commit) []string {
+	switch o.Mode {
+	case ModePreserve:
+		if o.Reword {
+			return PlanReword(o.To, commits)
+		}
+		return PlanPreserve(o.To, commits)
+	case ModeCommitEach:
+		return PlanCommitEach(o.From, o.To, commits, o.Suffix)
+	case ModeNoFF:
+		return renderSteps(noFFSteps(o.To, o.picks(commits), o.Message))
+	default:
+		return renderSteps(squashSteps(o.To, o.picks(commits), o.Message))
+	}
+}
+
+// picks returns what a squash or no-ff transfer cherry-picks: the range
+// itself in git's default order, or otherwise each of commits in turn, so
+// that the range is applied in o.Order.
+func (o Options) picks(commits []git.Commit) []string {
+	order := strings.TrimSpace(o.Order)
+	if order == "" || order == git.OrderDefault || len(commits) == 0 {
+		return rangePicks(o.StartHash, o.EndHash)
+	}
+	hashes := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		hashes = append(hashes, commit.Hash)
+	}
+	return hashes
+}
+
+// Run lists the commits from opts.StartHash to opts.EndHash, leaves out those
+// already on the target, plans the transfer, and performs it when opts.Apply
+// is set. Checkouts go through git.CheckoutBranch, and the squash commit is
+// refused with ErrNothingToCommit when nothing is staged. When ctx is
+// cancelled part-way, the target is restored to where it started and the
+// no-ff scratch branch is deleted. Messages for the user go to out; a nil out
+// discards them. Run does not log the operation or record an undo entry.
+func Run(ctx context.Context, runner *git.Runner, opts Options, out io.Writer) (Result, error) {
+	if runner == nil {
+		runner = &git.Runner{}
+	}
+	if out == nil {
+		out = io.Discard
+	}
+	result := Result{Target: opts.To}
+	if err := opts.validate(); err != nil {
+		return result, err
+	}
+
+	commits := opts.Commits
+	if commits == nil {
+		var err error
+		if commits, err = RangeCommits(runner, opts.StartHash, opts.EndHash, opts.Order); err != nil {
+			return result, err
+		}
+		dups, err := opts.DetectDuplicates(ctx, runner, commits, out)
+		if err != nil {
+			return result, err
+		}
+		var skipped []git.Commit
+		if commits, skipped, err = opts.SkipDuplicates(commits, dups, out); err != nil {
+			return result, err
+		}
+		for _, commit := range skipped {
+			result.Skipped = append(result.Skipped, commit.Hash)
+		}
+		if len(commits) == 0 {
+			return result, nil
+		}
+		if opts.Mode == ModeCommitEach && opts.Suffix != "" {
+			if commits, err = LoadMessages(runner, commits); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	result.Commands = opts.Plan(commits)
+	if !opts.Apply {
+		fmt.Fprintln(out, "Planned commands:")
+		for _, command := range result.Commands {
+			fmt.Fprintf(out, "  %s\n", command)
+		}
+		return result, nil
+	}
+
+	var err error
+	if result.BeforeHead, err = revParse(runner, opts.To); err != nil {
+		return result, err
+	}
+	progress, applyErr := applyFn(ctx, runner, opts, commits)
+	if applyErr != nil && ctx.Err() != nil {
+		return result, rollback(runner, opts.To, result.BeforeHead)
+	}
+	result.Applied = progress.Applied
+	result.Skipped = append(result.Skipped, progress.Skipped...)
+	if progress.Failed != "" {
+		result.Conflicted = append(result.Conflicted, progress.Failed)
+	}
+	if result.NewHead, err = revParse(runner, opts.To); err != nil {
+		return result, err
+	}
+	if result.NewCommits, err = runner.NewCommits(result.BeforeHead, result.NewHead, opts.To); err != nil {
+		return result, err
+	}
+	if applyErr != nil {
+		return result, applyErr
+	}
+	fmt.Fprintf(out, "Transfer applied successfully (%d new commits on %s).\n", len(result.NewCommits), opts.To)
+	return result, nil
+}
+
+// RangeCommits lists the commits from start to end inclusive, oldest first,
+// in the given git.CommitOrderArgs order. Only Commit.Hash is set.
+func RangeCommits(runner *git.Runner, start, end, order string) ([]git.Commit, error) {
+	if runner == nil {
+		runner = &git.Runner{}
+	}
+	orderArgs, err := git.CommitOrderArgs(order)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := []string{fmt.Sprintf("%s^..%s", start, end), fmt.Sprintf("%s..%s", start, end)}
+	var hashes []string
+	for idx, spec := range specs {
+		out, stderr, err := runner.Run(append(append([]string{"rev-list", "--reverse"}, orderArgs...), spec)...)
+		if err != nil {
+			if idx == len(specs)-1 {
+				return nil, fmt.Errorf("git rev-list %s failed: %w", spec, git.CommandError(err, stderr))
+			}
+			continue
+		}
+
+		lines := strings.Fields(strings.TrimSpace(out))
+		if len(lines) == 0 && idx == 0 {
+			continue
+		}
+		hashes = lines
+		if idx == 1 && (len(hashes) == 0 || hashes[0] != start) {
+			hashes = append([]string{start}, hashes...)
+		}
+		break
+	}
+
+	if len(hashes) == 0 {
+		hashes = []string{start}
+		if start != end {
+			hashes = append(hashes, end)
+		}
+	}
+
+	commits := make([]git.Commit, 0, len(hashes))
+	for _, hash := range hashes {
+		commits = append(commits, git.Commit{Hash: hash})
+	}
+	return commits, nil
+}
+
+// DetectDuplicates finds which of commits are already on o.To with
+// o.Strategy, falling back to the heuristic, with a warning to out, when git
+// patch-id is unavailable.
+func (o Options) DetectDuplicates(ctx context.Context, runner *git.Runner, commits []git.Commit, out io.Writer) ([]git.Commit, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	if o.Strategy == StrategyHeuristic {
+		return detectHeuristicFn(ctx, runner, o.To, commits)
+	}
+	dups, err := detectPatchIDFn(ctx, runner, o.To, commits)
+	if errors.Is(err, git.ErrPatchIDUnavailable) {
+		fmt.Fprintf(out, "Warning: %v; matching duplicates by subject and changed files instead, which is approximate.\n", err)
+		return detectHeuristicFn(ctx, runner, o.To, commits)
+	}
+	return dups, err
+}
+
+// SkipDuplicates decides what to do with dups, the commits DetectDuplicates
+// found on the target. Unless o.ApplyDuplicates or o.ConfirmDuplicates says
+// to transfer them anyway, it returns the commits left to transfer and the
+// duplicates it skipped. Squash and no-ff transfers take the range as a
+// whole, so they are skipped entirely, as are per-commit transfers with
+// nothing left.
+func (o Options) SkipDuplicates(commits, dups []git.Commit, out io.Writer) ([]git.Commit, []git.Commit, error) {
+	if len(dups) == 0 || o.ApplyDuplicates {
+		return commits, nil, nil
+	}
+	if o.ConfirmDuplicates != nil {
+		apply, err := o.ConfirmDuplicates(dups)
+		if err != nil || apply {
+			return commits, nil, err
+		}
+	} else {
+		fmt.Fprintf(out, "Detected %d duplicate patches; skipping.\n", len(dups))
+	}
+
+	var kept []git.Commit
+	if o.perCommit() {
+		kept = withoutDuplicates(commits, dups)
+	}
+	if len(kept) == 0 {
+		fmt.Fprintln(out, "Skipping transfer due to duplicate patches.")
+	}
+	return kept, dups, nil
+}
+
+// apply performs the transfer planned for opts. The squash and no-ff modes
+// report every commit as applied once their final commit succeeds.
+func apply(ctx context.Context, runner *git.Runner, opts Options, commits []git.Commit) (Progress, error) {
+	switch opts.Mode {
+	case ModePreserve:
+		if opts.Reword {
+			return ExecuteReword(ctx, runner, opts.To, commits, opts.KeepDates)
+		}
+		return ExecutePreserve(ctx, runner, opts.To, commits, opts.KeepDates)
+	case ModeCommitEach:
+		return ExecuteCommitEach(ctx, runner, opts.From, opts.To, commits, opts.Suffix, opts.KeepDates)
+	}
+
+	progress := Progress{Total: len(commits)}
+	steps := squashSteps(opts.To, opts.picks(commits), opts.Message)
+	if opts.Mode == ModeNoFF {
+		steps = noFFSteps(opts.To, opts.picks(commits), opts.Message)
+	}
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		if err := runStep(runner, s, opts.AllowConflictMarkers); err != nil {
+			return progress, err
+		}
+	}
+	for _, commit := range commits {
+		progress.Applied = append(progress.Applied, commit.Hash)
+	}
+	return progress, nil
+}
+
+// runStep runs one step of a squash or no-ff transfer. Checkouts go through
+// git.CheckoutBranch, and a commit is refused while the index has nothing
+// staged or, unless allowMarkers is set, holds conflict markers.
+func runStep(runner *git.Runner, s step, allowMarkers bool) error {
+	args := s.args
+	if s.branch != "" {
+		if err := git.CheckoutBranch(runner, s.branch, s.create); err != nil {
+			return fmt.Errorf("%s failed: %w", renderStep(args), err)
+		}
+		return nil
+	}
+	if args[0] == "commit" {
+		if !allowMarkers {
+			if err := git.CheckStagedConflictMarkers(runner); err != nil {
+				return err
+			}
+		}
+		staged, err := git.HasStagedChanges(runner)
+		if err != nil {
+			return err
+		}
+		if !staged {
+			return ErrNothingToCommit
+		}
+	}
+	if _, stderr, err := runner.Run(args...); err != nil {
+		return fmt.Errorf("%s failed: %w", renderStep(args), git.CommandError(err, stderr))
+	}
+	return nil
+}
+
+// rollback restores target to beforeHead after an interrupted transfer and
+// deletes the no-ff scratch branch if one was left behind.
+func rollback(runner *git.Runner, target, beforeHead string) error {
+	if err := git.RestoreBranch(runner, target, beforeHead); err != nil {
+		return fmt.Errorf("interrupted; rollback failed: %w", err)
+	}
+	_, _, _ = runner.Run("branch", "-D", TempBranchName(target))
+	return fmt.Errorf("interrupted: rolled back %s to %s", target, shortHash(beforeHead))
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// LoadMessages returns commits with Message set to each commit's full message.
+func LoadMessages(runner *git.Runner, commits []git.Commit) ([]git.Commit, error) {
+	loaded := make([]git.Commit, len(commits))
+	for i, commit := range commits {
+		subject, body, err := runner.CommitMessage(commit.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("reading the message of %s: %w", commit.Hash, err)
+		}
+		commit.Message = joinMessage(subject, body)
+		loaded[i] = commit
+	}
+	return loaded, nil
+}
+
+// joinMessage puts a commit message back together from its subject and body.
+func joinMessage(subject, body string) string {
+	if body == "" {
+		return subject
+	}
+	return subject + "\n\n" + body
+}
+
+func withoutDuplicates(commits, dups []git.Commit) []git.Commit {
+	drop := make(map[string]bool, len(dups))
+	for _, commit := range dups {
+		drop[commit.Hash] = true
+	}
+	kept := make([]git.Commit, 0, len(commits))
+	for _, commit := range commits {
+		if !drop[commit.Hash] {
+			kept = append(kept, commit)
+		}
+	}
+	return kept
+}
+
+func revParse(runner *git.Runner, ref string) (string, error) {
+	stdout, stderr, err := runner.Run("rev-parse", ref)
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse %s failed: %w", ref, git.CommandError(err, stderr))
+	}
+	return strings.TrimSpace(stdout), nil
+}
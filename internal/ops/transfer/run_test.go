@@ -0,0 +1,244 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/julianchen24/gitcherry/internal/git"
+	"github.com/julianchen24/gitcherry/tests/repohelper"
+)
+
+func TestRunPlansWithoutApplying(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "target"))
+
+	out := &bytes.Buffer{}
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Mode: ModePreserve}
+	result, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, out)
+	require.NoError(t, err)
+	require.Equal(t, PlanPreserve("target", []git.Commit{{Hash: first}, {Hash: second}}), result.Commands)
+	require.Empty(t, result.Applied)
+	require.Contains(t, out.String(), "Planned commands:\n  git checkout target\n")
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "target")))
+}
+
+func TestRunAppliesAndSkipsDuplicates(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+	repo.MustRun(t, "checkout", "-b", "target", "main")
+	repo.MustRun(t, "cherry-pick", first)
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "target"))
+
+	out := &bytes.Buffer{}
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Mode: ModePreserve, Apply: true}
+	result, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, out)
+	require.NoError(t, err)
+	require.Equal(t, []string{second}, result.Applied)
+	require.Equal(t, []string{first}, result.Skipped)
+	require.Empty(t, result.Conflicted)
+	require.Equal(t, before, result.BeforeHead)
+	require.Equal(t, strings.TrimSpace(repo.MustRun(t, "rev-parse", "target")), result.NewHead)
+	require.Equal(t, []string{result.NewHead}, result.NewCommits)
+	require.Contains(t, out.String(), "Detected 1 duplicate patches; skipping.")
+	require.Contains(t, out.String(), "Transfer applied successfully (1 new commits on target).")
+}
+
+func TestRunSquashesRange(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Message: "squashed", Apply: true}
+	result, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{first, second}, result.Applied)
+	require.Len(t, result.NewCommits, 1)
+	require.Equal(t, "squashed", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "target")))
+	require.Equal(t, "a.txt\nb.txt", strings.TrimSpace(repo.MustRun(t, "diff", "--name-only", "main", "target")))
+}
+
+func TestRunReportsConflicts(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "conflict.txt", "target\n", "target change")
+	repo.MustRun(t, "checkout", "-b", "source", "main")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "conflict.txt", "source\n", "conflicting")
+
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Mode: ModePreserve, Apply: true}
+	result, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, nil)
+	require.Error(t, err)
+	require.Equal(t, []string{first}, result.Applied)
+	require.Equal(t, []string{second}, result.Conflicted)
+	require.Len(t, result.NewCommits, 1)
+	repo.MustRun(t, "cherry-pick", "--abort")
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	_, err := Run(context.Background(), nil, Options{From: "a", To: "b", StartHash: "x", EndHash: "y"}, nil)
+	require.ErrorContains(t, err, "commit message is required")
+
+	_, err = Run(context.Background(), nil, Options{From: "a", To: "b", StartHash: "x", EndHash: "y", Mode: "rebase"}, nil)
+	require.ErrorContains(t, err, `invalid transfer mode "rebase"`)
+
+	_, err = Run(context.Background(), nil, Options{From: "a", To: "b", StartHash: "x", EndHash: "y", Mode: ModeCommitEach, Reword: true}, nil)
+	require.ErrorContains(t, err, "rewording requires the preserve mode")
+}
+
+func TestRunRollsBackInterruptedTransfer(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "target")
+	repo.CommitFile(t, "conflict.txt", "target\n", "target change")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "target"))
+	repo.MustRun(t, "checkout", "-b", "source", "main")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "conflict.txt", "source\n", "conflicting")
+	repo.MustRun(t, "checkout", "main")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	origApply := applyFn
+	t.Cleanup(func() { applyFn = origApply })
+	applyFn = func(ctx context.Context, runner *git.Runner, opts Options, commits []git.Commit) (Progress, error) {
+		progress, err := ExecutePreserve(ctx, runner, opts.To, commits[:1], false)
+		require.NoError(t, err)
+		// Leave a cherry-pick stopped mid-way, as an interrupt would.
+		_, _, _ = runner.Run("cherry-pick", commits[1].Hash)
+		cancel()
+		return progress, ctx.Err()
+	}
+
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Mode: ModePreserve, Apply: true}
+	runner := &git.Runner{Dir: repo.Path}
+	_, err := Run(ctx, runner, opts, nil)
+	require.ErrorContains(t, err, "interrupted: rolled back target to "+before[:7])
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "target")))
+	require.Equal(t, "target", strings.TrimSpace(repo.MustRun(t, "rev-parse", "--abbrev-ref", "HEAD")))
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "status", "--porcelain")))
+	operation, err := runner.InProgressOperation()
+	require.NoError(t, err)
+	require.Empty(t, operation)
+}
+
+func TestRunRefusesEmptySquash(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	repo.MustRun(t, "checkout", "-b", "target", "main")
+	repo.CommitFile(t, "a.txt", "a\n", "same change")
+
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: first, Message: "squashed", Commits: []git.Commit{{Hash: first}}, Apply: true}
+	_, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, nil)
+	require.ErrorIs(t, err, ErrNothingToCommit)
+}
+
+func TestRunSquashPicksEachCommitInTheRequestedOrder(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Order: git.OrderTopo, Message: "squashed", Apply: true}
+	result, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, nil)
+	require.NoError(t, err)
+	require.Contains(t, result.Commands, "git cherry-pick --no-commit "+first+" "+second)
+	require.Equal(t, []string{first, second}, result.Applied)
+	require.Equal(t, "squashed", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "target")))
+	require.Equal(t, "A\ta.txt\nA\tb.txt", strings.TrimSpace(repo.MustRun(t, "diff", "--name-status", "target~1", "target")))
+}
+
+func TestRunMergesWithNoFFAndRunsWhatItPlans(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "branch", "target")
+	repo.MustRun(t, "checkout", "-b", "source")
+	first := repo.CommitFile(t, "a.txt", "a\n", "first")
+	second := repo.CommitFile(t, "b.txt", "b\n", "second")
+
+	opts := Options{From: "source", To: "target", StartHash: first, EndHash: second, Mode: ModeNoFF, Message: "Merge\n\nbody", Apply: true}
+	result, err := Run(context.Background(), &git.Runner{Dir: repo.Path}, opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, PlanNoFF("source", "target", first, second, "Merge\n\nbody"), result.Commands)
+	require.Equal(t, "Merge\n\nbody", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%B", "target")))
+	require.Len(t, strings.Fields(repo.MustRun(t, "log", "-1", "--format=%P", "target")), 2)
+	require.Empty(t, strings.TrimSpace(repo.MustRun(t, "branch", "--list", TempBranchName("target"))))
+}
+
+func TestDetectDuplicatesFallsBackToHeuristicWhenPatchIDUnavailable(t *testing.T) {
+	origPatchID, origHeuristic := detectPatchIDFn, detectHeuristicFn
+	t.Cleanup(func() { detectPatchIDFn, detectHeuristicFn = origPatchID, origHeuristic })
+	patchIDCalls, heuristicCalls := 0, 0
+	detectPatchIDFn = func(context.Context, *git.Runner, string, []git.Commit) ([]git.Commit, error) {
+		patchIDCalls++
+		return nil, fmt.Errorf("%w: exit code 128", git.ErrPatchIDUnavailable)
+	}
+	detectHeuristicFn = func(_ context.Context, _ *git.Runner, _ string, commits []git.Commit) ([]git.Commit, error) {
+		heuristicCalls++
+		return commits[:1], nil
+	}
+
+	commits := []git.Commit{{Hash: "a"}, {Hash: "b"}}
+	out := &bytes.Buffer{}
+	dups, err := Options{To: "target"}.DetectDuplicates(context.Background(), nil, commits, out)
+	require.NoError(t, err)
+	require.Equal(t, commits[:1], dups)
+	require.Equal(t, 1, patchIDCalls)
+	require.Equal(t, 1, heuristicCalls)
+	require.Contains(t, out.String(), "Warning: git patch-id unavailable: exit code 128; matching duplicates by subject and changed files instead")
+
+	out.Reset()
+	_, err = Options{To: "target", Strategy: StrategyHeuristic}.DetectDuplicates(context.Background(), nil, commits, out)
+	require.NoError(t, err)
+	require.Equal(t, 1, patchIDCalls)
+	require.Equal(t, 2, heuristicCalls)
+	require.Empty(t, out.String())
+}
+
+func TestSkipDuplicatesFollowsThePolicy(t *testing.T) {
+	commits := []git.Commit{{Hash: "a"}, {Hash: "b"}}
+	dups := commits[:1]
+	out := &bytes.Buffer{}
+
+	kept, skipped, err := Options{Mode: ModeSquash}.SkipDuplicates(commits, dups, out)
+	require.NoError(t, err)
+	require.Empty(t, kept)
+	require.Equal(t, dups, skipped)
+	require.Contains(t, out.String(), "Detected 1 duplicate patches; skipping.")
+	require.Contains(t, out.String(), "Skipping transfer due to duplicate patches.")
+
+	kept, skipped, err = Options{Mode: ModePreserve}.SkipDuplicates(commits, dups, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, commits[1:], kept)
+	require.Equal(t, dups, skipped)
+
+	kept, skipped, err = Options{ApplyDuplicates: true}.SkipDuplicates(commits, dups, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, commits, kept)
+	require.Empty(t, skipped)
+
+	var asked []git.Commit
+	confirm := func(d []git.Commit) (bool, error) {
+		asked = d
+		return true, nil
+	}
+	out.Reset()
+	kept, _, err = Options{ConfirmDuplicates: confirm}.SkipDuplicates(commits, dups, out)
+	require.NoError(t, err)
+	require.Equal(t, commits, kept)
+	require.Equal(t, dups, asked)
+	require.Empty(t, out.String())
+}
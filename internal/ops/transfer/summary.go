@@ -0,0 +1,32 @@
+package transfer
+
+import "github.com/julianchen24/gitcherry/internal/git"
+
+// Summary reports the outcome of a transfer once it has been applied.
+type Summary struct {
+	Target     string   `json:"target"`
+	Applied    []string `json:"applied"`
+	Skipped    []string `json:"skipped"`
+	Conflicted []string `json:"conflicted"`
+	NewCommits []string `json:"new_commits"`
+}
+
+// NewSummary combines the progress of an apply with the commits that were
+// filtered out beforehand (for example, duplicates already on the target).
+func NewSummary(target string, progress Progress, filtered []git.Commit, newCommits []string) Summary {
+	summary := Summary{
+		Target:     target,
+		Applied:    append([]string{}, progress.Applied...),
+		Skipped:    make([]string, 0, len(filtered)+len(progress.Skipped)),
+		Conflicted: []string{},
+		NewCommits: append([]string{}, newCommits...),
+	}
+	for _, commit := range filtered {
+		summary.Skipped = append(summary.Skipped, commit.Hash)
+	}
+	summary.Skipped = append(summary.Skipped, progress.Skipped...)
+	if progress.Failed != "" {
+		summary.Conflicted = append(summary.Conflicted, progress.Failed)
+	}
+	return summary
+}
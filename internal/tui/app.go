@@ -2,14 +2,17 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 
+	"github.com/julianchen24/gitcherry/internal/clipboard"
 	"github.com/julianchen24/gitcherry/internal/config"
 	"github.com/julianchen24/gitcherry/internal/git"
 	"github.com/julianchen24/gitcherry/internal/logs"
@@ -18,27 +21,69 @@ import (
 )
 
 var (
-	listBranchesFunc   = git.ListBranches
-	commitsBetweenFunc = git.CommitsBetween
-	colorSupportFn     = detectColorSupport
+	listBranchesFunc    = git.ListBranches
+	commitsBetweenFunc  = git.CommitSummariesBetween
+	commitFilesFunc     = git.CommitFiles
+	loadRecentFunc      = logs.LoadRecentPairs
+	recordRecentFunc    = logs.RecordRecentPair
+	recordOperationFunc = logs.RecordOperation
+	pushUndoFunc        = logs.PushUndo
+	colorSupportFn      = detectColorSupport
 )
 
-type colorPalette struct {
-	listSelectedBg   tcell.Color
-	listSelectedFg   tcell.Color
-	commitSelectedBg tcell.Color
-	commitSelectedFg tcell.Color
-	bannerText       tcell.Color
+// Theme names accepted by --tui-theme and the tuiTheme config key.
+const (
+	ThemeDefault      = "default"
+	ThemeMono         = "mono"
+	ThemeHighContrast = "high-contrast"
+)
+
+// theme holds every colour choice the TUI makes so views never pick their own.
+type theme struct {
+	branchSelected tcell.Style
+	commitSelected tcell.Style
+	tableSelected  tcell.Style
+	bannerText     tcell.Color
+}
+
+var themes = map[string]theme{
+	ThemeDefault: {
+		branchSelected: tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite),
+		commitSelected: tcell.StyleDefault.Background(tcell.ColorGreen).Foreground(tcell.ColorBlack),
+		tableSelected:  tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite),
+		bannerText:     tcell.ColorYellow,
+	},
+	ThemeMono: {
+		branchSelected: tcell.StyleDefault.Reverse(true),
+		commitSelected: tcell.StyleDefault.Reverse(true),
+		tableSelected:  tcell.StyleDefault.Reverse(true),
+		bannerText:     tcell.ColorDefault,
+	},
+	ThemeHighContrast: {
+		branchSelected: tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack).Bold(true),
+		commitSelected: tcell.StyleDefault.Background(tcell.ColorWhite).Foreground(tcell.ColorBlack).Bold(true),
+		tableSelected:  tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack).Bold(true),
+		bannerText:     tcell.ColorWhite,
+	},
+}
+
+// ValidateTheme reports an error when name is not a known TUI theme.
+func ValidateTheme(name string) error {
+	if _, ok := themes[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return nil
+	}
+	return fmt.Errorf("unknown TUI theme %q (expected %s, %s or %s)", name, ThemeDefault, ThemeMono, ThemeHighContrast)
 }
 
 // App represents the terminal UI for GitCherry.
 type App struct {
+	ctx     context.Context
 	runner  *git.Runner
 	config  *config.Config
 	audit   *logs.AuditLog
 	fetchFn func() error
 
-	colors colorPalette
+	theme theme
 
 	ui    *tview.Application
 	pages *tview.Pages
@@ -55,25 +100,59 @@ type App struct {
 	previewActions *tview.List
 	previewVisible bool
 
-	duplicateModal   *tview.Modal
+	duplicatePanel   *tview.Flex
+	duplicateInfo    *tview.TextView
+	duplicateTable   *tview.Table
 	duplicateVisible bool
-	duplicates       []git.Commit
-	duplicateFn      func(target string, commits []git.Commit) ([]git.Commit, error)
+	duplicates       []transfer.Duplicate
+	duplicateFn      func(target string, commits []git.Commit) ([]transfer.Duplicate, error)
+	skippedHashes    map[string]bool
+
+	clipboardFn func(text string) error
+
+	// apply makes the confirmed transfer change the repository; otherwise
+	// confirming only plans it, like the CLI without --apply.
+	apply          bool
+	confirmModal   *tview.Modal
+	confirmVisible bool
+	transferFn     func(opts transfer.Options) (transfer.Result, string, error)
+	guards         TransferGuards
+	// confirmingDefault is set while the modal asks the second question a
+	// transfer onto the default branch needs.
+	confirmingDefault bool
+	// transferring is set while a confirmed transfer runs in the background.
+	transferring bool
+	// backgroundFn runs work off the UI goroutine and then done on it.
+	backgroundFn func(work, done func())
 
 	restoreForm        *tview.Form
 	restoreVisible     bool
 	restoreCommitIndex int
 
 	refreshBanner *tview.TextView
+	statusBar     *tview.TextView
 
 	branchStage  int
 	branchSource string
 	branchTarget string
 
+	// recentPairs are listed, in order, ahead of the branches in BranchList.
+	recentPairs []logs.RecentPair
+
 	commits     []git.Commit
 	commitStart int
 	commitEnd   int
 
+	// Changed files are loaded per commit the first time it is expanded.
+	commitFiles   map[string][]string
+	filesExpanded map[string]bool
+
+	// commitClick is set by the commit list's mouse capture so its selected
+	// func can tell a click (which marks the start, or with Shift the end) from
+	// Enter. Any key press clears it.
+	commitClick      bool
+	commitClickShift bool
+
 	helpVisible bool
 }
 
@@ -87,6 +166,7 @@ func NewApp(runner *git.Runner, cfg *config.Config, audit *logs.AuditLog) *App {
 	}
 
 	app := &App{
+		ctx:                context.Background(),
 		runner:             runner,
 		config:             cfg,
 		audit:              audit,
@@ -96,15 +176,35 @@ func NewApp(runner *git.Runner, cfg *config.Config, audit *logs.AuditLog) *App {
 		restoreCommitIndex: -1,
 	}
 
-	app.colors = defaultPalette()
+	app.theme = selectTheme(cfg.TUITheme)
 	app.fetchFn = app.defaultFetch
-	app.duplicateFn = func(target string, commits []git.Commit) ([]git.Commit, error) {
-		return transfer.DetectDuplicates(app.runner, target, commits)
+	app.clipboardFn = clipboard.CopyToClipboard
+	app.transferFn = func(opts transfer.Options) (transfer.Result, string, error) {
+		var out strings.Builder
+		result, err := transfer.Run(app.ctx, app.runner, opts, &out)
+		return result, out.String(), err
+	}
+	app.backgroundFn = func(work, done func()) {
+		go func() {
+			work()
+			app.ui.QueueUpdateDraw(done)
+		}()
+	}
+	app.duplicateFn = func(target string, commits []git.Commit) ([]transfer.Duplicate, error) {
+		duplicates, err := transfer.FindDuplicates(app.ctx, app.runner, target, commits)
+		if errors.Is(err, git.ErrPatchIDUnavailable) {
+			if app.refreshBanner != nil {
+				app.refreshBanner.SetText("git patch-id unavailable; duplicate check is approximate")
+			}
+			return transfer.FindDuplicatesHeuristic(app.ctx, app.runner, target, commits)
+		}
+		return duplicates, err
 	}
 
 	app.initialiseViews()
 	app.initialiseLayout()
 	app.bindKeys()
+	app.ui.EnableMouse(cfg.Mouse)
 	app.loadBranches()
 
 	return app
@@ -115,6 +215,8 @@ func (a *App) Run(ctx context.Context) error {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	// Git work started from the UI, such as the duplicate scan, stops with ctx.
+	a.ctx = ctx
 
 	errCh := make(chan error, 1)
 	go func() {
@@ -152,6 +254,35 @@ func (a *App) ToggleHelp() {
 	a.ui.SetFocus(a.HelpModal)
 }
 
+// SetApply chooses whether a confirmed transfer is applied to the repository
+// or only planned. It mirrors the CLI's --apply flag.
+func (a *App) SetApply(apply bool) {
+	a.apply = apply
+}
+
+// TransferGuards are the checks the CLI makes before a transfer, so that a
+// transfer confirmed in the TUI is refused or questioned in the same cases.
+// A nil field skips that check.
+type TransferGuards struct {
+	// DefaultBranch returns the branch that needs a second confirmation
+	// before an applied transfer changes it, or "" when none does.
+	DefaultBranch func() string
+	// CheckIdentity fails when git has no user identity to commit with.
+	CheckIdentity func() error
+	// FilterMessage rewrites the squash message, as message_filter does.
+	FilterMessage func(message string) (string, error)
+}
+
+// SetTransferGuards installs the checks run before a confirmed transfer.
+func (a *App) SetTransferGuards(guards TransferGuards) {
+	a.guards = guards
+}
+
+// MouseEnabled reports whether clicks and wheel scrolling are turned on.
+func (a *App) MouseEnabled() bool {
+	return a.config.Mouse
+}
+
 // HelpVisible reports whether the help modal is currently shown.
 func (a *App) HelpVisible() bool {
 	a.mu.RLock()
@@ -164,11 +295,14 @@ func (a *App) initialiseViews() {
 	a.BranchList.ShowSecondaryText(false)
 	a.BranchList.SetTitle("Branches")
 	a.BranchList.SetBorder(true)
-	a.BranchList.SetSelectedBackgroundColor(a.colors.listSelectedBg)
-	a.BranchList.SetSelectedTextColor(a.colors.listSelectedFg)
+	a.BranchList.SetSelectedStyle(a.theme.branchSelected)
 	a.BranchList.SetSelectedFocusOnly(true)
 	a.BranchList.AddItem("(loading branches...)", "", 0, nil)
 	a.BranchList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+		if index < len(a.recentPairs) {
+			a.selectRecentPair(a.recentPairs[index])
+			return
+		}
 		a.handleBranchSelection(mainText)
 	})
 
@@ -176,12 +310,24 @@ func (a *App) initialiseViews() {
 	a.CommitList.ShowSecondaryText(true)
 	a.CommitList.SetTitle("Commits")
 	a.CommitList.SetBorder(true)
-	a.CommitList.SetSelectedBackgroundColor(a.colors.commitSelectedBg)
-	a.CommitList.SetSelectedTextColor(a.colors.commitSelectedFg)
+	a.CommitList.SetSelectedStyle(a.theme.commitSelected)
 	a.CommitList.AddItem("(select a branch)", "", 0, nil)
 	a.CommitList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+		if a.commitClick && !a.commitClickShift {
+			a.commitClick = false
+			a.markCommitStart(index)
+			return
+		}
+		a.commitClick, a.commitClickShift = false, false
 		a.confirmCommitRange(index)
 	})
+	a.CommitList.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if action == tview.MouseLeftClick {
+			a.commitClick = true
+			a.commitClickShift = event.Modifiers()&tcell.ModShift != 0
+		}
+		return action, event
+	})
 
 	helpText := strings.Join([]string{
 		"GitCherry Keybindings",
@@ -190,14 +336,24 @@ func (a *App) initialiseViews() {
 		"  q : quit",
 		"  r : refresh remotes",
 		"  ? : toggle this help",
+		"  backspace : start over from source branch",
+		"  1-5 : reopen a recent source → target pair",
 		"",
 		"Commit selection",
 		"  space : mark start commit",
 		"  enter : confirm range",
+		"  A : select every commit and preview",
+		"  click / shift-click : mark start / confirm range (--mouse)",
+		"  f : show or hide changed files",
 		"  b : create restore branch",
 		"",
 		"Duplicates",
-		"  y / n : answer duplicate prompt",
+		"  s : skip duplicates and preview the rest",
+		"  a : apply anyway (preview all)",
+		"  q : cancel",
+		"",
+		"Preview",
+		"  t : transfer (asks for confirmation first)",
 	}, "\n")
 
 	a.HelpModal = tview.NewModal().
@@ -207,8 +363,13 @@ func (a *App) initialiseViews() {
 		a.ToggleHelp()
 	})
 
+	a.confirmModal = tview.NewModal().
+		AddButtons([]string{"Confirm", "Cancel"})
+	a.confirmModal.SetDoneFunc(a.confirmDone)
+
 	a.restoreForm = tview.NewForm().
 		AddInputField("Branch name", "", 40, nil, nil).
+		AddCheckbox("Check out after creating", false, nil).
 		AddButton("Create", func() {
 			a.submitRestore()
 		}).
@@ -242,19 +403,30 @@ func (a *App) initialiseViews() {
 	a.previewActions.AddItem("[A] Use suggested message", "", 'a', func() {
 		a.applySuggestedMessage()
 	})
-
-	a.duplicateModal = tview.NewModal().
-		AddButtons([]string{"Yes", "No"})
-	a.duplicateModal.SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-		switch buttonLabel {
-		case "Yes":
-			a.hideDuplicatePrompt()
-			a.showPreview()
-		case "No":
-			a.hideDuplicatePrompt()
-		}
+	a.previewActions.AddItem("[C] Copy message to clipboard", "", 'c', func() {
+		a.copyPreviewMessage()
+	})
+	a.previewActions.AddItem("[T] Transfer to target", "", 't', func() {
+		a.showConfirm()
 	})
 
+	a.duplicateInfo = tview.NewTextView()
+	a.duplicateInfo.SetDynamicColors(false)
+	a.duplicateInfo.SetBorder(true)
+	a.duplicateInfo.SetTitle("Duplicates")
+
+	a.duplicateTable = tview.NewTable()
+	a.duplicateTable.SetBorder(true)
+	a.duplicateTable.SetTitle("Already on Target")
+	a.duplicateTable.SetSelectable(true, false)
+	a.duplicateTable.SetSelectedStyle(a.theme.tableSelected)
+	a.duplicateTable.SetFixed(1, 0)
+
+	a.duplicatePanel = tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(a.duplicateInfo, 4, 0, false).
+		AddItem(a.duplicateTable, 0, 1, true)
+
 	body := tview.NewFlex().
 		SetDirection(tview.FlexRow).
 		AddItem(a.previewInfo, 3, 0, false).
@@ -273,7 +445,7 @@ func (a *App) initialiseLayout() {
 		a.refreshBanner = tview.NewTextView().
 			SetText("Press 'r' to refresh remote refs").
 			SetDynamicColors(false)
-		a.refreshBanner.SetTextColor(a.colors.bannerText)
+		a.refreshBanner.SetTextColor(a.theme.bannerText)
 		left.AddItem(a.refreshBanner, 1, 0, false)
 	}
 	left.AddItem(a.BranchList, 0, 1, true)
@@ -285,22 +457,79 @@ func (a *App) initialiseLayout() {
 	a.pages = tview.NewPages().
 		AddPage("main", mainContent, true, true).
 		AddPage("preview", a.previewFrame, true, false).
-		AddPage("duplicates", a.duplicateModal, true, false).
+		AddPage("duplicates", a.duplicatePanel, true, false).
 		AddPage("help", a.HelpModal, true, false).
+		AddPage("confirm", a.confirmModal, true, false).
 		AddPage("restore", a.restoreForm, true, false)
 
-	a.ui.SetRoot(a.pages, true)
+	a.statusBar = tview.NewTextView().SetDynamicColors(false)
+	a.updateStatus()
+
+	root := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(a.pages, 0, 1, true).
+		AddItem(a.statusBar, 1, 0, false)
+
+	a.ui.SetRoot(root, true)
 	a.ui.SetFocus(a.BranchList)
 }
 
+// statusText describes the current step of the flow, the branches chosen so
+// far, and the keys that matter at this point.
+func (a *App) statusText() string {
+	switch {
+	case a.restoreVisible:
+		return "Restore branch | enter: create  esc: cancel"
+	case a.confirmVisible:
+		return fmt.Sprintf("Confirm %s → %s | enter: choose  esc: cancel", a.branchSource, a.branchTarget)
+	case a.duplicateVisible:
+		return fmt.Sprintf("Duplicates on %s | s: skip  a: apply anyway  q: cancel", a.branchTarget)
+	case a.previewVisible:
+		return fmt.Sprintf("Preview %s → %s | e: edit message  a: suggested message  c: copy  t: transfer  esc: back", a.branchSource, a.branchTarget)
+	}
+	switch a.branchStage {
+	case 1:
+		return fmt.Sprintf("Select target (source: %s) | enter: select  r: refresh  ?: help  q: quit", a.branchSource)
+	case 2:
+		status := fmt.Sprintf("Pick range %s → %s", a.branchSource, a.branchTarget)
+		if a.commitStart >= 0 && a.commitStart < len(a.commits) {
+			status += fmt.Sprintf(" (start: %s)", shortHash(a.commits[a.commitStart].Hash))
+		}
+		return status + " | space: mark start  enter: confirm  A: all  f: files  b: restore  ?: help"
+	default:
+		return "Select source branch | enter: select  r: refresh  ?: help  q: quit"
+	}
+}
+
+func (a *App) updateStatus() {
+	if a.statusBar != nil {
+		a.statusBar.SetText(a.statusText())
+	}
+}
+
 func (a *App) bindKeys() {
 	a.ui.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event == nil {
 			return nil
 		}
+		a.commitClick, a.commitClickShift = false, false
 
 		switch event.Key() {
 		case tcell.KeyRune:
+			if a.duplicateVisible {
+				switch event.Rune() {
+				case 's', 'S':
+					a.skipDuplicates()
+					return nil
+				case 'a', 'A':
+					a.hideDuplicatePrompt()
+					a.showPreview()
+					return nil
+				case 'q', 'Q':
+					a.hideDuplicatePrompt()
+					return nil
+				}
+			}
 			switch event.Rune() {
 			case '?':
 				a.ToggleHelp()
@@ -320,6 +549,16 @@ func (a *App) bindKeys() {
 					a.commitTargetReset()
 				}
 				return nil
+			case 'A':
+				if a.ui.GetFocus() == a.CommitList {
+					a.selectAllCommits()
+					return nil
+				}
+			case 'f', 'F':
+				if a.ui.GetFocus() == a.CommitList {
+					a.toggleCommitFiles(a.CommitList.GetCurrentItem())
+					return nil
+				}
 			case 'b', 'B':
 				if a.ui.GetFocus() == a.CommitList {
 					index := a.CommitList.GetCurrentItem()
@@ -328,6 +567,10 @@ func (a *App) bindKeys() {
 				}
 			}
 		case tcell.KeyEscape:
+			if a.confirmVisible {
+				a.hideConfirm()
+				return nil
+			}
 			if a.previewVisible {
 				a.hidePreview()
 				return nil
@@ -340,6 +583,11 @@ func (a *App) bindKeys() {
 				a.hideRestore()
 				return nil
 			}
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if focus := a.ui.GetFocus(); focus == a.BranchList || focus == a.CommitList {
+				a.resetSelection()
+				return nil
+			}
 		case tcell.KeyCtrlC:
 			a.Stop()
 			return nil
@@ -366,6 +614,7 @@ func (a *App) loadBranchesWithFetch(doFetch bool) {
 
 	branches, err := listBranchesFunc()
 	a.BranchList.Clear()
+	a.recentPairs = nil
 	if err != nil {
 		a.BranchList.AddItem(fmt.Sprintf("Error loading branches: %v", err), "", 0, nil)
 		return
@@ -374,6 +623,7 @@ func (a *App) loadBranchesWithFetch(doFetch bool) {
 		a.BranchList.AddItem("No local branches found", "", 0, nil)
 		return
 	}
+	a.addRecentPairs(branches)
 	for _, branch := range branches {
 		branch = strings.TrimSpace(branch)
 		if branch == "" {
@@ -381,7 +631,48 @@ func (a *App) loadBranchesWithFetch(doFetch bool) {
 		}
 		a.BranchList.AddItem(branch, "", 0, nil)
 	}
-	a.BranchList.SetCurrentItem(0)
+	a.BranchList.SetCurrentItem(len(a.recentPairs))
+}
+
+// addRecentPairs lists the recently used pairs whose branches still exist,
+// with the digit keys as shortcuts.
+func (a *App) addRecentPairs(branches []string) {
+	pairs, err := loadRecentFunc()
+	if err != nil {
+		if a.refreshBanner != nil {
+			a.refreshBanner.SetText(fmt.Sprintf("Could not load recent branches: %v", err))
+		}
+		return
+	}
+
+	existing := make(map[string]bool, len(branches))
+	for _, branch := range branches {
+		existing[strings.TrimSpace(branch)] = true
+	}
+	for _, pair := range pairs {
+		if !existing[pair.Source] || !existing[pair.Target] || pair.Source == pair.Target {
+			continue
+		}
+		a.recentPairs = append(a.recentPairs, pair)
+		shortcut := rune('0' + len(a.recentPairs))
+		a.BranchList.AddItem(fmt.Sprintf("%s → %s", pair.Source, pair.Target), "", shortcut, nil)
+	}
+}
+
+// selectRecentPair jumps straight to the commit list for a recent pair.
+func (a *App) selectRecentPair(pair logs.RecentPair) {
+	a.branchSource = pair.Source
+	a.branchTarget = pair.Target
+	a.branchStage = 2
+	a.rememberPair()
+	a.showCommitListForSource()
+	a.updateStatus()
+}
+
+func (a *App) rememberPair() {
+	if err := recordRecentFunc(a.branchSource, a.branchTarget); err != nil && a.refreshBanner != nil {
+		a.refreshBanner.SetText(fmt.Sprintf("Could not save recent branches: %v", err))
+	}
 }
 
 func (a *App) handleBranchSelection(branch string) {
@@ -397,8 +688,15 @@ func (a *App) handleBranchSelection(branch string) {
 		a.branchStage = 1
 		a.previewCommitSelectionPrompt()
 	case 1:
+		if branch == a.branchSource {
+			a.CommitList.Clear()
+			a.CommitList.AddItem(fmt.Sprintf("Target must differ from source (%s); select another branch", a.branchSource), "", 0, nil)
+			a.ui.SetFocus(a.BranchList)
+			break
+		}
 		a.branchTarget = branch
 		a.branchStage = 2
+		a.rememberPair()
 		a.showCommitListForSource()
 	default:
 		a.branchSource = branch
@@ -406,6 +704,35 @@ func (a *App) handleBranchSelection(branch string) {
 		a.branchStage = 1
 		a.previewCommitSelectionPrompt()
 	}
+	a.updateStatus()
+}
+
+// resetSelection returns to the first step of the flow, dropping the chosen
+// branches, the loaded commits, and any open preview.
+func (a *App) resetSelection() {
+	if a.previewVisible {
+		a.hidePreview()
+	}
+	if a.duplicateVisible {
+		a.hideDuplicatePrompt()
+	}
+	if a.restoreVisible {
+		a.hideRestore()
+	}
+
+	a.branchStage = 0
+	a.branchSource = ""
+	a.branchTarget = ""
+	a.commits = nil
+	a.commitStart = -1
+	a.commitEnd = -1
+	a.skippedHashes = nil
+	a.restoreCommitIndex = -1
+
+	a.CommitList.Clear()
+	a.CommitList.AddItem("(select a branch)", "", 0, nil)
+	a.ui.SetFocus(a.BranchList)
+	a.updateStatus()
 }
 
 func (a *App) previewCommitSelectionPrompt() {
@@ -424,8 +751,13 @@ func (a *App) showCommitListForSource() {
 
 func (a *App) commitTargetReset() {
 	a.CommitList.Clear()
-	commits, err := commitsBetweenFunc(a.branchTarget, a.branchSource)
+	format := git.DefaultDisplayFormat
+	if a.config != nil && a.config.CommitDisplayFormat != "" {
+		format = a.config.CommitDisplayFormat
+	}
+	commits, err := commitsBetweenFunc(a.branchTarget, a.branchSource, format)
 	a.commits = commits
+	a.filesExpanded = nil
 
 	if err != nil {
 		a.CommitList.AddItem(fmt.Sprintf("Error loading commits: %v", err), "", 0, nil)
@@ -442,18 +774,62 @@ func (a *App) commitTargetReset() {
 		if strings.TrimSpace(title) == "" {
 			title = commit.Hash
 		}
-		secondary := commit.Hash
-		a.CommitList.AddItem(title, secondary, 0, nil)
+		a.CommitList.AddItem(title, a.commitSecondaryText(commit), 0, nil)
 	}
 	a.CommitList.SetCurrentItem(0)
 }
 
+// toggleCommitFiles shows or hides the files changed by the commit at index in
+// its secondary text, loading them on first use.
+func (a *App) toggleCommitFiles(index int) {
+	if index < 0 || index >= len(a.commits) {
+		return
+	}
+	commit := a.commits[index]
+	main, _ := a.CommitList.GetItemText(index)
+
+	if a.filesExpanded[commit.Hash] {
+		delete(a.filesExpanded, commit.Hash)
+		a.CommitList.SetItemText(index, main, a.commitSecondaryText(commit))
+		return
+	}
+	if _, ok := a.commitFiles[commit.Hash]; !ok {
+		files, err := commitFilesFunc(a.runner, commit.Hash)
+		if err != nil {
+			a.CommitList.SetItemText(index, main, fmt.Sprintf("%s  (files unavailable: %v)", commit.Hash, err))
+			return
+		}
+		if a.commitFiles == nil {
+			a.commitFiles = make(map[string][]string)
+		}
+		a.commitFiles[commit.Hash] = files
+	}
+	if a.filesExpanded == nil {
+		a.filesExpanded = make(map[string]bool)
+	}
+	a.filesExpanded[commit.Hash] = true
+	a.CommitList.SetItemText(index, main, a.commitSecondaryText(commit))
+}
+
+func (a *App) commitSecondaryText(commit git.Commit) string {
+	if !a.filesExpanded[commit.Hash] {
+		return commit.Hash
+	}
+	files := a.commitFiles[commit.Hash]
+	noun := "files"
+	if len(files) == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%s  %d %s: %s", commit.Hash, len(files), noun, strings.Join(files, ", "))
+}
+
 func (a *App) markCommitStart(index int) {
 	if index < 0 || index >= len(a.commits) {
 		return
 	}
 	a.commitStart = index
 	a.commitEnd = index
+	a.updateStatus()
 }
 
 func (a *App) confirmCommitRange(index int) {
@@ -476,6 +852,7 @@ func (a *App) confirmCommitRange(index int) {
 	} else {
 		a.commitEnd = index
 	}
+	a.skippedHashes = nil
 
 	if a.branchTarget != "" && a.duplicateFn != nil {
 		duplicates, err := a.detectDuplicates()
@@ -493,6 +870,16 @@ func (a *App) confirmCommitRange(index int) {
 	a.showPreview()
 }
 
+// selectAllCommits selects every commit in the list, the whole delta between
+// source and target, and continues as confirmCommitRange does.
+func (a *App) selectAllCommits() {
+	if len(a.commits) == 0 {
+		return
+	}
+	a.markCommitStart(0)
+	a.confirmCommitRange(len(a.commits) - 1)
+}
+
 // SelectedRange returns the hash bounds for the current selection if available.
 func (a *App) SelectedRange() (string, string, bool) {
 	if a.commitStart < 0 || a.commitEnd < 0 ||
@@ -502,6 +889,69 @@ func (a *App) SelectedRange() (string, string, bool) {
 	return a.commits[a.commitStart].Hash, a.commits[a.commitEnd].Hash, true
 }
 
+// AppState captures the selection progress of the TUI so tests can start from
+// a known point without replaying key presses.
+type AppState struct {
+	BranchStage    int
+	BranchSource   string
+	BranchTarget   string
+	CommitStart    int
+	CommitEnd      int
+	PreviewVisible bool
+}
+
+// ExportState returns the current selection state.
+func (a *App) ExportState() AppState {
+	return AppState{
+		BranchStage:    a.branchStage,
+		BranchSource:   a.branchSource,
+		BranchTarget:   a.branchTarget,
+		CommitStart:    a.commitStart,
+		CommitEnd:      a.commitEnd,
+		PreviewVisible: a.previewVisible,
+	}
+}
+
+// LoadState restores a state produced by ExportState. Branches and commits are
+// not reloaded, so commit indexes must refer to the commits already loaded.
+func (a *App) LoadState(state AppState) error {
+	switch {
+	case state.BranchStage < 0 || state.BranchStage > 2:
+		return fmt.Errorf("invalid branch stage %d", state.BranchStage)
+	case state.BranchStage >= 1 && strings.TrimSpace(state.BranchSource) == "":
+		return errors.New("branch stage requires a source branch")
+	case state.BranchStage == 2 && strings.TrimSpace(state.BranchTarget) == "":
+		return errors.New("branch stage requires a target branch")
+	}
+
+	hasRange := state.CommitStart >= 0 || state.CommitEnd >= 0
+	if hasRange && (state.CommitStart < 0 || state.CommitStart > state.CommitEnd || state.CommitEnd >= len(a.commits)) {
+		return fmt.Errorf("invalid commit range %d..%d for %d loaded commits", state.CommitStart, state.CommitEnd, len(a.commits))
+	}
+	if state.PreviewVisible && (!hasRange || state.BranchStage != 2) {
+		return errors.New("preview requires a source, target, and commit range")
+	}
+
+	a.branchStage = state.BranchStage
+	a.branchSource = state.BranchSource
+	a.branchTarget = state.BranchTarget
+	a.commitStart = -1
+	a.commitEnd = -1
+	if hasRange {
+		a.commitStart = state.CommitStart
+		a.commitEnd = state.CommitEnd
+	}
+	a.skippedHashes = nil
+
+	if state.PreviewVisible {
+		a.showPreview()
+	} else if a.previewVisible {
+		a.hidePreview()
+	}
+	a.updateStatus()
+	return nil
+}
+
 func (a *App) showPreview() {
 	if a.commitStart < 0 || a.commitEnd < 0 || a.commitEnd >= len(a.commits) {
 		return
@@ -509,15 +959,24 @@ func (a *App) showPreview() {
 	startCommit := a.commits[a.commitStart]
 	endCommit := a.commits[a.commitEnd]
 
-	a.populatePreviewTable(a.commitStart, a.commitEnd)
-	a.previewInfo.SetText(fmt.Sprintf("Target: %s\n→ Will become 1 new commit", a.branchTarget))
+	warning := a.populatePreviewTable(a.commitStart, a.commitEnd)
+	info := fmt.Sprintf("Target: %s\n→ Will become 1 new commit", a.branchTarget)
+	if len(a.skippedHashes) > 0 {
+		info += fmt.Sprintf(" (%d duplicates skipped)", len(a.skippedHashes))
+	}
+	if warning != "" {
+		info += "\n" + warning
+	}
+	a.previewInfo.SetText(info)
 
 	suggested := a.renderSuggestedMessage(startCommit, endCommit)
 	a.previewEditor.SetText(suggested, true)
+	a.previewEditor.SetTitle("Commit Message")
 
 	a.previewVisible = true
 	a.pages.ShowPage("preview")
 	a.ui.SetFocus(a.previewActions)
+	a.updateStatus()
 }
 
 func (a *App) selectedCommits() []git.Commit {
@@ -531,7 +990,7 @@ func (a *App) selectedCommits() []git.Commit {
 	return out
 }
 
-func (a *App) detectDuplicates() ([]git.Commit, error) {
+func (a *App) detectDuplicates() ([]transfer.Duplicate, error) {
 	if a.duplicateFn == nil || a.branchTarget == "" {
 		return nil, nil
 	}
@@ -542,24 +1001,61 @@ func (a *App) detectDuplicates() ([]git.Commit, error) {
 	return a.duplicateFn(a.branchTarget, commits)
 }
 
-func (a *App) populatePreviewTable(start, end int) {
+// populatePreviewTable lists the selected commits, up to the preview limit.
+// When the limit cuts the table short the returned warning says how many
+// commits were selected; the whole selection is still applied.
+func (a *App) populatePreviewTable(start, end int) string {
 	a.previewTable.Clear()
 	a.previewTable.SetCell(0, 0, tview.NewTableCell("Hash").SetAttributes(tcell.AttrBold))
 	a.previewTable.SetCell(0, 1, tview.NewTableCell("Author").SetAttributes(tcell.AttrBold))
 	a.previewTable.SetCell(0, 2, tview.NewTableCell("Subject").SetAttributes(tcell.AttrBold))
 
-	row := 1
+	limit := 0
+	if a.config != nil {
+		limit = a.config.PreviewLimit
+	}
+
+	commits := make([]git.Commit, 0, end-start+1)
 	for i := start; i <= end && i < len(a.commits); i++ {
-		commit := a.commits[i]
-		hash := commit.Hash
-		if len(hash) > 7 {
-			hash = hash[:7]
+		if a.skippedHashes[a.commits[i].Hash] {
+			continue
 		}
-		a.previewTable.SetCell(row, 0, tview.NewTableCell(hash))
+		commits = append(commits, a.commits[i])
+	}
+
+	shown := len(commits)
+	if limit > 0 {
+		shown = min(limit, len(commits))
+	}
+	var warning string
+	if shown < len(commits) {
+		warning = fmt.Sprintf("Selected %s commits — showing first %d", groupThousands(len(commits)), shown)
+	}
+
+	for i, commit := range commits {
+		row := i + 1
+		if i == shown {
+			a.previewTable.SetCell(row, 0, tview.NewTableCell(fmt.Sprintf("…and %d more", len(commits)-i)))
+			break
+		}
+		a.previewTable.SetCell(row, 0, tview.NewTableCell(shortHash(commit.Hash)))
 		a.previewTable.SetCell(row, 1, tview.NewTableCell(commit.Author))
 		a.previewTable.SetCell(row, 2, tview.NewTableCell(commit.Message))
-		row++
 	}
+	return warning
+}
+
+// groupThousands formats n with comma thousands separators, as in 1,240.
+func groupThousands(n int) string {
+	digits := strconv.Itoa(n)
+	var sb strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(digit)
+	}
+	return sb.String()
 }
 
 func (a *App) renderSuggestedMessage(start, end git.Commit) string {
@@ -588,6 +1084,19 @@ func (a *App) applySuggestedMessage() {
 	a.ui.SetFocus(a.previewEditor)
 }
 
+// copyPreviewMessage copies the preview message to the system clipboard and
+// reports the outcome in the editor title.
+func (a *App) copyPreviewMessage() {
+	if a.clipboardFn == nil {
+		return
+	}
+	if err := a.clipboardFn(a.previewEditor.GetText()); err != nil {
+		a.previewEditor.SetTitle(fmt.Sprintf("Commit Message (copy failed: %v)", err))
+		return
+	}
+	a.previewEditor.SetTitle("Commit Message (copied to clipboard)")
+}
+
 func (a *App) editPreviewMessage() {
 	a.ui.SetFocus(a.previewEditor)
 }
@@ -596,22 +1105,176 @@ func (a *App) hidePreview() {
 	a.previewVisible = false
 	a.pages.HidePage("preview")
 	a.ui.SetFocus(a.CommitList)
+	a.updateStatus()
 }
 
-func (a *App) showDuplicatePrompt() {
-	example := ""
-	if len(a.duplicates) > 0 {
-		hash := a.duplicates[0].Hash
-		if len(hash) > 6 {
-			hash = hash[:6]
+// confirmSummary describes the transfer the confirmation modal asks about.
+func (a *App) confirmSummary() string {
+	count := 0
+	for _, commit := range a.selectedCommits() {
+		if !a.skippedHashes[commit.Hash] {
+			count++
 		}
-		example = hash
 	}
-	message := fmt.Sprintf("Detected %d duplicate patches already on target (e.g., %s). Apply anyway?", len(a.duplicates), example)
-	a.duplicateModal.SetText(message)
+	duplicates := "No duplicates skipped"
+	if len(a.skippedHashes) > 0 {
+		duplicates = fmt.Sprintf("%d duplicates skipped", len(a.skippedHashes))
+	}
+	action := fmt.Sprintf("This will apply the transfer to %s.", a.branchTarget)
+	if !a.apply {
+		action = fmt.Sprintf("Dry run: would apply the transfer to %s (start with --apply to change it).", a.branchTarget)
+	}
+	return fmt.Sprintf("Transfer %s → %s\n%d commits → 1 new commit\n%s\n\nMessage:\n%s\n\n%s",
+		a.branchSource, a.branchTarget, count, duplicates, strings.TrimSpace(a.previewEditor.GetText()), action)
+}
+
+// showConfirm asks for a final confirmation of the previewed transfer.
+func (a *App) showConfirm() {
+	if _, _, ok := a.SelectedRange(); !ok || !a.previewVisible {
+		return
+	}
+	a.openConfirm(a.confirmSummary())
+}
+
+func (a *App) openConfirm(text string) {
+	a.confirmModal.SetText(text)
+	a.confirmModal.SetFocus(0)
+	a.confirmVisible = true
+	a.pages.ShowPage("confirm")
+	a.ui.SetFocus(a.confirmModal)
+	a.updateStatus()
+}
+
+func (a *App) hideConfirm() {
+	a.confirmVisible = false
+	a.pages.HidePage("confirm")
+	a.ui.SetFocus(a.previewActions)
+	a.updateStatus()
+}
+
+func (a *App) confirmDone(buttonIndex int, buttonLabel string) {
+	a.hideConfirm()
+	if buttonLabel != "Confirm" {
+		a.confirmingDefault = false
+		return
+	}
+	// Like the CLI, changing the default branch takes a second yes.
+	if a.apply && !a.confirmingDefault && a.guards.DefaultBranch != nil && a.guards.DefaultBranch() == a.branchTarget {
+		a.confirmingDefault = true
+		a.openConfirm(fmt.Sprintf("%s is the default branch. Change it anyway?", a.branchTarget))
+		return
+	}
+	a.confirmingDefault = false
+	a.executeTransfer()
+}
+
+// executeTransfer runs the previewed transfer in the background, applying it
+// only when the app was started with --apply, and reports the outcome in the
+// preview summary. An applied transfer is logged with an undo entry, as the
+// CLI logs it.
+func (a *App) executeTransfer() {
+	start, end, ok := a.SelectedRange()
+	if !ok || a.transferFn == nil || a.transferring {
+		return
+	}
+	opts := transfer.Options{
+		From:            a.branchSource,
+		To:              a.branchTarget,
+		StartHash:       start,
+		EndHash:         end,
+		Message:         strings.TrimSpace(a.previewEditor.GetText()),
+		ApplyDuplicates: len(a.skippedHashes) == 0,
+		Apply:           a.apply,
+	}
+
+	a.transferring = true
+	a.previewInfo.SetText(fmt.Sprintf("Transferring %s → %s…", opts.From, opts.To))
+	var (
+		result transfer.Result
+		output string
+		err    error
+	)
+	a.backgroundFn(func() {
+		result, output, err = a.runTransfer(opts)
+	}, func() {
+		a.transferring = false
+		switch {
+		case err != nil:
+			a.previewInfo.SetText(fmt.Sprintf("Transfer failed: %v", err))
+		case !a.apply:
+			a.previewInfo.SetText(fmt.Sprintf("Dry run: would run %d commands on %s; start with --apply to execute", len(result.Commands), opts.To))
+		default:
+			lines := git.SplitLines(output)
+			if len(lines) > 0 {
+				a.previewInfo.SetText(lines[len(lines)-1])
+			}
+			if a.audit != nil {
+				a.audit.Record(logs.Entry{Summary: fmt.Sprintf("transfer %s → %s applied (%d new commits)", opts.From, opts.To, len(result.NewCommits))})
+			}
+		}
+	})
+}
+
+// runTransfer checks the guards, runs the transfer, and logs it when it was
+// applied. It runs off the UI goroutine.
+func (a *App) runTransfer(opts transfer.Options) (transfer.Result, string, error) {
+	if opts.Apply && a.guards.CheckIdentity != nil {
+		if err := a.guards.CheckIdentity(); err != nil {
+			return transfer.Result{}, "", err
+		}
+	}
+	if a.guards.FilterMessage != nil {
+		message, err := a.guards.FilterMessage(opts.Message)
+		if err != nil {
+			return transfer.Result{}, "", err
+		}
+		opts.Message = message
+	}
+
+	result, output, err := a.transferFn(opts)
+	if err != nil || !opts.Apply || result.NewHead == "" {
+		return result, output, err
+	}
+	if _, err := recordOperationFunc(result.Operation(opts)); err != nil {
+		return result, output, err
+	}
+	if err := pushUndoFunc(result.UndoEntry()); err != nil {
+		return result, output, err
+	}
+	return result, output, nil
+}
+
+func (a *App) showDuplicatePrompt() {
+	a.duplicateInfo.SetText(fmt.Sprintf("%d of the selected commits are already on %s.\ns: skip them   a: apply anyway   q: cancel", len(a.duplicates), a.branchTarget))
+
+	a.duplicateTable.Clear()
+	a.duplicateTable.SetCell(0, 0, tview.NewTableCell("Hash").SetAttributes(tcell.AttrBold).SetSelectable(false))
+	a.duplicateTable.SetCell(0, 1, tview.NewTableCell("Subject").SetAttributes(tcell.AttrBold).SetSelectable(false))
+	a.duplicateTable.SetCell(0, 2, tview.NewTableCell("Matches "+a.branchTarget).SetAttributes(tcell.AttrBold).SetSelectable(false))
+	for i, duplicate := range a.duplicates {
+		row := i + 1
+		a.duplicateTable.SetCell(row, 0, tview.NewTableCell(shortHash(duplicate.Commit.Hash)))
+		a.duplicateTable.SetCell(row, 1, tview.NewTableCell(duplicate.Commit.Message))
+		a.duplicateTable.SetCell(row, 2, tview.NewTableCell(shortHash(duplicate.TargetHash)))
+	}
+	a.duplicateTable.Select(1, 0)
+
 	a.duplicateVisible = true
 	a.pages.ShowPage("duplicates")
-	a.ui.SetFocus(a.duplicateModal)
+	a.ui.SetFocus(a.duplicateTable)
+	a.updateStatus()
+}
+
+// skipDuplicates drops the detected duplicates from the selection and opens
+// the preview for the remaining commits.
+func (a *App) skipDuplicates() {
+	skipped := make(map[string]bool, len(a.duplicates))
+	for _, duplicate := range a.duplicates {
+		skipped[duplicate.Commit.Hash] = true
+	}
+	a.hideDuplicatePrompt()
+	a.skippedHashes = skipped
+	a.showPreview()
 }
 
 func (a *App) hideDuplicatePrompt() {
@@ -619,17 +1282,18 @@ func (a *App) hideDuplicatePrompt() {
 	a.pages.HidePage("duplicates")
 	a.duplicates = nil
 	a.ui.SetFocus(a.CommitList)
+	a.updateStatus()
 }
 
 func (a *App) defaultFetch() error {
-	if a.runner != nil {
-		_, stderr, err := a.runner.Run("fetch", "--prune", "--tags")
-		if err != nil {
-			return fmt.Errorf("git fetch failed: %v (%s)", err, strings.TrimSpace(stderr))
-		}
-		return nil
+	opts := git.FetchOptions{Prune: true, Tags: true}
+	if a.config != nil {
+		opts.Remote = a.config.RefreshRemote
 	}
-	return git.Fetch(true, true)
+	if err := git.FetchWithOptions(a.runner, opts); err != nil {
+		return fmt.Errorf("git fetch failed: %w", err)
+	}
+	return nil
 }
 
 func (a *App) openRestoreModal(index int) {
@@ -641,10 +1305,14 @@ func (a *App) openRestoreModal(index int) {
 	if input := a.restoreInput(); input != nil {
 		input.SetText(defaultName)
 	}
+	if checkout := a.restoreCheckout(); checkout != nil {
+		checkout.SetChecked(false)
+	}
 	a.restoreForm.SetTitle("Restore Branch")
 	a.restoreVisible = true
 	a.pages.ShowPage("restore")
 	a.ui.SetFocus(a.restoreForm)
+	a.updateStatus()
 }
 
 func (a *App) submitRestore() {
@@ -653,8 +1321,8 @@ func (a *App) submitRestore() {
 		return
 	}
 	value := strings.TrimSpace(input.GetText())
-	if value == "" {
-		a.restoreForm.SetTitle("Restore Branch (name required)")
+	if err := git.ValidateBranchName(value); err != nil {
+		a.restoreForm.SetTitle(fmt.Sprintf("Restore Branch (%v)", err))
 		return
 	}
 	a.executeRestore(value)
@@ -665,6 +1333,7 @@ func (a *App) hideRestore() {
 	a.pages.HidePage("restore")
 	a.restoreForm.SetTitle("Restore Branch")
 	a.ui.SetFocus(a.CommitList)
+	a.updateStatus()
 }
 
 func (a *App) restoreInput() *tview.InputField {
@@ -677,6 +1346,16 @@ func (a *App) restoreInput() *tview.InputField {
 	return nil
 }
 
+func (a *App) restoreCheckout() *tview.Checkbox {
+	if a.restoreForm == nil || a.restoreForm.GetFormItemCount() < 2 {
+		return nil
+	}
+	if checkbox, ok := a.restoreForm.GetFormItem(1).(*tview.Checkbox); ok {
+		return checkbox
+	}
+	return nil
+}
+
 func (a *App) executeRestore(branchName string) {
 	if a.restoreCommitIndex < 0 || a.restoreCommitIndex >= len(a.commits) {
 		a.restoreForm.SetTitle("Restore Branch (select a commit)")
@@ -687,6 +1366,12 @@ func (a *App) executeRestore(branchName string) {
 		a.restoreForm.SetTitle(fmt.Sprintf("Restore Branch (error: %v)", err))
 		return
 	}
+	if checkout := a.restoreCheckout(); checkout != nil && checkout.IsChecked() {
+		if err := git.CheckoutBranch(a.runner, branchName, false); err != nil {
+			a.restoreForm.SetTitle(fmt.Sprintf("Restore Branch (created, checkout failed: %v)", err))
+			return
+		}
+	}
 	a.restoreForm.SetTitle("Restore Branch (created)")
 	a.hideRestore()
 	a.loadBranchesWithFetch(false)
@@ -695,7 +1380,23 @@ func (a *App) executeRestore(branchName string) {
 	}
 }
 
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// detectColorSupport decides whether the TUI uses colour. GITCHERRY_NO_COLOR
+// and GITCHERRY_FORCE_COLOR only affect gitcherry and take precedence, in that
+// order, over NO_COLOR and TERM.
 func detectColorSupport() bool {
+	if envEnabled("GITCHERRY_NO_COLOR") {
+		return false
+	}
+	if envEnabled("GITCHERRY_FORCE_COLOR") {
+		return true
+	}
 	if strings.ToLower(os.Getenv("NO_COLOR")) != "" {
 		return false
 	}
@@ -706,21 +1407,21 @@ func detectColorSupport() bool {
 	return true
 }
 
-func defaultPalette() colorPalette {
-	if colorSupportFn() {
-		return colorPalette{
-			listSelectedBg:   tcell.ColorBlue,
-			listSelectedFg:   tcell.ColorWhite,
-			commitSelectedBg: tcell.ColorGreen,
-			commitSelectedFg: tcell.ColorBlack,
-			bannerText:       tcell.ColorYellow,
-		}
+// envEnabled reports whether the environment variable name is set to a true
+// value such as "1" or "true".
+func envEnabled(name string) bool {
+	enabled, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && enabled
+}
+
+// selectTheme returns the named theme, falling back to the default theme for
+// unknown names and to mono when the terminal does not support colour.
+func selectTheme(name string) theme {
+	if !colorSupportFn() {
+		return themes[ThemeMono]
 	}
-	return colorPalette{
-		listSelectedBg:   tcell.ColorDefault,
-		listSelectedFg:   tcell.ColorWhite,
-		commitSelectedBg: tcell.ColorDefault,
-		commitSelectedFg: tcell.ColorWhite,
-		bannerText:       tcell.ColorWhite,
+	if t, ok := themes[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return t
 	}
+	return themes[ThemeDefault]
 }
@@ -1,17 +1,23 @@
 package tui
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"github.com/stretchr/testify/require"
 
+	"github.com/julianchen24/gitcherry/internal/clipboard"
 	"github.com/julianchen24/gitcherry/internal/config"
 	"github.com/julianchen24/gitcherry/internal/git"
 	"github.com/julianchen24/gitcherry/internal/logs"
+	"github.com/julianchen24/gitcherry/internal/ops/transfer"
+	"github.com/julianchen24/gitcherry/tests/repohelper"
 )
 
 func stubColorSupport(t *testing.T, enabled bool) {
@@ -20,6 +26,15 @@ func stubColorSupport(t *testing.T, enabled bool) {
 	t.Cleanup(func() { colorSupportFn = original })
 }
 
+// runInForeground makes confirmed transfers finish before executeTransfer
+// returns, since tests do not run the tview event loop.
+func runInForeground(app *App) {
+	app.backgroundFn = func(work, done func()) {
+		work()
+		done()
+	}
+}
+
 func withStubBranches(t *testing.T, branches []string, err error) {
 	original := listBranchesFunc
 	listBranchesFunc = func() ([]string, error) {
@@ -28,11 +43,27 @@ func withStubBranches(t *testing.T, branches []string, err error) {
 	t.Cleanup(func() {
 		listBranchesFunc = original
 	})
+	// Keep tests from reading or writing .gitcherry/recent.json.
+	withStubRecentPairs(t, nil)
+}
+
+func withStubRecentPairs(t *testing.T, pairs []logs.RecentPair) *[]logs.RecentPair {
+	originalLoad, originalRecord := loadRecentFunc, recordRecentFunc
+	var recorded []logs.RecentPair
+	loadRecentFunc = func() ([]logs.RecentPair, error) { return pairs, nil }
+	recordRecentFunc = func(source, target string) error {
+		recorded = append(recorded, logs.RecentPair{Source: source, Target: target})
+		return nil
+	}
+	t.Cleanup(func() {
+		loadRecentFunc, recordRecentFunc = originalLoad, originalRecord
+	})
+	return &recorded
 }
 
 func withStubCommits(t *testing.T, commits []git.Commit, err error) {
 	original := commitsBetweenFunc
-	commitsBetweenFunc = func(base, head string) ([]git.Commit, error) {
+	commitsBetweenFunc = func(base, head, format string) ([]git.Commit, error) {
 		return commits, err
 	}
 	t.Cleanup(func() {
@@ -50,7 +81,7 @@ func TestNewAppInitializes(t *testing.T) {
 	stubColorSupport(t, true)
 	app := NewApp(nil, cfg, logs.NewAuditLog())
 	app.fetchFn = func() error { return nil }
-	app.duplicateFn = func(string, []git.Commit) ([]git.Commit, error) { return nil, nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
 	require.NotNil(t, app)
 	require.NotNil(t, app.BranchList)
 	require.NotNil(t, app.CommitList)
@@ -70,7 +101,7 @@ func TestToggleHelp(t *testing.T) {
 	stubColorSupport(t, true)
 	app := NewApp(nil, config.Default(), logs.NewAuditLog())
 	app.fetchFn = func() error { return nil }
-	app.duplicateFn = func(string, []git.Commit) ([]git.Commit, error) { return nil, nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
 	require.False(t, app.HelpVisible())
 
 	app.ToggleHelp()
@@ -93,7 +124,7 @@ func TestBranchSelectionFlowAndCommitRange(t *testing.T) {
 	stubColorSupport(t, true)
 	app := NewApp(nil, cfg, logs.NewAuditLog())
 	app.fetchFn = func() error { return nil }
-	app.duplicateFn = func(string, []git.Commit) ([]git.Commit, error) { return nil, nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
 	require.Equal(t, 2, app.BranchList.GetItemCount())
 	require.Equal(t, 0, app.branchStage)
 
@@ -125,6 +156,46 @@ func TestBranchSelectionFlowAndCommitRange(t *testing.T) {
 	require.Equal(t, expected, app.previewEditor.GetText())
 }
 
+func TestExportLoadStateRoundTrip(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	commits := []git.Commit{
+		{Hash: "c1", Message: "First"},
+		{Hash: "c2", Message: "Second"},
+		{Hash: "c3", Message: "Third"},
+	}
+	withStubCommits(t, commits, nil)
+
+	stubColorSupport(t, true)
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.commits = commits
+
+	want := AppState{
+		BranchStage:    2,
+		BranchSource:   "main",
+		BranchTarget:   "feature",
+		CommitStart:    1,
+		CommitEnd:      2,
+		PreviewVisible: true,
+	}
+	require.NoError(t, app.LoadState(want))
+	require.Equal(t, want, app.ExportState())
+
+	start, end, ok := app.SelectedRange()
+	require.True(t, ok)
+	require.Equal(t, "c2", start)
+	require.Equal(t, "c3", end)
+	require.Equal(t, 3, app.previewTable.GetRowCount())
+
+	require.NoError(t, app.LoadState(AppState{CommitStart: -1, CommitEnd: -1}))
+	require.Equal(t, AppState{CommitStart: -1, CommitEnd: -1}, app.ExportState())
+
+	require.Error(t, app.LoadState(AppState{BranchStage: 3}))
+	require.Error(t, app.LoadState(AppState{BranchStage: 2, BranchSource: "main", BranchTarget: "feature", CommitStart: 0, CommitEnd: 3}))
+	require.Error(t, app.LoadState(AppState{BranchStage: 1, BranchSource: "main", CommitStart: -1, CommitEnd: -1, PreviewVisible: true}))
+}
+
 func TestPreviewTemplateActions(t *testing.T) {
 	withStubBranches(t, []string{"main", "feature"}, nil)
 	commits := []git.Commit{
@@ -138,7 +209,7 @@ func TestPreviewTemplateActions(t *testing.T) {
 	stubColorSupport(t, true)
 	app := NewApp(nil, cfg, logs.NewAuditLog())
 	app.fetchFn = func() error { return nil }
-	app.duplicateFn = func(string, []git.Commit) ([]git.Commit, error) { return nil, nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
 	app.handleBranchSelection("main")
 	app.handleBranchSelection("feature")
 	app.markCommitStart(0)
@@ -154,6 +225,74 @@ func TestPreviewTemplateActions(t *testing.T) {
 	require.Equal(t, "edited", app.previewEditor.GetText())
 }
 
+func TestPreviewTableRespectsPreviewLimit(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	commits := []git.Commit{
+		{Hash: "c1", Message: "First"},
+		{Hash: "c2", Message: "Second"},
+		{Hash: "c3", Message: "Third"},
+		{Hash: "c4", Message: "Fourth"},
+	}
+	withStubCommits(t, commits, nil)
+
+	cfg := config.Default()
+	cfg.PreviewLimit = 2
+	stubColorSupport(t, true)
+	app := NewApp(nil, cfg, logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.markCommitStart(0)
+	app.confirmCommitRange(3)
+
+	require.Equal(t, 4, app.previewTable.GetRowCount())
+	require.Equal(t, "c2", app.previewTable.GetCell(2, 0).Text)
+	require.Equal(t, "…and 2 more", app.previewTable.GetCell(3, 0).Text)
+
+	start, end, ok := app.SelectedRange()
+	require.True(t, ok)
+	require.Equal(t, "c1", start)
+	require.Equal(t, "c4", end)
+}
+
+func TestPreviewWarnsAndCapsLargeSelections(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	commits := make([]git.Commit, 1240)
+	for i := range commits {
+		commits[i] = git.Commit{Hash: fmt.Sprintf("c%d", i+1), Message: fmt.Sprintf("Commit %d", i+1)}
+	}
+	withStubCommits(t, commits, nil)
+
+	cfg := config.Default()
+	cfg.PreviewLimit = 50
+	stubColorSupport(t, true)
+	app := NewApp(nil, cfg, logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.markCommitStart(0)
+	app.confirmCommitRange(len(commits) - 1)
+
+	require.Equal(t, 52, app.previewTable.GetRowCount())
+	require.Equal(t, "c50", app.previewTable.GetCell(50, 0).Text)
+	require.Equal(t, "…and 1190 more", app.previewTable.GetCell(51, 0).Text)
+	require.Contains(t, app.previewInfo.GetText(true), "Selected 1,240 commits — showing first 50")
+
+	// The cap only affects the table; the whole range is still applied.
+	start, end, ok := app.SelectedRange()
+	require.True(t, ok)
+	require.Equal(t, "c1", start)
+	require.Equal(t, "c1240", end)
+
+	app.hidePreview()
+	app.markCommitStart(0)
+	app.confirmCommitRange(49)
+	require.Equal(t, 51, app.previewTable.GetRowCount())
+	require.NotContains(t, app.previewInfo.GetText(true), "Selected")
+}
+
 func TestManualRefreshInvokesFetch(t *testing.T) {
 	withStubBranches(t, []string{"main"}, nil)
 	withStubCommits(t, nil, nil)
@@ -180,8 +319,8 @@ func TestDuplicatePromptShown(t *testing.T) {
 	stubColorSupport(t, true)
 	app := NewApp(nil, config.Default(), logs.NewAuditLog())
 	app.fetchFn = func() error { return nil }
-	app.duplicateFn = func(target string, selection []git.Commit) ([]git.Commit, error) {
-		return selection[:1], nil
+	app.duplicateFn = func(target string, selection []git.Commit) ([]transfer.Duplicate, error) {
+		return []transfer.Duplicate{{Commit: selection[0], TargetHash: "t1"}}, nil
 	}
 
 	app.handleBranchSelection("main")
@@ -193,24 +332,278 @@ func TestDuplicatePromptShown(t *testing.T) {
 	app.hideDuplicatePrompt()
 }
 
+func TestDuplicatePanelListsMatches(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	commits := []git.Commit{
+		{Hash: "c1ffee0123", Author: "Alice", Message: "Fix lint warnings"},
+		{Hash: "deadbeef45", Author: "Bob", Message: "Improve logging"},
+		{Hash: "faceb00c67", Author: "Carol", Message: "Add telemetry hooks"},
+	}
+	withStubCommits(t, commits, nil)
+	stubColorSupport(t, false)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(target string, selection []git.Commit) ([]transfer.Duplicate, error) {
+		return []transfer.Duplicate{
+			{Commit: selection[0], TargetHash: "0123456789"},
+			{Commit: selection[2], TargetHash: "abcdef0123"},
+		}, nil
+	}
+
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.markCommitStart(0)
+	app.confirmCommitRange(2)
+
+	require.True(t, app.duplicateVisible)
+	require.Equal(t, strings.Join([]string{
+		"Hash | Subject | Matches feature",
+		"c1ffee0 | Fix lint warnings | 0123456",
+		"faceb00 | Add telemetry hooks | abcdef0",
+		"",
+	}, "\n"), renderTable(app.duplicateTable))
+	require.Contains(t, app.duplicateInfo.GetText(false), "2 of the selected commits are already on feature")
+
+	app.skipDuplicates()
+	require.False(t, app.duplicateVisible)
+	require.True(t, app.previewVisible)
+	require.Equal(t, "Hash | Author | Subject\ndeadbee | Bob | Improve logging\n", renderTable(app.previewTable))
+	require.Contains(t, app.previewInfo.GetText(false), "(2 duplicates skipped)")
+
+	app.hidePreview()
+	app.confirmCommitRange(2)
+	require.True(t, app.duplicateVisible)
+	app.hideDuplicatePrompt()
+	app.showPreview()
+	require.Equal(t, 4, app.previewTable.GetRowCount(), "applying anyway previews every commit")
+}
+
+func TestConfirmTransferCancelLeavesTargetUnchanged(t *testing.T) {
+	repo := repohelper.Init(t)
+	repo.MustRun(t, "checkout", "-b", "feature")
+	first := repo.CommitFile(t, "a.txt", "a\n", "First")
+	second := repo.CommitFile(t, "b.txt", "b\n", "Second")
+	repo.MustRun(t, "checkout", "main")
+	before := strings.TrimSpace(repo.MustRun(t, "rev-parse", "main"))
+
+	withStubBranches(t, []string{"feature", "main"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: first, Message: "First"}, {Hash: second, Message: "Second"}}, nil)
+	stubColorSupport(t, true)
+	logs.SetBasePath(t.TempDir())
+	t.Cleanup(func() { logs.SetBasePath("") })
+	app := NewApp(&git.Runner{Dir: repo.Path}, config.Default(), logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.SetApply(true)
+	runInForeground(app)
+
+	app.handleBranchSelection("feature")
+	app.handleBranchSelection("main")
+	app.markCommitStart(0)
+	app.confirmCommitRange(1)
+	app.previewEditor.SetText("Backport feature", true)
+	app.previewActions.InputHandler()(tcell.NewEventKey(tcell.KeyRune, 't', tcell.ModNone), func(tview.Primitive) {})
+
+	require.True(t, app.confirmVisible)
+	require.Contains(t, app.statusText(), "Confirm feature → main")
+
+	app.confirmDone(1, "Cancel")
+	require.False(t, app.confirmVisible)
+	require.True(t, app.previewVisible)
+	require.Equal(t, before, strings.TrimSpace(repo.MustRun(t, "rev-parse", "main")))
+
+	app.showConfirm()
+	app.confirmDone(0, "Confirm")
+	require.Contains(t, app.previewInfo.GetText(false), "Transfer applied successfully (1 new commits on main).")
+	require.Equal(t, "Backport feature", strings.TrimSpace(repo.MustRun(t, "log", "-1", "--format=%s", "main")))
+
+	ops, err := logs.ListOperations(logs.OperationsDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, logs.KindTransfer, ops[0].Kind)
+	require.Equal(t, "squash", ops[0].Mode)
+	require.Equal(t, "Backport feature", ops[0].Message)
+	require.Equal(t, before, ops[0].BeforeHead)
+	entry, ok, err := logs.Undo()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, before, entry.BeforeHead)
+}
+
+func TestConfirmTransferRunsGuards(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}}, nil)
+	stubColorSupport(t, true)
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	var ran []transfer.Options
+	app.transferFn = func(opts transfer.Options) (transfer.Result, string, error) {
+		ran = append(ran, opts)
+		return transfer.Result{}, "", nil
+	}
+	identity := errors.New("git user identity is not configured")
+	app.SetTransferGuards(TransferGuards{
+		DefaultBranch: func() string { return "main" },
+		CheckIdentity: func() error { return identity },
+		FilterMessage: func(message string) (string, error) { return strings.ToUpper(message), nil },
+	})
+	app.SetApply(true)
+	runInForeground(app)
+
+	app.handleBranchSelection("feature")
+	app.handleBranchSelection("main")
+	app.markCommitStart(0)
+	app.confirmCommitRange(0)
+	app.previewEditor.SetText("Backport", true)
+	app.showConfirm()
+
+	app.confirmDone(0, "Confirm")
+	require.True(t, app.confirmVisible, "the default branch needs a second confirmation")
+	require.True(t, app.confirmingDefault)
+	app.confirmDone(1, "Cancel")
+	require.Empty(t, ran)
+
+	app.showConfirm()
+	app.confirmDone(0, "Confirm")
+	app.confirmDone(0, "Confirm")
+	require.Empty(t, ran)
+	require.Equal(t, "Transfer failed: git user identity is not configured", app.previewInfo.GetText(false))
+
+	identity = nil
+	app.SetTransferGuards(TransferGuards{
+		CheckIdentity: func() error { return identity },
+		FilterMessage: func(message string) (string, error) { return strings.ToUpper(message), nil },
+	})
+	app.showConfirm()
+	app.confirmDone(0, "Confirm")
+	require.Len(t, ran, 1)
+	require.Equal(t, "BACKPORT", ran[0].Message)
+}
+
+func TestConfirmSummaryRespectsApply(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}, {Hash: "c2", Message: "Second"}, {Hash: "c3", Message: "Third"}}, nil)
+	stubColorSupport(t, true)
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.fetchFn = func() error { return nil }
+	app.duplicateFn = func(target string, selection []git.Commit) ([]transfer.Duplicate, error) {
+		return []transfer.Duplicate{{Commit: selection[1], TargetHash: "t2"}}, nil
+	}
+	var ran []transfer.Options
+	app.transferFn = func(opts transfer.Options) (transfer.Result, string, error) {
+		ran = append(ran, opts)
+		return transfer.Result{Commands: []string{"git checkout feature", "git commit"}}, "Planned commands:\n", nil
+	}
+	runInForeground(app)
+
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.markCommitStart(0)
+	app.confirmCommitRange(2)
+	app.skipDuplicates()
+	app.previewEditor.SetText("Squashed", true)
+	app.showConfirm()
+
+	summary := app.confirmSummary()
+	require.Contains(t, summary, "Transfer main → feature\n2 commits → 1 new commit\n1 duplicates skipped")
+	require.Contains(t, summary, "Message:\nSquashed")
+	require.Contains(t, summary, "Dry run: would apply the transfer to feature")
+
+	app.confirmDone(0, "Confirm")
+	require.Len(t, ran, 1)
+	require.False(t, ran[0].Apply)
+	require.False(t, ran[0].ApplyDuplicates)
+	require.Equal(t, "Dry run: would run 2 commands on feature; start with --apply to execute", app.previewInfo.GetText(false))
+
+	app.SetApply(true)
+	require.Contains(t, app.confirmSummary(), "This will apply the transfer to feature.")
+}
+
 func TestDetectColorSupportRespectsNoColor(t *testing.T) {
 	t.Setenv("NO_COLOR", "1")
 	t.Setenv("TERM", "xterm")
+	t.Setenv("GITCHERRY_NO_COLOR", "")
+	t.Setenv("GITCHERRY_FORCE_COLOR", "")
 	require.False(t, detectColorSupport())
+
+	cases := []struct {
+		name            string
+		noColor         string
+		gitcherryNo     string
+		gitcherryForce  string
+		term            string
+		expectColorized bool
+	}{
+		{name: "gitcherry no color", gitcherryNo: "1", term: "xterm", expectColorized: false},
+		{name: "gitcherry no color true", gitcherryNo: "true", term: "xterm", expectColorized: false},
+		{name: "gitcherry no color false falls through", gitcherryNo: "0", term: "xterm", expectColorized: true},
+		{name: "gitcherry no color false keeps NO_COLOR", gitcherryNo: "false", noColor: "1", term: "xterm", expectColorized: false},
+		{name: "force overrides NO_COLOR", noColor: "1", gitcherryForce: "1", term: "xterm", expectColorized: true},
+		{name: "force overrides dumb term", gitcherryForce: "true", term: "dumb", expectColorized: true},
+		{name: "gitcherry no color beats force", gitcherryNo: "1", gitcherryForce: "1", term: "xterm", expectColorized: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tc.noColor)
+			t.Setenv("GITCHERRY_NO_COLOR", tc.gitcherryNo)
+			t.Setenv("GITCHERRY_FORCE_COLOR", tc.gitcherryForce)
+			t.Setenv("TERM", tc.term)
+			require.Equal(t, tc.expectColorized, detectColorSupport())
+		})
+	}
 }
 
 func TestDetectColorSupportHandlesDumbTerm(t *testing.T) {
 	t.Setenv("NO_COLOR", "")
+	t.Setenv("GITCHERRY_NO_COLOR", "")
+	t.Setenv("GITCHERRY_FORCE_COLOR", "")
 	t.Setenv("TERM", "dumb")
 	require.False(t, detectColorSupport())
 }
 
 func TestDetectColorSupportDefaultsToColor(t *testing.T) {
 	t.Setenv("NO_COLOR", "")
+	t.Setenv("GITCHERRY_NO_COLOR", "")
+	t.Setenv("GITCHERRY_FORCE_COLOR", "")
 	t.Setenv("TERM", "xterm-256color")
 	require.True(t, detectColorSupport())
 }
 
+func TestMonoThemeAvoidsColors(t *testing.T) {
+	stubColorSupport(t, true)
+	withStubBranches(t, []string{"main"}, nil)
+
+	cfg := config.Default()
+	cfg.TUITheme = ThemeMono
+	app := NewApp(nil, cfg, nil)
+
+	styles := []tcell.Style{app.theme.branchSelected, app.theme.commitSelected, app.theme.tableSelected}
+	for _, style := range styles {
+		fg, bg, attrs := style.Decompose()
+		require.Equal(t, tcell.ColorDefault, fg)
+		require.Equal(t, tcell.ColorDefault, bg)
+		require.NotZero(t, attrs&tcell.AttrReverse)
+	}
+	require.Equal(t, tcell.ColorDefault, app.theme.bannerText)
+}
+
+func TestSelectThemeFallsBackToMonoWithoutColor(t *testing.T) {
+	stubColorSupport(t, false)
+	require.Equal(t, themes[ThemeMono], selectTheme(ThemeHighContrast))
+
+	stubColorSupport(t, true)
+	require.Equal(t, themes[ThemeHighContrast], selectTheme("High-Contrast"))
+	require.Equal(t, themes[ThemeDefault], selectTheme("unknown"))
+}
+
+func TestValidateTheme(t *testing.T) {
+	require.NoError(t, ValidateTheme("mono"))
+	require.NoError(t, ValidateTheme(" Default "))
+	require.ErrorContains(t, ValidateTheme("neon"), `unknown TUI theme "neon"`)
+}
+
 func TestAppSnapshots(t *testing.T) {
 	withStubBranches(t, []string{"main", "feature", "bugfix"}, nil)
 	commits := []git.Commit{
@@ -223,7 +616,7 @@ func TestAppSnapshots(t *testing.T) {
 
 	app := NewApp(nil, config.Default(), logs.NewAuditLog())
 	app.fetchFn = func() error { return nil }
-	app.duplicateFn = func(string, []git.Commit) ([]git.Commit, error) { return nil, nil }
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
 
 	app.handleBranchSelection("main")
 	app.handleBranchSelection("feature")
@@ -308,3 +701,292 @@ func assertGolden(t *testing.T, name, actual string) {
 	}
 	require.Equal(t, string(data), actual)
 }
+
+func TestMouseEnabledWhenConfigured(t *testing.T) {
+	withStubBranches(t, []string{"main"}, nil)
+
+	app := NewApp(nil, config.Default(), nil)
+	require.False(t, app.MouseEnabled())
+
+	cfg := config.Default()
+	cfg.Mouse = true
+	app = NewApp(nil, cfg, nil)
+	require.True(t, app.MouseEnabled())
+}
+
+func TestCommitListClickMarksStartAndShiftClickConfirms(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}, {Hash: "c2", Message: "Second"}, {Hash: "c3", Message: "Third"}}, nil)
+
+	cfg := config.Default()
+	cfg.Mouse = true
+	app := NewApp(nil, cfg, logs.NewAuditLog())
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.CommitList.SetRect(0, 0, 40, 10)
+
+	// Inside the border each commit takes two rows: message, then secondary text.
+	click := func(row int, mods tcell.ModMask) {
+		handler := app.CommitList.MouseHandler()
+		handler(tview.MouseLeftClick, tcell.NewEventMouse(2, 1+2*row, tcell.Button1, mods), func(tview.Primitive) {})
+	}
+
+	click(1, tcell.ModNone)
+	require.Equal(t, 1, app.commitStart)
+	require.False(t, app.previewVisible)
+
+	click(2, tcell.ModShift)
+	require.Equal(t, 1, app.commitStart)
+	require.Equal(t, 2, app.commitEnd)
+	require.True(t, app.previewVisible)
+}
+
+func TestStatusBarFollowsStages(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1ffee0", Message: "First"}, {Hash: "deadbeef", Message: "Second"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	status := func() string { return app.statusBar.GetText(true) }
+
+	require.Equal(t, "Select source branch | enter: select  r: refresh  ?: help  q: quit", status())
+
+	app.handleBranchSelection("main")
+	require.Equal(t, "Select target (source: main) | enter: select  r: refresh  ?: help  q: quit", status())
+
+	app.handleBranchSelection("feature")
+	require.Equal(t, "Pick range main → feature | space: mark start  enter: confirm  A: all  f: files  b: restore  ?: help", status())
+
+	app.markCommitStart(0)
+	require.Equal(t, "Pick range main → feature (start: c1ffee0) | space: mark start  enter: confirm  A: all  f: files  b: restore  ?: help", status())
+
+	app.confirmCommitRange(1)
+	require.Equal(t, "Preview main → feature | e: edit message  a: suggested message  c: copy  t: transfer  esc: back", status())
+
+	app.hidePreview()
+	require.True(t, strings.HasPrefix(status(), "Pick range main → feature"))
+
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) {
+		return []transfer.Duplicate{{Commit: git.Commit{Hash: "c1ffee0"}, TargetHash: "0ff1ce"}}, nil
+	}
+	app.confirmCommitRange(1)
+	require.Equal(t, "Duplicates on feature | s: skip  a: apply anyway  q: cancel", status())
+	app.hideDuplicatePrompt()
+
+	app.openRestoreModal(0)
+	require.Equal(t, "Restore branch | enter: create  esc: cancel", status())
+	app.hideRestore()
+	require.True(t, strings.HasPrefix(status(), "Pick range"))
+}
+
+func TestRestoreFormValidatesBranchName(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1ffee0", Message: "First"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.openRestoreModal(0)
+	require.Equal(t, "main-backup", app.restoreInput().GetText())
+	require.False(t, app.restoreCheckout().IsChecked())
+
+	for name, want := range map[string]string{
+		"":            "Restore Branch (name required)",
+		"my backup":   "Restore Branch (no spaces allowed)",
+		"main..old":   "Restore Branch ('..' not allowed)",
+		"-rf":         "Restore Branch (cannot start with '-')",
+		"backup.lock": "Restore Branch (no part may end with '.lock')",
+	} {
+		app.restoreInput().SetText(name)
+		app.submitRestore()
+		require.Equal(t, want, app.restoreForm.GetTitle(), name)
+		require.True(t, app.restoreVisible, name)
+	}
+}
+
+func TestBackspaceResetsSelection(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}, {Hash: "c2", Message: "Second"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.markCommitStart(0)
+	app.confirmCommitRange(1)
+	require.True(t, app.previewVisible)
+
+	// Backspace is left alone while the preview has focus so the editor keeps it.
+	capture := app.ui.GetInputCapture()
+	require.NotNil(t, capture(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone)))
+	require.True(t, app.previewVisible)
+
+	app.hidePreview()
+	require.Nil(t, capture(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone)))
+
+	require.Equal(t, AppState{CommitStart: -1, CommitEnd: -1}, app.ExportState())
+	require.Empty(t, app.commits)
+	require.Equal(t, 1, app.CommitList.GetItemCount())
+	main, _ := app.CommitList.GetItemText(0)
+	require.Equal(t, "(select a branch)", main)
+	require.Equal(t, app.BranchList, app.ui.GetFocus())
+	require.True(t, strings.HasPrefix(app.statusBar.GetText(true), "Select source branch"))
+
+	app.handleBranchSelection("feature")
+	require.Equal(t, "feature", app.branchSource)
+	require.Equal(t, 1, app.branchStage)
+}
+
+func TestResetSelectionClosesPreview(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.confirmCommitRange(0)
+	require.True(t, app.previewVisible)
+
+	app.resetSelection()
+	require.False(t, app.previewVisible)
+	_, _, ok := app.SelectedRange()
+	require.False(t, ok)
+}
+
+func TestSameBranchAsTargetStaysOnTargetSelection(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("main")
+
+	require.Equal(t, 1, app.branchStage)
+	require.Equal(t, "main", app.branchSource)
+	require.Empty(t, app.branchTarget)
+	require.Empty(t, app.commits)
+	warning, _ := app.CommitList.GetItemText(0)
+	require.Equal(t, "Target must differ from source (main); select another branch", warning)
+	require.Equal(t, app.BranchList, app.ui.GetFocus())
+	require.True(t, strings.HasPrefix(app.statusBar.GetText(true), "Select target (source: main)"))
+
+	app.handleBranchSelection("feature")
+	require.Equal(t, 2, app.branchStage)
+	require.Equal(t, "feature", app.branchTarget)
+}
+
+func TestPreviewCopiesMessageToClipboard(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.duplicateFn = func(string, []git.Commit) ([]transfer.Duplicate, error) { return nil, nil }
+	var copied string
+	app.clipboardFn = func(text string) error {
+		copied = text
+		return nil
+	}
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.confirmCommitRange(0)
+
+	app.copyPreviewMessage()
+	require.Equal(t, app.previewEditor.GetText(), copied)
+	require.Contains(t, copied, "main")
+	require.Equal(t, "Commit Message (copied to clipboard)", app.previewEditor.GetTitle())
+
+	app.clipboardFn = func(string) error { return clipboard.ErrUnavailable }
+	app.copyPreviewMessage()
+	require.Contains(t, app.previewEditor.GetTitle(), "copy failed: no clipboard tool found")
+}
+
+func TestToggleCommitFilesLoadsFilesLazily(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}, {Hash: "c2", Message: "Second"}}, nil)
+
+	var loaded []string
+	original := commitFilesFunc
+	commitFilesFunc = func(_ *git.Runner, hash string) ([]string, error) {
+		loaded = append(loaded, hash)
+		return []string{"cmd/main.go", "README.md"}, nil
+	}
+	t.Cleanup(func() { commitFilesFunc = original })
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	require.Empty(t, loaded)
+	_, secondary := app.CommitList.GetItemText(1)
+	require.Equal(t, "c2", secondary)
+
+	app.CommitList.SetCurrentItem(1)
+	capture := app.ui.GetInputCapture()
+	require.Nil(t, capture(tcell.NewEventKey(tcell.KeyRune, 'f', tcell.ModNone)))
+	main, secondary := app.CommitList.GetItemText(1)
+	require.Equal(t, "Second", main)
+	require.Equal(t, "c2  2 files: cmd/main.go, README.md", secondary)
+	require.Equal(t, []string{"c2"}, loaded)
+
+	app.toggleCommitFiles(1)
+	_, secondary = app.CommitList.GetItemText(1)
+	require.Equal(t, "c2", secondary)
+
+	app.toggleCommitFiles(1)
+	_, secondary = app.CommitList.GetItemText(1)
+	require.Contains(t, secondary, "2 files")
+	require.Equal(t, []string{"c2"}, loaded, "files are cached after the first load")
+
+	_, secondary = app.CommitList.GetItemText(0)
+	require.Equal(t, "c1", secondary)
+}
+
+func TestSelectAllSelectsTheWholeListAndPreviews(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature"}, nil)
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}, {Hash: "c2", Message: "Second"}, {Hash: "c3", Message: "Third"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	app.handleBranchSelection("main")
+	app.handleBranchSelection("feature")
+	app.CommitList.SetCurrentItem(1)
+
+	capture := app.ui.GetInputCapture()
+	require.Nil(t, capture(tcell.NewEventKey(tcell.KeyRune, 'A', tcell.ModNone)))
+	start, end, ok := app.SelectedRange()
+	require.True(t, ok)
+	require.Equal(t, "c1", start)
+	require.Equal(t, "c3", end)
+	require.True(t, app.previewVisible)
+}
+
+func TestRecentPairsAreListedFirstAndQuickPicked(t *testing.T) {
+	withStubBranches(t, []string{"main", "feature", "release"}, nil)
+	recorded := withStubRecentPairs(t, []logs.RecentPair{
+		{Source: "feature", Target: "release"},
+		{Source: "gone", Target: "main"},
+		{Source: "feature", Target: "main"},
+	})
+	withStubCommits(t, []git.Commit{{Hash: "c1", Message: "First"}}, nil)
+
+	app := NewApp(nil, config.Default(), logs.NewAuditLog())
+	require.Equal(t, 5, app.BranchList.GetItemCount())
+	main, _ := app.BranchList.GetItemText(0)
+	require.Equal(t, "feature → release", main)
+	main, _ = app.BranchList.GetItemText(1)
+	require.Equal(t, "feature → main", main)
+	require.Equal(t, 2, app.BranchList.GetCurrentItem(), "the cursor starts on the first branch")
+
+	app.ui.SetFocus(app.BranchList)
+	app.BranchList.InputHandler()(tcell.NewEventKey(tcell.KeyRune, '2', tcell.ModNone), func(tview.Primitive) {})
+	require.Equal(t, "feature", app.branchSource)
+	require.Equal(t, "main", app.branchTarget)
+	require.Equal(t, 2, app.branchStage)
+	require.Len(t, app.commits, 1)
+	require.Equal(t, []logs.RecentPair{{Source: "feature", Target: "main"}}, *recorded)
+
+	app.resetSelection()
+	app.handleBranchSelection("release")
+	app.handleBranchSelection("main")
+	require.Equal(t, logs.RecentPair{Source: "release", Target: "main"}, (*recorded)[1])
+}
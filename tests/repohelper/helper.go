@@ -16,7 +16,7 @@ type Repo struct {
 }
 
 // Init creates a new repository with an initial commit.
-func Init(t *testing.T) *Repo {
+func Init(t testing.TB) *Repo {
 	t.Helper()
 
 	dir := t.TempDir()
@@ -38,13 +38,38 @@ func Init(t *testing.T) *Repo {
 	return &Repo{Path: dir}
 }
 
+// InitWithRemote creates an upstream repository and a local clone of it whose
+// origin remote points at the upstream. It returns the clone and the upstream.
+func InitWithRemote(t testing.TB, cloneArgs ...string) (*Repo, *Repo) {
+	t.Helper()
+
+	remote := Init(t)
+	return Clone(t, remote, cloneArgs...), remote
+}
+
+// Clone clones source into a new temporary directory and configures a test
+// identity. Extra arguments (for example "--depth=1") are passed to git clone.
+func Clone(t testing.TB, source *Repo, cloneArgs ...string) *Repo {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	args := append([]string{"clone"}, cloneArgs...)
+	args = append(args, "file://"+filepath.ToSlash(source.Path), dir)
+	mustRun(t, filepath.Dir(dir), "git", args...)
+
+	mustRun(t, dir, "git", "config", "user.name", "Test User")
+	mustRun(t, dir, "git", "config", "user.email", "test@example.com")
+
+	return &Repo{Path: dir}
+}
+
 // Run runs a git command inside the repository.
 func (r *Repo) Run(args ...string) (string, string, error) {
 	return run(r.Path, "git", args...)
 }
 
 // MustRun runs a git command and fails the test on error, returning stdout.
-func (r *Repo) MustRun(t *testing.T, args ...string) string {
+func (r *Repo) MustRun(t testing.TB, args ...string) string {
 	t.Helper()
 	stdout, stderr, err := r.Run(args...)
 	if err != nil {
@@ -54,7 +79,7 @@ func (r *Repo) MustRun(t *testing.T, args ...string) string {
 }
 
 // CommitFile creates/updates a file, commits it, and returns the new hash.
-func (r *Repo) CommitFile(t *testing.T, name, content, message string) string {
+func (r *Repo) CommitFile(t testing.TB, name, content, message string) string {
 	t.Helper()
 
 	path := filepath.Join(r.Path, name)
@@ -81,7 +106,7 @@ func (r *Repo) WriteFile(name, content string) error {
 }
 
 // Chdir changes the working directory to the repository and restores it afterwards.
-func Chdir(t *testing.T, dir string) {
+func Chdir(t testing.TB, dir string) {
 	t.Helper()
 
 	orig, err := os.Getwd()
@@ -113,7 +138,7 @@ func run(dir, command string, args ...string) (string, string, error) {
 	return stdoutBuf.String(), stderrBuf.String(), err
 }
 
-func mustRun(t *testing.T, dir, command string, args ...string) {
+func mustRun(t testing.TB, dir, command string, args ...string) {
 	t.Helper()
 	if _, stderr, err := run(dir, command, args...); err != nil {
 		t.Fatalf("%s %v: %v (%s)", command, strings.Join(args, " "), err, strings.TrimSpace(stderr))